@@ -0,0 +1,150 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/replicatedhq/local-volume-provider/pkg/plugin"
+)
+
+// fileserverBreakerFromEnv returns the circuit breaker settings set by the plugin in the environment, or their defaults.
+func fileserverBreakerFromEnv() (plugin.FileserverBreaker, error) {
+	return plugin.ParseFileserverBreaker(
+		os.Getenv("FILESERVER_BREAKER_FAILURES"),
+		os.Getenv("FILESERVER_BREAKER_WINDOW"),
+		os.Getenv("FILESERVER_BREAKER_COOLDOWN"),
+	)
+}
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+// circuitBreaker stops objects from being served while the volume keeps failing. It is closed until the configured
+// number of consecutive failures happen within the window, then open, rejecting every request, for the cooldown.
+// It then half-opens, letting a single probe through: the breaker closes if the probe succeeds, and opens again
+// if it fails. A nil circuitBreaker lets every request through.
+type circuitBreaker struct {
+	settings plugin.FileserverBreaker
+	now      func() time.Time
+
+	mu           sync.Mutex
+	state        breakerState
+	failures     int
+	firstFailure time.Time
+	openedAt     time.Time
+	probing      bool
+}
+
+// newCircuitBreaker returns a closed circuit breaker, or nil if the settings disable it.
+func newCircuitBreaker(settings plugin.FileserverBreaker) *circuitBreaker {
+	if settings.Failures <= 0 {
+		return nil
+	}
+	return &circuitBreaker{settings: settings, now: time.Now}
+}
+
+// allow returns the func reporting the outcome of a request if it may read the volume, which must be called once
+// the request is done. When it may not, it returns nil and how long until the breaker lets a probe through.
+func (b *circuitBreaker) allow() (done func(err error), retryAfter time.Duration) {
+	if b == nil {
+		return func(error) {}, 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case breakerOpen:
+		reopen := b.openedAt.Add(b.settings.Cooldown)
+		if now := b.now(); now.Before(reopen) {
+			return nil, reopen.Sub(now)
+		}
+		b.state = breakerHalfOpen
+		b.probing = true
+		return b.probeDone, 0
+	case breakerHalfOpen:
+		if b.probing {
+			return nil, b.settings.Cooldown
+		}
+		b.probing = true
+		return b.probeDone, 0
+	default:
+		return b.done, 0
+	}
+}
+
+// probeDone reports the outcome of the probe let through while half-open, closing the breaker if it succeeded
+// and opening it again if it failed.
+func (b *circuitBreaker) probeDone(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.probing = false
+	if err == nil {
+		b.state = breakerClosed
+		b.failures = 0
+		return
+	}
+	b.state = breakerOpen
+	b.openedAt = b.now()
+}
+
+// done reports the outcome of a request allowed through while the breaker was closed. Requests still running when
+// it opened, such as long downloads, do not change it until it is closed again; only the probe does.
+func (b *circuitBreaker) done(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state != breakerClosed {
+		return
+	}
+	if err == nil {
+		b.failures = 0
+		return
+	}
+	now := b.now()
+	if b.failures == 0 || now.Sub(b.firstFailure) > b.settings.Window {
+		b.failures = 0
+		b.firstFailure = now
+	}
+	b.failures++
+	if b.failures >= b.settings.Failures {
+		b.state = breakerOpen
+		b.openedAt = now
+		b.failures = 0
+	}
+}
+
+// rejectOpen answers a request the breaker does not allow with 503, telling the client when to retry.
+func rejectOpen(c *fiber.Ctx, retryAfter time.Duration) error {
+	seconds := int(retryAfter.Round(time.Second) / time.Second)
+	if seconds < 1 {
+		seconds = 1
+	}
+	c.Set(fiber.HeaderRetryAfter, strconv.Itoa(seconds))
+	return c.SendStatus(http.StatusServiceUnavailable)
+}
+
+// readErrorFile records the first error other than io.EOF returned reading the file, so failures of the volume can
+// be told apart from failures to send the response to the client.
+type readErrorFile struct {
+	http.File
+	err error
+}
+
+func (f *readErrorFile) Read(p []byte) (int, error) {
+	n, err := f.File.Read(p)
+	if err != nil && err != io.EOF && f.err == nil {
+		f.err = err
+	}
+	return n, err
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/replicatedhq/local-volume-provider/pkg/plugin"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeClock is a time source tests move forward by hand.
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func newTestCircuitBreaker(settings plugin.FileserverBreaker) (*circuitBreaker, *fakeClock) {
+	clock := &fakeClock{now: time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)}
+	breaker := newCircuitBreaker(settings)
+	breaker.now = clock.Now
+	return breaker, clock
+}
+
+func Test_circuitBreaker(t *testing.T) {
+	req := require.New(t)
+	breaker, clock := newTestCircuitBreaker(plugin.FileserverBreaker{Failures: 3, Window: 10 * time.Second, Cooldown: time.Minute})
+	fail := func() {
+		done, _ := breaker.allow()
+		req.NotNil(done)
+		done(syscall.EIO)
+	}
+	succeed := func() {
+		done, _ := breaker.allow()
+		req.NotNil(done)
+		done(nil)
+	}
+
+	// Failures further apart than the window do not trip the breaker
+	fail()
+	fail()
+	clock.Advance(11 * time.Second)
+	fail()
+	fail()
+	succeed()
+
+	// A success resets the count
+	fail()
+	fail()
+	succeed()
+	fail()
+	fail()
+	// A download admitted before the breaker trips
+	longDownload, _ := breaker.allow()
+	req.NotNil(longDownload)
+	fail()
+
+	// Tripped: rejected until the cooldown has passed, even if a download admitted before then succeeds
+	done, retryAfter := breaker.allow()
+	req.Nil(done)
+	req.Equal(time.Minute, retryAfter)
+	longDownload(nil)
+	clock.Advance(59 * time.Second)
+	done, _ = breaker.allow()
+	req.Nil(done)
+
+	// Half-open: a single probe is let through, and failing it opens the breaker again
+	clock.Advance(time.Second)
+	probe, _ := breaker.allow()
+	req.NotNil(probe)
+	done, _ = breaker.allow()
+	req.Nil(done, "a second probe was let through")
+	probe(syscall.EIO)
+	done, _ = breaker.allow()
+	req.Nil(done)
+
+	// A successful probe closes it
+	clock.Advance(time.Minute)
+	probe, _ = breaker.allow()
+	req.NotNil(probe)
+	probe(nil)
+	for i := 0; i < 5; i++ {
+		succeed()
+	}
+
+	req.Nil(newCircuitBreaker(plugin.FileserverBreaker{}))
+	done, _ = (*circuitBreaker)(nil).allow()
+	req.NotNil(done)
+	done(syscall.EIO)
+}
+
+// failingFileSystem fails to open files while failing is set, as a stale NFS mount does.
+type failingFileSystem struct {
+	http.FileSystem
+	mu      sync.Mutex
+	failing bool
+	opens   int
+}
+
+func (fs *failingFileSystem) Open(name string) (http.File, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.opens++
+	if fs.failing {
+		return nil, &os.PathError{Op: "open", Path: name, Err: syscall.EIO}
+	}
+	return fs.FileSystem.Open(name)
+}
+
+func (fs *failingFileSystem) setFailing(failing bool) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.failing = failing
+}
+
+func Test_serveContent_breaker(t *testing.T) {
+	req := require.New(t)
+	root := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "my-bucket"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "my-bucket", "my-backup.tar.gz"), []byte("content"), 0644))
+
+	fs := &failingFileSystem{FileSystem: http.Dir(root), failing: true}
+	breaker, clock := newTestCircuitBreaker(plugin.FileserverBreaker{Failures: 3, Window: time.Minute, Cooldown: 30 * time.Second})
+	app := fiber.New()
+	app.Get("/*", serveContent(fs, nil, breaker))
+
+	get := func() *http.Response {
+		resp, err := app.Test(httptest.NewRequest(http.MethodGet, "/my-bucket/my-backup.tar.gz", nil))
+		req.NoError(err)
+		return resp
+	}
+
+	for i := 0; i < 3; i++ {
+		req.Equal(http.StatusInternalServerError, get().StatusCode)
+	}
+	req.Equal(3, fs.opens)
+
+	// Tripped: requests fail fast without opening files
+	resp := get()
+	req.Equal(http.StatusServiceUnavailable, resp.StatusCode)
+	req.Equal("30", resp.Header.Get(fiber.HeaderRetryAfter))
+	req.Equal(3, fs.opens)
+
+	// The mount recovers and the probe after the cooldown closes the breaker
+	fs.setFailing(false)
+	clock.Advance(30 * time.Second)
+	resp = get()
+	req.Equal(http.StatusOK, resp.StatusCode)
+	body, err := io.ReadAll(resp.Body)
+	req.NoError(err)
+	req.Equal("content", string(body))
+	req.Eventually(func() bool {
+		done, _ := breaker.allow()
+		if done != nil {
+			done(nil)
+		}
+		return done != nil
+	}, time.Second, 10*time.Millisecond)
+	req.Equal(http.StatusOK, get().StatusCode)
+}
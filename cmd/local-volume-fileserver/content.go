@@ -0,0 +1,210 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/adaptor"
+	"github.com/replicatedhq/local-volume-provider/pkg/plugin"
+)
+
+// serveObjectOperation is the operation label for objects downloaded through signed URLs.
+const serveObjectOperation = "ServeObject"
+
+// serveContent returns a handler that serves files from root using http.ServeContent,
+// which takes care of Range, multi-range and If-Range requests so that clients can
+// download part of an object or resume an interrupted download.
+// If an encryption key is given, objects are decrypted as they are served. While the breaker is open, requests are
+// answered with 503 without touching the volume.
+func serveContent(root http.FileSystem, encryptionKey []byte, breaker *circuitBreaker) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		start := time.Now()
+		done, retryAfter := breaker.allow()
+		if done == nil {
+			return rejectOpen(c, retryAfter)
+		}
+
+		req, err := adaptor.ConvertRequest(c, false)
+		if err != nil {
+			done(nil)
+			return c.SendStatus(http.StatusInternalServerError)
+		}
+
+		name := path.Clean("/" + req.URL.Path)
+		file, err := root.Open(name)
+		if os.IsNotExist(err) {
+			for _, compression := range plugin.CompressionFormats {
+				if compressed, cerr := root.Open(name + plugin.CompressionSuffix(compression)); cerr == nil {
+					return serveDecoded(c, compressed, compression, encryptionKey, done, start)
+				}
+			}
+		}
+		if err != nil {
+			if os.IsNotExist(err) {
+				done(nil)
+				return c.SendStatus(http.StatusNotFound)
+			}
+			done(err)
+			return c.SendStatus(http.StatusInternalServerError)
+		}
+
+		stat, err := file.Stat()
+		if err != nil {
+			file.Close()
+			done(err)
+			return c.SendStatus(http.StatusInternalServerError)
+		}
+		if stat.IsDir() {
+			file.Close()
+			done(nil)
+			return c.SendStatus(http.StatusNotFound)
+		}
+		if encryptionKey != nil {
+			return serveDecoded(c, file, "", encryptionKey, done, start)
+		}
+
+		// Stream the body through a pipe rather than buffering it, as objects can be several gigabytes
+		pr, pw := io.Pipe()
+		w := newStreamingResponseWriter(pw)
+		body := &readErrorFile{File: file}
+		go func() {
+			defer file.Close()
+			http.ServeContent(w, req, stat.Name(), stat.ModTime(), body)
+			w.WriteHeader(http.StatusOK) // no-op unless ServeContent wrote nothing
+			pw.Close()
+			done(body.err)
+
+			plugin.ObserveBytes(serveObjectOperation, w.written)
+			plugin.ObserveOperation(serveObjectOperation, start, w.err)
+		}()
+
+		status := <-w.status
+		c.Status(status)
+		for key, values := range w.header {
+			if key == "Content-Length" {
+				continue
+			}
+			for _, value := range values {
+				c.Response().Header.Add(key, value)
+			}
+		}
+
+		size := -1
+		if contentLength := w.header.Get("Content-Length"); contentLength != "" {
+			if n, err := strconv.Atoi(contentLength); err == nil {
+				size = n
+			}
+		}
+		c.Response().SetBodyStream(pr, size)
+
+		return nil
+	}
+}
+
+// serveDecoded streams an object the plugin stored compressed or encrypted, decoding it on the fly.
+// Range requests are not supported for these objects, so the whole object is always sent.
+func serveDecoded(c *fiber.Ctx, f http.File, compression string, encryptionKey []byte, done func(error), start time.Time) error {
+	file := &readErrorFile{File: f}
+	fail := func(err error) error {
+		file.Close()
+		done(file.err)
+		plugin.ObserveOperation(serveObjectOperation, start, err)
+		return c.SendStatus(http.StatusInternalServerError)
+	}
+
+	var r io.Reader = file
+	if encryptionKey != nil {
+		var err error
+		if r, err = plugin.NewDecryptingReader(file, encryptionKey); err != nil {
+			return fail(err)
+		}
+	}
+	var decoder io.Closer
+	if compression != "" {
+		dr, err := plugin.NewDecompressingReader(r, compression)
+		if err != nil {
+			return fail(err)
+		}
+		r, decoder = dr, dr
+	}
+
+	c.Set(fiber.HeaderContentType, fiber.MIMEOctetStream)
+	c.Response().SetBodyStream(&decodedBody{Reader: r, file: file, decoder: decoder, done: done, start: start}, -1)
+	return nil
+}
+
+// decodedBody is the response body of a compressed or encrypted object. Metrics are recorded once it is closed.
+type decodedBody struct {
+	io.Reader
+	file    *readErrorFile
+	decoder io.Closer
+	// done reports the outcome of reading the file to the circuit breaker
+	done    func(error)
+	start   time.Time
+	written int64
+	err     error
+}
+
+func (b *decodedBody) Read(p []byte) (int, error) {
+	n, err := b.Reader.Read(p)
+	b.written += int64(n)
+	if err != nil && err != io.EOF {
+		b.err = err
+	}
+	return n, err
+}
+
+func (b *decodedBody) Close() error {
+	plugin.ObserveBytes(serveObjectOperation, b.written)
+	plugin.ObserveOperation(serveObjectOperation, b.start, b.err)
+	b.done(b.file.err)
+	if b.decoder != nil {
+		b.decoder.Close()
+	}
+	return b.file.Close()
+}
+
+// streamingResponseWriter is an http.ResponseWriter that reports the status code once the
+// headers are written and sends the body to a pipe.
+type streamingResponseWriter struct {
+	header  http.Header
+	body    *io.PipeWriter
+	status  chan int
+	once    sync.Once
+	written int64
+	err     error
+}
+
+func newStreamingResponseWriter(body *io.PipeWriter) *streamingResponseWriter {
+	return &streamingResponseWriter{
+		header: make(http.Header),
+		body:   body,
+		status: make(chan int, 1),
+	}
+}
+
+func (w *streamingResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *streamingResponseWriter) WriteHeader(statusCode int) {
+	w.once.Do(func() {
+		w.status <- statusCode
+	})
+}
+
+func (w *streamingResponseWriter) Write(p []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	n, err := w.body.Write(p)
+	w.written += int64(n)
+	if err != nil {
+		w.err = err
+	}
+	return n, err
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/klauspost/compress/zstd"
+	"github.com/replicatedhq/local-volume-provider/pkg/plugin"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_serveContent(t *testing.T) {
+	root := t.TempDir()
+	content := make([]byte, 1000)
+	for i := range content {
+		content[i] = byte(i % 251)
+	}
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "my-bucket", "backups"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "my-bucket", "backups", "my-backup.tar.gz"), content, 0644))
+
+	var compressed bytes.Buffer
+	gz := gzip.NewWriter(&compressed)
+	_, err := gz.Write(content)
+	require.NoError(t, err)
+	require.NoError(t, gz.Close())
+	require.NoError(t, os.WriteFile(filepath.Join(root, "my-bucket", "backups", "my-backup-logs.gz"+plugin.CompressedSuffix), compressed.Bytes(), 0644))
+
+	zw, err := zstd.NewWriter(nil)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(root, "my-bucket", "backups", "my-backup-podvolumebackups.json.gz"+plugin.ZstdCompressedSuffix), zw.EncodeAll(content, nil), 0644))
+
+	app := fiber.New()
+	app.Get("/*", serveContent(http.Dir(root), nil, nil))
+
+	tests := []struct {
+		name       string
+		path       string
+		rangeValue string
+		wantStatus int
+		wantBody   []byte
+	}{
+		{
+			name:       "whole file",
+			path:       "/my-bucket/backups/my-backup.tar.gz",
+			wantStatus: http.StatusOK,
+			wantBody:   content,
+		},
+		{
+			name:       "byte range",
+			path:       "/my-bucket/backups/my-backup.tar.gz",
+			rangeValue: "bytes=100-199",
+			wantStatus: http.StatusPartialContent,
+			wantBody:   content[100:200],
+		},
+		{
+			name:       "unsatisfiable range",
+			path:       "/my-bucket/backups/my-backup.tar.gz",
+			rangeValue: "bytes=2000-2999",
+			wantStatus: http.StatusRequestedRangeNotSatisfiable,
+		},
+		{
+			name:       "compressed object is decompressed",
+			path:       "/my-bucket/backups/my-backup-logs.gz",
+			wantStatus: http.StatusOK,
+			wantBody:   content,
+		},
+		{
+			name:       "zstd compressed object is decompressed",
+			path:       "/my-bucket/backups/my-backup-podvolumebackups.json.gz",
+			wantStatus: http.StatusOK,
+			wantBody:   content,
+		},
+		{
+			name:       "missing file",
+			path:       "/my-bucket/backups/missing.tar.gz",
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:       "directory",
+			path:       "/my-bucket/backups",
+			wantStatus: http.StatusNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if tt.rangeValue != "" {
+				req.Header.Set("Range", tt.rangeValue)
+			}
+
+			resp, err := app.Test(req)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+
+			require.Equal(t, tt.wantStatus, resp.StatusCode)
+			if tt.wantBody != nil {
+				body, err := io.ReadAll(resp.Body)
+				require.NoError(t, err)
+				require.Equal(t, tt.wantBody, body)
+			}
+		})
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/replicatedhq/local-volume-provider/pkg/plugin"
+)
+
+// signingGuard rejects requests that are not for a valid signed URL and, when an auth token is configured,
+// requests that do not present it as a bearer token.
+type signingGuard struct {
+	// signingKey returns the key URLs are signed with. It is called for every request so that rotating
+	// the secret invalidates previously signed URLs.
+	signingKey         func() ([]byte, error)
+	algorithm          string
+	clockSkewTolerance time.Duration
+	// authToken returns the token requests must present, or is nil if none is required.
+	// Like the signing key, it is called for every request so the token can be rotated.
+	authToken func() ([]byte, error)
+}
+
+// handler checks the bearer token before the signature, so a leaked signed URL is useless without the token.
+// Without a token configured, expired URLs are rejected with 403 and otherwise invalid ones with 400.
+// With one, requests without the token are rejected with 401 and any URL that fails verification with 403.
+func (g signingGuard) handler(c *fiber.Ctx) error {
+	if rejected, err := g.rejectWithoutToken(c); rejected {
+		return err
+	}
+
+	signingKey, err := g.signingKey()
+	if err != nil {
+		return c.SendStatus(http.StatusInternalServerError)
+	}
+	err = plugin.CheckSignedURL(signedURLFromRequest(c), signingKey, g.algorithm, g.clockSkewTolerance)
+	if errors.Is(err, plugin.ErrSignedURLExpired) || errors.Is(err, plugin.ErrSignedURLVersion) {
+		return c.SendStatus(http.StatusForbidden)
+	} else if errors.Is(err, plugin.ErrSignedURLInvalid) {
+		if g.authToken != nil {
+			return c.SendStatus(http.StatusForbidden)
+		}
+		return c.SendStatus(http.StatusBadRequest)
+	} else if err != nil {
+		return c.SendStatus(http.StatusInternalServerError)
+	}
+
+	return c.Next()
+}
+
+// tokenHandler only checks the bearer token, rejecting requests without it with 401. It guards the endpoints
+// that are not for signed URLs, such as /usage, which are open when no token is configured.
+func (g signingGuard) tokenHandler(c *fiber.Ctx) error {
+	if rejected, err := g.rejectWithoutToken(c); rejected {
+		return err
+	}
+	return c.Next()
+}
+
+// rejectWithoutToken answers requests that do not present the configured token with 401, returning truthy if it did.
+func (g signingGuard) rejectWithoutToken(c *fiber.Ctx) (bool, error) {
+	if g.authToken == nil {
+		return false, nil
+	}
+	token, err := g.authToken()
+	if err != nil {
+		return true, c.SendStatus(http.StatusInternalServerError)
+	}
+	if !hasBearerToken(c.Get(fiber.HeaderAuthorization), token) {
+		c.Set(fiber.HeaderWWWAuthenticate, "Bearer")
+		return true, c.SendStatus(http.StatusUnauthorized)
+	}
+	return false, nil
+}
+
+// hasBearerToken returns truthy if the Authorization header value holds the token as a bearer token.
+func hasBearerToken(header string, token []byte) bool {
+	scheme, credentials, ok := strings.Cut(header, " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") || len(token) == 0 {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(strings.TrimSpace(credentials)), token) == 1
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/replicatedhq/local-volume-provider/pkg/plugin"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_signingGuard(t *testing.T) {
+	signingKey := []byte("signing-key")
+	authToken := []byte("org-token")
+
+	signedURL := func(key []byte, ttl time.Duration) string {
+		u, err := url.Parse("http://fileserver.example.com/my-bucket/backups/my-backup.tar.gz")
+		require.NoError(t, err)
+		require.NoError(t, plugin.SignURL(u, key, "", ttl))
+		return u.String()
+	}
+	unknownVersion := func() string {
+		u, err := url.Parse(signedURL(signingKey, time.Hour))
+		require.NoError(t, err)
+		query := u.Query()
+		query.Set("v", "99")
+		u.RawQuery = query.Encode()
+		return u.String()
+	}
+
+	tests := []struct {
+		name          string
+		requireToken  bool
+		authorization string
+		url           string
+		wantStatus    int
+	}{
+		{
+			name:       "no token required -- valid signature",
+			url:        signedURL(signingKey, time.Hour),
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "no token required -- bad signature",
+			url:        signedURL([]byte("other-key"), time.Hour),
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:          "valid token -- valid signature",
+			requireToken:  true,
+			authorization: "Bearer org-token",
+			url:           signedURL(signingKey, time.Hour),
+			wantStatus:    http.StatusOK,
+		},
+		{
+			name:          "valid token -- bad signature",
+			requireToken:  true,
+			authorization: "Bearer org-token",
+			url:           signedURL([]byte("other-key"), time.Hour),
+			wantStatus:    http.StatusForbidden,
+		},
+		{
+			name:          "valid token -- expired signature",
+			requireToken:  true,
+			authorization: "Bearer org-token",
+			url:           signedURL(signingKey, -time.Hour),
+			wantStatus:    http.StatusForbidden,
+		},
+		{
+			name:       "no token required -- unknown version",
+			url:        unknownVersion(),
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:         "missing token",
+			requireToken: true,
+			url:          signedURL(signingKey, time.Hour),
+			wantStatus:   http.StatusUnauthorized,
+		},
+		{
+			name:          "wrong token",
+			requireToken:  true,
+			authorization: "Bearer leaked-url-only",
+			url:           signedURL(signingKey, time.Hour),
+			wantStatus:    http.StatusUnauthorized,
+		},
+		{
+			name:          "token with another scheme",
+			requireToken:  true,
+			authorization: "Basic org-token",
+			url:           signedURL(signingKey, time.Hour),
+			wantStatus:    http.StatusUnauthorized,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			guard := signingGuard{
+				signingKey:         func() ([]byte, error) { return signingKey, nil },
+				clockSkewTolerance: time.Minute,
+			}
+			if tt.requireToken {
+				guard.authToken = func() ([]byte, error) { return authToken, nil }
+			}
+
+			app := fiber.New()
+			app.Use(guard.handler)
+			app.Get("/*", func(c *fiber.Ctx) error { return c.SendString("ok") })
+
+			r := httptest.NewRequest(http.MethodGet, tt.url, nil)
+			if tt.authorization != "" {
+				r.Header.Set("Authorization", tt.authorization)
+			}
+			resp, err := app.Test(r)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+			require.Equal(t, tt.wantStatus, resp.StatusCode)
+		})
+	}
+}
+
+func Test_signingGuard_tokenHandler(t *testing.T) {
+	tests := []struct {
+		name          string
+		requireToken  bool
+		authorization string
+		path          string
+		wantStatus    int
+	}{
+		{
+			name:       "no token required -- usage is open",
+			path:       "/usage/my-bucket",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:         "missing token",
+			requireToken: true,
+			path:         "/usage/my-bucket",
+			wantStatus:   http.StatusUnauthorized,
+		},
+		{
+			name:          "valid token",
+			requireToken:  true,
+			authorization: "Bearer org-token",
+			path:          "/usage/my-bucket",
+			wantStatus:    http.StatusOK,
+		},
+		{
+			name:          "objects still require a signed URL",
+			requireToken:  true,
+			authorization: "Bearer org-token",
+			path:          "/my-bucket/backups/my-backup.tar.gz",
+			wantStatus:    http.StatusForbidden,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			guard := signingGuard{
+				signingKey: func() ([]byte, error) { return []byte("signing-key"), nil },
+			}
+			if tt.requireToken {
+				guard.authToken = func() ([]byte, error) { return []byte("org-token"), nil }
+			}
+
+			app := fiber.New()
+			app.Get("/usage/:bucket", guard.tokenHandler, func(c *fiber.Ctx) error { return c.SendString("usage") })
+			app.Use(guard.handler)
+			app.Get("/*", func(c *fiber.Ctx) error { return c.SendString("ok") })
+
+			r := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if tt.authorization != "" {
+				r.Header.Set("Authorization", tt.authorization)
+			}
+			resp, err := app.Test(r)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+			require.Equal(t, tt.wantStatus, resp.StatusCode)
+		})
+	}
+}
+
+func Test_signedURL_specialCharacters(t *testing.T) {
+	signingKey := []byte("signing-key")
+	root := t.TempDir()
+	guard := signingGuard{
+		signingKey:         func() ([]byte, error) { return signingKey, nil },
+		clockSkewTolerance: time.Minute,
+	}
+	app := fiber.New()
+	app.Use(guard.handler)
+	app.Get("/*", serveContent(http.Dir(root), nil, nil))
+
+	for _, key := range []string{
+		"backups/my backup/my backup.tar.gz",
+		"backups/my-backup/#1.tar.gz",
+		"backups/my-backup/what?.tar.gz",
+		"backups/my-backup/100%.tar.gz",
+		"backups/sauvegarde-été/données-日本.tar.gz",
+		"backups/my-backup/a;b,c=d+(e)!.tar.gz",
+	} {
+		t.Run(key, func(t *testing.T) {
+			req := require.New(t)
+			path := filepath.Join(root, "my-bucket", filepath.FromSlash(key))
+			req.NoError(os.MkdirAll(filepath.Dir(path), 0755))
+			req.NoError(os.WriteFile(path, []byte(key), 0644))
+
+			urlPath := "/my-bucket/" + key
+			signed := &url.URL{Scheme: "http", Host: "fileserver.example.com", Path: urlPath, RawPath: plugin.EscapeKeyPath(urlPath)}
+			req.NoError(plugin.SignURL(signed, signingKey, "", time.Hour))
+
+			// Clients may escape the path differently than it was signed
+			reescaped, err := url.Parse(signed.String())
+			req.NoError(err)
+			reescaped.RawPath = ""
+
+			for _, u := range []string{signed.String(), reescaped.String()} {
+				resp, err := app.Test(httptest.NewRequest(http.MethodGet, u, nil))
+				req.NoError(err)
+				body, err := io.ReadAll(resp.Body)
+				req.NoError(err)
+				resp.Body.Close()
+				req.Equal(http.StatusOK, resp.StatusCode, u)
+				req.Equal(key, string(body))
+			}
+		})
+	}
+}
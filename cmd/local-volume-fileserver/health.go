@@ -0,0 +1,35 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/replicatedhq/local-volume-provider/pkg/plugin"
+)
+
+// healthHandler reports that the process is up, for use as a liveness probe.
+func healthHandler(c *fiber.Ctx) error {
+	return c.SendString("ok")
+}
+
+// readyHandler reports whether the volumes mounted beneath mountPoint can be served and written to,
+// for use as a readiness probe. Each bucket is its own volume mounted directly beneath the mount point.
+func readyHandler(mountPoint string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		entries, err := os.ReadDir(mountPoint)
+		if err != nil {
+			return c.Status(http.StatusServiceUnavailable).SendString("mount point " + mountPoint + " is not available: " + err.Error())
+		}
+		for _, entry := range entries {
+			if !entry.IsDir() {
+				continue
+			}
+			if err := plugin.CheckVolumeReady(filepath.Join(mountPoint, entry.Name())); err != nil {
+				return c.Status(http.StatusServiceUnavailable).SendString(err.Error())
+			}
+		}
+		return c.SendString("ok")
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_healthHandlers(t *testing.T) {
+	tests := []struct {
+		name       string
+		path       string
+		setup      func(t *testing.T, root string) string
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			name:       "liveness",
+			path:       "/healthz",
+			setup:      func(t *testing.T, root string) string { return root },
+			wantStatus: http.StatusOK,
+		},
+		{
+			name: "writable volume -- ready",
+			path: "/readyz",
+			setup: func(t *testing.T, root string) string {
+				require.NoError(t, os.Mkdir(filepath.Join(root, "my-bucket"), 0755))
+				return root
+			},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name: "non-writable volume -- not ready",
+			path: "/readyz",
+			setup: func(t *testing.T, root string) string {
+				require.NoError(t, os.Mkdir(filepath.Join(root, "my-bucket"), 0555))
+				return root
+			},
+			wantStatus: http.StatusServiceUnavailable,
+			wantBody:   "is not writable",
+		},
+		{
+			name:       "missing mount point -- not ready",
+			path:       "/readyz",
+			setup:      func(t *testing.T, root string) string { return filepath.Join(root, "missing") },
+			wantStatus: http.StatusServiceUnavailable,
+			wantBody:   "is not available",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mountPoint := tt.setup(t, t.TempDir())
+
+			app := fiber.New()
+			app.Get("/healthz", healthHandler)
+			app.Get("/readyz", readyHandler(mountPoint))
+
+			resp, err := app.Test(httptest.NewRequest(http.MethodGet, tt.path, nil))
+			require.NoError(t, err)
+			defer resp.Body.Close()
+
+			require.Equal(t, tt.wantStatus, resp.StatusCode)
+			body, err := io.ReadAll(resp.Body)
+			require.NoError(t, err)
+			require.Contains(t, string(body), tt.wantBody)
+		})
+	}
+}
@@ -4,11 +4,17 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
 
 	"github.com/gofiber/fiber/v2"
-	"github.com/gofiber/fiber/v2/middleware/filesystem"
+	"github.com/gofiber/fiber/v2/middleware/adaptor"
 	"github.com/gofiber/fiber/v2/middleware/logger"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/replicatedhq/local-volume-provider/pkg/plugin"
 	"github.com/replicatedhq/local-volume-provider/pkg/version"
 )
@@ -19,14 +25,18 @@ func main() {
 		os.Exit(0)
 	}
 
-	app := fiber.New()
+	timeouts, err := fileserverTimeoutsFromEnv()
+	if err != nil {
+		log.Fatalf("Invalid timeouts: %v", err)
+	}
+	app := newApp(timeouts)
 
 	mountPoint := os.Getenv("MOUNT_POINT")
 	if mountPoint == "" {
 		mountPoint = "/var/velero-local-volume-provider"
 	}
 
-	_, err := os.Stat(mountPoint)
+	_, err = os.Stat(mountPoint)
 	if err != nil {
 		log.Fatalf("Could not find mountpoint: %s", mountPoint)
 	}
@@ -36,26 +46,103 @@ func main() {
 		return c.SendString("Hello, World!")
 	})
 
+	// liveness and readiness probes
+	app.Get("/healthz", healthHandler)
+	app.Get("/readyz", readyHandler(mountPoint))
+
+	// metrics endpoint
+	if err := plugin.RegisterMetrics(prometheus.DefaultRegisterer); err != nil {
+		log.Fatalf("Could not register metrics: %v", err)
+	}
+	app.Get("/metrics", adaptor.HTTPHandler(promhttp.Handler()))
+
 	app.Use(logger.New())
 
+	clockSkewTolerance, err := plugin.ParseClockSkewTolerance(os.Getenv("CLOCK_SKEW_TOLERANCE"))
+	if err != nil {
+		log.Fatalf("Invalid clock skew tolerance: %v", err)
+	}
+
 	// signing guard middleware
-	app.Use(func(c *fiber.Ctx) error {
-		rawUrl := c.Request().URI().String()
-		valid, err := plugin.IsSignedURLValid(rawUrl, os.Getenv("VELERO_NAMESPACE"))
+	guard := signingGuard{
+		signingKey: func() ([]byte, error) {
+			return plugin.GetSigningKey(os.Getenv("VELERO_NAMESPACE"), os.Getenv("SIGNING_SECRET_NAME"))
+		},
+		algorithm:          os.Getenv("SIGNING_ALGORITHM"),
+		clockSkewTolerance: clockSkewTolerance,
+	}
+	if secretName := os.Getenv("AUTH_SECRET_NAME"); secretName != "" {
+		guard.authToken = func() ([]byte, error) {
+			return plugin.GetAuthToken(os.Getenv("VELERO_NAMESPACE"), secretName)
+		}
+	}
+
+	// bucket usage endpoint, cached as walking a large volume is slow. It is not for a signed URL,
+	// so it is registered ahead of the signing guard and only requires the bearer token.
+	usageCacheTTL, err := plugin.ParseUsageCacheTTL(os.Getenv("USAGE_CACHE_TTL"))
+	if err != nil {
+		log.Fatalf("Invalid usage cache TTL: %v", err)
+	}
+	locationConfig := func(bucket string) (map[string]string, error) {
+		return plugin.GetLocationConfig(os.Getenv("VELERO_NAMESPACE"), bucket)
+	}
+	app.Get("/usage/:bucket", guard.tokenHandler, usageHandler(mountPoint, plugin.NewUsageCache(usageCacheTTL), locationConfig))
+
+	app.Use(guard.handler)
+
+	var encryptionKey []byte
+	if secretName := os.Getenv("ENCRYPTION_SECRET_NAME"); secretName != "" {
+		encryptionKey, err = plugin.GetEncryptionKey(os.Getenv("VELERO_NAMESPACE"), secretName)
 		if err != nil {
-			return c.SendStatus(http.StatusInternalServerError)
+			log.Fatalf("Could not get encryption key: %v", err)
 		}
-		if !valid {
-			return c.SendStatus(http.StatusBadRequest)
+	}
+
+	breaker, err := fileserverBreakerFromEnv()
+	if err != nil {
+		log.Fatalf("Invalid circuit breaker: %v", err)
+	}
+
+	// static file serving, with support for range requests
+	app.Get("/*", serveContent(http.Dir(mountPoint), encryptionKey, newCircuitBreaker(breaker)))
+
+	port := 3000
+	if p := os.Getenv("FILESERVER_PORT"); p != "" {
+		port, err = strconv.Atoi(p)
+		if err != nil || port < 1 || port > 65535 {
+			log.Fatalf("Invalid port: %s", p)
 		}
+	}
 
-		return c.Next()
-	})
+	shutdown := make(chan os.Signal, 1)
+	signal.Notify(shutdown, syscall.SIGTERM)
+	shutdownDone := shutdownOnSignal(app, shutdownTimeout, shutdown)
 
-	// static file serving middleware
-	app.Use(filesystem.New(filesystem.Config{
-		Root: http.Dir(mountPoint),
-	}))
+	if err := app.Listen(fmt.Sprintf(":%d", port)); err != nil {
+		log.Fatalf("Could not serve: %v", err)
+	}
+	// Listen returns as soon as shutdown starts; wait for in-flight requests before exiting
+	if err := <-shutdownDone; err != nil {
+		log.Fatalf("Could not shut down cleanly: %v", err)
+	}
+}
+
+// signedURLFromRequest returns the URL of the request as the client was given it. When the fileserver is
+// published behind a TLS ingress, the scheme and host it sees differ from the ones that were signed.
+// Clients and the fileserver may escape the path differently, so it is escaped again the way it was signed.
+func signedURLFromRequest(c *fiber.Ctx) string {
+	rawUrl := c.Request().URI().String()
 
-	app.Listen(":3000")
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		return rawUrl
+	}
+	u.RawPath = plugin.EscapeKeyPath(u.Path)
+	if scheme := os.Getenv("FILESERVER_SCHEME"); scheme != "" {
+		u.Scheme = scheme
+	}
+	if host := os.Getenv("FILESERVER_EXTERNAL_HOST"); host != "" {
+		u.Host = host
+	}
+	return u.String()
 }
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"log"
+	"os"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// shutdownTimeout is how long in-flight requests are given to complete once the fileserver is asked to stop.
+const shutdownTimeout = 30 * time.Second
+
+// shutdownOnSignal shuts the app down once a signal is received, closing its listener and waiting up to timeout
+// for in-flight requests to complete. The returned channel receives the result once the shutdown is done.
+func shutdownOnSignal(app *fiber.App, timeout time.Duration, signals <-chan os.Signal) <-chan error {
+	done := make(chan error, 1)
+	go func() {
+		sig := <-signals
+		log.Printf("Received %s, shutting down", sig)
+		done <- app.ShutdownWithTimeout(timeout)
+	}()
+	return done
+}
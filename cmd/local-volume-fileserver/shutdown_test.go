@@ -0,0 +1,63 @@
+package main
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_shutdownOnSignal(t *testing.T) {
+	req := require.New(t)
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	app := fiber.New(fiber.Config{DisableStartupMessage: true})
+	app.Get("/slow", func(c *fiber.Ctx) error {
+		close(started)
+		<-release
+		return c.SendString("done")
+	})
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	req.NoError(err)
+	signals := make(chan os.Signal, 1)
+	shutdownDone := shutdownOnSignal(app, 5*time.Second, signals)
+	go app.Listener(ln)
+
+	type result struct {
+		body string
+		err  error
+	}
+	results := make(chan result, 1)
+	go func() {
+		resp, err := http.Get("http://" + ln.Addr().String() + "/slow")
+		if err != nil {
+			results <- result{err: err}
+			return
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		results <- result{body: string(body), err: err}
+	}()
+	<-started
+
+	signals <- syscall.SIGTERM
+	select {
+	case <-shutdownDone:
+		t.Fatal("shutdown finished while a request was in flight")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	res := <-results
+	req.NoError(res.err)
+	req.Equal("done", res.body)
+	req.NoError(<-shutdownDone)
+}
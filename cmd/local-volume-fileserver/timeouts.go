@@ -0,0 +1,40 @@
+package main
+
+import (
+	"os"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/replicatedhq/local-volume-provider/pkg/plugin"
+	"github.com/valyala/fasthttp"
+)
+
+// fileserverTimeoutsFromEnv returns the timeouts set by the plugin in the environment, or their defaults.
+func fileserverTimeoutsFromEnv() (plugin.FileserverTimeouts, error) {
+	return plugin.ParseFileserverTimeouts(
+		os.Getenv("FILESERVER_READ_HEADER_TIMEOUT"),
+		os.Getenv("FILESERVER_READ_TIMEOUT"),
+		os.Getenv("FILESERVER_WRITE_TIMEOUT"),
+		os.Getenv("FILESERVER_IDLE_TIMEOUT"),
+	)
+}
+
+// newApp returns the fileserver app with its timeouts set. The read timeout of fasthttp starts before the request
+// line, so it is set to the header timeout, and the rest of the request is given the read timeout once its headers
+// are received. As with net/http, a zero header timeout falls back to the read timeout.
+func newApp(timeouts plugin.FileserverTimeouts) *fiber.App {
+	readHeader := timeouts.ReadHeader
+	if readHeader == 0 {
+		readHeader = timeouts.Read
+	}
+	app := fiber.New(fiber.Config{
+		ReadTimeout:  readHeader,
+		WriteTimeout: timeouts.Write,
+		IdleTimeout:  timeouts.Idle,
+	})
+	if timeouts.Read > 0 {
+		app.Server().HeaderReceived = func(*fasthttp.RequestHeader) fasthttp.RequestConfig {
+			return fasthttp.RequestConfig{ReadTimeout: timeouts.Read}
+		}
+	}
+	return app
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/replicatedhq/local-volume-provider/pkg/plugin"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_newApp_readHeaderTimeout(t *testing.T) {
+	req := require.New(t)
+
+	app := newApp(plugin.FileserverTimeouts{ReadHeader: 100 * time.Millisecond, Read: time.Minute})
+	app.Get("/ok", func(c *fiber.Ctx) error {
+		return c.SendString("ok")
+	})
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	req.NoError(err)
+	go app.Listener(ln)
+	defer app.Shutdown()
+
+	// a request sent at once is served
+	resp, err := http.Get("http://" + ln.Addr().String() + "/ok")
+	req.NoError(err)
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	req.NoError(err)
+	req.Equal("ok", string(body))
+
+	// a client dribbling its headers is cut off, however long it keeps sending
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	req.NoError(err)
+	defer conn.Close()
+	closed := make(chan error, 1)
+	go func() {
+		_, err := bufio.NewReader(conn).ReadByte()
+		closed <- err
+	}()
+
+	start := time.Now()
+	_, err = conn.Write([]byte("GET /ok HTTP/1.1\r\nHost: localhost\r\n"))
+	req.NoError(err)
+	for {
+		select {
+		case <-closed:
+			req.Less(time.Since(start), time.Second)
+			return
+		case <-time.After(20 * time.Millisecond):
+		}
+		req.Less(time.Since(start), 2*time.Second, "connection was not closed")
+		// write errors once the server has closed the connection are expected
+		conn.Write([]byte("X-Slow: a\r\n"))
+	}
+}
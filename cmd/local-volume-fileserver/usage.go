@@ -0,0 +1,34 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/replicatedhq/local-volume-provider/pkg/plugin"
+)
+
+// usageHandler reports the bytes used by the objects of the bucket named in the path and their number, as JSON.
+// Each bucket is its own volume mounted directly beneath the mount point. The objects are counted as BucketUsage
+// counts them, for the config locationConfig returns for the bucket, which is nil for the defaults.
+func usageHandler(mountPoint string, cache *plugin.UsageCache, locationConfig func(bucket string) (map[string]string, error)) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		bucket := c.Params("bucket")
+		if bucket == "" || bucket == "." || bucket == ".." || filepath.Base(bucket) != bucket {
+			return c.SendStatus(http.StatusBadRequest)
+		}
+
+		config, err := locationConfig(bucket)
+		if err != nil {
+			return c.Status(http.StatusInternalServerError).SendString(err.Error())
+		}
+		usage, err := cache.LocationUsage(filepath.Join(mountPoint, bucket), config)
+		if os.IsNotExist(err) {
+			return c.SendStatus(http.StatusNotFound)
+		} else if err != nil {
+			return c.Status(http.StatusInternalServerError).SendString(err.Error())
+		}
+		return c.JSON(usage)
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/replicatedhq/local-volume-provider/pkg/plugin"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_usageHandler(t *testing.T) {
+	tests := []struct {
+		name       string
+		path       string
+		config     map[string]string
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			name:       "bucket with nested objects",
+			path:       "/usage/my-bucket",
+			wantStatus: http.StatusOK,
+			wantBody:   `{"totalBytes":9,"objectCount":2}`,
+		},
+		{
+			name:       "empty bucket",
+			path:       "/usage/empty-bucket",
+			wantStatus: http.StatusOK,
+			wantBody:   `{"totalBytes":0,"objectCount":0}`,
+		},
+		{
+			name:       "location with a root sub path and an audit log",
+			path:       "/usage/my-bucket",
+			config:     map[string]string{"rootSubPath": "backups", "auditLogPath": "backups/my-backup/audit.log"},
+			wantStatus: http.StatusOK,
+			wantBody:   `{"totalBytes":9,"objectCount":2}`,
+		},
+		{
+			name:       "invalid location config",
+			path:       "/usage/my-bucket",
+			config:     map[string]string{"rootSubPath": "../escape"},
+			wantStatus: http.StatusInternalServerError,
+		},
+		{
+			name:       "missing bucket",
+			path:       "/usage/missing",
+			wantStatus: http.StatusNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mountPoint := t.TempDir()
+			nested := filepath.Join(mountPoint, "my-bucket", "backups", "my-backup")
+			require.NoError(t, os.MkdirAll(nested, 0755))
+			require.NoError(t, os.WriteFile(filepath.Join(nested, "my-backup.tar.gz"), []byte("backup"), 0644))
+			require.NoError(t, os.WriteFile(filepath.Join(nested, "my-backup.tar.gz.sha256"), []byte("digest"), 0644))
+			require.NoError(t, os.WriteFile(filepath.Join(nested, "logs.gz"), []byte("log"), 0644))
+			if tt.config != nil {
+				// neither the audit log nor files outside the root sub path are objects of the location
+				require.NoError(t, os.WriteFile(filepath.Join(nested, "audit.log"), []byte("{}\n"), 0644))
+				require.NoError(t, os.WriteFile(filepath.Join(mountPoint, "my-bucket", "other-location"), []byte("other"), 0644))
+			}
+			require.NoError(t, os.Mkdir(filepath.Join(mountPoint, "empty-bucket"), 0755))
+
+			app := fiber.New()
+			locationConfig := func(bucket string) (map[string]string, error) { return tt.config, nil }
+			app.Get("/usage/:bucket", usageHandler(mountPoint, plugin.NewUsageCache(0), locationConfig))
+
+			resp, err := app.Test(httptest.NewRequest(http.MethodGet, tt.path, nil))
+			require.NoError(t, err)
+			defer resp.Body.Close()
+
+			require.Equal(t, tt.wantStatus, resp.StatusCode)
+			body, err := io.ReadAll(resp.Body)
+			require.NoError(t, err)
+			if tt.wantBody != "" {
+				require.JSONEq(t, tt.wantBody, string(body))
+			}
+		})
+	}
+}
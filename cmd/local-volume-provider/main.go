@@ -3,12 +3,16 @@ package main
 import (
 	"fmt"
 	"os"
+	"os/signal"
+	"sync"
+	"syscall"
 
 	"github.com/replicatedhq/local-volume-provider/pkg/plugin"
 	"github.com/replicatedhq/local-volume-provider/pkg/version"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/pflag"
 	veleroplugin "github.com/vmware-tanzu/velero/pkg/plugin/framework"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
 )
 
 func main() {
@@ -17,22 +21,81 @@ func main() {
 		os.Exit(0)
 	}
 
+	go closeStoresOnSignal(syscall.SIGTERM)
+	serveMetricsFromEnv()
+
 	veleroplugin.NewServer().
 		BindFlags(pflag.CommandLine).
 		RegisterObjectStore("replicated.com/hostpath", newHostPathObjectStorePlugin).
 		RegisterObjectStore("replicated.com/nfs", newNFSObjectStorePlugin).
 		RegisterObjectStore("replicated.com/pvc", newPVCObjectStorePlugin).
+		RegisterObjectStore("replicated.com/smb", newSMBObjectStorePlugin).
+		RegisterObjectStore("replicated.com/iscsi", newISCSIObjectStorePlugin).
+		RegisterObjectStore("replicated.com/existingClaim", newExistingClaimObjectStorePlugin).
+		RegisterObjectStore("replicated.com/emptyDir", newEmptyDirObjectStorePlugin).
 		Serve()
 }
 
 func newHostPathObjectStorePlugin(logger logrus.FieldLogger) (interface{}, error) {
-	return plugin.NewLocalVolumeObjectStore(logger, plugin.Hostpath), nil
+	return newObjectStore(logger, plugin.Hostpath), nil
 }
 
 func newNFSObjectStorePlugin(logger logrus.FieldLogger) (interface{}, error) {
-	return plugin.NewLocalVolumeObjectStore(logger, plugin.NFS), nil
+	return newObjectStore(logger, plugin.NFS), nil
+}
+
+func newSMBObjectStorePlugin(logger logrus.FieldLogger) (interface{}, error) {
+	return newObjectStore(logger, plugin.SMB), nil
+}
+
+func newISCSIObjectStorePlugin(logger logrus.FieldLogger) (interface{}, error) {
+	return newObjectStore(logger, plugin.ISCSI), nil
 }
 
 func newPVCObjectStorePlugin(logger logrus.FieldLogger) (interface{}, error) {
-	return plugin.NewLocalVolumeObjectStore(logger, plugin.PVC), nil
+	return newObjectStore(logger, plugin.PVC), nil
+}
+
+func newExistingClaimObjectStorePlugin(logger logrus.FieldLogger) (interface{}, error) {
+	return newObjectStore(logger, plugin.ExistingClaim), nil
+}
+
+func newEmptyDirObjectStorePlugin(logger logrus.FieldLogger) (interface{}, error) {
+	return newObjectStore(logger, plugin.EmptyDir), nil
+}
+
+var (
+	storesMu sync.Mutex
+	stores   []*plugin.LocalVolumeObjectStore
+)
+
+// newObjectStore creates an object store and records it to be closed when the process is asked to stop.
+func newObjectStore(logger logrus.FieldLogger, v plugin.VolumeType) *plugin.LocalVolumeObjectStore {
+	o := plugin.NewLocalVolumeObjectStore(logger, v)
+	storesMu.Lock()
+	stores = append(stores, o)
+	storesMu.Unlock()
+	return o
+}
+
+// closeStoresOnSignal waits for one of the signals, then closes every object store so in-flight writes finish before exiting.
+func closeStoresOnSignal(signals ...os.Signal) {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, signals...)
+	sig := <-ch
+
+	logger := logrus.New()
+	logger.Infof("Received %s, waiting for in-flight writes", sig)
+
+	storesMu.Lock()
+	defer storesMu.Unlock()
+	var errs []error
+	for _, o := range stores {
+		errs = append(errs, o.Close())
+	}
+	if err := utilerrors.NewAggregate(errs); err != nil {
+		logger.WithError(err).Error("Failed to close object stores")
+		os.Exit(1)
+	}
+	os.Exit(0)
 }
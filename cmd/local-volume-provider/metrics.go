@@ -0,0 +1,54 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"os"
+	"syscall"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/replicatedhq/local-volume-provider/pkg/plugin"
+	"github.com/sirupsen/logrus"
+)
+
+// metricsAddrEnvVar is set on the Velero container to the address the plugin serves its metrics on.
+// It is an environment variable as Velero does not pass flags of its own to plugins.
+const metricsAddrEnvVar = "PLUGIN_METRICS_ADDR"
+
+// serveMetrics serves the object store metrics of the plugin process on /metrics at the address,
+// returning the address listened on.
+func serveMetrics(addr string) (net.Addr, error) {
+	reg := prometheus.NewRegistry()
+	if err := plugin.RegisterMetrics(reg); err != nil {
+		return nil, errors.Wrap(err, "failed to register metrics")
+	}
+	if err := plugin.RegisterWriteMetrics(reg); err != nil {
+		return nil, errors.Wrap(err, "failed to register metrics")
+	}
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to listen on %s", addr)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(reg, promhttp.HandlerOpts{}))
+	go http.Serve(listener, mux)
+	return listener.Addr(), nil
+}
+
+// serveMetricsFromEnv serves the metrics if the address is set in the environment. Velero runs several plugin
+// processes at once, such as during a backup, and those started while another listens on the address do not serve theirs.
+func serveMetricsFromEnv() {
+	addr := os.Getenv(metricsAddrEnvVar)
+	if addr == "" {
+		return
+	}
+	_, err := serveMetrics(addr)
+	if errors.Is(err, syscall.EADDRINUSE) {
+		logrus.New().WithError(err).Debug("Plugin metrics are served by another plugin process")
+	} else if err != nil {
+		logrus.New().WithError(err).Warn("Not serving plugin metrics")
+	}
+}
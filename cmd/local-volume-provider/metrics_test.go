@@ -0,0 +1,36 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/replicatedhq/local-volume-provider/pkg/plugin"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_serveMetrics(t *testing.T) {
+	req := require.New(t)
+	t.Setenv("VOLUME_ROOT", t.TempDir())
+
+	addr, err := serveMetrics("127.0.0.1:0")
+	req.NoError(err)
+
+	o := plugin.NewLocalVolumeObjectStore(logrus.New(), plugin.Hostpath)
+	req.NoError(o.PutObject("my-bucket", "backups/my-backup/my-backup.tar.gz", strings.NewReader("backup contents")))
+
+	resp, err := http.Get("http://" + addr.String() + "/metrics")
+	req.NoError(err)
+	defer resp.Body.Close()
+	req.Equal(http.StatusOK, resp.StatusCode)
+	body, err := io.ReadAll(resp.Body)
+	req.NoError(err)
+	req.Contains(string(body), `local_volume_provider_operations_total{operation="PutObject",outcome="success"}`)
+	req.Contains(string(body), "local_volume_provider_storage_full_total")
+	req.Contains(string(body), "local_volume_provider_inflight_bytes")
+
+	_, err = serveMetrics(addr.String())
+	req.Error(err, "a second process should not serve on the same address")
+}
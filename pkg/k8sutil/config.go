@@ -2,6 +2,7 @@ package k8sutil
 
 import (
 	"github.com/pkg/errors"
+	veleroclientset "github.com/vmware-tanzu/velero/pkg/generated/clientset/versioned"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 )
@@ -19,3 +20,17 @@ func GetClientset() (*kubernetes.Clientset, error) {
 
 	return clientset, nil
 }
+
+func GetVeleroClientset() (*veleroclientset.Clientset, error) {
+	config, err := rest.InClusterConfig()
+	if err != nil {
+		return nil, errors.Wrap(err, "could not get k8s in cluster config")
+	}
+
+	clientset, err := veleroclientset.NewForConfig(config)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not get velero clientset")
+	}
+
+	return clientset, nil
+}
@@ -0,0 +1,219 @@
+package plugin
+
+import (
+	"encoding/binary"
+	"os/user"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// aclXattr is the extended attribute the kernel stores the access ACL of a file in.
+const aclXattr = "system.posix_acl_access"
+
+// The binary form of an ACL, as in linux/posix_acl_xattr.h: a version header followed by one entry per tag and ID.
+const (
+	aclXattrVersion = 2
+	aclUndefinedID  = 0xffffffff
+
+	aclUserObj  = 0x01
+	aclUser     = 0x02
+	aclGroupObj = 0x04
+	aclGroup    = 0x08
+	aclMask     = 0x10
+	aclOther    = 0x20
+)
+
+// aclEntry is one entry of an access ACL.
+type aclEntry struct {
+	tag  uint16
+	perm uint16
+	id   uint32
+}
+
+// parseACL parses an ACL in the text form of setfacl, such as "user::rw-,user:1001:r--,group::r--,mask::r--,other::---",
+// returning it in the binary form of its extended attribute. Users and groups may be given by name or ID.
+// Like setfacl, the mask is computed from the group class entries if named entries are given without one.
+func parseACL(text string) ([]byte, error) {
+	var entries []aclEntry
+	seen := map[[2]uint32]bool{}
+	hasMask, hasNamed := false, false
+	required := map[uint16]bool{aclUserObj: false, aclGroupObj: false, aclOther: false}
+
+	for _, field := range strings.FieldsFunc(text, func(r rune) bool { return r == ',' || r == '\n' }) {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		parts := strings.Split(field, ":")
+		if len(parts) != 3 {
+			return nil, errors.Errorf("invalid ACL entry %q: must be tag:qualifier:permissions", field)
+		}
+		tagName, qualifier, perms := parts[0], parts[1], parts[2]
+
+		entry := aclEntry{id: aclUndefinedID}
+		switch tagName {
+		case "u", "user":
+			entry.tag = aclUserObj
+			if qualifier != "" {
+				entry.tag = aclUser
+			}
+		case "g", "group":
+			entry.tag = aclGroupObj
+			if qualifier != "" {
+				entry.tag = aclGroup
+			}
+		case "m", "mask":
+			entry.tag = aclMask
+		case "o", "other":
+			entry.tag = aclOther
+		default:
+			return nil, errors.Errorf("invalid ACL entry %q: unknown tag %q", field, tagName)
+		}
+		if qualifier != "" && (entry.tag == aclMask || entry.tag == aclOther) {
+			return nil, errors.Errorf("invalid ACL entry %q: %s entries have no qualifier", field, tagName)
+		}
+
+		if entry.tag == aclUser || entry.tag == aclGroup {
+			id, err := lookupACLQualifier(entry.tag, qualifier)
+			if err != nil {
+				return nil, errors.Wrapf(err, "invalid ACL entry %q", field)
+			}
+			entry.id = id
+			hasNamed = true
+		}
+		perm, err := parseACLPerms(perms)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid ACL entry %q", field)
+		}
+		entry.perm = perm
+
+		key := [2]uint32{uint32(entry.tag), entry.id}
+		if seen[key] {
+			return nil, errors.Errorf("invalid ACL: duplicate entry %q", field)
+		}
+		seen[key] = true
+		if entry.tag == aclMask {
+			hasMask = true
+		}
+		if _, ok := required[entry.tag]; ok {
+			required[entry.tag] = true
+		}
+		entries = append(entries, entry)
+	}
+
+	for tag, ok := range required {
+		if !ok {
+			return nil, errors.Errorf("invalid ACL: missing %s entry", aclTagName(tag))
+		}
+	}
+	if hasNamed && !hasMask {
+		mask := aclEntry{tag: aclMask, id: aclUndefinedID}
+		for _, entry := range entries {
+			if entry.tag == aclUser || entry.tag == aclGroupObj || entry.tag == aclGroup {
+				mask.perm |= entry.perm
+			}
+		}
+		entries = append(entries, mask)
+	}
+
+	// The kernel requires the entries ordered by tag, then by ID
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].tag != entries[j].tag {
+			return entries[i].tag < entries[j].tag
+		}
+		return entries[i].id < entries[j].id
+	})
+
+	data := make([]byte, 4+8*len(entries))
+	binary.LittleEndian.PutUint32(data, aclXattrVersion)
+	for i, entry := range entries {
+		b := data[4+8*i:]
+		binary.LittleEndian.PutUint16(b, entry.tag)
+		binary.LittleEndian.PutUint16(b[2:], entry.perm)
+		binary.LittleEndian.PutUint32(b[4:], entry.id)
+	}
+	return data, nil
+}
+
+// parseACLPerms parses the permissions of an ACL entry, such as r-x or rw.
+func parseACLPerms(perms string) (uint16, error) {
+	var perm uint16
+	for _, c := range perms {
+		switch c {
+		case 'r':
+			perm |= 4
+		case 'w':
+			perm |= 2
+		case 'x':
+			perm |= 1
+		case '-':
+		default:
+			return 0, errors.Errorf("invalid permissions %q", perms)
+		}
+	}
+	return perm, nil
+}
+
+// lookupACLQualifier returns the ID of the user or group named by the qualifier of an ACL entry,
+// which may also be the ID itself.
+func lookupACLQualifier(tag uint16, qualifier string) (uint32, error) {
+	if id, err := strconv.ParseUint(qualifier, 10, 32); err == nil {
+		return uint32(id), nil
+	}
+	var id string
+	if tag == aclUser {
+		u, err := user.Lookup(qualifier)
+		if err != nil {
+			return 0, err
+		}
+		id = u.Uid
+	} else {
+		g, err := user.LookupGroup(qualifier)
+		if err != nil {
+			return 0, err
+		}
+		id = g.Gid
+	}
+	n, err := strconv.ParseUint(id, 10, 32)
+	if err != nil {
+		return 0, errors.Errorf("non-numeric ID %q", id)
+	}
+	return uint32(n), nil
+}
+
+// aclTagName returns the setfacl name of an ACL tag.
+func aclTagName(tag uint16) string {
+	switch tag {
+	case aclUserObj, aclUser:
+		return "user"
+	case aclGroupObj, aclGroup:
+		return "group"
+	case aclMask:
+		return "mask"
+	default:
+		return "other"
+	}
+}
+
+// applyDefaultACL sets the configured defaultACL on a newly written object file. Where the filesystem
+// does not support ACLs, as on NFS mounts without ACL support, this is warned about once and skipped.
+func (o *LocalVolumeObjectStore) applyDefaultACL(filePath string, log logrus.FieldLogger) error {
+	if o.defaultACL == nil {
+		return nil
+	}
+	err := setxattr(filePath, aclXattr, o.defaultACL, 0)
+	if xattrUnsupported(err) {
+		o.aclUnsupported.Do(func() {
+			log.WithError(err).Warn("ACLs are not supported by the volume, objects are written without the defaultACL")
+		})
+		return nil
+	}
+	if err != nil {
+		return errors.Wrap(err, "failed to set object ACL")
+	}
+	return nil
+}
@@ -0,0 +1,150 @@
+package plugin
+
+import (
+	"encoding/binary"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+// encodeACL returns the binary form of the ACL entries, each a tag, permissions and ID.
+func encodeACL(entries ...[3]uint32) []byte {
+	data := make([]byte, 4+8*len(entries))
+	binary.LittleEndian.PutUint32(data, aclXattrVersion)
+	for i, entry := range entries {
+		binary.LittleEndian.PutUint16(data[4+8*i:], uint16(entry[0]))
+		binary.LittleEndian.PutUint16(data[6+8*i:], uint16(entry[1]))
+		binary.LittleEndian.PutUint32(data[8+8*i:], entry[2])
+	}
+	return data
+}
+
+func Test_parseACL(t *testing.T) {
+	tests := []struct {
+		name    string
+		text    string
+		want    []byte
+		wantErr string
+	}{
+		{
+			name: "base entries",
+			text: "user::rw-,group::r--,other::---",
+			want: encodeACL(
+				[3]uint32{aclUserObj, 6, aclUndefinedID},
+				[3]uint32{aclGroupObj, 4, aclUndefinedID},
+				[3]uint32{aclOther, 0, aclUndefinedID},
+			),
+		},
+		{
+			name: "named entries are sorted and a mask is computed",
+			text: "other::---, g:1002:rx, user::rw, u:1001:r--, group::r",
+			want: encodeACL(
+				[3]uint32{aclUserObj, 6, aclUndefinedID},
+				[3]uint32{aclUser, 4, 1001},
+				[3]uint32{aclGroupObj, 4, aclUndefinedID},
+				[3]uint32{aclGroup, 5, 1002},
+				[3]uint32{aclMask, 5, aclUndefinedID},
+				[3]uint32{aclOther, 0, aclUndefinedID},
+			),
+		},
+		{
+			name: "explicit mask",
+			text: "user::rw-,user:root:rw-,group::r--,mask::r--,other::---",
+			want: encodeACL(
+				[3]uint32{aclUserObj, 6, aclUndefinedID},
+				[3]uint32{aclUser, 6, 0},
+				[3]uint32{aclGroupObj, 4, aclUndefinedID},
+				[3]uint32{aclMask, 4, aclUndefinedID},
+				[3]uint32{aclOther, 0, aclUndefinedID},
+			),
+		},
+		{
+			name:    "missing other",
+			text:    "user::rw-,group::r--",
+			wantErr: "missing other entry",
+		},
+		{
+			name:    "bad permissions",
+			text:    "user::rwz,group::r--,other::---",
+			wantErr: `invalid permissions "rwz"`,
+		},
+		{
+			name:    "unknown user",
+			text:    "user::rw-,user:no-such-user-lvp:r--,group::r--,other::---",
+			wantErr: "no-such-user-lvp",
+		},
+		{
+			name:    "duplicate entry",
+			text:    "user::rw-,user::r--,group::r--,other::---",
+			wantErr: "duplicate entry",
+		},
+		{
+			name:    "qualified other",
+			text:    "user::rw-,group::r--,other:1001:---",
+			wantErr: "other entries have no qualifier",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseACL(tt.text)
+			if tt.wantErr != "" {
+				require.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_defaultACL(t *testing.T) {
+	req := require.New(t)
+	o, root := newTestObjectStore(t)
+	acl := "user::rw-,user:1001:r--,group::r--,mask::r--,other::---"
+	req.NoError(o.applyConfig(map[string]string{"defaultACL": acl}))
+	if err := setxattr(root, aclXattr, o.defaultACL, 0); err != nil {
+		t.Skipf("ACLs are not supported by the test filesystem: %v", err)
+	}
+
+	for i, config := range []map[string]string{{}, {"compression": compressionGzip}} {
+		config["defaultACL"] = acl
+		req.NoError(o.applyConfig(config))
+		key := fmt.Sprintf("backups/my-backup/object-%d", i)
+		req.NoError(o.PutObject("my-bucket", key, strings.NewReader("data")))
+
+		filePath, _, err := findObjectFile(filepath.Join(root, "my-bucket", key))
+		req.NoError(err)
+		got := make([]byte, 64)
+		n, err := getxattr(filePath, aclXattr, got)
+		req.NoError(err)
+		req.Equal(o.defaultACL, got[:n])
+	}
+
+	req.NoError(o.CopyObject("my-bucket", "backups/my-backup/object-0", "backups/copy/copy"))
+	got := make([]byte, 64)
+	n, err := getxattr(filepath.Join(root, "my-bucket", "backups/copy/copy"), aclXattr, got)
+	req.NoError(err)
+	req.Equal(o.defaultACL, got[:n])
+}
+
+func Test_defaultACL_unsupported(t *testing.T) {
+	req := require.New(t)
+	o, _ := newTestObjectStore(t)
+	req.NoError(o.applyConfig(map[string]string{"defaultACL": "user::rw-,user:1001:r--,group::r--,other::---"}))
+	withoutXattrs(t)
+
+	var out strings.Builder
+	log := logrus.New()
+	log.Out = &out
+	o.log = log
+
+	for i := 0; i < 2; i++ {
+		req.NoError(o.PutObject("my-bucket", fmt.Sprintf("backups/my-backup/object-%d", i), strings.NewReader("data")))
+	}
+	// skipping the ACL is logged once
+	req.Equal(1, strings.Count(out.String(), "ACLs are not supported"), out.String())
+}
@@ -0,0 +1,158 @@
+package plugin
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// defaultAuditLogMaxBytes is the size the audit log is rotated at.
+const defaultAuditLogMaxBytes = 10 << 20
+
+// auditLog appends a JSON record of every mutating operation to a file, keeping one rotated file alongside it.
+type auditLog struct {
+	mu       sync.Mutex
+	path     string
+	maxBytes int64
+	mode     os.FileMode
+	file     *os.File
+	size     int64
+	logger   *logrus.Logger
+}
+
+// newAuditLog opens the audit log at path for appending, creating it if needed.
+func newAuditLog(path string, maxBytes int64, dirMode, fileMode os.FileMode) (*auditLog, error) {
+	if err := mkdirAll(filepath.Dir(path), dirMode); err != nil {
+		return nil, errors.Wrap(err, "failed to create audit log directory")
+	}
+	a := &auditLog{
+		path:     path,
+		maxBytes: maxBytes,
+		mode:     fileMode,
+	}
+	if err := a.open(); err != nil {
+		return nil, err
+	}
+	a.logger = logrus.New()
+	a.logger.SetOutput(a)
+	a.logger.SetFormatter(&logrus.JSONFormatter{})
+	return a, nil
+}
+
+// openAuditLog opens the configured audit log within the volume at volumePath, closing any previously opened one.
+func (o *LocalVolumeObjectStore) openAuditLog(volumePath string) error {
+	if o.auditLog != nil {
+		if err := o.auditLog.Close(); err != nil {
+			o.log.WithError(err).Warn("Failed to close audit log")
+		}
+		o.auditLog = nil
+	}
+	if o.auditLogPath == "" {
+		return nil
+	}
+
+	auditLog, err := newAuditLog(filepath.Join(volumePath, o.auditLogPath), o.auditLogMaxBytes, o.getDirMode(), o.getFileMode())
+	if err != nil {
+		return errors.Wrap(err, "failed to open audit log")
+	}
+	o.auditLog = auditLog
+	return nil
+}
+
+func (a *auditLog) open() error {
+	file, err := os.OpenFile(a.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, a.mode)
+	if err != nil {
+		return errors.Wrap(err, "failed to open audit log")
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return errors.Wrap(err, "failed to stat audit log")
+	}
+	a.file = file
+	a.size = info.Size()
+	return nil
+}
+
+// Write appends a record, first rotating the file if the record would take it past maxBytes.
+func (a *auditLog) Write(p []byte) (int, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.size > 0 && a.size+int64(len(p)) > a.maxBytes {
+		if err := a.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := a.file.Write(p)
+	a.size += int64(n)
+	return n, err
+}
+
+// rotate replaces the previously rotated file with the current one and starts a new file.
+func (a *auditLog) rotate() error {
+	if err := a.file.Close(); err != nil {
+		return errors.Wrap(err, "failed to close audit log")
+	}
+	if err := os.Rename(a.path, rotatedAuditLogPath(a.path)); err != nil {
+		return errors.Wrap(err, "failed to rotate audit log")
+	}
+	return a.open()
+}
+
+// Close closes the audit log file.
+func (a *auditLog) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.file.Close()
+}
+
+// rotatedAuditLogPath returns the path the audit log at path is rotated to.
+func rotatedAuditLogPath(path string) string {
+	return path + ".1"
+}
+
+// isAuditLogFile returns truthy if the file at path is the audit log or its rotated file, which are not objects.
+func (a *auditLog) isAuditLogFile(path string) bool {
+	return a != nil && (path == a.path || path == rotatedAuditLogPath(a.path))
+}
+
+// record appends a record of a mutation, made up of the fields of the operation's log entry,
+// its outcome, and the backup or restore the key belongs to.
+func (a *auditLog) record(log logrus.FieldLogger, operation string, bytes int64, err error) {
+	if a == nil {
+		return
+	}
+	fields := logrus.Fields{}
+	if entry, ok := log.(*logrus.Entry); ok {
+		for k, v := range entry.Data {
+			fields[k] = v
+		}
+	}
+	fields["operation"] = operation
+	fields["bytes"] = bytes
+	fields["result"] = outcomeSuccess
+	if err != nil {
+		fields["result"] = outcomeError
+		fields[logrus.ErrorKey] = err.Error()
+	}
+
+	// Velero keys are laid out as [<prefix>/]<kind>/<name>/<file>, e.g. backups/my-backup/my-backup.tar.gz
+	key, _ := fields["key"].(string)
+	if key == "" {
+		key, _ = fields["dstKey"].(string)
+	}
+	parts := strings.Split(key, "/")
+	for i := 0; i+2 < len(parts); i++ {
+		if parts[i] == "backups" || parts[i] == "restores" {
+			fields[strings.TrimSuffix(parts[i], "s")] = parts[i+1]
+			break
+		}
+	}
+
+	a.logger.WithFields(fields).Info(operation)
+}
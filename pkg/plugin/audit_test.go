@@ -0,0 +1,105 @@
+package plugin
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// newTestAuditedObjectStore returns an object store writing its audit log to audit/audit.log in the my-bucket volume.
+func newTestAuditedObjectStore(t *testing.T, maxBytes int64) (*LocalVolumeObjectStore, string) {
+	o, root := newTestObjectStore(t)
+	o.auditLogPath = filepath.Join("audit", "audit.log")
+	o.auditLogMaxBytes = maxBytes
+	require.NoError(t, o.openAuditLog(filepath.Join(root, "my-bucket")))
+	t.Cleanup(func() { o.auditLog.Close() })
+	return o, filepath.Join(root, "my-bucket", "audit", "audit.log")
+}
+
+// readAuditRecords returns the JSON records in the audit log file.
+func readAuditRecords(t *testing.T, path string) []map[string]interface{} {
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+
+	var records []map[string]interface{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		record := map[string]interface{}{}
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &record))
+		records = append(records, record)
+	}
+	require.NoError(t, scanner.Err())
+	return records
+}
+
+func Test_auditLog(t *testing.T) {
+	req := require.New(t)
+	o, auditLogPath := newTestAuditedObjectStore(t, defaultAuditLogMaxBytes)
+
+	req.NoError(o.PutObject("my-bucket", "backups/my-backup/my-backup.tar.gz", strings.NewReader("backup contents")))
+	req.NoError(o.DeleteObject("my-bucket", "backups/my-backup/my-backup.tar.gz"))
+	req.Error(o.DeleteObject("my-bucket", "backups/my-backup/missing.tar.gz"))
+
+	records := readAuditRecords(t, auditLogPath)
+	req.Len(records, 3)
+
+	req.Equal("PutObject", records[0]["operation"])
+	req.Equal("success", records[0]["result"])
+	req.Equal("my-backup", records[0]["backup"])
+	req.Equal("backups/my-backup/my-backup.tar.gz", records[0]["key"])
+	req.Equal(float64(len("backup contents")), records[0]["bytes"])
+	req.NotEmpty(records[0]["time"])
+
+	req.Equal("DeleteObject", records[1]["operation"])
+	req.Equal("success", records[1]["result"])
+	req.Equal("my-backup", records[1]["backup"])
+
+	req.Equal("DeleteObject", records[2]["operation"])
+	req.Equal("error", records[2]["result"])
+	req.Equal("backups/my-backup/missing.tar.gz", records[2]["key"])
+	req.NotEmpty(records[2]["error"])
+
+	// the audit log is not an object
+	keys, err := o.ListObjects("my-bucket", "")
+	req.NoError(err)
+	req.Empty(keys)
+}
+
+func Test_auditLog_deleteObjects(t *testing.T) {
+	req := require.New(t)
+	o, auditLogPath := newTestAuditedObjectStore(t, defaultAuditLogMaxBytes)
+
+	req.NoError(o.PutObject("my-bucket", "restores/my-restore/restore-logs.gz", strings.NewReader("logs")))
+	req.Error(o.DeleteObjects("my-bucket", []string{"restores/my-restore/restore-logs.gz", "restores/my-restore/missing.gz"}))
+
+	records := readAuditRecords(t, auditLogPath)
+	req.Len(records, 3)
+	req.Equal("success", records[1]["result"])
+	req.Equal("my-restore", records[1]["restore"])
+	req.Equal("error", records[2]["result"])
+	req.Equal("restores/my-restore/missing.gz", records[2]["key"])
+}
+
+func Test_auditLog_rotate(t *testing.T) {
+	req := require.New(t)
+	o, auditLogPath := newTestAuditedObjectStore(t, 512)
+
+	for i := 0; i < 10; i++ {
+		req.NoError(o.PutObject("my-bucket", "backups/my-backup/my-backup.tar.gz", strings.NewReader("backup contents")))
+	}
+
+	info, err := os.Stat(auditLogPath)
+	req.NoError(err)
+	req.LessOrEqual(info.Size(), int64(512))
+	req.NotEmpty(readAuditRecords(t, rotatedAuditLogPath(auditLogPath)))
+
+	keys, err := o.ListObjects("my-bucket", "")
+	req.NoError(err)
+	req.Equal([]string{"backups/my-backup/my-backup.tar.gz"}, keys)
+}
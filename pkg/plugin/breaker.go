@@ -0,0 +1,75 @@
+package plugin
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// defaultFileserverBreakerFailures is how many consecutive failures to open or read an object trip the breaker.
+	defaultFileserverBreakerFailures = 5
+	// defaultFileserverBreakerWindow is how close together the failures must be to trip the breaker.
+	defaultFileserverBreakerWindow = 30 * time.Second
+	// defaultFileserverBreakerCooldown is how long a tripped breaker rejects requests before letting one through.
+	defaultFileserverBreakerCooldown = 30 * time.Second
+)
+
+// fileserverBreakerKeys are the plugin ConfigMap keys of the fileserver circuit breaker, passed to the fileserver
+// in the environment variables of fileserverBreakerEnvVars.
+var fileserverBreakerKeys = []string{
+	"fileserverBreakerFailures",
+	"fileserverBreakerWindow",
+	"fileserverBreakerCooldown",
+}
+
+var fileserverBreakerEnvVars = map[string]string{
+	"fileserverBreakerFailures": "FILESERVER_BREAKER_FAILURES",
+	"fileserverBreakerWindow":   "FILESERVER_BREAKER_WINDOW",
+	"fileserverBreakerCooldown": "FILESERVER_BREAKER_COOLDOWN",
+}
+
+// FileserverBreaker configures the circuit breaker of the fileserver, which stops serving objects for Cooldown
+// once Failures consecutive objects failed to open or read within Window, rather than piling up requests on a
+// failing mount. Zero Failures disables the breaker.
+type FileserverBreaker struct {
+	Failures int
+	Window   time.Duration
+	Cooldown time.Duration
+}
+
+// ParseFileserverBreaker parses the fileserverBreakerFailures, fileserverBreakerWindow and fileserverBreakerCooldown
+// settings, returning the default for each one that is empty.
+func ParseFileserverBreaker(failures, window, cooldown string) (FileserverBreaker, error) {
+	breaker := FileserverBreaker{
+		Failures: defaultFileserverBreakerFailures,
+		Window:   defaultFileserverBreakerWindow,
+		Cooldown: defaultFileserverBreakerCooldown,
+	}
+	if failures != "" {
+		n, err := strconv.Atoi(failures)
+		if err != nil || n < 0 {
+			return FileserverBreaker{}, errors.Errorf("invalid fileserverBreakerFailures %q: must be a non-negative integer", failures)
+		}
+		breaker.Failures = n
+	}
+	for _, setting := range []struct {
+		key   string
+		value string
+		dst   *time.Duration
+	}{
+		{"fileserverBreakerWindow", window, &breaker.Window},
+		{"fileserverBreakerCooldown", cooldown, &breaker.Cooldown},
+	} {
+		if setting.value == "" {
+			continue
+		}
+		d, err := time.ParseDuration(setting.value)
+		if err != nil || d <= 0 {
+			return FileserverBreaker{}, errors.Errorf("invalid %s %q: must be a positive duration", setting.key, setting.value)
+		}
+		*setting.dst = d
+	}
+	return breaker, nil
+}
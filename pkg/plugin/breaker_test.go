@@ -0,0 +1,31 @@
+package plugin
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_ParseFileserverBreaker(t *testing.T) {
+	req := require.New(t)
+
+	breaker, err := ParseFileserverBreaker("", "", "")
+	req.NoError(err)
+	req.Equal(FileserverBreaker{
+		Failures: defaultFileserverBreakerFailures,
+		Window:   defaultFileserverBreakerWindow,
+		Cooldown: defaultFileserverBreakerCooldown,
+	}, breaker)
+
+	breaker, err = ParseFileserverBreaker("0", "1m", "5m")
+	req.NoError(err)
+	req.Equal(FileserverBreaker{Window: time.Minute, Cooldown: 5 * time.Minute}, breaker)
+
+	_, err = ParseFileserverBreaker("-1", "", "")
+	req.ErrorContains(err, "fileserverBreakerFailures")
+	_, err = ParseFileserverBreaker("", "soon", "")
+	req.ErrorContains(err, "fileserverBreakerWindow")
+	_, err = ParseFileserverBreaker("", "", "0s")
+	req.ErrorContains(err, "fileserverBreakerCooldown")
+}
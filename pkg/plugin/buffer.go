@@ -0,0 +1,35 @@
+package plugin
+
+import (
+	"io"
+	"sync"
+)
+
+// defaultCopyBufferSize is the size of the buffer used to stream objects when copyBufferSizeBytes is not configured.
+const defaultCopyBufferSize = 1 << 20
+
+// copyBufferPool holds reusable copy buffers shared by all object store instances.
+var copyBufferPool sync.Pool
+
+// getCopyBuffer returns a buffer of the given size, reusing a pooled one when possible.
+func getCopyBuffer(size int) *[]byte {
+	if buf, ok := copyBufferPool.Get().(*[]byte); ok && len(*buf) == size {
+		return buf
+	}
+	buf := make([]byte, size)
+	return &buf
+}
+
+// putCopyBuffer returns a buffer to the pool for reuse.
+func putCopyBuffer(buf *[]byte) {
+	copyBufferPool.Put(buf)
+}
+
+// copyBuffered copies from src to dst using a pooled buffer of the given size.
+func copyBuffered(dst io.Writer, src io.Reader, size int) (int64, error) {
+	buf := getCopyBuffer(size)
+	defer putCopyBuffer(buf)
+
+	// Hide any ReaderFrom/WriterTo implementations (e.g. *os.File) so the pooled buffer is actually used.
+	return io.CopyBuffer(struct{ io.Writer }{dst}, struct{ io.Reader }{src}, *buf)
+}
@@ -0,0 +1,128 @@
+package plugin
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// checksumSuffix is appended to an object path to get the path of its SHA256 sidecar file.
+const checksumSuffix = ".sha256"
+
+// checksumPath returns the path of the sidecar file holding the checksum for the object at path.
+func checksumPath(path string) string {
+	return path + checksumSuffix
+}
+
+// isChecksumFile returns truthy if the file name is a checksum sidecar rather than an object.
+func isChecksumFile(name string) bool {
+	return strings.HasSuffix(name, checksumSuffix)
+}
+
+// writeChecksum atomically writes the hex encoded digest to the sidecar file of the object at path.
+func writeChecksum(path, digest string, mode os.FileMode) error {
+	sidecar := checksumPath(path)
+	tmpPath := tempFilePath(sidecar)
+	if err := os.WriteFile(tmpPath, []byte(digest+"\n"), mode); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := os.Chmod(tmpPath, mode); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := os.Rename(tmpPath, sidecar); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return nil
+}
+
+// readChecksum returns the hex encoded digest stored in the sidecar file of the object at path.
+func readChecksum(path string) (string, error) {
+	data, err := os.ReadFile(checksumPath(path))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// isDigest returns truthy if the sidecar content is a hex encoded SHA256 digest, rather than left empty or garbled.
+func isDigest(digest string) bool {
+	if len(digest) != hex.EncodedLen(sha256.Size) {
+		return false
+	}
+	_, err := hex.DecodeString(digest)
+	return err == nil
+}
+
+// removeChecksum removes the sidecar file of the object at path, if there is one.
+func removeChecksum(path string) error {
+	err := os.Remove(checksumPath(path))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// verifyChecksum hashes the contents read from r and compares it to the sidecar of the object at path.
+func verifyChecksum(path string, r io.Reader, bufferSize int) error {
+	want, err := readChecksum(path)
+	if err != nil {
+		return errors.Wrap(err, "failed to read checksum")
+	}
+
+	hash := sha256.New()
+	if _, err := copyBuffered(hash, r, bufferSize); err != nil {
+		return errors.Wrap(err, "failed to hash object")
+	}
+	if got := hex.EncodeToString(hash.Sum(nil)); got != want {
+		return fmt.Errorf("%w: expected %s, got %s", ErrChecksumMismatch, want, got)
+	}
+	return nil
+}
+
+// verifyingReadCloser hashes the content as it is read and compares it to the expected digest once the end is reached,
+// so an object is verified in the same pass that reads it. A mismatch is returned in place of io.EOF, and again
+// by Close. Objects closed before the end are not verified.
+type verifyingReadCloser struct {
+	io.ReadCloser
+	hash hash.Hash
+	want string
+	err  error
+}
+
+func newVerifyingReadCloser(rc io.ReadCloser, want string) *verifyingReadCloser {
+	return &verifyingReadCloser{ReadCloser: rc, hash: sha256.New(), want: want}
+}
+
+func (r *verifyingReadCloser) Read(p []byte) (int, error) {
+	if r.err != nil {
+		return 0, r.err
+	}
+	n, err := r.ReadCloser.Read(p)
+	r.hash.Write(p[:n])
+	if err == io.EOF {
+		if got := hex.EncodeToString(r.hash.Sum(nil)); got != r.want {
+			r.err = fmt.Errorf("%w: expected %s, got %s", ErrChecksumMismatch, r.want, got)
+		} else {
+			r.err = io.EOF
+		}
+		return n, r.err
+	}
+	return n, err
+}
+
+func (r *verifyingReadCloser) Close() error {
+	err := r.ReadCloser.Close()
+	if errors.Is(r.err, ErrChecksumMismatch) {
+		return r.err
+	}
+	return err
+}
@@ -0,0 +1,59 @@
+package plugin
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+)
+
+// ErrClosed is returned by writes started after the object store has been closed.
+var ErrClosed = errors.New("object store is closed")
+
+// closeTimeout is how long Close waits for in-flight writes to finish.
+var closeTimeout = 30 * time.Second
+
+// beginWrite registers an in-flight write, which Close waits for. The returned func must be called once the write is done.
+func (o *LocalVolumeObjectStore) beginWrite() (done func(), err error) {
+	o.closeMu.Lock()
+	defer o.closeMu.Unlock()
+	if o.closed {
+		return nil, ErrClosed
+	}
+	o.writes.Add(1)
+	return o.writes.Done, nil
+}
+
+// Close stops the object store accepting writes and waits for the ones in flight to finish,
+// so the process can exit without losing data, then closes the audit log. The retention sweeper is stopped.
+// It returns an error if the writes do not finish within closeTimeout.
+func (o *LocalVolumeObjectStore) Close() error {
+	o.closeMu.Lock()
+	o.closed = true
+	o.closeMu.Unlock()
+
+	o.log.Debug("LocalVolumeObjectStore.Close called")
+
+	o.stopRetentionSweeper()
+
+	done := make(chan struct{})
+	go func() {
+		o.writes.Wait()
+		close(done)
+	}()
+
+	var errs []error
+	select {
+	case <-done:
+	case <-time.After(closeTimeout):
+		errs = append(errs, errors.Errorf("timed out after %s waiting for in-flight writes", closeTimeout))
+	}
+
+	if o.auditLog != nil {
+		if err := o.auditLog.Close(); err != nil {
+			errs = append(errs, errors.Wrap(err, "failed to close audit log"))
+		}
+	}
+
+	return utilerrors.NewAggregate(errs)
+}
@@ -0,0 +1,70 @@
+package plugin
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Close(t *testing.T) {
+	req := require.New(t)
+	o, root := newTestObjectStore(t)
+
+	// the write blocks reading its body until the pipe is written to
+	body, w := io.Pipe()
+	putErr := make(chan error, 1)
+	go func() {
+		putErr <- o.PutObject("my-bucket", "backups/my-backup/my-backup.tar.gz", body)
+	}()
+	_, err := w.Write([]byte("backup "))
+	req.NoError(err)
+
+	closeErr := make(chan error, 1)
+	go func() {
+		closeErr <- o.Close()
+	}()
+
+	select {
+	case <-closeErr:
+		t.Fatal("Close returned while a write was in flight")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	_, err = w.Write([]byte("contents"))
+	req.NoError(err)
+	req.NoError(w.Close())
+	req.NoError(<-putErr)
+	req.NoError(<-closeErr)
+
+	got, err := os.ReadFile(filepath.Join(root, "my-bucket", "backups", "my-backup", "my-backup.tar.gz"))
+	req.NoError(err)
+	req.Equal("backup contents", string(got))
+
+	err = o.PutObject("my-bucket", "backups/my-backup/other.tar.gz", strings.NewReader("backup contents"))
+	req.ErrorIs(err, ErrClosed)
+}
+
+func Test_Close_timeout(t *testing.T) {
+	req := require.New(t)
+	o, _ := newTestObjectStore(t)
+	defer func(d time.Duration) { closeTimeout = d }(closeTimeout)
+	closeTimeout = 10 * time.Millisecond
+
+	body, w := io.Pipe()
+	putErr := make(chan error, 1)
+	go func() {
+		putErr <- o.PutObject("my-bucket", "backups/my-backup/my-backup.tar.gz", body)
+	}()
+	_, err := w.Write([]byte("backup "))
+	req.NoError(err)
+
+	req.Error(o.Close())
+
+	w.CloseWithError(io.ErrUnexpectedEOF)
+	req.Error(<-putErr)
+}
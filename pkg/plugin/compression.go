@@ -0,0 +1,212 @@
+package plugin
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/pkg/errors"
+)
+
+const (
+	compressionGzip = "gzip"
+	compressionZstd = "zstd"
+)
+
+// CompressedSuffix is appended to the path of objects stored gzip compressed by the plugin.
+// It is distinct from a plain ".gz" so objects Velero compresses itself are never mistaken for them.
+const CompressedSuffix = ".lvp.gz"
+
+// ZstdCompressedSuffix is appended to the path of objects stored zstd compressed by the plugin.
+const ZstdCompressedSuffix = ".lvp.zst"
+
+// CompressionFormats are the compressions an object may be stored with, whatever the configured compression,
+// in the order their files are looked for once the object is not found uncompressed.
+var CompressionFormats = []string{compressionGzip, compressionZstd}
+
+// CompressionSuffix returns the suffix of the files of objects stored with the compression, which records
+// the format so the object can be read whatever compression is configured when it is read.
+func CompressionSuffix(compression string) string {
+	switch compression {
+	case compressionGzip:
+		return CompressedSuffix
+	case compressionZstd:
+		return ZstdCompressedSuffix
+	default:
+		return ""
+	}
+}
+
+// validateCompression returns an error if the compression is not supported.
+func validateCompression(compression string) error {
+	switch compression {
+	case "", compressionGzip, compressionZstd:
+		return nil
+	default:
+		return errors.Errorf("unsupported compression %q", compression)
+	}
+}
+
+// parseCompressionLevel parses the compressionLevel setting for the compression, from 1 (fastest) to 9 for gzip
+// or 22 for zstd. Zero is returned for an empty value, which uses the default level of the format.
+func parseCompressionLevel(compression, value string) (int, error) {
+	if value == "" {
+		return 0, nil
+	}
+	if compression == "" {
+		return 0, errors.New("compressionLevel requires compression to be set")
+	}
+
+	maxLevel := gzip.BestCompression
+	if compression == compressionZstd {
+		maxLevel = 22
+	}
+	level, err := strconv.Atoi(value)
+	if err != nil || level < 1 || level > maxLevel {
+		return 0, errors.Errorf("invalid compressionLevel %q: must be between 1 and %d for %s", value, maxLevel, compression)
+	}
+	return level, nil
+}
+
+// compressedPath returns the path an object at path is stored at with the compression.
+func compressedPath(path, compression string) string {
+	return path + CompressionSuffix(compression)
+}
+
+// objectFilePaths returns the paths the object at path may be stored at, uncompressed and in each compression format.
+func objectFilePaths(path string) []string {
+	paths := []string{path}
+	for _, compression := range CompressionFormats {
+		paths = append(paths, compressedPath(path, compression))
+	}
+	return paths
+}
+
+// fileCompression returns the compression of the object stored in the named file, or "" if it is uncompressed.
+func fileCompression(name string) string {
+	for _, compression := range CompressionFormats {
+		if strings.HasSuffix(name, CompressionSuffix(compression)) {
+			return compression
+		}
+	}
+	return ""
+}
+
+// objectNameFromFile returns the object name for the file name, removing any compressed suffix.
+func objectNameFromFile(name string) string {
+	return strings.TrimSuffix(name, CompressionSuffix(fileCompression(name)))
+}
+
+// findObjectFile returns the path of the file holding the object at path, and the compression it is stored with.
+// If the object does not exist in any form, ErrObjectNotFound wrapping the error from checking the uncompressed path is returned.
+func findObjectFile(path string) (string, string, error) {
+	_, err := statObject(path)
+	if err == nil {
+		return path, "", nil
+	}
+	if !os.IsNotExist(err) {
+		return path, "", err
+	}
+
+	for _, compression := range CompressionFormats {
+		if _, cerr := statObject(compressedPath(path, compression)); cerr == nil {
+			return compressedPath(path, compression), compression, nil
+		}
+	}
+	return path, "", fmt.Errorf("%w: %w", ErrObjectNotFound, err)
+}
+
+// newCompressingWriter returns a writer compressing to w in the format at the level, or the default level of the format if zero.
+// It must be closed to flush the compressed stream.
+func newCompressingWriter(w io.Writer, compression string, level int) (io.WriteCloser, error) {
+	switch compression {
+	case compressionGzip:
+		if level == 0 {
+			level = gzip.DefaultCompression
+		}
+		return gzip.NewWriterLevel(w, level)
+	case compressionZstd:
+		// An empty object still gets a frame, so that it is a valid zstd stream
+		opts := []zstd.EOption{zstd.WithZeroFrames(true)}
+		if level != 0 {
+			opts = append(opts, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)))
+		}
+		return zstd.NewWriter(w, opts...)
+	default:
+		return nil, errors.Errorf("unsupported compression %q", compression)
+	}
+}
+
+// NewDecompressingReader returns a reader decompressing r from the compression format.
+// Closing it releases the decoder, but not r.
+func NewDecompressingReader(r io.Reader, compression string) (io.ReadCloser, error) {
+	switch compression {
+	case compressionGzip:
+		return gzip.NewReader(r)
+	case compressionZstd:
+		zr, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		return zr.IOReadCloser(), nil
+	default:
+		return nil, errors.Errorf("unsupported compression %q", compression)
+	}
+}
+
+// openObjectFile opens the object file, transparently decrypting it if a key is given and decompressing it if needed.
+func openObjectFile(filePath string, compression string, key []byte) (*objectReadCloser, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	var r io.Reader = file
+	if key != nil {
+		if r, err = NewDecryptingReader(file, key); err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+	if compression == "" {
+		return &objectReadCloser{Reader: r, file: file}, nil
+	}
+
+	dr, err := NewDecompressingReader(r, compression)
+	if err != nil {
+		file.Close()
+		return nil, errors.Wrap(err, "failed to read compressed object")
+	}
+	return &objectReadCloser{Reader: dr, file: file, decoder: dr}, nil
+}
+
+// objectReadCloser reads an object through any decryption and decompression, and closes the underlying file.
+type objectReadCloser struct {
+	io.Reader
+	file    *os.File
+	decoder io.Closer
+}
+
+func (r *objectReadCloser) Close() error {
+	if r.decoder != nil {
+		r.decoder.Close()
+	}
+	return r.file.Close()
+}
+
+// contentSize returns the number of bytes the object will read, from the open file,
+// or -1 if the file is decoded and its content size is unknown.
+func (r *objectReadCloser) contentSize() (int64, error) {
+	if r.Reader != io.Reader(r.file) {
+		return -1, nil
+	}
+	info, err := r.file.Stat()
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
@@ -0,0 +1,154 @@
+package plugin
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_compression_mixedFormats(t *testing.T) {
+	req := require.New(t)
+	o, root := newTestObjectStore(t)
+	o.verifyChecksums = true
+
+	content := bytes.Repeat([]byte("compressible backup content "), 1000)
+	formats := map[string]string{
+		"backups/raw/raw.tar.gz":   "",
+		"backups/gzip/gzip.tar.gz": compressionGzip,
+		"backups/zstd/zstd.tar.gz": compressionZstd,
+		"backups/empty/empty.json": compressionZstd,
+	}
+	for key, compression := range formats {
+		req.NoError(o.applyConfig(map[string]string{"compression": compression}))
+		body := content
+		if key == "backups/empty/empty.json" {
+			body = []byte{}
+		}
+		req.NoError(o.PutObject("my-bucket", key, bytes.NewReader(body)))
+
+		// stored under the suffix of its format only
+		path := filepath.Join(root, "my-bucket", key)
+		for _, filePath := range objectFilePaths(path) {
+			_, err := os.Stat(filePath)
+			req.Equal(filePath == compressedPath(path, compression), err == nil, filePath)
+		}
+	}
+
+	// every object reads back whatever compression is configured now
+	for _, compression := range []string{"", compressionGzip, compressionZstd} {
+		req.NoError(o.applyConfig(map[string]string{"compression": compression, "verifyChecksums": "true"}))
+
+		objects, err := o.ListObjects("my-bucket", "backups/")
+		req.NoError(err)
+		req.ElementsMatch([]string{"backups/raw/raw.tar.gz", "backups/gzip/gzip.tar.gz", "backups/zstd/zstd.tar.gz", "backups/empty/empty.json"}, objects)
+
+		for key := range formats {
+			rc, err := o.GetObject("my-bucket", key)
+			req.NoError(err, key)
+			got, err := io.ReadAll(rc)
+			req.NoError(err, key)
+			req.NoError(rc.Close())
+			if key == "backups/empty/empty.json" {
+				req.Empty(got)
+			} else {
+				req.Equal(content, got, key)
+			}
+		}
+	}
+
+	// copying keeps the format, and rewriting in another format replaces the old file
+	req.NoError(o.CopyObject("my-bucket", "backups/zstd/zstd.tar.gz", "backups/copy/copy.tar.gz"))
+	_, err := os.Stat(filepath.Join(root, "my-bucket", "backups", "copy", "copy.tar.gz"+ZstdCompressedSuffix))
+	req.NoError(err)
+
+	req.NoError(o.applyConfig(map[string]string{"compression": compressionGzip}))
+	req.NoError(o.PutObject("my-bucket", "backups/zstd/zstd.tar.gz", bytes.NewReader(content)))
+	_, err = os.Stat(filepath.Join(root, "my-bucket", "backups", "zstd", "zstd.tar.gz"+ZstdCompressedSuffix))
+	req.True(os.IsNotExist(err))
+
+	results, err := o.VerifyBucket("my-bucket")
+	req.NoError(err)
+	for _, result := range results {
+		req.Equal(VerifyPassed, result.Status, result.Key)
+	}
+
+	for key := range formats {
+		req.NoError(o.DeleteObject("my-bucket", key))
+		exists, err := o.ObjectExists("my-bucket", key)
+		req.NoError(err)
+		req.False(exists, key)
+	}
+}
+
+func Test_compressionLevel(t *testing.T) {
+	content := bytes.Repeat([]byte("compressible backup content "), 1000)
+
+	tests := []struct {
+		name    string
+		config  map[string]string
+		wantErr string
+	}{
+		{
+			name:   "gzip best compression",
+			config: map[string]string{"compression": compressionGzip, "compressionLevel": "9"},
+		},
+		{
+			name:   "zstd fastest",
+			config: map[string]string{"compression": compressionZstd, "compressionLevel": "1"},
+		},
+		{
+			name:   "zstd best compression",
+			config: map[string]string{"compression": compressionZstd, "compressionLevel": "22"},
+		},
+		{
+			name:    "gzip out of range",
+			config:  map[string]string{"compression": compressionGzip, "compressionLevel": "10"},
+			wantErr: "invalid compressionLevel",
+		},
+		{
+			name:    "zstd out of range",
+			config:  map[string]string{"compression": compressionZstd, "compressionLevel": "0"},
+			wantErr: "invalid compressionLevel",
+		},
+		{
+			name:    "not a number",
+			config:  map[string]string{"compression": compressionZstd, "compressionLevel": "best"},
+			wantErr: "invalid compressionLevel",
+		},
+		{
+			name:    "without compression",
+			config:  map[string]string{"compressionLevel": "3"},
+			wantErr: "requires compression",
+		},
+		{
+			name:    "unsupported compression",
+			config:  map[string]string{"compression": "lz4"},
+			wantErr: "unsupported compression",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := require.New(t)
+			o, _ := newTestObjectStore(t)
+
+			err := o.applyConfig(tt.config)
+			if tt.wantErr != "" {
+				req.ErrorContains(err, tt.wantErr)
+				return
+			}
+			req.NoError(err)
+
+			req.NoError(o.PutObject("my-bucket", "backups/my-backup/my-backup.tar.gz", bytes.NewReader(content)))
+			rc, err := o.GetObject("my-bucket", "backups/my-backup/my-backup.tar.gz")
+			req.NoError(err)
+			got, err := io.ReadAll(rc)
+			req.NoError(err)
+			req.NoError(rc.Close())
+			req.Equal(content, got)
+		})
+	}
+}
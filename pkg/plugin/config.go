@@ -0,0 +1,496 @@
+package plugin
+
+import (
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// applyConfig sets the object store options found in the Velero BSL Config.
+// Options that are not present are reset to their defaults.
+func (o *LocalVolumeObjectStore) applyConfig(config map[string]string) error {
+	level, err := parseLogLevel(config["logLevel"])
+	if err != nil {
+		return err
+	}
+	if err := validateLogFormat(config["logFormat"]); err != nil {
+		return err
+	}
+	o.log = configureLogger(o.baseLog, level, config["logFormat"])
+
+	o.verifyChecksums = config["verifyChecksums"] == "true"
+	o.durableWrites = config["durableWrites"] != "false"
+	o.readOnly = config["readOnly"] == "true"
+	o.followSymlinks = config["followSymlinks"] == "true"
+	o.preserveOwnership = config["preserveOwnership"] == "true"
+	o.requireRemoteMount = config["requireRemoteMount"] == "true"
+	o.dryRun = config["dryRun"] == "true"
+	o.directIO = config["directIO"] == "true"
+	o.validateSignedURLReachability = config["validateSignedURLReachability"] == "true"
+	o.treatEmptyAsMissing = config["treatEmptyAsMissing"] == "true"
+	o.validateOnExists = config["validateOnExists"] == "true"
+
+	o.defaultACL = nil
+	if config["defaultACL"] != "" {
+		acl, err := parseACL(config["defaultACL"])
+		if err != nil {
+			return errors.Wrap(err, "invalid defaultACL")
+		}
+		o.defaultACL = acl
+	}
+
+	if err := validateWORMConfig(config); err != nil {
+		return err
+	}
+	o.worm = config["worm"] == "true"
+	o.wormImmutable = config["wormImmutable"] == "true"
+	o.wormRetention = 0
+	if o.worm {
+		retention, err := parseTTL(config["wormRetention"])
+		if err != nil {
+			return errors.Wrap(err, "invalid wormRetention")
+		}
+		o.wormRetention = retention
+	}
+
+	o.copyBufferSize = defaultCopyBufferSize
+	if config["copyBufferSizeBytes"] != "" {
+		size, err := strconv.Atoi(config["copyBufferSizeBytes"])
+		if err != nil || size <= 0 {
+			return errors.Errorf("invalid copyBufferSizeBytes %q", config["copyBufferSizeBytes"])
+		}
+		o.copyBufferSize = size
+	}
+
+	if err := validateKeyLayout(config["keyLayout"]); err != nil {
+		return err
+	}
+	o.keyLayout = config["keyLayout"]
+
+	if err := validateCompression(config["compression"]); err != nil {
+		return err
+	}
+	o.compression = config["compression"]
+	if o.compressionLevel, err = parseCompressionLevel(o.compression, config["compressionLevel"]); err != nil {
+		return err
+	}
+
+	o.maxRetries = defaultMaxRetries
+	if config["maxRetries"] != "" {
+		retries, err := strconv.Atoi(config["maxRetries"])
+		if err != nil || retries < 0 {
+			return errors.Errorf("invalid maxRetries %q", config["maxRetries"])
+		}
+		o.maxRetries = retries
+	}
+
+	o.uploadParallelism = 1
+	if config["uploadParallelism"] != "" {
+		parallelism, err := strconv.Atoi(config["uploadParallelism"])
+		if err != nil || parallelism < 1 {
+			return errors.Errorf("invalid uploadParallelism %q", config["uploadParallelism"])
+		}
+		o.uploadParallelism = parallelism
+	}
+
+	o.verifyWorkers = defaultVerifyWorkers
+	if config["verifyWorkers"] != "" {
+		workers, err := strconv.Atoi(config["verifyWorkers"])
+		if err != nil || workers < 1 {
+			return errors.Errorf("invalid verifyWorkers %q", config["verifyWorkers"])
+		}
+		o.verifyWorkers = workers
+	}
+
+	o.importWorkers = defaultImportWorkers
+	if config["importWorkers"] != "" {
+		workers, err := strconv.Atoi(config["importWorkers"])
+		if err != nil || workers < 1 {
+			return errors.Errorf("invalid importWorkers %q", config["importWorkers"])
+		}
+		o.importWorkers = workers
+	}
+
+	o.prefetchWorkers = defaultPrefetchWorkers
+	if config["prefetchWorkers"] != "" {
+		workers, err := strconv.Atoi(config["prefetchWorkers"])
+		if err != nil || workers < 1 {
+			return errors.Errorf("invalid prefetchWorkers %q", config["prefetchWorkers"])
+		}
+		o.prefetchWorkers = workers
+	}
+
+	if err := validateDedup(config["dedup"]); err != nil {
+		return err
+	}
+	o.dedup = config["dedup"]
+
+	o.syncEveryBytes = 0
+	if config["syncEveryBytes"] != "" {
+		every, err := strconv.ParseInt(config["syncEveryBytes"], 10, 64)
+		if err != nil || every < 0 {
+			return errors.Errorf("invalid syncEveryBytes %q", config["syncEveryBytes"])
+		}
+		o.syncEveryBytes = every
+	}
+
+	o.maxObjectSizeBytes = 0
+	if config["maxObjectSizeBytes"] != "" {
+		maxSize, err := strconv.ParseInt(config["maxObjectSizeBytes"], 10, 64)
+		if err != nil || maxSize < 0 {
+			return errors.Errorf("invalid maxObjectSizeBytes %q", config["maxObjectSizeBytes"])
+		}
+		o.maxObjectSizeBytes = maxSize
+	}
+
+	o.minFreeBytes = 0
+	if config["minFreeBytes"] != "" {
+		minFree, err := strconv.ParseInt(config["minFreeBytes"], 10, 64)
+		if err != nil || minFree < 0 {
+			return errors.Errorf("invalid minFreeBytes %q", config["minFreeBytes"])
+		}
+		o.minFreeBytes = minFree
+	}
+
+	auditLogPath, err := parseAuditLogPath(config["auditLogPath"])
+	if err != nil {
+		return err
+	}
+	o.auditLogPath = auditLogPath
+
+	o.auditLogMaxBytes = defaultAuditLogMaxBytes
+	if config["auditLogMaxBytes"] != "" {
+		maxBytes, err := strconv.ParseInt(config["auditLogMaxBytes"], 10, 64)
+		if err != nil || maxBytes <= 0 {
+			return errors.Errorf("invalid auditLogMaxBytes %q", config["auditLogMaxBytes"])
+		}
+		o.auditLogMaxBytes = maxBytes
+	}
+
+	o.tmpFileMaxAge = defaultTmpFileMaxAge
+	if config["tmpFileMaxAge"] != "" {
+		maxAge, err := time.ParseDuration(config["tmpFileMaxAge"])
+		if err != nil || maxAge <= 0 {
+			return errors.Errorf("invalid tmpFileMaxAge %q: must be a positive duration", config["tmpFileMaxAge"])
+		}
+		o.tmpFileMaxAge = maxAge
+	}
+
+	o.progressInterval = 0
+	if config["progressInterval"] != "" {
+		interval, err := time.ParseDuration(config["progressInterval"])
+		if err != nil || interval < 0 {
+			return errors.Errorf("invalid progressInterval %q: must be a non-negative duration", config["progressInterval"])
+		}
+		o.progressInterval = interval
+	}
+
+	o.statTimeout = 0
+	if config["statTimeout"] != "" {
+		timeout, err := time.ParseDuration(config["statTimeout"])
+		if err != nil || timeout < 0 {
+			return errors.Errorf("invalid statTimeout %q: must be a non-negative duration", config["statTimeout"])
+		}
+		o.statTimeout = timeout
+	}
+
+	o.extraSubdirs = nil
+	for _, subdir := range strings.Split(config["extraSubdirs"], ",") {
+		subdir = strings.TrimSpace(subdir)
+		if subdir == "" {
+			continue
+		}
+		cleaned := filepath.Clean(subdir)
+		if !filepath.IsLocal(cleaned) {
+			return errors.Errorf("invalid extraSubdirs entry %q: must be a relative path within the prefix", subdir)
+		}
+		o.extraSubdirs = append(o.extraSubdirs, cleaned)
+	}
+
+	rules, err := parseRetentionRules(config)
+	if err != nil {
+		return err
+	}
+	o.retentionRules = rules
+
+	o.retentionSweepInterval = defaultRetentionSweepInterval
+	if config["retentionSweepInterval"] != "" {
+		interval, err := time.ParseDuration(config["retentionSweepInterval"])
+		if err != nil || interval <= 0 {
+			return errors.Errorf("invalid retentionSweepInterval %q: must be a positive duration", config["retentionSweepInterval"])
+		}
+		o.retentionSweepInterval = interval
+	}
+
+	rootSubPath, err := parseRootSubPath(config["rootSubPath"])
+	if err != nil {
+		return err
+	}
+	o.rootSubPath = rootSubPath
+
+	return nil
+}
+
+// parseAuditLogPath returns the cleaned auditLogPath setting, or an error if it is not a relative path within the volume.
+func parseAuditLogPath(value string) (string, error) {
+	if value == "" {
+		return "", nil
+	}
+	auditLogPath := filepath.Clean(value)
+	if !filepath.IsLocal(auditLogPath) {
+		return "", errors.Errorf("invalid auditLogPath %q: must be a relative path within the volume", value)
+	}
+	return auditLogPath, nil
+}
+
+// parseRootSubPath returns the cleaned rootSubPath setting, empty for the root of the volume,
+// or an error if it is not a relative path within the volume.
+func parseRootSubPath(value string) (string, error) {
+	subPath := filepath.Clean(value)
+	if value == "" || subPath == "." {
+		return "", nil
+	}
+	if !filepath.IsLocal(subPath) {
+		return "", errors.Errorf("invalid rootSubPath %q: must be a relative path within the volume", value)
+	}
+	return subPath, nil
+}
+
+// pluginConfigKeys are the keys of the plugin ConfigMap, besides the per bucket keys starting with bucketConfigPrefix.
+var pluginConfigKeys = map[string]bool{
+	"fileserverImage":             true,
+	"securityContextRunAsUser":    true,
+	"securityContextRunAsGroup":   true,
+	"securityContextFsGroup":      true,
+	"preserveVolumes":             true,
+	"fileserverPort":              true,
+	"fileserverScheme":            true,
+	"fileserverExternalHost":      true,
+	"signingSecretName":           true,
+	"signingAlgorithm":            true,
+	"encryptionSecretName":        true,
+	"fileserverAuthSecretName":    true,
+	"dirMode":                     true,
+	"fileMode":                    true,
+	"rootPath":                    true,
+	"clockSkewTolerance":          true,
+	"usageCacheTTL":               true,
+	"fileserverImagePullPolicy":   true,
+	"fileserverCPURequest":        true,
+	"fileserverCPULimit":          true,
+	"fileserverMemoryRequest":     true,
+	"fileserverMemoryLimit":       true,
+	"nodeAgentDaemonsetName":      true,
+	"fileserverReadHeaderTimeout": true,
+	"fileserverReadTimeout":       true,
+	"fileserverWriteTimeout":      true,
+	"fileserverIdleTimeout":       true,
+	"fileserverBreakerFailures":   true,
+	"fileserverBreakerWindow":     true,
+	"fileserverBreakerCooldown":   true,
+}
+
+// parsePluginConfig returns the options set by the plugin ConfigMap data, which may be nil if there is no ConfigMap.
+// Invalid values are errors, while unknown keys, which are most likely typos, are logged and ignored.
+func parsePluginConfig(data map[string]string, log logrus.FieldLogger) (*localVolumeObjectStoreOpts, error) {
+	warnUnknownPluginConfigKeys(data, log)
+
+	preserveVolumes := make(map[string]bool)
+	for _, volume := range strings.Split(data["preserveVolumes"], ",") {
+		if volume = strings.TrimSpace(volume); volume != "" {
+			preserveVolumes[volume] = true
+		}
+	}
+
+	var fileserverPort int
+	if data["fileserverPort"] != "" {
+		port, err := strconv.Atoi(data["fileserverPort"])
+		if err != nil || port < 1 || port > 65535 {
+			return nil, errors.Errorf("invalid fileserverPort %q: must be between 1 and 65535", data["fileserverPort"])
+		}
+		fileserverPort = port
+	}
+
+	fileserverScheme := data["fileserverScheme"]
+	if fileserverScheme != "" && fileserverScheme != "http" && fileserverScheme != "https" {
+		return nil, errors.Errorf("invalid fileserverScheme %q: must be http or https", fileserverScheme)
+	}
+
+	if _, err := getSigningHash(data["signingAlgorithm"]); err != nil {
+		return nil, errors.Wrap(err, "invalid signingAlgorithm")
+	}
+
+	runAsUser, err := parseSecurityContextID("securityContextRunAsUser", data["securityContextRunAsUser"])
+	if err != nil {
+		return nil, err
+	}
+	runAsGroup, err := parseSecurityContextID("securityContextRunAsGroup", data["securityContextRunAsGroup"])
+	if err != nil {
+		return nil, err
+	}
+	fsGroup, err := parseSecurityContextID("securityContextFsGroup", data["securityContextFsGroup"])
+	if err != nil {
+		return nil, err
+	}
+
+	dirMode, err := parseFileMode("dirMode", data["dirMode"], defaultDirMode)
+	if err != nil {
+		return nil, err
+	}
+	fileMode, err := parseFileMode("fileMode", data["fileMode"], defaultFileMode)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := ParseClockSkewTolerance(data["clockSkewTolerance"]); err != nil {
+		return nil, err
+	}
+	if _, err := ParseUsageCacheTTL(data["usageCacheTTL"]); err != nil {
+		return nil, err
+	}
+	var fileserverTimeouts map[string]string
+	for _, key := range fileserverTimeoutKeys {
+		if data[key] == "" {
+			continue
+		}
+		if fileserverTimeouts == nil {
+			fileserverTimeouts = map[string]string{}
+		}
+		fileserverTimeouts[key] = data[key]
+	}
+	if _, err := ParseFileserverTimeouts(data["fileserverReadHeaderTimeout"], data["fileserverReadTimeout"],
+		data["fileserverWriteTimeout"], data["fileserverIdleTimeout"]); err != nil {
+		return nil, err
+	}
+	var fileserverBreaker map[string]string
+	for _, key := range fileserverBreakerKeys {
+		if data[key] == "" {
+			continue
+		}
+		if fileserverBreaker == nil {
+			fileserverBreaker = map[string]string{}
+		}
+		fileserverBreaker[key] = data[key]
+	}
+	if _, err := ParseFileserverBreaker(data["fileserverBreakerFailures"], data["fileserverBreakerWindow"],
+		data["fileserverBreakerCooldown"]); err != nil {
+		return nil, err
+	}
+
+	rootPath := data["rootPath"]
+	if rootPath != "" && !filepath.IsAbs(rootPath) {
+		return nil, errors.Errorf("invalid rootPath %q: must be an absolute path", rootPath)
+	}
+
+	pullPolicy, err := parseImagePullPolicy(data["fileserverImagePullPolicy"])
+	if err != nil {
+		return nil, err
+	}
+	resources, err := parseFileserverResources(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return &localVolumeObjectStoreOpts{
+		fileserverImage:           data["fileserverImage"],
+		securityContextRunAsUser:  runAsUser,
+		securityContextRunAsGroup: runAsGroup,
+		securityContextFSGroup:    fsGroup,
+		preserveVolumes:           preserveVolumes,
+		fileserverPort:            fileserverPort,
+		fileserverScheme:          fileserverScheme,
+		fileserverExternalHost:    data["fileserverExternalHost"],
+		signingSecretName:         data["signingSecretName"],
+		signingAlgorithm:          data["signingAlgorithm"],
+		encryptionSecretName:      data["encryptionSecretName"],
+		authSecretName:            data["fileserverAuthSecretName"],
+		dirMode:                   dirMode,
+		fileMode:                  fileMode,
+		bucketConfigs:             parseBucketConfigs(data),
+		rootPath:                  rootPath,
+		clockSkewTolerance:        data["clockSkewTolerance"],
+		usageCacheTTL:             data["usageCacheTTL"],
+		fileserverImagePullPolicy: pullPolicy,
+		fileserverResources:       resources,
+		nodeAgentDaemonsetName:    data["nodeAgentDaemonsetName"],
+		fileserverTimeouts:        fileserverTimeouts,
+		fileserverBreaker:         fileserverBreaker,
+	}, nil
+}
+
+// warnUnknownPluginConfigKeys logs a warning for each key of the plugin ConfigMap data that the plugin does not use.
+func warnUnknownPluginConfigKeys(data map[string]string, log logrus.FieldLogger) {
+	var unknown []string
+	for key := range data {
+		if isBucketConfigKey(key) || pluginConfigKeys[key] {
+			continue
+		}
+		unknown = append(unknown, key)
+	}
+	sort.Strings(unknown)
+	for _, key := range unknown {
+		log.Warnf("Ignoring unknown key %q in the plugin config map", key)
+	}
+}
+
+// bucketConfigPrefix starts the plugin ConfigMap keys that configure a single bucket, as buckets.<bucket>.<key>.
+const bucketConfigPrefix = "buckets."
+
+// parseBucketConfigs returns the per bucket config found in the plugin ConfigMap data, keyed by bucket.
+func parseBucketConfigs(data map[string]string) map[string]map[string]string {
+	configs := map[string]map[string]string{}
+	for key, value := range data {
+		if !strings.HasPrefix(key, bucketConfigPrefix) {
+			continue
+		}
+		bucket, configKey, ok := splitBucketConfigKey(key)
+		if !ok {
+			continue
+		}
+		if configs[bucket] == nil {
+			configs[bucket] = map[string]string{}
+		}
+		configs[bucket][configKey] = value
+	}
+	return configs
+}
+
+// splitBucketConfigKey returns the bucket and config key of a buckets.<bucket>.<key> plugin ConfigMap key.
+func splitBucketConfigKey(key string) (bucket, configKey string, ok bool) {
+	if !strings.HasPrefix(key, bucketConfigPrefix) {
+		return "", "", false
+	}
+	// Bucket names may contain dots, config keys do not
+	rest := strings.TrimPrefix(key, bucketConfigPrefix)
+	i := strings.LastIndex(rest, ".")
+	if i <= 0 || i == len(rest)-1 {
+		return "", "", false
+	}
+	return rest[:i], rest[i+1:], true
+}
+
+// isBucketConfigKey returns truthy if the plugin ConfigMap key configures a single bucket.
+func isBucketConfigKey(key string) bool {
+	_, _, ok := splitBucketConfigKey(key)
+	return ok
+}
+
+// withBucketConfig returns the BSL config with the keys it does not set filled in from the bucket's plugin ConfigMap config.
+func withBucketConfig(config, bucketConfig map[string]string) map[string]string {
+	if len(bucketConfig) == 0 {
+		return config
+	}
+	merged := make(map[string]string, len(config)+len(bucketConfig))
+	for key, value := range bucketConfig {
+		merged[key] = value
+	}
+	for key, value := range config {
+		merged[key] = value
+	}
+	return merged
+}
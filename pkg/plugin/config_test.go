@@ -0,0 +1,281 @@
+package plugin
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/utils/pointer"
+)
+
+func Test_parsePluginConfig(t *testing.T) {
+	tests := []struct {
+		name         string
+		data         map[string]string
+		want         *localVolumeObjectStoreOpts
+		wantErr      string
+		wantWarnings []string
+	}{
+		{
+			name: "missing config map -- defaults",
+			data: nil,
+			want: &localVolumeObjectStoreOpts{
+				preserveVolumes: map[string]bool{},
+				dirMode:         defaultDirMode,
+				fileMode:        defaultFileMode,
+				bucketConfigs:   map[string]map[string]string{},
+			},
+		},
+		{
+			name: "valid config map",
+			data: map[string]string{
+				"securityContextRunAsUser": "1001",
+				"preserveVolumes":          "my-bucket, my-other-bucket",
+				"fileserverPort":           "8080",
+				"dirMode":                  "0700",
+				"buckets.daily.path":       "/exports/daily",
+			},
+			want: &localVolumeObjectStoreOpts{
+				securityContextRunAsUser: pointer.Int64Ptr(1001),
+				preserveVolumes:          map[string]bool{"my-bucket": true, "my-other-bucket": true},
+				fileserverPort:           8080,
+				dirMode:                  0700,
+				fileMode:                 defaultFileMode,
+				bucketConfigs:            map[string]map[string]string{"daily": {"path": "/exports/daily"}},
+			},
+		},
+		{
+			name: "unknown keys -- warned and ignored",
+			data: map[string]string{
+				"fileserverPrt": "8080",
+				"buckets.daily": "/exports/daily",
+			},
+			want: &localVolumeObjectStoreOpts{
+				preserveVolumes: map[string]bool{},
+				dirMode:         defaultDirMode,
+				fileMode:        defaultFileMode,
+				bucketConfigs:   map[string]map[string]string{},
+			},
+			wantWarnings: []string{"buckets.daily", "fileserverPrt"},
+		},
+		{
+			name:    "non-numeric security context",
+			data:    map[string]string{"securityContextRunAsUser": "nobody"},
+			wantErr: "securityContextRunAsUser",
+		},
+		{
+			name:    "bad octal mode",
+			data:    map[string]string{"dirMode": "0999"},
+			wantErr: "dirMode",
+		},
+		{
+			name:    "port out of range",
+			data:    map[string]string{"fileserverPort": "70000"},
+			wantErr: "fileserverPort",
+		},
+		{
+			name:    "unknown image pull policy",
+			data:    map[string]string{"fileserverImagePullPolicy": "Sometimes"},
+			wantErr: "fileserverImagePullPolicy",
+		},
+		{
+			name:    "bad resource quantity",
+			data:    map[string]string{"fileserverMemoryLimit": "lots"},
+			wantErr: "fileserverMemoryLimit",
+		},
+		{
+			name:    "negative fileserver timeout",
+			data:    map[string]string{"fileserverReadHeaderTimeout": "-1s"},
+			wantErr: "fileserverReadHeaderTimeout",
+		},
+		{
+			name:    "zero breaker cooldown",
+			data:    map[string]string{"fileserverBreakerCooldown": "0s"},
+			wantErr: "fileserverBreakerCooldown",
+		},
+		{
+			name:    "request exceeds limit",
+			data:    map[string]string{"fileserverCPURequest": "2", "fileserverCPULimit": "500m"},
+			wantErr: "cpu request 2 exceeds its limit 500m",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := require.New(t)
+			var out strings.Builder
+			log := logrus.New()
+			log.Out = &out
+
+			got, err := parsePluginConfig(tt.data, log)
+			if tt.wantErr != "" {
+				req.ErrorContains(err, tt.wantErr)
+				return
+			}
+			req.NoError(err)
+			req.Equal(tt.want, got)
+
+			warnings := strings.Count(out.String(), "level=warning")
+			req.Equal(len(tt.wantWarnings), warnings, out.String())
+			for _, warning := range tt.wantWarnings {
+				req.Contains(out.String(), warning)
+			}
+		})
+	}
+}
+
+func Test_parseBucketConfigs(t *testing.T) {
+	got := parseBucketConfigs(map[string]string{
+		"fileserverPort":             "3000",
+		"buckets.daily.server":       "nfs-daily.example.com",
+		"buckets.daily.path":         "/exports/daily",
+		"buckets.weekly.v1.server":   "nfs-weekly.example.com",
+		"buckets.weekly.v1.path":     "/exports/weekly",
+		"buckets.missing-key":        "ignored",
+		"buckets.trailing-dot.":      "ignored",
+		"buckets..leading-dot-empty": "ignored",
+	})
+	require.Equal(t, map[string]map[string]string{
+		"daily":     {"server": "nfs-daily.example.com", "path": "/exports/daily"},
+		"weekly.v1": {"server": "nfs-weekly.example.com", "path": "/exports/weekly"},
+	}, got)
+}
+
+func Test_withBucketConfig(t *testing.T) {
+	config := map[string]string{"bucket": "daily", "path": "/bsl/path"}
+	merged := withBucketConfig(config, map[string]string{"bucket": "other", "path": "/configmap/path", "server": "nfs.example.com"})
+	require.Equal(t, map[string]string{"bucket": "daily", "path": "/bsl/path", "server": "nfs.example.com"}, merged)
+	require.Equal(t, map[string]string{"bucket": "daily", "path": "/bsl/path"}, config)
+}
+
+func Test_multipleBuckets(t *testing.T) {
+	req := require.New(t)
+	root := t.TempDir()
+	t.Setenv("VOLUME_ROOT", root)
+
+	bucketConfigs := parseBucketConfigs(map[string]string{
+		"buckets.daily.server":  "nfs-daily.example.com",
+		"buckets.daily.path":    "/exports/daily",
+		"buckets.weekly.server": "nfs-weekly.example.com",
+		"buckets.weekly.path":   "/exports/weekly",
+	})
+	clientset := fake.NewSimpleClientset(&appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: VeleroDeploymentName, Namespace: "velero"},
+		Spec: appsv1.DeploymentSpec{
+			Template: corev1.PodTemplateSpec{
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{{Name: "velero"}},
+				},
+			},
+		},
+	})
+
+	for _, bucket := range []string{"daily", "weekly"} {
+		config := withBucketConfig(map[string]string{"bucket": bucket}, bucketConfigs[bucket])
+		req.NoError(validateVolumeConfig(NFS, config))
+		_, err := ensureResources(EnsureResourcesOpts{
+			clientset:  clientset,
+			namespace:  "velero",
+			bucket:     bucket,
+			path:       filepath.Join(getRoot(), bucket),
+			config:     config,
+			pluginOpts: &localVolumeObjectStoreOpts{bucketConfigs: bucketConfigs},
+			volumeType: NFS,
+			log:        logrus.NewEntry(logrus.New()),
+		})
+		req.NoError(err)
+	}
+
+	deployment, err := clientset.AppsV1().Deployments("velero").Get(context.Background(), VeleroDeploymentName, metav1.GetOptions{})
+	req.NoError(err)
+	servers := map[string]string{}
+	for _, volume := range deployment.Spec.Template.Spec.Volumes {
+		if volume.NFS != nil {
+			servers[volume.Name] = volume.NFS.Server + ":" + volume.NFS.Path
+		}
+	}
+	req.Equal(map[string]string{
+		"daily":  "nfs-daily.example.com:/exports/daily",
+		"weekly": "nfs-weekly.example.com:/exports/weekly",
+	}, servers)
+	mounts := map[string]string{}
+	for _, mount := range getContainerByName(deployment, "velero").VolumeMounts {
+		mounts[mount.Name] = mount.MountPath
+	}
+	req.Equal(filepath.Join(root, "daily"), mounts["daily"])
+	req.Equal(filepath.Join(root, "weekly"), mounts["weekly"])
+
+	// objects of each bucket are stored on that bucket's mount
+	o := NewLocalVolumeObjectStore(logrus.New(), NFS)
+	req.NoError(o.PutObject("daily", "backups/b/b.tar.gz", strings.NewReader("daily")))
+	req.NoError(o.PutObject("weekly", "backups/b/b.tar.gz", strings.NewReader("weekly")))
+	for _, bucket := range []string{"daily", "weekly"} {
+		rc, err := o.GetObject(bucket, "backups/b/b.tar.gz")
+		req.NoError(err)
+		buf := new(strings.Builder)
+		_, err = copyBuffered(buf, rc, defaultCopyBufferSize)
+		rc.Close()
+		req.NoError(err)
+		req.Equal(bucket, buf.String())
+		req.FileExists(filepath.Join(root, bucket, "backups", "b", "b.tar.gz"))
+	}
+}
+
+func Test_extraSubdirs(t *testing.T) {
+	tests := []struct {
+		name         string
+		extraSubdirs string
+		wantSubdirs  []string
+		wantErr      bool
+	}{
+		{
+			name:        "defaults only",
+			wantSubdirs: getSubDirectoryLayout(),
+		},
+		{
+			name:         "extra subdirectories",
+			extraSubdirs: "kopia, tooling/restores,",
+			wantSubdirs:  append(getSubDirectoryLayout(), "kopia", filepath.Join("tooling", "restores")),
+		},
+		{
+			name:         "escaping the prefix",
+			extraSubdirs: "kopia,../other-prefix",
+			wantErr:      true,
+		},
+		{
+			name:         "absolute path",
+			extraSubdirs: "/etc",
+			wantErr:      true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := require.New(t)
+			o, root := newTestObjectStore(t)
+			err := o.applyConfig(map[string]string{"extraSubdirs": tt.extraSubdirs})
+			if tt.wantErr {
+				req.Error(err)
+				return
+			}
+			req.NoError(err)
+
+			path := filepath.Join(root, "my-bucket")
+			req.NoError(os.Mkdir(path, 0755))
+			req.NoError(ensureFilesystem(path, "my-prefix", o.getSubDirectoryLayout(), 0750, logrus.NewEntry(logrus.New())))
+
+			for _, subdir := range tt.wantSubdirs {
+				info, err := os.Stat(filepath.Join(path, "my-prefix", subdir))
+				req.NoError(err, subdir)
+				req.True(info.IsDir())
+				req.Equal(os.FileMode(0750), info.Mode().Perm())
+			}
+		})
+	}
+}
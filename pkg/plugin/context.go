@@ -0,0 +1,42 @@
+package plugin
+
+import (
+	"context"
+	"io"
+)
+
+// contextReader fails reads once its context is done, so a copy from it can be cancelled between reads.
+type contextReader struct {
+	ctx context.Context
+	io.Reader
+}
+
+func (r *contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.Reader.Read(p)
+}
+
+// contextReaderAt is the io.ReaderAt counterpart of contextReader.
+type contextReaderAt struct {
+	ctx context.Context
+	io.ReaderAt
+}
+
+func (r *contextReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.ReaderAt.ReadAt(p, off)
+}
+
+// contextReadCloser is a contextReader that closes the underlying reader.
+type contextReadCloser struct {
+	contextReader
+	closer io.Closer
+}
+
+func (r *contextReadCloser) Close() error {
+	return r.closer.Close()
+}
@@ -0,0 +1,87 @@
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// cancellingReader cancels its context once the given number of bytes have been read.
+type cancellingReader struct {
+	*bytes.Reader
+	cancel context.CancelFunc
+	after  int64
+	read   int64
+}
+
+func (r *cancellingReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.read += int64(n)
+	if r.read >= r.after {
+		r.cancel()
+	}
+	return n, err
+}
+
+func Test_PutObjectCtx_cancelled(t *testing.T) {
+	tests := []struct {
+		name              string
+		uploadParallelism int
+	}{
+		{
+			name:              "sequential write",
+			uploadParallelism: 1,
+		},
+		{
+			name:              "parallel write",
+			uploadParallelism: 4,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := require.New(t)
+			o, root := newTestObjectStore(t)
+			o.copyBufferSize = 1024
+			o.uploadParallelism = tt.uploadParallelism
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			content := bytes.Repeat([]byte("backup contents "), 10000)
+			body := &cancellingReader{Reader: bytes.NewReader(content), cancel: cancel, after: 4096}
+			if tt.uploadParallelism > 1 {
+				// the parallel path reads with ReadAt, so cancel before it starts
+				cancel()
+			}
+
+			err := o.PutObjectCtx(ctx, "my-bucket", "backups/my-backup/my-backup.tar.gz", body)
+			req.ErrorIs(err, context.Canceled)
+
+			entries, err := os.ReadDir(filepath.Join(root, "my-bucket", "backups", "my-backup"))
+			req.NoError(err)
+			req.Empty(entries, "partial files should be removed")
+		})
+	}
+}
+
+func Test_GetObjectCtx_cancelled(t *testing.T) {
+	req := require.New(t)
+	o, _ := newTestObjectStore(t)
+	req.NoError(o.PutObject("my-bucket", "backups/my-backup/my-backup.tar.gz", bytes.NewReader([]byte("backup contents"))))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	rc, err := o.GetObjectCtx(ctx, "my-bucket", "backups/my-backup/my-backup.tar.gz")
+	req.NoError(err)
+	defer rc.Close()
+
+	cancel()
+	_, err = io.ReadAll(rc)
+	req.ErrorIs(err, context.Canceled)
+
+	_, err = o.GetObjectCtx(ctx, "my-bucket", "backups/my-backup/my-backup.tar.gz")
+	req.ErrorIs(err, context.Canceled)
+}
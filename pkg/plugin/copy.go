@@ -0,0 +1,262 @@
+package plugin
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+)
+
+// CopyObject copies the object at srcKey to dstKey within the bucket, along with its checksum and metadata.
+// The copy shares storage with the source where the filesystem supports reflinks,
+// and is otherwise copied within the kernel where possible rather than through the plugin.
+func (o *LocalVolumeObjectStore) CopyObject(bucket, srcKey, dstKey string) (err error) {
+	defer observeOperation("CopyObject", time.Now(), &err)
+	if o.readOnly {
+		return ErrReadOnly
+	}
+
+	done, err := o.beginWrite()
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	srcPath, err := o.objectPath(bucket, srcKey)
+	if err != nil {
+		return err
+	}
+	dstPath, err := o.objectPath(bucket, dstKey)
+	if err != nil {
+		return err
+	}
+
+	log := o.log.WithFields(logrus.Fields{
+		"bucket": bucket,
+		"srcKey": srcKey,
+		"dstKey": dstKey,
+	})
+	log.Debug("LocalVolumeObjectStore.CopyObject called")
+	// The source is locked too, so its checksum and metadata match the content copied
+	defer o.keyLocks.lockAll(srcPath, dstPath)()
+	defer func() { o.auditLog.record(log, "CopyObject", 0, err) }()
+
+	return o.copyObject(bucket, srcPath, dstPath, log)
+}
+
+// copyObject copies the object at srcPath to dstPath, as CopyObject. The caller holds the key locks.
+func (o *LocalVolumeObjectStore) copyObject(bucket, srcPath, dstPath string, log logrus.FieldLogger) error {
+	// The object being replaced may be the last link to a deduplicated blob
+	defer releaseDedupBlobs(linkedDedupBlobs(o.bucketPath(bucket), dstPath), log)
+
+	srcFilePath, compression, err := findObjectFile(srcPath)
+	if err != nil {
+		return err
+	}
+	if err := o.checkSymlinks(o.bucketPath(bucket), srcFilePath); err != nil {
+		return err
+	}
+	// Opening a FIFO or device would block or read something other than an object
+	if err := checkRegularFile(srcFilePath); err != nil {
+		return err
+	}
+	if srcPath == dstPath {
+		return nil
+	}
+	if err := o.checkSymlinks(o.bucketPath(bucket), dstPath); err != nil {
+		return err
+	}
+	if err := o.checkWORMRetention(dstPath); err != nil {
+		return err
+	}
+
+	digest, err := readChecksum(srcPath)
+	if err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "failed to read object checksum")
+	}
+	meta, err := readMetadata(srcPath, srcFilePath)
+	if err != nil {
+		return err
+	}
+
+	src, err := os.Open(srcFilePath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	if err := mkdirAll(filepath.Dir(dstPath), o.getDirMode()); err != nil {
+		return err
+	}
+	dstFilePath := compressedPath(dstPath, compression)
+
+	_, err = writeObjectFile(dstFilePath, o.getFileMode(), false, log, func(file *os.File) (string, error) {
+		return digest, copyFile(file, src, o.copyBufferSize)
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := o.finishPutObject(bucket, dstPath, dstFilePath, digest, log); err != nil {
+		return err
+	}
+	if len(meta) > 0 {
+		if err := o.writeMetadata(dstPath, dstFilePath, meta, log); err != nil {
+			return err
+		}
+	}
+	if err := o.applyRecordedOwnership(dstFilePath, meta, log); err != nil {
+		return err
+	}
+	if err := o.applyDefaultACL(dstFilePath, log); err != nil {
+		return err
+	}
+	return o.protectWORMObject(dstFilePath, log)
+}
+
+// MoveObject moves the object at srcKey to dstKey within the bucket, along with its checksum and metadata.
+// The object is renamed into place, unless the keys are on different filesystems in which case it is copied and deleted.
+func (o *LocalVolumeObjectStore) MoveObject(bucket, srcKey, dstKey string) (err error) {
+	defer observeOperation("MoveObject", time.Now(), &err)
+	if o.readOnly {
+		return ErrReadOnly
+	}
+
+	done, err := o.beginWrite()
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	srcPath, err := o.objectPath(bucket, srcKey)
+	if err != nil {
+		return err
+	}
+	dstPath, err := o.objectPath(bucket, dstKey)
+	if err != nil {
+		return err
+	}
+
+	log := o.log.WithFields(logrus.Fields{
+		"bucket": bucket,
+		"srcKey": srcKey,
+		"dstKey": dstKey,
+	})
+	log.Debug("LocalVolumeObjectStore.MoveObject called")
+	defer o.keyLocks.lockAll(srcPath, dstPath)()
+	defer func() { o.auditLog.record(log, "MoveObject", 0, err) }()
+
+	// The object being replaced may be the last link to a deduplicated blob
+	defer releaseDedupBlobs(linkedDedupBlobs(o.bucketPath(bucket), dstPath), log)
+
+	srcFilePath, compression, err := findObjectFile(srcPath)
+	if err != nil {
+		return err
+	}
+	if err := o.checkSymlinks(o.bucketPath(bucket), srcFilePath); err != nil {
+		return err
+	}
+	if srcPath == dstPath {
+		return nil
+	}
+	if err := o.checkSymlinks(o.bucketPath(bucket), dstPath); err != nil {
+		return err
+	}
+	// Moving an object removes it from its key, and replaces any object at the destination
+	if err := o.checkWORMRetention(srcPath); err != nil {
+		return err
+	}
+	if err := o.checkWORMRetention(dstPath); err != nil {
+		return err
+	}
+
+	if err := mkdirAll(filepath.Dir(dstPath), o.getDirMode()); err != nil {
+		return err
+	}
+	dstFilePath := compressedPath(dstPath, compression)
+
+	if err := os.Rename(srcFilePath, dstFilePath); errors.Is(err, syscall.EXDEV) {
+		log.Debug("Keys are on different filesystems, copying object")
+		if err := o.copyObject(bucket, srcPath, dstPath, log); err != nil {
+			return err
+		}
+		if _, err := o.removeObject(o.bucketPath(bucket), srcPath, log); err != nil {
+			return err
+		}
+		o.cleanupDir(bucket, filepath.Dir(srcPath), log)
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	removeStaleObjectFile(dstPath, dstFilePath, log)
+	if err := os.Rename(checksumPath(srcPath), checksumPath(dstPath)); os.IsNotExist(err) {
+		if err := removeChecksum(dstPath); err != nil {
+			return errors.Wrap(err, "failed to remove object checksum")
+		}
+	} else if err != nil {
+		return errors.Wrap(err, "failed to move object checksum")
+	}
+	// Metadata in an extended attribute moves with the file
+	if err := os.Rename(metadataPath(srcPath), metadataPath(dstPath)); os.IsNotExist(err) {
+		if err := os.Remove(metadataPath(dstPath)); err != nil && !os.IsNotExist(err) {
+			return errors.Wrap(err, "failed to remove object metadata sidecar")
+		}
+	} else if err != nil {
+		return errors.Wrap(err, "failed to move object metadata sidecar")
+	}
+
+	if o.durableWrites {
+		for _, dir := range []string{filepath.Dir(dstPath), filepath.Dir(srcPath)} {
+			if err := syncDir(dir); err != nil {
+				return errors.Wrap(err, "failed to sync object directory")
+			}
+		}
+	}
+
+	o.cleanupDir(bucket, filepath.Dir(srcPath), log)
+
+	return nil
+}
+
+// copyFile copies the contents of src to dst, both positioned at their start.
+// It clones the file where the filesystem supports it, then tries copy_file_range,
+// which NFS 4.2 performs on the server, before falling back to copying through a buffer.
+func copyFile(dst, src *os.File, bufferSize int) error {
+	if err := unix.IoctlFileClone(int(dst.Fd()), int(src.Fd())); err == nil {
+		return nil
+	}
+
+	info, err := src.Stat()
+	if err != nil {
+		return err
+	}
+	remaining := info.Size()
+	for remaining > 0 {
+		n, err := unix.CopyFileRange(int(src.Fd()), nil, int(dst.Fd()), nil, int(remaining), 0)
+		if err != nil {
+			if remaining == info.Size() {
+				// Not supported between these files; nothing has been copied yet
+				break
+			}
+			return errors.Wrap(err, "failed to copy object")
+		}
+		if n == 0 {
+			break
+		}
+		remaining -= int64(n)
+	}
+	if remaining == 0 {
+		return nil
+	}
+
+	// copy_file_range advances both file offsets, so this copies whatever it did not
+	if _, err := copyBuffered(dst, src, bufferSize); err != nil {
+		return errors.Wrap(err, "failed to copy object")
+	}
+	return nil
+}
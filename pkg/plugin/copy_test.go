@@ -0,0 +1,160 @@
+package plugin
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_CopyObject(t *testing.T) {
+	tests := []struct {
+		name        string
+		srcKey      string
+		dstKey      string
+		compression string
+		wantErr     error
+	}{
+		{
+			name:   "cross-prefix copy",
+			srcKey: "backups/my-backup/my-backup.tar.gz",
+			dstKey: "archive/my-backup/my-backup.tar.gz",
+		},
+		{
+			name:        "compressed object",
+			srcKey:      "backups/my-backup/my-backup.tar.gz",
+			dstKey:      "archive/my-backup/my-backup.tar.gz",
+			compression: compressionGzip,
+		},
+		{
+			name:    "missing source",
+			srcKey:  "backups/my-backup/missing",
+			dstKey:  "archive/my-backup/missing",
+			wantErr: os.ErrNotExist,
+		},
+		{
+			name:    "path traversal",
+			srcKey:  "backups/my-backup/my-backup.tar.gz",
+			dstKey:  "../../etc/passwd",
+			wantErr: ErrPathTraversal,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := require.New(t)
+			o, root := newTestObjectStore(t)
+			o.compression = tt.compression
+			o.verifyChecksums = true
+			req.NoError(o.PutObject("my-bucket", "backups/my-backup/my-backup.tar.gz", strings.NewReader("backup contents")))
+
+			err := o.CopyObject("my-bucket", tt.srcKey, tt.dstKey)
+			if tt.wantErr != nil {
+				req.ErrorIs(err, tt.wantErr)
+				return
+			}
+			req.NoError(err)
+
+			for _, key := range []string{tt.srcKey, tt.dstKey} {
+				rc, err := o.GetObject("my-bucket", key)
+				req.NoError(err)
+				content, err := io.ReadAll(rc)
+				rc.Close()
+				req.NoError(err)
+				req.Equal("backup contents", string(content))
+			}
+			_, err = os.Stat(checksumPath(filepath.Join(root, "my-bucket", tt.dstKey)))
+			req.NoError(err)
+		})
+	}
+}
+
+func Test_MoveObject(t *testing.T) {
+	tests := []struct {
+		name          string
+		srcKey        string
+		dstKey        string
+		wantErr       error
+		wantBackupDir bool
+	}{
+		{
+			name:          "same directory move",
+			srcKey:        "backups/my-backup/my-backup.tar.gz",
+			dstKey:        "backups/my-backup/renamed.tar.gz",
+			wantBackupDir: true,
+		},
+		{
+			name:          "cross-prefix move -- emptied backup directory is removed",
+			srcKey:        "backups/my-backup/my-backup.tar.gz",
+			dstKey:        "archive/my-backup/my-backup.tar.gz",
+			wantBackupDir: false,
+		},
+		{
+			name:          "missing source",
+			srcKey:        "backups/my-backup/missing",
+			dstKey:        "backups/my-backup/renamed",
+			wantErr:       os.ErrNotExist,
+			wantBackupDir: true,
+		},
+		{
+			name:          "path traversal",
+			srcKey:        "../../etc/passwd",
+			dstKey:        "backups/my-backup/passwd",
+			wantErr:       ErrPathTraversal,
+			wantBackupDir: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := require.New(t)
+			o, root := newTestObjectStore(t)
+			o.verifyChecksums = true
+			req.NoError(o.PutObject("my-bucket", "backups/my-backup/my-backup.tar.gz", strings.NewReader("backup contents")))
+
+			err := o.MoveObject("my-bucket", tt.srcKey, tt.dstKey)
+
+			_, statErr := os.Stat(filepath.Join(root, "my-bucket", "backups", "my-backup"))
+			req.Equal(tt.wantBackupDir, statErr == nil)
+
+			if tt.wantErr != nil {
+				req.ErrorIs(err, tt.wantErr)
+				return
+			}
+			req.NoError(err)
+
+			exists, err := o.ObjectExists("my-bucket", tt.srcKey)
+			req.NoError(err)
+			req.False(exists)
+			_, err = os.Stat(checksumPath(filepath.Join(root, "my-bucket", tt.srcKey)))
+			req.True(os.IsNotExist(err))
+
+			rc, err := o.GetObject("my-bucket", tt.dstKey)
+			req.NoError(err)
+			defer rc.Close()
+			content, err := io.ReadAll(rc)
+			req.NoError(err)
+			req.Equal("backup contents", string(content))
+		})
+	}
+}
+
+func Test_copyFile(t *testing.T) {
+	req := require.New(t)
+	dir := t.TempDir()
+	content := strings.Repeat("0123456789", 100000)
+	req.NoError(os.WriteFile(filepath.Join(dir, "src"), []byte(content), 0644))
+
+	src, err := os.Open(filepath.Join(dir, "src"))
+	req.NoError(err)
+	defer src.Close()
+	dst, err := os.Create(filepath.Join(dir, "dst"))
+	req.NoError(err)
+	defer dst.Close()
+
+	req.NoError(copyFile(dst, src, 4096))
+	got, err := os.ReadFile(filepath.Join(dir, "dst"))
+	req.NoError(err)
+	req.Equal(content, string(got))
+}
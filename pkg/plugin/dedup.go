@@ -0,0 +1,126 @@
+package plugin
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const dedupHardlink = "hardlink"
+
+// dedupDirName is the directory at the root of a bucket holding one blob per distinct object content,
+// named by its checksum. Deduplicated objects are hardlinks to these blobs.
+const dedupDirName = ".dedup"
+
+// validateDedup returns an error if the deduplication mode is not supported.
+func validateDedup(dedup string) error {
+	switch dedup {
+	case "", dedupHardlink:
+		return nil
+	default:
+		return errors.Errorf("unsupported dedup %q", dedup)
+	}
+}
+
+// dedupBlobPath returns the path of the blob for content with the digest, stored with the compression and encrypted
+// with the key, if not nil. Objects stored uncompressed and in each compression format hold different bytes, and only
+// those encrypted with the same key can be read with it, so each form has its own blob. Encrypted blobs are named by
+// a fingerprint of their key, which does not reveal the key.
+func dedupBlobPath(bucketPath, digest, compression string, key []byte) string {
+	name := digest
+	if key != nil {
+		fingerprint := sha256.Sum256(append([]byte("lvp-dedup-key:"), key...))
+		name += "-" + hex.EncodeToString(fingerprint[:8])
+	}
+	return compressedPath(filepath.Join(bucketPath, dedupDirName, name), compression)
+}
+
+// isDedupDir returns truthy if path is the blob store of the bucket.
+func isDedupDir(bucketPath, path string) bool {
+	return path == filepath.Join(bucketPath, dedupDirName)
+}
+
+// dedupObjectFile replaces the new object file at filePath with a hardlink to the blob of its content,
+// making it the blob if there is none yet.
+func (o *LocalVolumeObjectStore) dedupObjectFile(bucketPath, filePath, digest string, log logrus.FieldLogger) error {
+	blob := dedupBlobPath(bucketPath, digest, fileCompression(filePath), o.getEncryptionKey())
+	if err := mkdirAll(filepath.Dir(blob), o.getDirMode()); err != nil {
+		return err
+	}
+
+	err := os.Link(filePath, blob)
+	if err == nil {
+		log.Debugf("Stored new dedup blob %s", blob)
+	} else if os.IsExist(err) {
+		log.Debugf("Linking to existing dedup blob %s", blob)
+		tmpPath := tempFilePath(filePath)
+		if err := os.Link(blob, tmpPath); err != nil {
+			return err
+		}
+		if err := os.Rename(tmpPath, filePath); err != nil {
+			os.Remove(tmpPath)
+			return err
+		}
+	} else {
+		return err
+	}
+
+	if o.durableWrites {
+		return syncDir(filepath.Dir(blob))
+	}
+	return nil
+}
+
+// linkedDedupBlobs returns the blobs that the existing files of the object at path are linked to.
+// The blobs of the content in every form are checked, as the object may have been encrypted with another key.
+func linkedDedupBlobs(bucketPath, path string) []string {
+	var blobs []string
+	for _, filePath := range objectFilePaths(path) {
+		info, err := os.Stat(filePath)
+		if err != nil || linkCount(info) < 2 {
+			continue
+		}
+		digest, err := readChecksum(path)
+		if err != nil {
+			continue
+		}
+		candidates, err := filepath.Glob(filepath.Join(bucketPath, dedupDirName, digest+"*"))
+		if err != nil {
+			continue
+		}
+		for _, blob := range candidates {
+			if blobInfo, err := os.Stat(blob); err == nil && os.SameFile(info, blobInfo) {
+				blobs = append(blobs, blob)
+			}
+		}
+	}
+	return blobs
+}
+
+// releaseDedupBlobs removes the blobs that are no longer linked to by any object.
+func releaseDedupBlobs(blobs []string, log logrus.FieldLogger) {
+	for _, blob := range blobs {
+		info, err := os.Stat(blob)
+		if err != nil || linkCount(info) > 1 {
+			continue
+		}
+		if err := os.Remove(blob); err != nil {
+			log.WithError(err).Warnf("Failed to remove unused dedup blob %s", blob)
+		} else {
+			log.Debugf("Removed unused dedup blob %s", blob)
+		}
+	}
+}
+
+// linkCount returns the number of hardlinks to the file.
+func linkCount(info os.FileInfo) uint64 {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		return uint64(stat.Nlink)
+	}
+	return 1
+}
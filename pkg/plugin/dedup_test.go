@@ -0,0 +1,106 @@
+package plugin
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_dedup(t *testing.T) {
+	req := require.New(t)
+	o, root := newTestObjectStore(t)
+	req.NoError(o.applyConfig(map[string]string{"dedup": dedupHardlink}))
+	bucketPath := filepath.Join(root, "my-bucket")
+
+	first := "backups/backup-1/backup-1.tar.gz"
+	second := "backups/backup-2/backup-2.tar.gz"
+	req.NoError(o.PutObject("my-bucket", first, strings.NewReader("same contents")))
+	req.NoError(o.PutObject("my-bucket", second, strings.NewReader("same contents")))
+	req.NoError(o.PutObject("my-bucket", "backups/backup-2/other", strings.NewReader("other contents")))
+
+	digest, err := readChecksum(filepath.Join(bucketPath, first))
+	req.NoError(err)
+	blob := dedupBlobPath(bucketPath, digest, "", nil)
+
+	linkCountOf := func(path string) uint64 {
+		info, err := os.Stat(path)
+		req.NoError(err)
+		return linkCount(info)
+	}
+	req.Equal(uint64(3), linkCountOf(blob))
+	req.Equal(uint64(3), linkCountOf(filepath.Join(bucketPath, second)))
+
+	objects, err := o.ListObjects("my-bucket", "")
+	req.NoError(err)
+	req.ElementsMatch([]string{first, second, "backups/backup-2/other"}, objects)
+	prefixes, err := o.ListCommonPrefixes("my-bucket", "", "/")
+	req.NoError(err)
+	req.NotContains(prefixes, dedupDirName+"/")
+
+	// deleting one key keeps the other's data
+	req.NoError(o.DeleteObject("my-bucket", first))
+	req.Equal(uint64(2), linkCountOf(blob))
+	rc, err := o.GetObject("my-bucket", second)
+	req.NoError(err)
+	content, err := io.ReadAll(rc)
+	rc.Close()
+	req.NoError(err)
+	req.Equal("same contents", string(content))
+
+	// replacing the last linked object removes the blob
+	req.NoError(o.PutObject("my-bucket", second, strings.NewReader("new contents")))
+	_, err = os.Stat(blob)
+	req.True(os.IsNotExist(err))
+
+	// deleting the last linked object removes the blob
+	digest, err = readChecksum(filepath.Join(bucketPath, second))
+	req.NoError(err)
+	blob = dedupBlobPath(bucketPath, digest, "", nil)
+	req.Equal(uint64(2), linkCountOf(blob))
+	req.NoError(o.DeleteObject("my-bucket", second))
+	_, err = os.Stat(blob)
+	req.True(os.IsNotExist(err))
+}
+
+func Test_dedup_encryption(t *testing.T) {
+	req := require.New(t)
+	o, root := newTestObjectStore(t)
+	req.NoError(o.applyConfig(map[string]string{"dedup": dedupHardlink}))
+	blobsDir := filepath.Join(root, "my-bucket", dedupDirName)
+
+	readObject := func(key string) string {
+		rc, err := o.GetObject("my-bucket", key)
+		req.NoError(err)
+		defer rc.Close()
+		content, err := io.ReadAll(rc)
+		req.NoError(err)
+		return string(content)
+	}
+	countBlobs := func() int {
+		entries, err := os.ReadDir(blobsDir)
+		req.NoError(err)
+		return len(entries)
+	}
+
+	req.NoError(o.PutObject("my-bucket", "backups/plain/plain.tar.gz", strings.NewReader("same contents")))
+
+	// Encryption is enabled over the existing store, then its key is rotated
+	o.opts = &localVolumeObjectStoreOpts{encryptionKey: newTestEncryptionKey(t)}
+	req.NoError(o.PutObject("my-bucket", "backups/first-key/first-key.tar.gz", strings.NewReader("same contents")))
+	req.Equal("same contents", readObject("backups/first-key/first-key.tar.gz"))
+	req.NoError(o.PutObject("my-bucket", "backups/first-key/again.tar.gz", strings.NewReader("same contents")))
+	req.Equal(2, countBlobs(), "objects encrypted with the same key should share a blob")
+
+	o.opts = &localVolumeObjectStoreOpts{encryptionKey: newTestEncryptionKey(t)}
+	req.NoError(o.PutObject("my-bucket", "backups/second-key/second-key.tar.gz", strings.NewReader("same contents")))
+	req.Equal("same contents", readObject("backups/second-key/second-key.tar.gz"))
+	req.Equal(3, countBlobs())
+
+	// The blobs of objects encrypted with an earlier key are still removed with their last object
+	req.NoError(o.DeleteObjects("my-bucket", []string{"backups/first-key/first-key.tar.gz", "backups/first-key/again.tar.gz"}))
+	req.Equal(2, countBlobs())
+}
@@ -0,0 +1,86 @@
+package plugin
+
+import (
+	"os"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// directIOAlignment is the alignment of the buffers, offsets and lengths of O_DIRECT writes.
+// It covers the logical block size of the filesystems the plugin is used with.
+const directIOAlignment = 4096
+
+// setDirectIO turns O_DIRECT on or off for the open file. It fails with EINVAL where the filesystem does not support it.
+// It is a variable so tests can simulate such filesystems.
+var setDirectIO = func(file *os.File, enabled bool) error {
+	flags, err := unix.FcntlInt(file.Fd(), unix.F_GETFL, 0)
+	if err != nil {
+		return err
+	}
+	if enabled {
+		flags |= unix.O_DIRECT
+	} else {
+		flags &^= unix.O_DIRECT
+	}
+	_, err = unix.FcntlInt(file.Fd(), unix.F_SETFL, flags)
+	return err
+}
+
+// alignedBuffer returns a buffer of size bytes starting at a multiple of directIOAlignment in memory.
+func alignedBuffer(size int) []byte {
+	buf := make([]byte, size+directIOAlignment)
+	offset := 0
+	if rem := int(uintptr(unsafe.Pointer(&buf[0])) & (directIOAlignment - 1)); rem != 0 {
+		offset = directIOAlignment - rem
+	}
+	return buf[offset : offset+size]
+}
+
+// directWriter writes to a file with O_DIRECT set through an aligned buffer, so that every write is of whole
+// aligned blocks at an aligned offset, bypassing the page cache. Close writes the final partial block
+// once O_DIRECT is turned off, and does not close the file.
+type directWriter struct {
+	file *os.File
+	buf  []byte
+	n    int
+}
+
+// newDirectWriter returns a writer for the file, which must have O_DIRECT set, buffering at least size bytes.
+func newDirectWriter(file *os.File, size int) *directWriter {
+	size = (size + directIOAlignment - 1) / directIOAlignment * directIOAlignment
+	if size == 0 {
+		size = directIOAlignment
+	}
+	return &directWriter{file: file, buf: alignedBuffer(size)}
+}
+
+func (w *directWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		copied := copy(w.buf[w.n:], p)
+		w.n += copied
+		written += copied
+		p = p[copied:]
+
+		if w.n == len(w.buf) {
+			if _, err := w.file.Write(w.buf); err != nil {
+				return written, err
+			}
+			w.n = 0
+		}
+	}
+	return written, nil
+}
+
+func (w *directWriter) Close() error {
+	if w.n == 0 {
+		return nil
+	}
+	if err := setDirectIO(w.file, false); err != nil {
+		return err
+	}
+	_, err := w.file.Write(w.buf[:w.n])
+	w.n = 0
+	return err
+}
@@ -0,0 +1,130 @@
+package plugin
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"strings"
+	"testing"
+	"unsafe"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+// requireDirectIO skips the test unless the filesystem of dir supports O_DIRECT.
+func requireDirectIO(t testing.TB, dir string) {
+	file, err := os.CreateTemp(dir, "directio")
+	require.NoError(t, err)
+	defer os.Remove(file.Name())
+	defer file.Close()
+	if err := setDirectIO(file, true); err != nil {
+		t.Skipf("direct IO is not supported by the test filesystem: %v", err)
+	}
+}
+
+func Test_alignedBuffer(t *testing.T) {
+	for _, size := range []int{directIOAlignment, 3 * directIOAlignment, 1 << 20} {
+		buf := alignedBuffer(size)
+		require.Len(t, buf, size)
+		require.Zero(t, uintptr(unsafe.Pointer(&buf[0]))%directIOAlignment)
+	}
+}
+
+func Test_directIO(t *testing.T) {
+	tests := []struct {
+		name   string
+		size   int
+		config map[string]string
+	}{
+		{name: "empty object", size: 0},
+		{name: "partial block", size: 17},
+		{name: "whole blocks", size: 4 * directIOAlignment},
+		{name: "larger than the buffer", size: 3*defaultCopyBufferSize + 17},
+		{name: "unaligned buffer size", size: 100000, config: map[string]string{"copyBufferSizeBytes": "10000"}},
+		{name: "compressed", size: 100000, config: map[string]string{"compression": compressionZstd}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := require.New(t)
+			o, root := newTestObjectStore(t)
+			requireDirectIO(t, root)
+
+			config := map[string]string{"directIO": "true"}
+			for k, v := range tt.config {
+				config[k] = v
+			}
+			req.NoError(o.applyConfig(config))
+
+			var out strings.Builder
+			log := logrus.New()
+			log.Out = &out
+			o.log = log
+
+			content := make([]byte, tt.size)
+			rand.New(rand.NewSource(int64(tt.size))).Read(content)
+			req.NoError(o.PutObject("my-bucket", "backups/my-backup/my-backup.tar.gz", bytes.NewReader(content)))
+			req.NotContains(out.String(), "Direct IO is not supported")
+
+			rc, err := o.GetObject("my-bucket", "backups/my-backup/my-backup.tar.gz")
+			req.NoError(err)
+			got, err := io.ReadAll(rc)
+			req.NoError(err)
+			req.NoError(rc.Close())
+			req.Equal(content, got)
+		})
+	}
+}
+
+func Test_directIO_fallback(t *testing.T) {
+	req := require.New(t)
+	o, _ := newTestObjectStore(t)
+	req.NoError(o.applyConfig(map[string]string{"directIO": "true"}))
+
+	defer func(set func(*os.File, bool) error) { setDirectIO = set }(setDirectIO)
+	setDirectIO = func(*os.File, bool) error { return unix.EINVAL }
+
+	var out strings.Builder
+	log := logrus.New()
+	log.Out = &out
+	o.log = log
+
+	content := bytes.Repeat([]byte("data"), 10000)
+	for i := 0; i < 2; i++ {
+		req.NoError(o.PutObject("my-bucket", fmt.Sprintf("backups/my-backup/object-%d", i), bytes.NewReader(content)))
+	}
+	// the fallback is logged once
+	req.Equal(1, strings.Count(out.String(), "Direct IO is not supported"), out.String())
+
+	rc, err := o.GetObject("my-bucket", "backups/my-backup/object-1")
+	req.NoError(err)
+	got, err := io.ReadAll(rc)
+	req.NoError(err)
+	req.NoError(rc.Close())
+	req.Equal(content, got)
+}
+
+func Benchmark_PutObject_directIO(b *testing.B) {
+	const objectSize = 64 << 20
+
+	// The page cache is only bypassed on a disk backed filesystem
+	root := b.TempDir()
+	requireDirectIO(b, root)
+	b.Setenv("VOLUME_ROOT", root)
+
+	for _, directIO := range []string{"false", "true"} {
+		b.Run(fmt.Sprintf("directIO=%s", directIO), func(b *testing.B) {
+			o := NewLocalVolumeObjectStore(logrus.New(), Hostpath)
+			require.NoError(b, o.applyConfig(map[string]string{"directIO": directIO}))
+
+			b.SetBytes(objectSize)
+			for i := 0; i < b.N; i++ {
+				err := o.PutObject("my-bucket", "backups/my-backup/my-backup.tar.gz", io.LimitReader(zeroReader{}, objectSize))
+				require.NoError(b, err)
+			}
+		})
+	}
+}
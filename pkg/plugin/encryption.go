@@ -0,0 +1,195 @@
+package plugin
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// Encrypted objects start with encryptionMagic and a random nonce, followed by a sequence of AES-256-GCM sealed chunks.
+// Each chunk holds up to encryptionChunkSize bytes of plaintext and is sealed with the nonce XOR'd with its index,
+// and with additional data marking whether it is the last chunk, so chunks cannot be reordered, dropped or truncated.
+const (
+	encryptionMagic     = "LVPENC01"
+	encryptionKeySize   = 32
+	encryptionChunkSize = 64 << 10
+)
+
+// ErrObjectAuthentication is returned when an encrypted object cannot be authenticated with the configured key,
+// because it was tampered with, truncated, not encrypted or encrypted with a different key.
+var ErrObjectAuthentication = errors.New("object failed authentication")
+
+var (
+	lastChunk     = []byte{1}
+	notLastChunk  = []byte{0}
+	errWriterDone = errors.New("encrypting writer is closed")
+)
+
+// newObjectCipher returns an AES-256-GCM cipher for the given key.
+func newObjectCipher(key []byte) (cipher.AEAD, error) {
+	if len(key) != encryptionKeySize {
+		return nil, errors.Errorf("encryption key must be %d bytes, got %d", encryptionKeySize, len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// chunkNonce returns the nonce for the chunk with the given index.
+func chunkNonce(nonce []byte, index uint64) []byte {
+	n := make([]byte, len(nonce))
+	copy(n, nonce)
+	counter := binary.BigEndian.Uint64(n[len(n)-8:]) ^ index
+	binary.BigEndian.PutUint64(n[len(n)-8:], counter)
+	return n
+}
+
+// encryptingWriter seals everything written to it into chunks written to the underlying writer.
+// Close must be called to write the final chunk; it does not close the underlying writer.
+type encryptingWriter struct {
+	w      io.Writer
+	aead   cipher.AEAD
+	nonce  []byte
+	index  uint64
+	buf    []byte
+	sealed []byte
+	closed bool
+}
+
+// newEncryptingWriter writes the header of a new encrypted object to w and returns a writer for its content.
+func newEncryptingWriter(w io.Writer, key []byte) (io.WriteCloser, error) {
+	aead, err := newObjectCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, errors.Wrap(err, "failed to generate nonce")
+	}
+	if _, err := w.Write(append([]byte(encryptionMagic), nonce...)); err != nil {
+		return nil, err
+	}
+
+	return &encryptingWriter{
+		w:     w,
+		aead:  aead,
+		nonce: nonce,
+		buf:   make([]byte, 0, encryptionChunkSize),
+	}, nil
+}
+
+func (e *encryptingWriter) Write(p []byte) (int, error) {
+	if e.closed {
+		return 0, errWriterDone
+	}
+	written := 0
+	for len(p) > 0 {
+		// A full chunk is only sealed once more data arrives, as the last chunk has to be marked as such
+		if len(e.buf) == encryptionChunkSize {
+			if err := e.seal(notLastChunk); err != nil {
+				return written, err
+			}
+		}
+		n := copy(e.buf[len(e.buf):encryptionChunkSize], p)
+		e.buf = e.buf[:len(e.buf)+n]
+		p = p[n:]
+		written += n
+	}
+	return written, nil
+}
+
+func (e *encryptingWriter) Close() error {
+	if e.closed {
+		return nil
+	}
+	e.closed = true
+	return e.seal(lastChunk)
+}
+
+func (e *encryptingWriter) seal(additionalData []byte) error {
+	e.sealed = e.aead.Seal(e.sealed[:0], chunkNonce(e.nonce, e.index), e.buf, additionalData)
+	e.index++
+	e.buf = e.buf[:0]
+	_, err := e.w.Write(e.sealed)
+	return err
+}
+
+// decryptingReader authenticates and decrypts the chunks of an encrypted object.
+type decryptingReader struct {
+	r      *bufio.Reader
+	aead   cipher.AEAD
+	nonce  []byte
+	index  uint64
+	sealed []byte
+	plain  []byte
+	done   bool
+	err    error
+}
+
+// NewDecryptingReader reads the header of an encrypted object from r and returns a reader for its content.
+// Reads fail with ErrObjectAuthentication as soon as a chunk cannot be authenticated.
+func NewDecryptingReader(r io.Reader, key []byte) (io.Reader, error) {
+	aead, err := newObjectCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, len(encryptionMagic)+aead.NonceSize())
+	if _, err := io.ReadFull(r, header); err != nil || string(header[:len(encryptionMagic)]) != encryptionMagic {
+		return nil, errors.Wrap(ErrObjectAuthentication, "missing encryption header")
+	}
+
+	return &decryptingReader{
+		r:      bufio.NewReaderSize(r, encryptionChunkSize+aead.Overhead()),
+		aead:   aead,
+		nonce:  header[len(encryptionMagic):],
+		sealed: make([]byte, encryptionChunkSize+aead.Overhead()),
+	}, nil
+}
+
+func (d *decryptingReader) Read(p []byte) (int, error) {
+	for len(d.plain) == 0 {
+		if d.err != nil {
+			return 0, d.err
+		}
+		if d.done {
+			return 0, io.EOF
+		}
+		d.err = d.open()
+	}
+	n := copy(p, d.plain)
+	d.plain = d.plain[n:]
+	return n, nil
+}
+
+func (d *decryptingReader) open() error {
+	n, err := io.ReadFull(d.r, d.sealed)
+	if err == io.ErrUnexpectedEOF || err == io.EOF {
+		d.done = true
+	} else if err != nil {
+		return err
+	} else if _, err := d.r.Peek(1); err == io.EOF {
+		d.done = true
+	} else if err != nil {
+		return err
+	}
+
+	additionalData := notLastChunk
+	if d.done {
+		additionalData = lastChunk
+	}
+	plain, err := d.aead.Open(d.sealed[:0], chunkNonce(d.nonce, d.index), d.sealed[:n], additionalData)
+	if err != nil {
+		return errors.Wrapf(ErrObjectAuthentication, "chunk %d", d.index)
+	}
+	d.index++
+	d.plain = plain
+	return nil
+}
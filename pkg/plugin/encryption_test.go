@@ -0,0 +1,124 @@
+package plugin
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newTestEncryptionKey(t *testing.T) []byte {
+	key := make([]byte, encryptionKeySize)
+	_, err := rand.Read(key)
+	require.NoError(t, err)
+	return key
+}
+
+func Test_encryption_roundTrip(t *testing.T) {
+	tests := []struct {
+		name        string
+		size        int
+		compression string
+	}{
+		{name: "empty", size: 0},
+		{name: "smaller than a chunk", size: 100},
+		{name: "exactly one chunk", size: encryptionChunkSize},
+		{name: "one byte over a chunk", size: encryptionChunkSize + 1},
+		{name: "several chunks", size: 3*encryptionChunkSize + 17},
+		{name: "compressed", size: 3*encryptionChunkSize + 17, compression: compressionGzip},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := require.New(t)
+			o, root := newTestObjectStore(t)
+			o.opts = &localVolumeObjectStoreOpts{encryptionKey: newTestEncryptionKey(t)}
+			o.compression = tt.compression
+			o.verifyChecksums = true
+
+			content := make([]byte, tt.size)
+			_, err := rand.Read(content)
+			req.NoError(err)
+			req.NoError(o.PutObject("bucket", "key", bytes.NewReader(content)))
+
+			filePath, _, err := findObjectFile(filepath.Join(root, "bucket", "key"))
+			req.NoError(err)
+			stored, err := os.ReadFile(filePath)
+			req.NoError(err)
+			req.True(bytes.HasPrefix(stored, []byte(encryptionMagic)))
+			if tt.size > 0 {
+				req.False(bytes.Contains(stored, content))
+			}
+
+			rc, err := o.GetObject("bucket", "key")
+			req.NoError(err)
+			defer rc.Close()
+			got, err := io.ReadAll(rc)
+			req.NoError(err)
+			req.True(bytes.Equal(content, got))
+		})
+	}
+}
+
+func Test_encryption_tamperDetection(t *testing.T) {
+	tests := []struct {
+		name   string
+		tamper func(stored []byte, key []byte) ([]byte, []byte)
+	}{
+		{
+			name: "flipped byte",
+			tamper: func(stored []byte, key []byte) ([]byte, []byte) {
+				stored[len(stored)/2] ^= 1
+				return stored, key
+			},
+		},
+		{
+			name: "truncated at a chunk boundary",
+			tamper: func(stored []byte, key []byte) ([]byte, []byte) {
+				return stored[:len(encryptionMagic)+12+encryptionChunkSize+16], key
+			},
+		},
+		{
+			name: "wrong key",
+			tamper: func(stored []byte, key []byte) ([]byte, []byte) {
+				otherKey := make([]byte, len(key))
+				copy(otherKey, key)
+				otherKey[0] ^= 1
+				return stored, otherKey
+			},
+		},
+		{
+			name: "not encrypted",
+			tamper: func(stored []byte, key []byte) ([]byte, []byte) {
+				return []byte("plaintext object"), key
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := require.New(t)
+			o, root := newTestObjectStore(t)
+			key := newTestEncryptionKey(t)
+			o.opts = &localVolumeObjectStoreOpts{encryptionKey: key}
+
+			content := make([]byte, 3*encryptionChunkSize)
+			req.NoError(o.PutObject("bucket", "key", bytes.NewReader(content)))
+
+			path := filepath.Join(root, "bucket", "key")
+			stored, err := os.ReadFile(path)
+			req.NoError(err)
+			stored, o.opts.encryptionKey = tt.tamper(stored, key)
+			req.NoError(os.WriteFile(path, stored, 0644))
+
+			rc, err := o.GetObject("bucket", "key")
+			if err == nil {
+				defer rc.Close()
+				_, err = io.ReadAll(rc)
+			}
+			req.ErrorIs(err, ErrObjectAuthentication)
+		})
+	}
+}
@@ -0,0 +1,136 @@
+package plugin
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+	"github.com/replicatedhq/local-volume-provider/pkg/k8sutil"
+	corev1 "k8s.io/api/core/v1"
+	kuberneteserrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// initFailedReason is the reason of the event recorded on the Velero deployment when Init fails.
+	initFailedReason = "LocalVolumeInitFailed"
+
+	eventSourceComponent = "local-volume-provider"
+
+	// maxEventMessageLength is the longest message the API server accepts for an event.
+	maxEventMessageLength = 1024
+)
+
+// invalidEventNameChars matches the characters of a bucket that cannot be part of an event name.
+var invalidEventNameChars = regexp.MustCompile(`[^a-z0-9]+`)
+
+// initEventName returns the name of the event recording the Init failure of the bucket. There is one per bucket,
+// so repeated failures update its count rather than adding events, and a successful Init can remove it.
+// Buckets that would not make a valid name, being too long or holding other characters than those of a DNS
+// subdomain, are sanitized and shortened, with a hash of the bucket so that they still have an event each.
+func initEventName(bucket string) string {
+	prefix := fmt.Sprintf("%s.%s-init-failed.", VeleroDeploymentName, eventSourceComponent)
+	if name := prefix + strings.ToLower(bucket); len(validation.IsDNS1123Subdomain(name)) == 0 {
+		return name
+	}
+
+	sum := sha256.Sum256([]byte(bucket))
+	suffix := hex.EncodeToString(sum[:4])
+	sanitized := invalidEventNameChars.ReplaceAllString(strings.ToLower(bucket), "-")
+	if max := validation.DNS1123SubdomainMaxLength - len(prefix) - len(suffix) - 1; len(sanitized) > max {
+		sanitized = sanitized[:max]
+	}
+	if sanitized = strings.Trim(sanitized, "-"); sanitized == "" {
+		return prefix + suffix
+	}
+	return prefix + sanitized + "-" + suffix
+}
+
+// truncateEventMessage shortens the message to the longest the API server accepts, on a rune boundary.
+func truncateEventMessage(message string) string {
+	if len(message) <= maxEventMessageLength {
+		return message
+	}
+	cut := maxEventMessageLength - len("...")
+	for cut > 0 && !utf8.RuneStart(message[cut]) {
+		cut--
+	}
+	return message[:cut] + "..."
+}
+
+// reportInitResult records a warning event on the Velero deployment summarizing why Init failed, so the reason is
+// visible with kubectl rather than only in the Velero logs, or removes the event once Init succeeds.
+// Failing to record the event is logged, and never changes the result of Init.
+func (o *LocalVolumeObjectStore) reportInitResult(bucket string, initErr error) {
+	log := o.log.WithField("bucket", bucket)
+	clientset, err := k8sutil.GetClientset()
+	if err != nil {
+		log.WithError(err).Debug("Failed to get kubernetes clientset, not recording Init event")
+		return
+	}
+	if err := recordInitEvent(clientset, o.getVeleroNamespace(), bucket, initErr); err != nil {
+		log.WithError(err).Warn("Failed to record Init event")
+	}
+}
+
+// recordInitEvent creates or updates the event recording the Init failure of the bucket, or deletes it if initErr is nil.
+func recordInitEvent(clientset kubernetes.Interface, namespace, bucket string, initErr error) error {
+	events := clientset.CoreV1().Events(namespace)
+	name := initEventName(bucket)
+
+	if initErr == nil {
+		err := events.Delete(context.TODO(), name, metav1.DeleteOptions{})
+		if err != nil && !kuberneteserrors.IsNotFound(err) {
+			return errors.Wrap(err, "failed to delete Init failure event")
+		}
+		return nil
+	}
+
+	message := truncateEventMessage(fmt.Sprintf("Backup storage location for bucket %s failed to initialize: %v", bucket, initErr))
+	now := metav1.Now()
+
+	existing, err := events.Get(context.TODO(), name, metav1.GetOptions{})
+	if err == nil {
+		existing.Message = message
+		existing.Count++
+		existing.LastTimestamp = now
+		_, err = events.Update(context.TODO(), existing, metav1.UpdateOptions{})
+		return errors.Wrap(err, "failed to update Init failure event")
+	}
+	if !kuberneteserrors.IsNotFound(err) {
+		return errors.Wrap(err, "failed to get Init failure event")
+	}
+
+	involvedObject := corev1.ObjectReference{
+		APIVersion: "apps/v1",
+		Kind:       "Deployment",
+		Namespace:  namespace,
+		Name:       VeleroDeploymentName,
+	}
+	if deployment, err := clientset.AppsV1().Deployments(namespace).Get(context.TODO(), VeleroDeploymentName, metav1.GetOptions{}); err == nil {
+		involvedObject.UID = deployment.UID
+	}
+
+	_, err = events.Create(context.TODO(), &corev1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+		InvolvedObject: involvedObject,
+		Reason:         initFailedReason,
+		Message:        message,
+		Type:           corev1.EventTypeWarning,
+		Source:         corev1.EventSource{Component: eventSourceComponent},
+		FirstTimestamp: now,
+		LastTimestamp:  now,
+		Count:          1,
+	}, metav1.CreateOptions{})
+	return errors.Wrap(err, "failed to create Init failure event")
+}
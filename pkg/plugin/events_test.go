@@ -0,0 +1,111 @@
+package plugin
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	kuberneteserrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func Test_recordInitEvent(t *testing.T) {
+	req := require.New(t)
+
+	// The volume of the bucket is mounted without write permission
+	path := filepath.Join(t.TempDir(), "my-bucket")
+	req.NoError(os.Mkdir(path, 0555))
+	initErr := ensureFilesystem(path, "my-prefix", nil, 0750, logrus.NewEntry(logrus.New()))
+	req.EqualError(initErr, "directory is not writeable")
+
+	clientset := fake.NewSimpleClientset(&appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: VeleroDeploymentName, Namespace: "velero", UID: "velero-uid"},
+	})
+	events := clientset.CoreV1().Events("velero")
+
+	req.NoError(recordInitEvent(clientset, "velero", "my-bucket", initErr))
+	event, err := events.Get(context.TODO(), initEventName("my-bucket"), metav1.GetOptions{})
+	req.NoError(err)
+	req.Equal(corev1.EventTypeWarning, event.Type)
+	req.Equal(initFailedReason, event.Reason)
+	req.Equal("Backup storage location for bucket my-bucket failed to initialize: directory is not writeable", event.Message)
+	req.Equal("Deployment", event.InvolvedObject.Kind)
+	req.Equal(VeleroDeploymentName, event.InvolvedObject.Name)
+	req.EqualValues("velero-uid", event.InvolvedObject.UID)
+	req.EqualValues(1, event.Count)
+
+	// Repeated failures update the event
+	req.NoError(recordInitEvent(clientset, "velero", "my-bucket", initErr))
+	event, err = events.Get(context.TODO(), initEventName("my-bucket"), metav1.GetOptions{})
+	req.NoError(err)
+	req.EqualValues(2, event.Count)
+	list, err := events.List(context.TODO(), metav1.ListOptions{})
+	req.NoError(err)
+	req.Len(list.Items, 1)
+
+	// Success clears the failure, and succeeds without one
+	req.NoError(recordInitEvent(clientset, "velero", "my-bucket", nil))
+	_, err = events.Get(context.TODO(), initEventName("my-bucket"), metav1.GetOptions{})
+	req.True(kuberneteserrors.IsNotFound(err))
+	req.NoError(recordInitEvent(clientset, "velero", "my-bucket", nil))
+}
+
+func Test_initEventName(t *testing.T) {
+	tests := []struct {
+		name   string
+		bucket string
+		want   string
+	}{
+		{
+			name:   "valid bucket",
+			bucket: "My-Bucket",
+			want:   VeleroDeploymentName + "." + eventSourceComponent + "-init-failed.my-bucket",
+		},
+		{
+			name:   "invalid characters",
+			bucket: "my_bucket",
+		},
+		{
+			name:   "only invalid characters",
+			bucket: "__",
+		},
+		{
+			name:   "long bucket",
+			bucket: strings.Repeat("b", 300),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := require.New(t)
+			name := initEventName(tt.bucket)
+			req.Empty(validation.IsDNS1123Subdomain(name), name)
+			if tt.want != "" {
+				req.Equal(tt.want, name)
+			}
+		})
+	}
+
+	// Buckets sanitized to the same name still have an event each
+	require.NotEqual(t, initEventName("my_bucket"), initEventName("my.bucket_"))
+	require.NotEqual(t, initEventName("my_bucket"), initEventName("my-bucket"))
+}
+
+func Test_truncateEventMessage(t *testing.T) {
+	req := require.New(t)
+	req.Equal("short", truncateEventMessage("short"))
+
+	// The cut would fall inside a multi-byte rune
+	message := strings.Repeat("a", maxEventMessageLength-4) + strings.Repeat("é", 10)
+	truncated := truncateEventMessage(message)
+	req.True(utf8.ValidString(truncated), truncated)
+	req.Equal(strings.Repeat("a", maxEventMessageLength-4)+"...", truncated)
+}
@@ -0,0 +1,174 @@
+package plugin
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"io"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// ExportOptions changes what ExportPrefixWithOptions writes.
+type ExportOptions struct {
+	// IncludeSidecars also exports the checksum and metadata sidecars of each object, named after its key.
+	// Metadata is exported as a sidecar even when it is stored in an extended attribute.
+	IncludeSidecars bool
+}
+
+// ExportPrefix writes every object whose key starts with prefix, such as all the objects of a backup, to w as a tar
+// archive, each named by its key, so a backup can be copied offsite as a single file.
+func (o *LocalVolumeObjectStore) ExportPrefix(bucket, prefix string, w io.Writer) error {
+	return o.ExportPrefixWithOptions(bucket, prefix, w, ExportOptions{})
+}
+
+// ExportPrefixWithOptions is ExportPrefix with options. Objects are streamed rather than buffered, and are written
+// as their content, decompressed and decrypted. As a tar entry needs its size up front, compressed and encrypted
+// objects are read twice: once to measure their content, then to write it.
+func (o *LocalVolumeObjectStore) ExportPrefixWithOptions(bucket, prefix string, w io.Writer, opts ExportOptions) (err error) {
+	defer observeOperation("ExportPrefix", time.Now(), &err)
+
+	log := o.log.WithFields(logrus.Fields{
+		"bucket": bucket,
+		"prefix": prefix,
+	})
+	log.Debug("LocalVolumeObjectStore.ExportPrefix called")
+
+	infos, err := o.listObjectsWithInfo(bucket, prefix)
+	if err != nil {
+		return errors.Wrap(err, "failed to list objects")
+	}
+
+	tw := tar.NewWriter(w)
+	for _, info := range infos {
+		if err := o.exportObject(tw, bucket, info, opts); err != nil {
+			return errors.Wrapf(err, "failed to export %s", info.Key)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return errors.Wrap(err, "failed to finish archive")
+	}
+
+	log.Infof("Exported %d objects", len(infos))
+	return nil
+}
+
+// exportObject writes the content of the object, and its sidecars if included, to the archive.
+func (o *LocalVolumeObjectStore) exportObject(tw *tar.Writer, bucket string, info ObjectInfo, opts ExportOptions) error {
+	path, err := o.objectPath(bucket, info.Key)
+	if err != nil {
+		return err
+	}
+	filePath, compression, err := findObjectFile(path)
+	if err != nil {
+		return err
+	}
+	if err := o.checkSymlinks(o.bucketPath(bucket), filePath); err != nil {
+		return err
+	}
+	if err := checkRegularFile(filePath); err != nil {
+		return err
+	}
+
+	file, err := openObjectFile(filePath, compression, o.getEncryptionKey())
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	size, err := file.contentSize()
+	if err != nil {
+		return err
+	}
+	if size < 0 {
+		if size, err = o.measureObjectContent(filePath, compression); err != nil {
+			return err
+		}
+	}
+
+	if err := tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     info.Key,
+		Size:     size,
+		Mode:     int64(o.getFileMode().Perm()),
+		ModTime:  info.ModTime,
+	}); err != nil {
+		return err
+	}
+	if _, err := copyBuffered(tw, file, o.copyBufferSize); err != nil {
+		return errors.Wrap(err, "failed to write object")
+	}
+
+	if !opts.IncludeSidecars {
+		return nil
+	}
+	if err := exportFile(tw, checksumPath(path), info.Key+checksumSuffix); err != nil {
+		return errors.Wrap(err, "failed to write checksum sidecar")
+	}
+	meta, err := readMetadata(path, filePath)
+	if err != nil {
+		return err
+	}
+	if len(meta) == 0 {
+		return nil
+	}
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode object metadata")
+	}
+	if err := tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     info.Key + metadataSuffix,
+		Size:     int64(len(data)),
+		Mode:     int64(o.getFileMode().Perm()),
+		ModTime:  info.ModTime,
+	}); err != nil {
+		return err
+	}
+	_, err = tw.Write(data)
+	return errors.Wrap(err, "failed to write metadata sidecar")
+}
+
+// measureObjectContent returns the size of the content of an object file once decompressed and decrypted,
+// which is only known once it has been read.
+func (o *LocalVolumeObjectStore) measureObjectContent(filePath, compression string) (int64, error) {
+	file, err := openObjectFile(filePath, compression, o.getEncryptionKey())
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	size, err := copyBuffered(io.Discard, file, o.copyBufferSize)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to read object")
+	}
+	return size, nil
+}
+
+// exportFile writes the file at filePath to the archive under name, if it exists.
+func exportFile(tw *tar.Writer, filePath, name string) error {
+	file, err := os.Open(filePath)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	header, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return err
+	}
+	header.Name = name
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, file)
+	return err
+}
@@ -0,0 +1,112 @@
+package plugin
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/rand"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_ExportPrefix(t *testing.T) {
+	tests := []struct {
+		name         string
+		opts         ExportOptions
+		encrypted    bool
+		wantSidecars bool
+	}{
+		{
+			name: "objects only",
+		},
+		{
+			name:         "with sidecars",
+			opts:         ExportOptions{IncludeSidecars: true},
+			wantSidecars: true,
+		},
+		{
+			name:      "encrypted -- exported decrypted",
+			encrypted: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := require.New(t)
+			o, root := newTestObjectStore(t)
+			if tt.encrypted {
+				o.opts = &localVolumeObjectStoreOpts{encryptionKey: newTestEncryptionKey(t)}
+			}
+
+			large := make([]byte, 3<<20)
+			_, err := rand.Read(large)
+			req.NoError(err)
+			want := map[string][]byte{
+				"backups/my-backup/my-backup.tar.gz":  large,
+				"backups/my-backup/my-backup-logs.gz": []byte("compressed logs"),
+				"backups/my-backup/nested/empty":      {},
+			}
+			req.NoError(o.PutObject("my-bucket", "backups/my-backup/my-backup.tar.gz", bytes.NewReader(large)))
+			req.NoError(o.PutObject("my-bucket", "backups/my-backup/nested/empty", bytes.NewReader(nil)))
+			o.compression = compressionZstd
+			req.NoError(o.PutObject("my-bucket", "backups/my-backup/my-backup-logs.gz", strings.NewReader("compressed logs")))
+			o.compression = ""
+			req.NoError(o.SetObjectMetadata("my-bucket", "backups/my-backup/my-backup.tar.gz", map[string]string{"app": "etcd"}))
+			req.NoError(o.PutObject("my-bucket", "backups/other-backup/other-backup.tar.gz", strings.NewReader("other")))
+
+			var archive bytes.Buffer
+			req.NoError(o.ExportPrefixWithOptions("my-bucket", "backups/my-backup/", &archive, tt.opts))
+
+			// Extract the archive and compare it with the bucket
+			dir := t.TempDir()
+			tr := tar.NewReader(&archive)
+			var names []string
+			for {
+				header, err := tr.Next()
+				if err == io.EOF {
+					break
+				}
+				req.NoError(err)
+				names = append(names, header.Name)
+				path := filepath.Join(dir, header.Name)
+				req.NoError(os.MkdirAll(filepath.Dir(path), 0755))
+				content, err := io.ReadAll(tr)
+				req.NoError(err)
+				req.NoError(os.WriteFile(path, content, 0644))
+			}
+
+			wantNames := []string{}
+			for key, content := range want {
+				wantNames = append(wantNames, key)
+				got, err := os.ReadFile(filepath.Join(dir, key))
+				req.NoError(err)
+				req.Equal(content, got, key)
+			}
+			if tt.wantSidecars {
+				for key := range want {
+					wantNames = append(wantNames, key+checksumSuffix)
+					sidecar, err := os.ReadFile(checksumPath(filepath.Join(root, "my-bucket", key)))
+					req.NoError(err)
+					got, err := os.ReadFile(checksumPath(filepath.Join(dir, key)))
+					req.NoError(err)
+					req.Equal(sidecar, got)
+				}
+				wantNames = append(wantNames, "backups/my-backup/my-backup.tar.gz"+metadataSuffix)
+			}
+			req.ElementsMatch(wantNames, names)
+		})
+	}
+}
+
+func Test_ExportPrefix_empty(t *testing.T) {
+	req := require.New(t)
+	o, _ := newTestObjectStore(t)
+
+	var archive bytes.Buffer
+	req.NoError(o.ExportPrefix("my-bucket", "backups/missing/", &archive))
+	_, err := tar.NewReader(&archive).Next()
+	req.Equal(io.EOF, err)
+}
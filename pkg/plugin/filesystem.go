@@ -3,14 +3,15 @@ package plugin
 import (
 	"os"
 	"path/filepath"
+	"syscall"
 
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 )
 
 // ensureFilesystem checks that the filesystem is ready for use by the plugin
-// and that the plugin's directory structure is in place.
-func ensureFilesystem(path, prefix string, log *logrus.Entry) error {
+// and that the subdirectories of its directory structure are in place under the prefix.
+func ensureFilesystem(path, prefix string, subdirs []string, dirMode os.FileMode, log *logrus.Entry) error {
 	info, err := os.Stat(path)
 	if err != nil {
 		if !os.IsNotExist(err) {
@@ -27,9 +28,9 @@ func ensureFilesystem(path, prefix string, log *logrus.Entry) error {
 			return errors.New("directory is not writeable")
 		}
 
-		for _, subdir := range getSubDirectoryLayout() {
+		for _, subdir := range subdirs {
 			subpath := filepath.Join(path, prefix, subdir)
-			if err := os.MkdirAll(subpath, 0755); err != nil {
+			if err := mkdirAll(subpath, dirMode); err != nil {
 				return errors.Wrapf(err, "could not create directory %s", subpath)
 			}
 		}
@@ -37,3 +38,28 @@ func ensureFilesystem(path, prefix string, log *logrus.Entry) error {
 
 	return nil
 }
+
+// CheckVolumeReady returns an error describing why the volume at path cannot be written to,
+// or nil if it is mounted and writable.
+func CheckVolumeReady(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return errors.Wrapf(err, "volume %s is not mounted", path)
+	}
+	if !info.IsDir() {
+		return errors.Errorf("volume %s is not a directory", path)
+	}
+	if !isWriteable(logrus.NewEntry(logrus.StandardLogger()), info) {
+		return errors.Errorf("volume %s is not writable", path)
+	}
+
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return errors.Wrapf(err, "failed to get filesystem status of volume %s", path)
+	}
+	if stat.Flags&syscall.MS_RDONLY != 0 {
+		return errors.Errorf("volume %s is mounted read-only", path)
+	}
+
+	return nil
+}
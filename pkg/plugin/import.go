@@ -0,0 +1,225 @@
+package plugin
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+)
+
+// defaultImportWorkers is the number of objects ImportFrom copies at once unless configured otherwise.
+const defaultImportWorkers = 4
+
+// ObjectSource is the part of a Velero object store ImportFrom reads from, so any Velero ObjectStore plugin can be imported.
+type ObjectSource interface {
+	ListObjects(bucket, prefix string) ([]string, error)
+	GetObject(bucket, key string) (io.ReadCloser, error)
+}
+
+// objectInfoSource is implemented by sources that can list the modification times of their objects,
+// such as another LocalVolumeObjectStore.
+type objectInfoSource interface {
+	ListObjectsWithInfo(bucket, prefix string) ([]ObjectInfo, error)
+}
+
+// ImportProgress reports an object of an import being done.
+type ImportProgress struct {
+	// Key is the key of the object copied or skipped
+	Key string
+	// Skipped is set if the object was already in the bucket with the same content
+	Skipped bool
+	// Err is set if the object failed to import
+	Err error
+	// Done is the number of objects done so far out of Total, including this one
+	Done  int
+	Total int
+}
+
+// ImportFrom copies every object of the bucket in src, such as an S3 object store being migrated from, into the same
+// bucket of this store, keeping the keys and the modification times where the source has them, as well as the
+// ownership with preserveOwnership.
+// At most importWorkers objects are copied at once. An import can be resumed: objects already in the bucket whose
+// checksum matches the source are skipped. Where the source lists sizes and modification times, only objects for
+// which they match are read to compare checksums, and each object is read from the source at most once.
+// Failing objects do not stop the rest; all failures are returned together.
+func (o *LocalVolumeObjectStore) ImportFrom(src ObjectSource, bucket string) error {
+	return o.ImportFromWithProgress(src, bucket, nil)
+}
+
+// ImportFromWithProgress is ImportFrom calling progress, if not nil, after each object. Calls are never concurrent.
+func (o *LocalVolumeObjectStore) ImportFromWithProgress(src ObjectSource, bucket string, progress func(ImportProgress)) (err error) {
+	defer observeOperation("ImportFrom", time.Now(), &err)
+	if o.readOnly {
+		return ErrReadOnly
+	}
+
+	log := o.log.WithFields(logrus.Fields{
+		"bucket": bucket,
+	})
+	log.Debug("LocalVolumeObjectStore.ImportFrom called")
+
+	var infos []ObjectInfo
+	if infoSrc, ok := src.(objectInfoSource); ok {
+		if infos, err = infoSrc.ListObjectsWithInfo(bucket, ""); err != nil {
+			return errors.Wrap(err, "failed to list source objects")
+		}
+	} else {
+		keys, err := src.ListObjects(bucket, "")
+		if err != nil {
+			return errors.Wrap(err, "failed to list source objects")
+		}
+		for _, key := range keys {
+			infos = append(infos, ObjectInfo{Key: key})
+		}
+	}
+
+	workers := o.importWorkers
+	if workers < 1 {
+		workers = defaultImportWorkers
+	}
+
+	var (
+		mu      sync.Mutex
+		errs    []error
+		done    int
+		skipped int
+	)
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				info := infos[i]
+				wasSkipped, err := o.importObject(src, bucket, info, log.WithField("key", info.Key))
+
+				mu.Lock()
+				done++
+				if err != nil {
+					errs = append(errs, errors.Wrapf(err, "failed to import %s", info.Key))
+				} else if wasSkipped {
+					skipped++
+				}
+				if progress != nil {
+					progress(ImportProgress{Key: info.Key, Skipped: wasSkipped, Err: err, Done: done, Total: len(infos)})
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for i := range infos {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+
+	log.Infof("Imported %d objects, skipped %d already imported, %d failed", done-skipped-len(errs), skipped, len(errs))
+	return utilerrors.NewAggregate(errs)
+}
+
+// importObject copies one object from the source, unless the bucket already has it with the same content.
+// It returns truthy if the object was skipped.
+func (o *LocalVolumeObjectStore) importObject(src ObjectSource, bucket string, info ObjectInfo, log logrus.FieldLogger) (bool, error) {
+	path, err := o.objectPath(bucket, info.Key)
+	if err != nil {
+		return false, err
+	}
+	want, err := o.importedChecksum(path, info)
+	if err != nil {
+		return false, err
+	}
+
+	rc, err := src.GetObject(bucket, info.Key)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to get source object")
+	}
+	defer rc.Close()
+
+	var body io.Reader = rc
+	if want != "" {
+		// The content is staged while it is hashed, so an object that differs is not downloaded again to be imported
+		staged, digest, err := o.stageImport(path, rc)
+		if err != nil {
+			return false, err
+		}
+		defer func() {
+			staged.Close()
+			os.Remove(staged.Name())
+		}()
+		if digest == want {
+			log.Debug("Object is already imported")
+			return true, nil
+		}
+		body = staged
+	}
+
+	// The ownership is only read from sources that have it when it can be applied
+	if ownerSrc, ok := src.(ownershipSource); ok && o.preserveOwnership {
+		owner, err := ownerSrc.GetObjectOwnership(bucket, info.Key)
+		if err != nil {
+			return false, errors.Wrap(err, "failed to get source object ownership")
+		}
+		return false, o.PutObjectWithOwnership(bucket, info.Key, body, info.ModTime, owner)
+	}
+	return false, o.PutObjectWithModTime(bucket, info.Key, body, info.ModTime)
+}
+
+// importedChecksum returns the checksum sidecar of the object at path if it may already have been imported from the
+// source object, or "" if it must be imported. Where the source lists them, the size and modification time must match
+// first, so that changed objects are imported without hashing them. The size of a compressed object is not compared,
+// as that of its file is not the size of its content. Objects without a sidecar are imported again, as their content
+// cannot be compared without reading both.
+func (o *LocalVolumeObjectStore) importedChecksum(path string, info ObjectInfo) (string, error) {
+	want, err := readChecksum(path)
+	if os.IsNotExist(err) {
+		return "", nil
+	} else if err != nil {
+		return "", errors.Wrap(err, "failed to read object checksum")
+	}
+	filePath, compression, err := findObjectFile(path)
+	if errors.Is(err, ErrObjectNotFound) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+	if info.ModTime.IsZero() {
+		return want, nil
+	}
+
+	stat, err := os.Stat(filePath)
+	if err != nil {
+		return "", err
+	}
+	if !stat.ModTime().Equal(info.ModTime) || (compression == "" && stat.Size() != info.Size) {
+		return "", nil
+	}
+	return want, nil
+}
+
+// stageImport copies the content of the source object to a temporary file beside the object at path while hashing it,
+// returning the file positioned at its start along with the checksum of the content.
+func (o *LocalVolumeObjectStore) stageImport(path string, r io.Reader) (*os.File, string, error) {
+	f, err := os.OpenFile(tempFilePath(path), os.O_RDWR|os.O_CREATE|os.O_EXCL, o.getFileMode())
+	if err != nil {
+		return nil, "", errors.Wrap(err, "failed to create import staging file")
+	}
+	hash := sha256.New()
+	if _, err = copyBuffered(io.MultiWriter(f, hash), r, o.copyBufferSize); err != nil {
+		err = errors.Wrap(err, "failed to read source object")
+	} else if _, err = f.Seek(0, io.SeekStart); err != nil {
+		err = errors.Wrap(err, "failed to rewind import staging file")
+	}
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, "", err
+	}
+	return f, hex.EncodeToString(hash.Sum(nil)), nil
+}
@@ -0,0 +1,223 @@
+package plugin
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeObjectSource is an in-memory object store with a single bucket, counting the objects read from it.
+type fakeObjectSource struct {
+	mu      sync.Mutex
+	objects map[string]string
+	failing map[string]bool
+	reads   map[string]int
+}
+
+func (s *fakeObjectSource) ListObjects(bucket, prefix string) ([]string, error) {
+	var keys []string
+	for key := range s.objects {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func (s *fakeObjectSource) GetObject(bucket, key string) (io.ReadCloser, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.failing[key] {
+		return nil, errors.New("connection reset by peer")
+	}
+	s.reads[key]++
+	return io.NopCloser(strings.NewReader(s.objects[key])), nil
+}
+
+// fakeObjectInfoSource also lists the modification times of its objects.
+type fakeObjectInfoSource struct {
+	*fakeObjectSource
+	modTime time.Time
+}
+
+func (s *fakeObjectInfoSource) ListObjectsWithInfo(bucket, prefix string) ([]ObjectInfo, error) {
+	keys, _ := s.ListObjects(bucket, prefix)
+	var infos []ObjectInfo
+	for _, key := range keys {
+		infos = append(infos, ObjectInfo{Key: key, Size: int64(len(s.objects[key])), ModTime: s.modTime})
+	}
+	return infos, nil
+}
+
+func newFakeObjectSource(n int) *fakeObjectSource {
+	src := &fakeObjectSource{objects: map[string]string{}, failing: map[string]bool{}, reads: map[string]int{}}
+	for i := 0; i < n; i++ {
+		src.objects[fmt.Sprintf("backups/backup-%d/backup-%d.tar.gz", i, i)] = fmt.Sprintf("contents of backup %d", i)
+	}
+	return src
+}
+
+func Test_ImportFrom(t *testing.T) {
+	req := require.New(t)
+	o, root := newTestObjectStore(t)
+	o.importWorkers = 3
+
+	modTime := time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)
+	src := &fakeObjectInfoSource{fakeObjectSource: newFakeObjectSource(20), modTime: modTime}
+
+	var progress []ImportProgress
+	req.NoError(o.ImportFromWithProgress(src, "my-bucket", func(p ImportProgress) { progress = append(progress, p) }))
+
+	keys, err := o.ListObjects("my-bucket", "")
+	req.NoError(err)
+	wantKeys, _ := src.ListObjects("my-bucket", "")
+	req.ElementsMatch(wantKeys, keys)
+	for _, key := range keys {
+		rc, err := o.GetObject("my-bucket", key)
+		req.NoError(err)
+		got, err := io.ReadAll(rc)
+		req.NoError(err)
+		req.NoError(rc.Close())
+		req.Equal(src.objects[key], string(got))
+
+		info, err := os.Stat(filepath.Join(root, "my-bucket", key))
+		req.NoError(err)
+		req.True(modTime.Equal(info.ModTime()), key)
+	}
+
+	req.Len(progress, 20)
+	for i, p := range progress {
+		req.Equal(i+1, p.Done)
+		req.Equal(20, p.Total)
+		req.False(p.Skipped)
+		req.NoError(p.Err)
+	}
+}
+
+func Test_ImportFrom_resume(t *testing.T) {
+	req := require.New(t)
+	o, _ := newTestObjectStore(t)
+	src := newFakeObjectSource(10)
+	keys, _ := src.ListObjects("my-bucket", "")
+
+	// an earlier import failed part way
+	src.failing[keys[3]] = true
+	src.failing[keys[7]] = true
+	err := o.ImportFrom(src, "my-bucket")
+	req.ErrorContains(err, keys[3])
+	req.ErrorContains(err, keys[7])
+	imported, err := o.ListObjects("my-bucket", "")
+	req.NoError(err)
+	req.Len(imported, 8)
+
+	// an imported object changed in the source since
+	src.objects[keys[0]] = "changed contents"
+
+	src.failing = map[string]bool{}
+	src.reads = map[string]int{}
+	skipped := map[string]bool{}
+	req.NoError(o.ImportFromWithProgress(src, "my-bucket", func(p ImportProgress) { skipped[p.Key] = p.Skipped }))
+
+	for i, key := range keys {
+		rc, err := o.GetObject("my-bucket", key)
+		req.NoError(err)
+		got, err := io.ReadAll(rc)
+		req.NoError(err)
+		req.NoError(rc.Close())
+		req.Equal(src.objects[key], string(got))
+
+		switch i {
+		case 0:
+			// read once to compare, and imported from that read
+			req.False(skipped[key])
+			req.Equal(1, src.reads[key])
+		case 3, 7:
+			req.False(skipped[key])
+			req.Equal(1, src.reads[key])
+		default:
+			req.True(skipped[key], key)
+			req.Equal(1, src.reads[key])
+		}
+	}
+}
+
+func Test_ImportFrom_resumeWithInfo(t *testing.T) {
+	modTime := time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		name        string
+		change      func(src *fakeObjectInfoSource, key string)
+		wantSkipped bool
+	}{
+		{
+			name:        "unchanged",
+			change:      func(src *fakeObjectInfoSource, key string) {},
+			wantSkipped: true,
+		},
+		{
+			name: "content of the same size changed",
+			change: func(src *fakeObjectInfoSource, key string) {
+				src.objects[key] = strings.ToUpper(src.objects[key])
+			},
+		},
+		{
+			name: "size changed",
+			change: func(src *fakeObjectInfoSource, key string) {
+				src.objects[key] = "changed contents"
+			},
+		},
+		{
+			// The content is not compared once the modification time differs
+			name: "modification time changed",
+			change: func(src *fakeObjectInfoSource, key string) {
+				src.modTime = modTime.Add(time.Hour)
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := require.New(t)
+			o, root := newTestObjectStore(t)
+			src := &fakeObjectInfoSource{fakeObjectSource: newFakeObjectSource(1), modTime: modTime}
+			keys, _ := src.ListObjects("my-bucket", "")
+			req.NoError(o.ImportFrom(src, "my-bucket"))
+
+			tt.change(src, keys[0])
+			src.reads = map[string]int{}
+			var progress []ImportProgress
+			req.NoError(o.ImportFromWithProgress(src, "my-bucket", func(p ImportProgress) { progress = append(progress, p) }))
+			req.Len(progress, 1)
+			req.Equal(tt.wantSkipped, progress[0].Skipped)
+			req.Equal(1, src.reads[keys[0]], "the object should be read from the source once")
+
+			rc, err := o.GetObject("my-bucket", keys[0])
+			req.NoError(err)
+			got, err := io.ReadAll(rc)
+			req.NoError(err)
+			req.NoError(rc.Close())
+			req.Equal(src.objects[keys[0]], string(got))
+
+			// The staged content is removed
+			entries, err := os.ReadDir(filepath.Dir(filepath.Join(root, "my-bucket", keys[0])))
+			req.NoError(err)
+			for _, entry := range entries {
+				req.False(isTempFile(entry.Name()), entry.Name())
+			}
+		})
+	}
+}
+
+func Test_ImportFrom_readOnly(t *testing.T) {
+	o, _ := newTestObjectStore(t)
+	o.readOnly = true
+	require.ErrorIs(t, o.ImportFrom(newFakeObjectSource(1), "my-bucket"), ErrReadOnly)
+}
@@ -0,0 +1,75 @@
+package plugin
+
+import (
+	"hash/fnv"
+	"sort"
+	"sync"
+)
+
+// keyLockShards is the number of independently locked maps the key locks are spread over.
+const keyLockShards = 32
+
+// keyLocks serializes operations on the same key while letting operations on different keys run in parallel.
+// A key's mutex only exists while it is held or waited for. The zero value is ready to use.
+type keyLocks struct {
+	shards [keyLockShards]keyLockShard
+}
+
+type keyLockShard struct {
+	mu    sync.Mutex
+	locks map[string]*keyLock
+}
+
+type keyLock struct {
+	sync.Mutex
+	refs int
+}
+
+// lock blocks until the key is held by the caller, and returns the func that releases it.
+func (l *keyLocks) lock(key string) (unlock func()) {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	shard := &l.shards[h.Sum32()%keyLockShards]
+
+	shard.mu.Lock()
+	if shard.locks == nil {
+		shard.locks = map[string]*keyLock{}
+	}
+	kl := shard.locks[key]
+	if kl == nil {
+		kl = &keyLock{}
+		shard.locks[key] = kl
+	}
+	kl.refs++
+	shard.mu.Unlock()
+
+	kl.Lock()
+	return func() {
+		kl.Unlock()
+		shard.mu.Lock()
+		kl.refs--
+		if kl.refs == 0 {
+			delete(shard.locks, key)
+		}
+		shard.mu.Unlock()
+	}
+}
+
+// lockAll blocks until all the keys are held by the caller, and returns the func that releases them.
+// The keys are locked in sorted order, so callers locking overlapping keys cannot deadlock.
+func (l *keyLocks) lockAll(keys ...string) (unlock func()) {
+	keys = append([]string(nil), keys...)
+	sort.Strings(keys)
+	var unlocks []func()
+	for i, key := range keys {
+		if i > 0 && key == keys[i-1] {
+			continue
+		}
+		unlocks = append(unlocks, l.lock(key))
+	}
+	return func() {
+		for i := len(unlocks) - 1; i >= 0; i-- {
+			unlocks[i]()
+		}
+	}
+}
@@ -2,29 +2,60 @@ package plugin
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"math/rand"
 	"os"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/google/go-cmp/cmp"
 	"github.com/pkg/errors"
 	"github.com/replicatedhq/local-volume-provider/pkg/k8sutil"
 	"github.com/replicatedhq/local-volume-provider/pkg/version"
 	"github.com/sirupsen/logrus"
+	veleroclientset "github.com/vmware-tanzu/velero/pkg/generated/clientset/versioned"
 	veleroplugin "github.com/vmware-tanzu/velero/pkg/plugin/framework/common"
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
 	kuberneteserrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
 )
 
 type localVolumeObjectStoreOpts struct {
 	fileserverImage           string
-	securityContextRunAsUser  string
-	securityContextRunAsGroup string
-	securityContextFSGroup    string
+	securityContextRunAsUser  *int64
+	securityContextRunAsGroup *int64
+	securityContextFSGroup    *int64
 	preserveVolumes           map[string]bool
+	fileserverPort            int
+	fileserverScheme          string
+	fileserverExternalHost    string
+	signingSecretName         string
+	signingAlgorithm          string
+	signingKey                []byte
+	encryptionSecretName      string
+	authSecretName            string
+	encryptionKey             []byte
+	dirMode                   os.FileMode
+	fileMode                  os.FileMode
+	bucketConfigs             map[string]map[string]string
+	rootPath                  string
+	clockSkewTolerance        string
+	usageCacheTTL             string
+	fileserverImagePullPolicy corev1.PullPolicy
+	fileserverResources       corev1.ResourceRequirements
+	nodeAgentDaemonsetName    string
+	fileserverTimeouts        map[string]string
+	fileserverBreaker         map[string]string
 }
 
 const (
@@ -34,7 +65,15 @@ const (
 	NodeAgentDaemonsetName = "node-agent"
 	ResticDaemonsetName    = "restic"
 
+	// nodeAgentPodLabel is the pod label Velero installs select the node-agent or restic pods by
+	nodeAgentPodLabel = "name"
+
 	signingSecretName = "lvp-signingsecret"
+
+	// volumeRevisionAnnotation is set on the pod template to a hash of its volumes
+	volumeRevisionAnnotation = "nfs-provider/volume-revision"
+
+	defaultFileserverPort = 3000
 )
 
 var (
@@ -50,87 +89,181 @@ type EnsureResourcesOpts struct {
 	config     map[string]string
 	pluginOpts *localVolumeObjectStoreOpts
 	volumeType VolumeType
-	log        *logrus.Entry
+	// dryRun logs the changes that would be made to the resources instead of updating them
+	dryRun bool
+	log    *logrus.Entry
 }
 
 // ensureResources ensures that the resources needed for the plugin are present
-// and will update them if they are not.
-func ensureResources(opts EnsureResourcesOpts) error {
-	ds, err := getDaemonset(opts.clientset, opts.namespace, opts.pluginOpts)
-	if err != nil {
-		return errors.Wrap(err, "could not get daemonset")
+// and will update them if they are not. It reports whether the Velero deployment was updated,
+// in which case its pods restart with the volume mounted. In a dry run nothing is updated and
+// it reports whether the deployment would have been.
+// Plugins initializing other locations may update the resources at the same time, so each update
+// that conflicts is retried against the latest version of the resource.
+func ensureResources(opts EnsureResourcesOpts) (bool, error) {
+	// if `preserveVolumes` is specified, clean up all other volumes and volume mounts
+	if len(opts.pluginOpts.preserveVolumes) > 0 && !opts.pluginOpts.preserveVolumes[opts.bucket] {
+		// BackupStorageLocation exists, but the bucket is not in `preserveVolumes`, do not update the resources
+		opts.log.Warnf("`preserveVolumes` was specified, but %s was not included. The volume will not be created/mounted.", opts.bucket)
+		return false, nil
 	}
 
-	deployment, err := getDeployment(opts.clientset, opts.namespace, opts.pluginOpts)
+	volumeMountSpec := buildVolumeMount(opts.bucket, opts.path)
+
+	volumeSpec, err := buildVolume(opts.volumeType, opts.config, opts.log)
 	if err != nil {
-		return errors.Wrap(err, "could not get Velero deployment")
+		return false, errors.Wrap(err, "failed to build volume")
 	}
 
-	// if `preserveVolumes` is specified, clean up all other volumes and volume mounts
-	if len(opts.pluginOpts.preserveVolumes) > 0 {
-		if !opts.pluginOpts.preserveVolumes[opts.bucket] {
-			// BackupStorageLocation exists, but the bucket is not in `preserveVolumes`, do not update the resources
-			opts.log.Warnf("`preserveVolumes` was specified, but %s was not included. The volume will not be created/mounted.", opts.bucket)
-			return nil
-		}
+	if err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		return ensureDaemonset(opts, volumeSpec, volumeMountSpec)
+	}); err != nil {
+		return false, err
+	}
 
-		if ds != nil {
-			ds.Spec.Template.Spec.Volumes = removeUnusedVolumes(ds.Spec.Template.Spec.Volumes, opts.pluginOpts.preserveVolumes)
-			ds.Spec.Template.Spec.Containers[0].VolumeMounts = removeUnusedVolumeMounts(ds.Spec.Template.Spec.Containers[0].VolumeMounts, opts.pluginOpts.preserveVolumes)
-		}
+	updated := false
+	err = retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		var err error
+		updated, err = ensureDeployment(opts, volumeSpec, volumeMountSpec)
+		return err
+	})
+	return updated, err
+}
 
-		deployment.Spec.Template.Spec.Volumes = removeUnusedVolumes(deployment.Spec.Template.Spec.Volumes, opts.pluginOpts.preserveVolumes)
-		// remove unused mounts from all containers in the deployment
-		for idx := range deployment.Spec.Template.Spec.Containers {
-			container := &deployment.Spec.Template.Spec.Containers[idx]
-			container.VolumeMounts = removeUnusedVolumeMounts(container.VolumeMounts, opts.pluginOpts.preserveVolumes)
-		}
+// ensureDaemonset gets the node-agent daemonset, if there is one, and updates it if it does not mount the volume
+// or has outdated plugin configuration. A conflicting update is returned unwrapped, so that it can be retried.
+func ensureDaemonset(opts EnsureResourcesOpts, volumeSpec *corev1.Volume, volumeMountSpec *corev1.VolumeMount) error {
+	ds, err := getDaemonset(opts.clientset, opts.namespace, opts.pluginOpts)
+	if err != nil {
+		return errors.Wrap(err, "could not get daemonset")
+	}
+	if ds == nil {
+		return nil
 	}
 
-	volumeMountSpec := buildVolumeMount(opts.bucket, opts.path)
+	// Only changed resources are updated, as any update to the pod template restarts the pods
+	originalDs := ds.DeepCopy()
 
-	volumeSpec, err := buildVolume(opts.volumeType, opts.config, opts.log)
-	if err != nil {
-		return errors.Wrap(err, "failed to build volume")
+	if len(opts.pluginOpts.preserveVolumes) > 0 {
+		ds.Spec.Template.Spec.Volumes = removeUnusedVolumes(ds.Spec.Template.Spec.Volumes, opts.pluginOpts.preserveVolumes)
+		ds.Spec.Template.Spec.Containers[0].VolumeMounts = removeUnusedVolumeMounts(ds.Spec.Template.Spec.Containers[0].VolumeMounts, opts.pluginOpts.preserveVolumes)
 	}
 
-	if ds != nil {
-		// If node-agent is present, it must also mount the volume
+	// If node-agent is present, it must also mount the volume
+	if opts.volumeType == ExistingClaim && podHasClaimMounted(&ds.Spec.Template.Spec, volumeSpec) {
+		opts.log.Debugf("Claim %s is already mounted in the node-agent daemonset", volumeSpec.PersistentVolumeClaim.ClaimName)
+	} else {
 		err = ensureDaemonsetHasVolume(ds, volumeSpec, volumeMountSpec)
 		if err != nil {
 			return errors.Wrap(err, "failed to ensure node-agent daemonset has volume")
 		}
+	}
 
-		err = ensureDaemonsetHasConfig(ds, opts.pluginOpts)
-		if err != nil {
-			return errors.Wrap(err, "failed to ensure node-agent daemonset has plugin configuration")
-		}
+	err = ensureDaemonsetHasConfig(ds, opts.pluginOpts)
+	if err != nil {
+		return errors.Wrap(err, "failed to ensure node-agent daemonset has plugin configuration")
+	}
 
-		// Update the node-agent daemonset
-		_, err = opts.clientset.AppsV1().DaemonSets(opts.namespace).Update(context.TODO(), ds, metav1.UpdateOptions{})
-		if err != nil {
-			return errors.Wrap(err, "unable to update node-agent daemonset")
-		}
+	// Update the node-agent daemonset
+	if apiequality.Semantic.DeepEqual(originalDs.Spec, ds.Spec) {
+		opts.log.Debug("Node-agent daemonset is already up to date")
+		return nil
+	}
+	if opts.dryRun {
+		opts.log.Infof("Dry run, not updating node-agent daemonset (-current +desired):\n%s", cmp.Diff(originalDs.Spec, ds.Spec))
+		return nil
 	}
+	_, err = opts.clientset.AppsV1().DaemonSets(opts.namespace).Update(context.TODO(), ds, metav1.UpdateOptions{})
+	if kuberneteserrors.IsConflict(err) {
+		opts.log.Debug("Node-agent daemonset was updated concurrently, retrying")
+		return err
+	} else if err != nil {
+		return errors.Wrap(err, "unable to update node-agent daemonset")
+	}
+	opts.log.Info("Updated node-agent daemonset, its pods will restart")
+	return nil
+}
 
-	err = ensureDeploymentHasVolume(deployment, volumeSpec, volumeMountSpec)
+// ensureDeployment gets the Velero deployment and updates it if it does not mount the volume or have the current
+// plugin configuration and fileserver, reporting whether it was updated. A conflicting update is returned unwrapped,
+// so that it can be retried.
+func ensureDeployment(opts EnsureResourcesOpts, volumeSpec *corev1.Volume, volumeMountSpec *corev1.VolumeMount) (bool, error) {
+	deployment, err := getDeployment(opts.clientset, opts.namespace, opts.pluginOpts)
 	if err != nil {
-		return errors.Wrap(err, "failed to ensure velero deployment has volume")
+		return false, errors.Wrap(err, "could not get Velero deployment")
+	}
+
+	// Only changed resources are updated, as any update to the pod template restarts the Velero pods
+	originalDeployment := deployment.DeepCopy()
+
+	if len(opts.pluginOpts.preserveVolumes) > 0 {
+		deployment.Spec.Template.Spec.Volumes = removeUnusedVolumes(deployment.Spec.Template.Spec.Volumes, opts.pluginOpts.preserveVolumes)
+		// remove unused mounts from all containers in the deployment
+		for idx := range deployment.Spec.Template.Spec.Containers {
+			container := &deployment.Spec.Template.Spec.Containers[idx]
+			container.VolumeMounts = removeUnusedVolumeMounts(container.VolumeMounts, opts.pluginOpts.preserveVolumes)
+		}
+	}
+
+	if opts.volumeType == ExistingClaim && podHasClaimMounted(&deployment.Spec.Template.Spec, volumeSpec) {
+		opts.log.Debugf("Claim %s is already mounted in the velero deployment", volumeSpec.PersistentVolumeClaim.ClaimName)
+	} else {
+		err = ensureDeploymentHasVolume(deployment, volumeSpec, volumeMountSpec)
+		if err != nil {
+			return false, errors.Wrap(err, "failed to ensure velero deployment has volume")
+		}
 	}
 
 	// Always update the deployment for new configmap setting and the fileserver,
 	// even if the local volume is already mounted.
 	err = ensureDeploymentHasConfigAndFileserver(deployment, volumeMountSpec, opts.pluginOpts)
 	if err != nil {
-		return errors.Wrap(err, "could not ensure plugin configuration")
+		return false, errors.Wrap(err, "could not ensure plugin configuration")
+	}
+
+	// The revision is only written in an update that changes the volumes, so it never restarts the pods on its own
+	if !apiequality.Semantic.DeepEqual(originalDeployment.Spec.Template.Spec.Volumes, deployment.Spec.Template.Spec.Volumes) {
+		if err := setVolumeRevision(&deployment.Spec.Template); err != nil {
+			return false, errors.Wrap(err, "failed to set volume revision of velero deployment")
+		}
 	}
 
 	// Update Velero deployment
+	if apiequality.Semantic.DeepEqual(originalDeployment.Spec, deployment.Spec) {
+		opts.log.Debug("Velero deployment is already up to date")
+		return false, nil
+	}
+	if opts.dryRun {
+		opts.log.Infof("Dry run, not updating velero deployment (-current +desired):\n%s", cmp.Diff(originalDeployment.Spec, deployment.Spec))
+		return true, nil
+	}
 	_, err = opts.clientset.AppsV1().Deployments(opts.namespace).Update(context.TODO(), deployment, metav1.UpdateOptions{})
+	if kuberneteserrors.IsConflict(err) {
+		opts.log.Debug("Velero deployment was updated concurrently, retrying")
+		return false, err
+	} else if err != nil {
+		return false, errors.Wrap(err, "unable to update velero deployment")
+	}
+	opts.log.Info("Updated velero deployment, its pods will restart")
+
+	return true, nil
+}
+
+// setVolumeRevision annotates the pod template with a hash of its volumes, so that a change to the volume set
+// rolls the pods whatever else changes in the template.
+func setVolumeRevision(template *corev1.PodTemplateSpec) error {
+	volumes := append([]corev1.Volume(nil), template.Spec.Volumes...)
+	sort.Slice(volumes, func(i, j int) bool { return volumes[i].Name < volumes[j].Name })
+	data, err := json.Marshal(volumes)
 	if err != nil {
-		return errors.Wrap(err, "unable to update velero deployment")
+		return err
 	}
+	sum := sha256.Sum256(data)
 
+	if template.Annotations == nil {
+		template.Annotations = map[string]string{}
+	}
+	template.Annotations[volumeRevisionAnnotation] = hex.EncodeToString(sum[:8])
 	return nil
 }
 
@@ -179,22 +312,63 @@ func ensureDeploymentHasVolume(deployment *appsv1.Deployment, volumeSpec *corev1
 	return nil
 }
 
+// nodeAgentLabelSelectors select the daemonsets of a Velero install, by the labels of the Velero CLI and the Helm chart,
+// to find a node agent daemonset that was renamed.
+var nodeAgentLabelSelectors = []string{
+	"component=velero",
+	"app.kubernetes.io/name=velero",
+}
+
 // getDaemonset returns the daemonset for node agent. It will return nil if it cannot be found,
-// as node agent is an optional component.
+// as node agent is an optional component. The daemonset is looked up by the configured name, then by
+// the node-agent name, then by the old restic name, and finally among the Velero daemonsets by its pod label.
 func getDaemonset(clientset kubernetes.Interface, namespace string, opts *localVolumeObjectStoreOpts) (*appsv1.DaemonSet, error) {
-	ds, err := clientset.AppsV1().DaemonSets(namespace).Get(context.TODO(), NodeAgentDaemonsetName, metav1.GetOptions{})
-	if kuberneteserrors.IsNotFound(err) {
-		// try the old name for backwards compatibility
-		ds, err = clientset.AppsV1().DaemonSets(namespace).Get(context.TODO(), ResticDaemonsetName, metav1.GetOptions{})
-		if kuberneteserrors.IsNotFound(err) {
-			return nil, nil
-		} else if err != nil {
-			return nil, errors.Wrap(err, "failed to get restic daemonset")
+	names := []string{NodeAgentDaemonsetName, ResticDaemonsetName}
+	if opts != nil && opts.nodeAgentDaemonsetName != "" {
+		names = append([]string{opts.nodeAgentDaemonsetName}, names...)
+	}
+	for _, name := range names {
+		ds, err := clientset.AppsV1().DaemonSets(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+		if err == nil {
+			return ds, nil
+		} else if !kuberneteserrors.IsNotFound(err) {
+			return nil, errors.Wrapf(err, "failed to get %s daemonset", name)
 		}
-	} else if err != nil {
-		return nil, errors.Wrap(err, "failed to get node-agent daemonset")
 	}
-	return ds, nil
+
+	found := map[string]*appsv1.DaemonSet{}
+	for _, selector := range nodeAgentLabelSelectors {
+		list, err := clientset.AppsV1().DaemonSets(namespace).List(context.TODO(), metav1.ListOptions{LabelSelector: selector})
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to list Velero daemonsets")
+		}
+		for i := range list.Items {
+			if isNodeAgentDaemonset(&list.Items[i]) {
+				found[list.Items[i].Name] = &list.Items[i]
+			}
+		}
+	}
+	if len(found) > 1 {
+		names := make([]string, 0, len(found))
+		for name := range found {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return nil, errors.Errorf("found node agent daemonsets %s, set nodeAgentDaemonsetName to choose one", strings.Join(names, ", "))
+	}
+	for _, ds := range found {
+		return ds, nil
+	}
+	return nil, nil
+}
+
+// isNodeAgentDaemonset returns truthy if the daemonset runs node-agent or restic pods.
+func isNodeAgentDaemonset(ds *appsv1.DaemonSet) bool {
+	switch ds.Spec.Template.Labels[nodeAgentPodLabel] {
+	case NodeAgentDaemonsetName, ResticDaemonsetName:
+		return true
+	}
+	return false
 }
 
 // ensureDaemonsetHasVolume checks the node-agent daemonset for a matching Volume name. If it does not find it,
@@ -214,11 +388,7 @@ func ensureDaemonsetHasVolume(ds *appsv1.DaemonSet, volumeSpec *corev1.Volume, v
 
 // ensureDaemonsetHasConfig will update the node-agent daemonset as-needed based on config options.
 func ensureDaemonsetHasConfig(ds *appsv1.DaemonSet, opts *localVolumeObjectStoreOpts) error {
-	podSecurityCxt, err := getPodSecurityContext(opts)
-	if err != nil {
-		return errors.Wrap(err, "unable to get security context")
-	}
-	if podSecurityCxt != nil {
+	if podSecurityCxt := getPodSecurityContext(opts); podSecurityCxt != nil {
 		ds.Spec.Template.Spec.SecurityContext = podSecurityCxt
 	}
 	return nil
@@ -289,19 +459,44 @@ func containerHasEnvVar(container *corev1.Container, name string) bool {
 	return false
 }
 
-// getPluginConfigMap return the config map for the plugin volume time based on velero label conventions.
-// It returns nil if it cannot be found.
-func getPluginConfigMap(kind VolumeType) (*corev1.ConfigMap, error) {
-	listOpts := metav1.ListOptions{
-		LabelSelector: fmt.Sprintf("replicated.com/%s=%s", string(kind), veleroplugin.PluginKindObjectStore),
+// serviceAccountNamespacePath is the file holding the namespace of the pod, mounted with its service account token.
+// It is a variable so tests can provide one.
+var serviceAccountNamespacePath = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+
+// resolveVeleroNamespace returns the namespace Velero is installed in: the veleroNamespace config if set,
+// otherwise the VELERO_NAMESPACE env var, otherwise the namespace of the pod the plugin runs in.
+// Setting it allows the plugin to run outside of the Velero namespace, as in multi-tenant installs.
+func resolveVeleroNamespace(override string) string {
+	if override != "" {
+		return override
 	}
+	if namespace := os.Getenv("VELERO_NAMESPACE"); namespace != "" {
+		return namespace
+	}
+	data, err := os.ReadFile(serviceAccountNamespacePath)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
 
+// getPluginConfigMap return the config map for the plugin volume time based on velero label conventions.
+// It returns nil if it cannot be found.
+func getPluginConfigMap(kind VolumeType, namespace string) (*corev1.ConfigMap, error) {
 	clientset, err := k8sutil.GetClientset()
 	if err != nil {
 		return nil, errors.Wrap(err, "unable to get kubernetes clientset")
 	}
+	return findPluginConfigMap(clientset, kind, namespace)
+}
 
-	list, err := clientset.CoreV1().ConfigMaps(os.Getenv("VELERO_NAMESPACE")).List(context.TODO(), listOpts)
+// findPluginConfigMap returns the config map of the plugin for the volume type in the namespace, or nil if there is none.
+func findPluginConfigMap(clientset kubernetes.Interface, kind VolumeType, namespace string) (*corev1.ConfigMap, error) {
+	listOpts := metav1.ListOptions{
+		LabelSelector: fmt.Sprintf("replicated.com/%s=%s", string(kind), veleroplugin.PluginKindObjectStore),
+	}
+
+	list, err := clientset.CoreV1().ConfigMaps(namespace).List(context.TODO(), listOpts)
 	if err != nil {
 		return nil, errors.Wrap(err, "could not list config maps")
 	}
@@ -321,9 +516,10 @@ func getPluginConfigMap(kind VolumeType) (*corev1.ConfigMap, error) {
 	return &list.Items[0], nil
 }
 
-// getSigningKey returns a byte slice of the a signing key located in a given namespace. If the key cannot be found,
-// it will generate a secret in the provided namespace.
-func getSigningKey(namespace string) ([]byte, error) {
+// GetSigningKey returns a byte slice of the a signing key held in the named secret in a given namespace.
+// If secretName is empty the default signing secret is used, and if that cannot be found,
+// it will generate a secret in the provided namespace. A user provided secret is never generated.
+func GetSigningKey(namespace, secretName string) ([]byte, error) {
 	clientset, err := k8sutil.GetClientset()
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to get kubernetes clientset")
@@ -331,6 +527,17 @@ func getSigningKey(namespace string) ([]byte, error) {
 
 	secrets := clientset.CoreV1().Secrets(namespace)
 
+	if secretName != "" && secretName != signingSecretName {
+		signingSecret, err := secrets.Get(context.Background(), secretName, metav1.GetOptions{})
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to get signing secret %s", secretName)
+		}
+		if len(signingSecret.Data["SigningKey"]) == 0 {
+			return nil, errors.Errorf("signing secret %s is missing the SigningKey key", secretName)
+		}
+		return signingSecret.Data["SigningKey"], nil
+	}
+
 	signingSecret, err := secrets.Get(context.Background(), signingSecretName, metav1.GetOptions{})
 	if err != nil {
 		// generate new signing secret if one isn't found
@@ -343,10 +550,91 @@ func getSigningKey(namespace string) ([]byte, error) {
 	return signingSecret.Data["SigningKey"], nil
 }
 
+// GetEncryptionKey returns the AES-256 key held in the EncryptionKey key of the named secret in a given namespace.
+// Unlike the signing key, an encryption key is never generated, as losing it would make every object unreadable.
+func GetEncryptionKey(namespace, secretName string) ([]byte, error) {
+	clientset, err := k8sutil.GetClientset()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get kubernetes clientset")
+	}
+
+	encryptionSecret, err := clientset.CoreV1().Secrets(namespace).Get(context.Background(), secretName, metav1.GetOptions{})
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get encryption secret %s", secretName)
+	}
+	key := encryptionSecret.Data["EncryptionKey"]
+	if len(key) != encryptionKeySize {
+		return nil, errors.Errorf("encryption secret %s must have an EncryptionKey key of %d bytes", secretName, encryptionKeySize)
+	}
+	return key, nil
+}
+
+// GetLocationConfig returns the config the plugin applies to the bucket: the config of its BackupStorageLocation in
+// the namespace, merged with the bucket config of the plugin config map, as Init applies it. It returns nil if no
+// location of the plugin has the bucket.
+func GetLocationConfig(namespace, bucket string) (map[string]string, error) {
+	clientset, err := k8sutil.GetClientset()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get kubernetes clientset")
+	}
+	veleroClient, err := k8sutil.GetVeleroClientset()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get velero clientset")
+	}
+	return getLocationConfig(clientset, veleroClient, namespace, bucket)
+}
+
+func getLocationConfig(clientset kubernetes.Interface, veleroClient veleroclientset.Interface, namespace, bucket string) (map[string]string, error) {
+	locations, err := veleroClient.VeleroV1().BackupStorageLocations(namespace).List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list backup storage locations")
+	}
+	for _, location := range locations.Items {
+		kind, ok := strings.CutPrefix(location.Spec.Provider, "replicated.com/")
+		if !ok || location.Spec.ObjectStorage == nil || location.Spec.ObjectStorage.Bucket != bucket {
+			continue
+		}
+
+		pluginConfigMap, err := findPluginConfigMap(clientset, VolumeType(kind), namespace)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to get plugin config map")
+		}
+		var data map[string]string
+		if pluginConfigMap != nil {
+			data = pluginConfigMap.Data
+		}
+		opts, err := parsePluginConfig(data, logrus.New())
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid plugin config map")
+		}
+		return withBucketConfig(location.Spec.Config, opts.bucketConfigs[bucket]), nil
+	}
+	return nil, nil
+}
+
+// GetAuthToken returns the token held in the AuthToken key of the named secret in a given namespace,
+// which clients of the fileserver must present as a bearer token along with a signed URL.
+func GetAuthToken(namespace, secretName string) ([]byte, error) {
+	clientset, err := k8sutil.GetClientset()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get kubernetes clientset")
+	}
+
+	authSecret, err := clientset.CoreV1().Secrets(namespace).Get(context.Background(), secretName, metav1.GetOptions{})
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get auth secret %s", secretName)
+	}
+	token := authSecret.Data["AuthToken"]
+	if len(token) == 0 {
+		return nil, errors.Errorf("auth secret %s is missing the AuthToken key", secretName)
+	}
+	return token, nil
+}
+
 // createSigningSecret creates a new signing key secret in the given namespace.
 func createSigningSecret(namespace string) (*corev1.Secret, error) {
 	if namespace == "" {
-		namespace = os.Getenv("VELERO_NAMESPACE")
+		namespace = resolveVeleroNamespace("")
 	}
 
 	secret := &corev1.Secret{
@@ -384,37 +672,81 @@ func createSigningSecret(namespace string) (*corev1.Secret, error) {
 }
 
 // getPodSecurityContext returns a pod security context object based on the plugin configuration provided in the options.
-func getPodSecurityContext(opts *localVolumeObjectStoreOpts) (*corev1.PodSecurityContext, error) {
-	var securityCxt *corev1.PodSecurityContext
-	// If pod security context was provided, ensure that it is added to the deployment
-	if opts.securityContextRunAsUser != "" || opts.securityContextRunAsGroup != "" || opts.securityContextFSGroup != "" {
-		securityCxt = &corev1.PodSecurityContext{}
-
-		if opts.securityContextRunAsUser != "" {
-			runAsUser, err := StringToIntPointer(opts.securityContextRunAsUser)
-			if err != nil {
-				return nil, errors.Wrap(err, "failed to parse security context 'runAsUser' into integer")
-			}
-			securityCxt.RunAsUser = runAsUser
-		}
+// It returns nil if no security context was configured.
+func getPodSecurityContext(opts *localVolumeObjectStoreOpts) *corev1.PodSecurityContext {
+	if opts.securityContextRunAsUser == nil && opts.securityContextRunAsGroup == nil && opts.securityContextFSGroup == nil {
+		return nil
+	}
+	return &corev1.PodSecurityContext{
+		RunAsUser:  opts.securityContextRunAsUser,
+		RunAsGroup: opts.securityContextRunAsGroup,
+		FSGroup:    opts.securityContextFSGroup,
+	}
+}
 
-		if opts.securityContextRunAsGroup != "" {
-			runAsGroup, err := StringToIntPointer(opts.securityContextRunAsGroup)
-			if err != nil {
-				return nil, errors.Wrap(err, "failed to parse security context 'runAsGroup' into integer")
-			}
-			securityCxt.RunAsGroup = runAsGroup
+// parseSecurityContextID parses the user or group ID of a security context config value.
+// An empty value is unset and returns nil.
+func parseSecurityContextID(key, value string) (*int64, error) {
+	if value == "" {
+		return nil, nil
+	}
+	id, err := StringToIntPointer(value)
+	if err != nil || *id < 0 {
+		return nil, errors.Errorf("invalid %s %q: must be a non-negative integer ID", key, value)
+	}
+	return id, nil
+}
+
+// parseImagePullPolicy parses the pull policy of the fileserver image. An empty value is unset.
+func parseImagePullPolicy(value string) (corev1.PullPolicy, error) {
+	switch policy := corev1.PullPolicy(value); policy {
+	case "", corev1.PullAlways, corev1.PullIfNotPresent, corev1.PullNever:
+		return policy, nil
+	default:
+		return "", errors.Errorf("invalid fileserverImagePullPolicy %q: must be Always, IfNotPresent or Never", value)
+	}
+}
+
+// fileserverResourceKeys are the plugin config keys of the fileserver container's resource requests and limits.
+var fileserverResourceKeys = []struct {
+	key      string
+	resource corev1.ResourceName
+	limit    bool
+}{
+	{key: "fileserverCPURequest", resource: corev1.ResourceCPU},
+	{key: "fileserverCPULimit", resource: corev1.ResourceCPU, limit: true},
+	{key: "fileserverMemoryRequest", resource: corev1.ResourceMemory},
+	{key: "fileserverMemoryLimit", resource: corev1.ResourceMemory, limit: true},
+}
+
+// parseFileserverResources returns the resource requests and limits of the fileserver container set in the plugin config.
+// Resources that are not set are left out, and a request may not exceed its limit.
+func parseFileserverResources(data map[string]string) (corev1.ResourceRequirements, error) {
+	var resources corev1.ResourceRequirements
+	for _, k := range fileserverResourceKeys {
+		if data[k.key] == "" {
+			continue
+		}
+		quantity, err := resource.ParseQuantity(data[k.key])
+		if err != nil {
+			return resources, errors.Wrapf(err, "invalid %s %q", k.key, data[k.key])
+		}
+		list := &resources.Requests
+		if k.limit {
+			list = &resources.Limits
 		}
+		if *list == nil {
+			*list = corev1.ResourceList{}
+		}
+		(*list)[k.resource] = quantity
+	}
 
-		if opts.securityContextFSGroup != "" {
-			fsGroup, err := StringToIntPointer(opts.securityContextFSGroup)
-			if err != nil {
-				return nil, errors.Wrap(err, "failed to parse security context 'fsGroup' into integer")
-			}
-			securityCxt.FSGroup = fsGroup
+	for name, request := range resources.Requests {
+		if limit, ok := resources.Limits[name]; ok && request.Cmp(limit) > 0 {
+			return resources, errors.Errorf("fileserver %s request %s exceeds its limit %s", name, request.String(), limit.String())
 		}
 	}
-	return securityCxt, nil
+	return resources, nil
 }
 
 func containerHasVolumeMount(container *corev1.Container, name string) bool {
@@ -430,16 +762,11 @@ func containerHasVolumeMount(container *corev1.Container, name string) bool {
 func ensureDeploymentHasConfigAndFileserver(deployment *appsv1.Deployment, volumeMountSpec *corev1.VolumeMount, opts *localVolumeObjectStoreOpts) error {
 
 	// Security Context
-	podSecurityCxt, err := getPodSecurityContext(opts)
-	if err != nil {
-		return errors.Wrap(err, "unable to get security context")
-	}
-	if podSecurityCxt != nil {
+	if podSecurityCxt := getPodSecurityContext(opts); podSecurityCxt != nil {
 		deployment.Spec.Template.Spec.SecurityContext = podSecurityCxt
 	}
 
 	// Fileserver
-	// TODO (dans): make sure that the MOUNT_POINT env exists, even if the container is already there.
 	fileServerContainer := getContainerByName(deployment, fileServerContainerName)
 
 	fileServerImage := defaultFileServerContainerImage
@@ -447,13 +774,8 @@ func ensureDeploymentHasConfigAndFileserver(deployment *appsv1.Deployment, volum
 		fileServerImage = opts.fileserverImage
 	}
 
-	// If the sidecar already exists and a volume mount with the same name, nothing to change
-	if fileServerContainer != nil && containerHasVolumeMount(fileServerContainer, volumeMountSpec.Name) {
-		return nil
-	}
-
 	if fileServerContainer == nil {
-		fileServerContainer = &corev1.Container{
+		deployment.Spec.Template.Spec.Containers = append(deployment.Spec.Template.Spec.Containers, corev1.Container{
 			Name:    fileServerContainerName,
 			Image:   fileServerImage,
 			Command: []string{"/local-volume-fileserver"},
@@ -472,11 +794,96 @@ func ensureDeploymentHasConfigAndFileserver(deployment *appsv1.Deployment, volum
 				},
 			},
 			VolumeMounts: []corev1.VolumeMount{*volumeMountSpec},
-		}
-		deployment.Spec.Template.Spec.Containers = append(deployment.Spec.Template.Spec.Containers, *fileServerContainer)
-	} else {
+		})
+		fileServerContainer = getContainerByName(deployment, fileServerContainerName)
+	} else if !containerHasVolumeMount(fileServerContainer, volumeMountSpec.Name) {
 		fileServerContainer.VolumeMounts = append(fileServerContainer.VolumeMounts, *volumeMountSpec)
 	}
 
+	// An unset policy is left to the Kubernetes default, so clearing the option resets it
+	fileServerContainer.ImagePullPolicy = opts.fileserverImagePullPolicy
+	// Only the configured resources are set, so others added to the deployment by hand are kept
+	for name, quantity := range opts.fileserverResources.Requests {
+		if fileServerContainer.Resources.Requests == nil {
+			fileServerContainer.Resources.Requests = corev1.ResourceList{}
+		}
+		fileServerContainer.Resources.Requests[name] = quantity
+	}
+	for name, quantity := range opts.fileserverResources.Limits {
+		if fileServerContainer.Resources.Limits == nil {
+			fileServerContainer.Resources.Limits = corev1.ResourceList{}
+		}
+		fileServerContainer.Resources.Limits[name] = quantity
+	}
+
+	// The listener port must match the one used to build signed URLs
+	if opts.fileserverPort != 0 {
+		setContainerEnvVar(fileServerContainer, "FILESERVER_PORT", strconv.Itoa(opts.fileserverPort))
+	} else {
+		removeContainerEnvVar(fileServerContainer, "FILESERVER_PORT")
+	}
+	// The verifier must rebuild the URL clients were given when the fileserver is published externally
+	syncContainerEnvVar(fileServerContainer, "FILESERVER_SCHEME", opts.fileserverScheme)
+	syncContainerEnvVar(fileServerContainer, "FILESERVER_EXTERNAL_HOST", opts.fileserverExternalHost)
+	// The verifier must use the same key and algorithm that URLs are signed with
+	syncContainerEnvVar(fileServerContainer, "SIGNING_SECRET_NAME", opts.signingSecretName)
+	syncContainerEnvVar(fileServerContainer, "SIGNING_ALGORITHM", opts.signingAlgorithm)
+	syncContainerEnvVar(fileServerContainer, "CLOCK_SKEW_TOLERANCE", opts.clockSkewTolerance)
+	syncContainerEnvVar(fileServerContainer, "USAGE_CACHE_TTL", opts.usageCacheTTL)
+	for _, key := range fileserverTimeoutKeys {
+		syncContainerEnvVar(fileServerContainer, fileserverTimeoutEnvVars[key], opts.fileserverTimeouts[key])
+	}
+	for _, key := range fileserverBreakerKeys {
+		syncContainerEnvVar(fileServerContainer, fileserverBreakerEnvVars[key], opts.fileserverBreaker[key])
+	}
+	syncContainerEnvVar(fileServerContainer, "AUTH_SECRET_NAME", opts.authSecretName)
+	syncContainerEnvVar(fileServerContainer, "ENCRYPTION_SECRET_NAME", opts.encryptionSecretName)
+	// The fileserver must serve from where the volumes are mounted, back at the default root once rootPath is cleared
+	mountPoint := getRoot()
+	if opts.rootPath != "" {
+		mountPoint = opts.rootPath
+	}
+	setContainerEnvVar(fileServerContainer, "MOUNT_POINT", mountPoint)
+
 	return nil
 }
+
+// setContainerEnvVar sets the value of the env var with the given name, adding it to the container if needed.
+func setContainerEnvVar(container *corev1.Container, name, value string) {
+	for idx := range container.Env {
+		if container.Env[idx].Name == name {
+			container.Env[idx] = corev1.EnvVar{Name: name, Value: value}
+			return
+		}
+	}
+	container.Env = append(container.Env, corev1.EnvVar{Name: name, Value: value})
+}
+
+// syncContainerEnvVar sets the value of the env var with the given name, or removes it from the container if the
+// value is empty.
+func syncContainerEnvVar(container *corev1.Container, name, value string) {
+	if value == "" {
+		removeContainerEnvVar(container, name)
+		return
+	}
+	setContainerEnvVar(container, name, value)
+}
+
+// removeContainerEnvVar removes the env var with the given name from the container, if it is set,
+// so that options cleared from the plugin config map stop applying.
+func removeContainerEnvVar(container *corev1.Container, name string) {
+	for idx := range container.Env {
+		if container.Env[idx].Name == name {
+			container.Env = append(container.Env[:idx], container.Env[idx+1:]...)
+			return
+		}
+	}
+}
+
+// getFileserverPort returns the port the fileserver listens on based on the plugin configuration.
+func getFileserverPort(opts *localVolumeObjectStoreOpts) int {
+	if opts != nil && opts.fileserverPort != 0 {
+		return opts.fileserverPort
+	}
+	return defaultFileserverPort
+}
@@ -2,14 +2,24 @@ package plugin
 
 import (
 	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/require"
+	velerov1 "github.com/vmware-tanzu/velero/pkg/apis/velero/v1"
+	velerofake "github.com/vmware-tanzu/velero/pkg/generated/clientset/versioned/fake"
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	kuberneteserrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
 	"k8s.io/utils/pointer"
 )
 
@@ -690,9 +700,9 @@ func Test_ensureResources(t *testing.T) {
 					"path":   "/backups",
 				},
 				pluginOpts: &localVolumeObjectStoreOpts{
-					securityContextRunAsUser:  "1001",
-					securityContextRunAsGroup: "1001",
-					securityContextFSGroup:    "2001",
+					securityContextRunAsUser:  pointer.Int64Ptr(1001),
+					securityContextRunAsGroup: pointer.Int64Ptr(1001),
+					securityContextFSGroup:    pointer.Int64Ptr(2001),
 				},
 				volumeType: Hostpath,
 				log:        logrus.NewEntry(logrus.New()),
@@ -921,9 +931,9 @@ func Test_ensureResources(t *testing.T) {
 					"path":   "/backups",
 				},
 				pluginOpts: &localVolumeObjectStoreOpts{
-					securityContextRunAsUser:  "1001",
-					securityContextRunAsGroup: "1001",
-					securityContextFSGroup:    "2001",
+					securityContextRunAsUser:  pointer.Int64Ptr(1001),
+					securityContextRunAsGroup: pointer.Int64Ptr(1001),
+					securityContextFSGroup:    pointer.Int64Ptr(2001),
 				},
 				volumeType: Hostpath,
 				log:        logrus.NewEntry(logrus.New()),
@@ -1041,7 +1051,7 @@ func Test_ensureResources(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			err := ensureResources(tt.opts)
+			_, err := ensureResources(tt.opts)
 			require.NoError(t, err)
 
 			if tt.wantDeployment != nil {
@@ -1088,3 +1098,718 @@ func getLVPContainerEnv() []corev1.EnvVar {
 		},
 	}
 }
+
+func Test_ensureDeploymentHasConfigAndFileserver_env(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    *localVolumeObjectStoreOpts
+		wantEnv []corev1.EnvVar
+	}{
+		{
+			name:    "default port -- no port env var",
+			opts:    &localVolumeObjectStoreOpts{},
+			wantEnv: getLVPContainerEnv(),
+		},
+		{
+			name: "custom port -- port env var is set",
+			opts: &localVolumeObjectStoreOpts{fileserverPort: 3333},
+			wantEnv: append(getLVPContainerEnv(), corev1.EnvVar{
+				Name:  "FILESERVER_PORT",
+				Value: "3333",
+			}),
+		},
+		{
+			name: "timeouts -- only the ones set are passed on",
+			opts: &localVolumeObjectStoreOpts{fileserverTimeouts: map[string]string{
+				"fileserverReadHeaderTimeout": "5s",
+				"fileserverWriteTimeout":      "1h",
+			}},
+			wantEnv: append(getLVPContainerEnv(),
+				corev1.EnvVar{Name: "FILESERVER_READ_HEADER_TIMEOUT", Value: "5s"},
+				corev1.EnvVar{Name: "FILESERVER_WRITE_TIMEOUT", Value: "1h"},
+			),
+		},
+		{
+			name: "circuit breaker -- only the settings set are passed on",
+			opts: &localVolumeObjectStoreOpts{fileserverBreaker: map[string]string{
+				"fileserverBreakerFailures": "10",
+			}},
+			wantEnv: append(getLVPContainerEnv(),
+				corev1.EnvVar{Name: "FILESERVER_BREAKER_FAILURES", Value: "10"},
+			),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			deployment := &appsv1.Deployment{
+				Spec: appsv1.DeploymentSpec{
+					Template: corev1.PodTemplateSpec{
+						Spec: corev1.PodSpec{
+							Containers: []corev1.Container{{Name: "velero"}},
+						},
+					},
+				},
+			}
+			volumeMountSpec := buildVolumeMount("my-bucket", "/var/velero-local-volume-provider/my-bucket")
+
+			// run twice to make sure the env var is not duplicated on reconcile
+			for i := 0; i < 2; i++ {
+				require.NoError(t, ensureDeploymentHasConfigAndFileserver(deployment, volumeMountSpec, tt.opts))
+			}
+
+			container := getContainerByName(deployment, fileServerContainerName)
+			require.NotNil(t, container)
+			require.Equal(t, tt.wantEnv, container.Env)
+			require.Equal(t, tt.opts.fileserverPort == 0, getFileserverPort(tt.opts) == defaultFileserverPort)
+		})
+	}
+}
+
+func Test_ensureDeploymentHasConfigAndFileserver_unset(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    *localVolumeObjectStoreOpts
+		wantEnv []corev1.EnvVar
+	}{
+		{
+			name:    "port",
+			opts:    &localVolumeObjectStoreOpts{fileserverPort: 3333},
+			wantEnv: []corev1.EnvVar{{Name: "FILESERVER_PORT", Value: "3333"}},
+		},
+		{
+			name: "scheme and external host",
+			opts: &localVolumeObjectStoreOpts{fileserverScheme: "https", fileserverExternalHost: "backups.example.com"},
+			wantEnv: []corev1.EnvVar{
+				{Name: "FILESERVER_SCHEME", Value: "https"},
+				{Name: "FILESERVER_EXTERNAL_HOST", Value: "backups.example.com"},
+			},
+		},
+		{
+			name: "signing secret and algorithm",
+			opts: &localVolumeObjectStoreOpts{signingSecretName: "my-signing-key", signingAlgorithm: "sha512"},
+			wantEnv: []corev1.EnvVar{
+				{Name: "SIGNING_SECRET_NAME", Value: "my-signing-key"},
+				{Name: "SIGNING_ALGORITHM", Value: "sha512"},
+			},
+		},
+		{
+			name:    "encryption secret",
+			opts:    &localVolumeObjectStoreOpts{encryptionSecretName: "my-encryption-key"},
+			wantEnv: []corev1.EnvVar{{Name: "ENCRYPTION_SECRET_NAME", Value: "my-encryption-key"}},
+		},
+		{
+			name:    "root path -- the mount point goes back to the default root",
+			opts:    &localVolumeObjectStoreOpts{rootPath: "/mnt/backups"},
+			wantEnv: []corev1.EnvVar{{Name: "MOUNT_POINT", Value: "/mnt/backups"}},
+		},
+		{
+			name:    "clock skew tolerance",
+			opts:    &localVolumeObjectStoreOpts{clockSkewTolerance: "1m"},
+			wantEnv: []corev1.EnvVar{{Name: "CLOCK_SKEW_TOLERANCE", Value: "1m"}},
+		},
+		{
+			name:    "usage cache TTL",
+			opts:    &localVolumeObjectStoreOpts{usageCacheTTL: "5m"},
+			wantEnv: []corev1.EnvVar{{Name: "USAGE_CACHE_TTL", Value: "5m"}},
+		},
+		{
+			name:    "auth secret",
+			opts:    &localVolumeObjectStoreOpts{authSecretName: "my-auth-token"},
+			wantEnv: []corev1.EnvVar{{Name: "AUTH_SECRET_NAME", Value: "my-auth-token"}},
+		},
+		{
+			name: "timeouts",
+			opts: &localVolumeObjectStoreOpts{fileserverTimeouts: map[string]string{
+				"fileserverReadTimeout": "10m",
+				"fileserverIdleTimeout": "2m",
+			}},
+			wantEnv: []corev1.EnvVar{
+				{Name: "FILESERVER_READ_TIMEOUT", Value: "10m"},
+				{Name: "FILESERVER_IDLE_TIMEOUT", Value: "2m"},
+			},
+		},
+		{
+			name: "circuit breaker",
+			opts: &localVolumeObjectStoreOpts{fileserverBreaker: map[string]string{
+				"fileserverBreakerFailures": "10",
+				"fileserverBreakerCooldown": "1m",
+			}},
+			wantEnv: []corev1.EnvVar{
+				{Name: "FILESERVER_BREAKER_FAILURES", Value: "10"},
+				{Name: "FILESERVER_BREAKER_COOLDOWN", Value: "1m"},
+			},
+		},
+		{
+			name: "image pull policy",
+			opts: &localVolumeObjectStoreOpts{fileserverImagePullPolicy: corev1.PullAlways},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := require.New(t)
+			deployment := &appsv1.Deployment{
+				Spec: appsv1.DeploymentSpec{
+					Template: corev1.PodTemplateSpec{
+						Spec: corev1.PodSpec{
+							Containers: []corev1.Container{{Name: "velero"}},
+						},
+					},
+				},
+			}
+			volumeMountSpec := buildVolumeMount("my-bucket", "/var/velero-local-volume-provider/my-bucket")
+
+			req.NoError(ensureDeploymentHasConfigAndFileserver(deployment, volumeMountSpec, tt.opts))
+			container := getContainerByName(deployment, fileServerContainerName)
+			for _, env := range tt.wantEnv {
+				req.Contains(container.Env, env)
+			}
+
+			req.Equal(tt.opts.fileserverImagePullPolicy, container.ImagePullPolicy)
+
+			// Clearing the options from the config map removes their env vars and settings
+			req.NoError(ensureDeploymentHasConfigAndFileserver(deployment, volumeMountSpec, &localVolumeObjectStoreOpts{}))
+			container = getContainerByName(deployment, fileServerContainerName)
+			req.Equal(getLVPContainerEnv(), container.Env)
+			req.Empty(container.ImagePullPolicy)
+		})
+	}
+}
+
+func Test_ensureDeploymentHasConfigAndFileserver_resources(t *testing.T) {
+	req := require.New(t)
+	opts, err := parsePluginConfig(map[string]string{
+		"fileserverImagePullPolicy": "Always",
+		"fileserverCPURequest":      "100m",
+		"fileserverCPULimit":        "1",
+		"fileserverMemoryRequest":   "64Mi",
+		"fileserverMemoryLimit":     "256Mi",
+	}, logrus.New())
+	req.NoError(err)
+
+	deployment := &appsv1.Deployment{
+		Spec: appsv1.DeploymentSpec{
+			Template: corev1.PodTemplateSpec{
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{{Name: "velero"}},
+				},
+			},
+		},
+	}
+	volumeMountSpec := buildVolumeMount("my-bucket", "/var/velero-local-volume-provider/my-bucket")
+	req.NoError(ensureDeploymentHasConfigAndFileserver(deployment, volumeMountSpec, opts))
+
+	container := getContainerByName(deployment, fileServerContainerName)
+	req.NotNil(container)
+	req.Equal(corev1.PullAlways, container.ImagePullPolicy)
+	req.Equal(corev1.ResourceRequirements{
+		Requests: corev1.ResourceList{
+			corev1.ResourceCPU:    resource.MustParse("100m"),
+			corev1.ResourceMemory: resource.MustParse("64Mi"),
+		},
+		Limits: corev1.ResourceList{
+			corev1.ResourceCPU:    resource.MustParse("1"),
+			corev1.ResourceMemory: resource.MustParse("256Mi"),
+		},
+	}, container.Resources)
+
+	// Resources that are not configured are kept on an existing container
+	container.Resources.Limits[corev1.ResourceEphemeralStorage] = resource.MustParse("1Gi")
+	opts.fileserverResources.Limits[corev1.ResourceMemory] = resource.MustParse("512Mi")
+	req.NoError(ensureDeploymentHasConfigAndFileserver(deployment, volumeMountSpec, opts))
+	container = getContainerByName(deployment, fileServerContainerName)
+	req.Equal(resource.MustParse("512Mi"), container.Resources.Limits[corev1.ResourceMemory])
+	req.Equal(resource.MustParse("1Gi"), container.Resources.Limits[corev1.ResourceEphemeralStorage])
+	req.Equal(resource.MustParse("1"), container.Resources.Limits[corev1.ResourceCPU])
+}
+
+func Test_parseSecurityContextID(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    *int64
+		wantErr bool
+	}{
+		{
+			name:  "numeric",
+			value: "1001",
+			want:  pointer.Int64Ptr(1001),
+		},
+		{
+			name:  "root",
+			value: "0",
+			want:  pointer.Int64Ptr(0),
+		},
+		{
+			name:  "empty -- unset",
+			value: "",
+			want:  nil,
+		},
+		{
+			name:    "name instead of ID",
+			value:   "nobody",
+			wantErr: true,
+		},
+		{
+			name:    "negative",
+			value:   "-1",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseSecurityContextID("securityContextFsGroup", tt.value)
+			if tt.wantErr {
+				require.ErrorContains(t, err, "securityContextFsGroup")
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_getPodSecurityContext(t *testing.T) {
+	require.Nil(t, getPodSecurityContext(&localVolumeObjectStoreOpts{}))
+	require.Equal(t, &corev1.PodSecurityContext{FSGroup: pointer.Int64Ptr(2001)},
+		getPodSecurityContext(&localVolumeObjectStoreOpts{securityContextFSGroup: pointer.Int64Ptr(2001)}))
+}
+
+func Test_ensureResources_unchanged(t *testing.T) {
+	req := require.New(t)
+	clientset := fake.NewSimpleClientset(
+		&appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Name: VeleroDeploymentName, Namespace: "velero"},
+			Spec: appsv1.DeploymentSpec{
+				Template: corev1.PodTemplateSpec{
+					Spec: corev1.PodSpec{
+						Containers: []corev1.Container{{Name: "velero"}},
+					},
+				},
+			},
+		},
+		&appsv1.DaemonSet{
+			ObjectMeta: metav1.ObjectMeta{Name: NodeAgentDaemonsetName, Namespace: "velero"},
+			Spec: appsv1.DaemonSetSpec{
+				Template: corev1.PodTemplateSpec{
+					Spec: corev1.PodSpec{
+						Containers: []corev1.Container{{Name: "node-agent"}},
+					},
+				},
+			},
+		},
+	)
+	opts := EnsureResourcesOpts{
+		clientset:  clientset,
+		namespace:  "velero",
+		bucket:     "my-bucket",
+		path:       "/var/velero-local-volume-provider/my-bucket",
+		config:     map[string]string{"bucket": "my-bucket", "path": "/backups"},
+		pluginOpts: &localVolumeObjectStoreOpts{},
+		volumeType: Hostpath,
+		log:        logrus.NewEntry(logrus.New()),
+	}
+
+	countUpdates := func() int {
+		updates := 0
+		for _, action := range clientset.Actions() {
+			if action.GetVerb() == "update" {
+				updates++
+			}
+		}
+		clientset.ClearActions()
+		return updates
+	}
+
+	// the first reconcile mounts the volume
+	updated, err := ensureResources(opts)
+	req.NoError(err)
+	req.True(updated)
+	req.Equal(2, countUpdates())
+
+	// the volume is already mounted, so nothing is updated and the pods are not restarted
+	updated, err = ensureResources(opts)
+	req.NoError(err)
+	req.False(updated)
+	req.Equal(0, countUpdates())
+}
+
+func Test_ensureResources_conflict(t *testing.T) {
+	req := require.New(t)
+	clientset := fake.NewSimpleClientset(
+		&appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Name: VeleroDeploymentName, Namespace: "velero"},
+			Spec: appsv1.DeploymentSpec{
+				Template: corev1.PodTemplateSpec{
+					Spec: corev1.PodSpec{
+						Containers: []corev1.Container{{Name: "velero"}},
+					},
+				},
+			},
+		},
+		&appsv1.DaemonSet{
+			ObjectMeta: metav1.ObjectMeta{Name: NodeAgentDaemonsetName, Namespace: "velero"},
+			Spec: appsv1.DaemonSetSpec{
+				Template: corev1.PodTemplateSpec{
+					Spec: corev1.PodSpec{
+						Containers: []corev1.Container{{Name: "node-agent"}},
+					},
+				},
+			},
+		},
+	)
+
+	// The first update of each resource conflicts with the plugin for another location mounting its volume
+	otherVolume := corev1.Volume{Name: "other-bucket", VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}}
+	conflicted := map[string]bool{}
+	clientset.PrependReactor("update", "*", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		resource := action.GetResource()
+		if conflicted[resource.Resource] {
+			return false, nil, nil
+		}
+		conflicted[resource.Resource] = true
+
+		obj, err := clientset.Tracker().Get(resource, "velero", action.(k8stesting.UpdateAction).GetObject().(metav1.Object).GetName())
+		req.NoError(err)
+		switch current := obj.(type) {
+		case *appsv1.Deployment:
+			current.Spec.Template.Spec.Volumes = append(current.Spec.Template.Spec.Volumes, otherVolume)
+		case *appsv1.DaemonSet:
+			current.Spec.Template.Spec.Volumes = append(current.Spec.Template.Spec.Volumes, otherVolume)
+		}
+		req.NoError(clientset.Tracker().Update(resource, obj, "velero"))
+		return true, nil, kuberneteserrors.NewConflict(resource.GroupResource(), "velero", errors.New("the object has been modified"))
+	})
+
+	updated, err := ensureResources(EnsureResourcesOpts{
+		clientset:  clientset,
+		namespace:  "velero",
+		bucket:     "my-bucket",
+		path:       "/var/velero-local-volume-provider/my-bucket",
+		config:     map[string]string{"bucket": "my-bucket", "path": "/backups"},
+		pluginOpts: &localVolumeObjectStoreOpts{},
+		volumeType: Hostpath,
+		log:        logrus.NewEntry(logrus.New()),
+	})
+	req.NoError(err)
+	req.True(updated)
+	req.True(conflicted["deployments"])
+	req.True(conflicted["daemonsets"])
+
+	volumeNames := func(volumes []corev1.Volume) []string {
+		var names []string
+		for _, volume := range volumes {
+			names = append(names, volume.Name)
+		}
+		return names
+	}
+	deployment, err := clientset.AppsV1().Deployments("velero").Get(context.Background(), VeleroDeploymentName, metav1.GetOptions{})
+	req.NoError(err)
+	req.ElementsMatch([]string{"other-bucket", "my-bucket"}, volumeNames(deployment.Spec.Template.Spec.Volumes))
+	ds, err := clientset.AppsV1().DaemonSets("velero").Get(context.Background(), NodeAgentDaemonsetName, metav1.GetOptions{})
+	req.NoError(err)
+	req.ElementsMatch([]string{"other-bucket", "my-bucket"}, volumeNames(ds.Spec.Template.Spec.Volumes))
+}
+
+func Test_ensureResources_dryRun(t *testing.T) {
+	req := require.New(t)
+	clientset := fake.NewSimpleClientset(
+		&appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Name: VeleroDeploymentName, Namespace: "velero"},
+			Spec: appsv1.DeploymentSpec{
+				Template: corev1.PodTemplateSpec{
+					Spec: corev1.PodSpec{
+						Containers: []corev1.Container{{Name: "velero"}},
+					},
+				},
+			},
+		},
+		&appsv1.DaemonSet{
+			ObjectMeta: metav1.ObjectMeta{Name: NodeAgentDaemonsetName, Namespace: "velero"},
+			Spec: appsv1.DaemonSetSpec{
+				Template: corev1.PodTemplateSpec{
+					Spec: corev1.PodSpec{
+						Containers: []corev1.Container{{Name: "node-agent"}},
+					},
+				},
+			},
+		},
+	)
+	var out strings.Builder
+	log := logrus.New()
+	log.Out = &out
+	opts := EnsureResourcesOpts{
+		clientset:  clientset,
+		namespace:  "velero",
+		bucket:     "my-bucket",
+		path:       "/var/velero-local-volume-provider/my-bucket",
+		config:     map[string]string{"bucket": "my-bucket", "path": "/backups"},
+		pluginOpts: &localVolumeObjectStoreOpts{},
+		volumeType: Hostpath,
+		dryRun:     true,
+		log:        logrus.NewEntry(log),
+	}
+
+	updated, err := ensureResources(opts)
+	req.NoError(err)
+	req.True(updated)
+	for _, action := range clientset.Actions() {
+		req.Equal("get", action.GetVerb(), action)
+	}
+
+	// the diff of both resources is logged
+	req.Contains(out.String(), "not updating node-agent daemonset")
+	req.Contains(out.String(), "not updating velero deployment")
+	req.Contains(out.String(), "/var/velero-local-volume-provider/my-bucket")
+
+	deployment, err := clientset.AppsV1().Deployments("velero").Get(context.Background(), VeleroDeploymentName, metav1.GetOptions{})
+	req.NoError(err)
+	req.Empty(deployment.Spec.Template.Spec.Volumes)
+	ds, err := clientset.AppsV1().DaemonSets("velero").Get(context.Background(), NodeAgentDaemonsetName, metav1.GetOptions{})
+	req.NoError(err)
+	req.Empty(ds.Spec.Template.Spec.Volumes)
+}
+
+func Test_ensureResources_volumeRevision(t *testing.T) {
+	req := require.New(t)
+	clientset := fake.NewSimpleClientset(
+		&appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Name: VeleroDeploymentName, Namespace: "velero"},
+			Spec: appsv1.DeploymentSpec{
+				Template: corev1.PodTemplateSpec{
+					Spec: corev1.PodSpec{
+						Containers: []corev1.Container{{Name: "velero"}},
+					},
+				},
+			},
+		},
+	)
+	ensureBucket := func(bucket string) string {
+		opts := EnsureResourcesOpts{
+			clientset:  clientset,
+			namespace:  "velero",
+			bucket:     bucket,
+			path:       "/var/velero-local-volume-provider/" + bucket,
+			config:     map[string]string{"bucket": bucket, "path": "/backups/" + bucket},
+			pluginOpts: &localVolumeObjectStoreOpts{},
+			volumeType: Hostpath,
+			log:        logrus.NewEntry(logrus.New()),
+		}
+		_, err := ensureResources(opts)
+		req.NoError(err)
+		deployment, err := clientset.AppsV1().Deployments("velero").Get(context.TODO(), VeleroDeploymentName, metav1.GetOptions{})
+		req.NoError(err)
+		return deployment.Spec.Template.Annotations[volumeRevisionAnnotation]
+	}
+
+	first := ensureBucket("first-bucket")
+	req.NotEmpty(first)
+	req.Equal(first, ensureBucket("first-bucket"), "the revision should not change while the volumes are unchanged")
+
+	second := ensureBucket("second-bucket")
+	req.NotEqual(first, second, "the revision should change when a volume is added")
+	req.Equal(second, ensureBucket("second-bucket"))
+	req.Equal(second, ensureBucket("first-bucket"))
+
+	// A deployment whose volumes are unchanged is not updated just to add the revision, as after an upgrade
+	deployment, err := clientset.AppsV1().Deployments("velero").Get(context.TODO(), VeleroDeploymentName, metav1.GetOptions{})
+	req.NoError(err)
+	delete(deployment.Spec.Template.Annotations, volumeRevisionAnnotation)
+	_, err = clientset.AppsV1().Deployments("velero").Update(context.TODO(), deployment, metav1.UpdateOptions{})
+	req.NoError(err)
+	req.Empty(ensureBucket("first-bucket"))
+	req.NotEmpty(ensureBucket("third-bucket"), "the revision should be written along with a new volume")
+}
+
+func Test_setVolumeRevision(t *testing.T) {
+	req := require.New(t)
+	volumes := []corev1.Volume{
+		{Name: "a", VolumeSource: corev1.VolumeSource{HostPath: &corev1.HostPathVolumeSource{Path: "/a"}}},
+		{Name: "b", VolumeSource: corev1.VolumeSource{HostPath: &corev1.HostPathVolumeSource{Path: "/b"}}},
+	}
+
+	template := &corev1.PodTemplateSpec{Spec: corev1.PodSpec{Volumes: volumes}}
+	req.NoError(setVolumeRevision(template))
+	revision := template.Annotations[volumeRevisionAnnotation]
+
+	reordered := &corev1.PodTemplateSpec{Spec: corev1.PodSpec{Volumes: []corev1.Volume{volumes[1], volumes[0]}}}
+	req.NoError(setVolumeRevision(reordered))
+	req.Equal(revision, reordered.Annotations[volumeRevisionAnnotation], "the revision should not depend on the order of the volumes")
+
+	changed := &corev1.PodTemplateSpec{Spec: corev1.PodSpec{Volumes: []corev1.Volume{volumes[0]}}}
+	req.NoError(setVolumeRevision(changed))
+	req.NotEqual(revision, changed.Annotations[volumeRevisionAnnotation])
+}
+
+func Test_resolveVeleroNamespace(t *testing.T) {
+	tests := []struct {
+		name                    string
+		override                string
+		env                     string
+		serviceAccountNamespace string
+		want                    string
+	}{
+		{
+			name:                    "veleroNamespace config takes precedence",
+			override:                "tenant-velero",
+			env:                     "velero",
+			serviceAccountNamespace: "pod-namespace",
+			want:                    "tenant-velero",
+		},
+		{
+			name:                    "VELERO_NAMESPACE",
+			env:                     "velero",
+			serviceAccountNamespace: "pod-namespace",
+			want:                    "velero",
+		},
+		{
+			name:                    "namespace of the pod",
+			serviceAccountNamespace: "pod-namespace\n",
+			want:                    "pod-namespace",
+		},
+		{
+			name: "unknown",
+			want: "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := require.New(t)
+			t.Setenv("VELERO_NAMESPACE", tt.env)
+
+			defer func(path string) { serviceAccountNamespacePath = path }(serviceAccountNamespacePath)
+			serviceAccountNamespacePath = filepath.Join(t.TempDir(), "namespace")
+			if tt.serviceAccountNamespace != "" {
+				req.NoError(os.WriteFile(serviceAccountNamespacePath, []byte(tt.serviceAccountNamespace), 0644))
+			}
+
+			req.Equal(tt.want, resolveVeleroNamespace(tt.override))
+
+			o := NewLocalVolumeObjectStore(logrus.New(), Hostpath)
+			o.veleroNamespace = tt.override
+			req.Equal(tt.want, o.getVeleroNamespace())
+		})
+	}
+}
+
+func Test_getDaemonset(t *testing.T) {
+	daemonset := func(name string, labels, podLabels map[string]string) *appsv1.DaemonSet {
+		return &appsv1.DaemonSet{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "velero", Labels: labels},
+			Spec: appsv1.DaemonSetSpec{
+				Template: corev1.PodTemplateSpec{ObjectMeta: metav1.ObjectMeta{Labels: podLabels}},
+			},
+		}
+	}
+	tests := []struct {
+		name       string
+		daemonsets []runtime.Object
+		opts       *localVolumeObjectStoreOpts
+		want       string
+		wantErr    string
+	}{
+		{
+			name:       "node-agent name",
+			daemonsets: []runtime.Object{daemonset("node-agent", nil, nil), daemonset("restic", nil, nil)},
+			want:       "node-agent",
+		},
+		{
+			name:       "restic name",
+			daemonsets: []runtime.Object{daemonset("restic", nil, nil)},
+			want:       "restic",
+		},
+		{
+			name:       "configured name takes precedence",
+			daemonsets: []runtime.Object{daemonset("node-agent", nil, nil), daemonset("kopia-agent", nil, nil)},
+			opts:       &localVolumeObjectStoreOpts{nodeAgentDaemonsetName: "kopia-agent"},
+			want:       "kopia-agent",
+		},
+		{
+			name: "label fallback -- Velero CLI install",
+			daemonsets: []runtime.Object{
+				daemonset("other", map[string]string{"component": "velero"}, map[string]string{"name": "other"}),
+				daemonset("velero-node-agent", map[string]string{"component": "velero"}, map[string]string{"name": "node-agent"}),
+			},
+			want: "velero-node-agent",
+		},
+		{
+			name: "label fallback -- Helm chart",
+			daemonsets: []runtime.Object{
+				daemonset("backup-node-agent", map[string]string{"app.kubernetes.io/name": "velero"}, map[string]string{"name": "node-agent"}),
+			},
+			want: "backup-node-agent",
+		},
+		{
+			name: "label fallback -- ambiguous",
+			daemonsets: []runtime.Object{
+				daemonset("a-node-agent", map[string]string{"component": "velero"}, map[string]string{"name": "node-agent"}),
+				daemonset("b-node-agent", map[string]string{"app.kubernetes.io/name": "velero"}, map[string]string{"name": "node-agent"}),
+			},
+			wantErr: "a-node-agent, b-node-agent",
+		},
+		{
+			name: "absent",
+			daemonsets: []runtime.Object{
+				daemonset("unrelated", nil, map[string]string{"name": "node-agent"}),
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := require.New(t)
+			clientset := fake.NewSimpleClientset(tt.daemonsets...)
+
+			ds, err := getDaemonset(clientset, "velero", tt.opts)
+			if tt.wantErr != "" {
+				req.ErrorContains(err, tt.wantErr)
+				return
+			}
+			req.NoError(err)
+			if tt.want == "" {
+				req.Nil(ds)
+				return
+			}
+			req.NotNil(ds)
+			req.Equal(tt.want, ds.Name)
+		})
+	}
+}
+
+func Test_getLocationConfig(t *testing.T) {
+	req := require.New(t)
+	location := func(name, provider, bucket string, config map[string]string) *velerov1.BackupStorageLocation {
+		return &velerov1.BackupStorageLocation{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "velero"},
+			Spec: velerov1.BackupStorageLocationSpec{
+				Provider: provider,
+				StorageType: velerov1.StorageType{
+					ObjectStorage: &velerov1.ObjectStorageLocation{Bucket: bucket},
+				},
+				Config: config,
+			},
+		}
+	}
+	veleroClient := velerofake.NewSimpleClientset(
+		location("aws", "aws", "my-bucket", map[string]string{"region": "us-east-1"}),
+		location("nfs", "replicated.com/nfs", "my-bucket", map[string]string{"rootSubPath": "team-a", "path": "/exports"}),
+	)
+	clientset := fake.NewSimpleClientset(&corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "local-volume-provider-config",
+			Namespace: "velero",
+			Labels:    map[string]string{"replicated.com/nfs": "ObjectStore"},
+		},
+		Data: map[string]string{
+			"buckets.my-bucket.auditLogPath": "audit.log",
+			"buckets.my-bucket.rootSubPath":  "ignored",
+		},
+	})
+
+	// The location config takes precedence over the bucket config, as in Init
+	config, err := getLocationConfig(clientset, veleroClient, "velero", "my-bucket")
+	req.NoError(err)
+	req.Equal(map[string]string{"rootSubPath": "team-a", "path": "/exports", "auditLogPath": "audit.log"}, config)
+
+	config, err = getLocationConfig(clientset, veleroClient, "velero", "other-bucket")
+	req.NoError(err)
+	req.Nil(config)
+}
@@ -0,0 +1,202 @@
+package plugin
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// keyLayoutFlat stores each object at the path of its key.
+	keyLayoutFlat = "flat"
+	// keyLayoutHashed stores each object two fanout directories below the directory of its key, named by the first
+	// bytes of the SHA-256 of the key, so that no directory holds more than a small share of the objects put in it.
+	keyLayoutHashed = "hashed"
+)
+
+// fanoutDirPrefix starts the names of the fanout directories of the hashed key layout, which are followed by
+// two lowercase hex digits. With the hashed layout, key segments of that form are reserved.
+const fanoutDirPrefix = ".lvp-"
+
+// validateKeyLayout returns an error unless the keyLayout setting is empty, flat or hashed.
+func validateKeyLayout(layout string) error {
+	switch layout {
+	case "", keyLayoutFlat, keyLayoutHashed:
+		return nil
+	default:
+		return errors.Errorf("unsupported keyLayout %q: must be flat or hashed", layout)
+	}
+}
+
+// isFanoutDirName returns truthy if the name is that of a fanout directory of the hashed key layout.
+func isFanoutDirName(name string) bool {
+	if len(name) != len(fanoutDirPrefix)+2 || !strings.HasPrefix(name, fanoutDirPrefix) {
+		return false
+	}
+	for _, c := range name[len(fanoutDirPrefix):] {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// fanoutKey returns the key relative to the bucket, separated by forward slashes, with the fanout directories of
+// the hashed layout inserted before its last segment, so that backups/b/b.tar.gz is stored as backups/b/.lvp-xx/.lvp-yy/b.tar.gz.
+func fanoutKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	digest := hex.EncodeToString(sum[:2])
+	dir, name := path.Split(key)
+	return dir + fanoutDirPrefix + digest[:2] + "/" + fanoutDirPrefix + digest[2:] + "/" + name
+}
+
+// isHashedLayout returns truthy if objects are stored with the hashed key layout.
+func (o *LocalVolumeObjectStore) isHashedLayout() bool {
+	return o.keyLayout == keyLayoutHashed
+}
+
+// storedPath returns the path the object at the logical path of its key within the bucket is stored at:
+// the same path with the flat layout, or with fanout directories inserted with the hashed layout.
+func (o *LocalVolumeObjectStore) storedPath(bucketPath, keyPath string) (string, error) {
+	if !o.isHashedLayout() {
+		return keyPath, nil
+	}
+	key, err := relativeKey(bucketPath, keyPath)
+	if err != nil {
+		return "", err
+	}
+	if key == "." {
+		return keyPath, nil
+	}
+	for _, segment := range strings.Split(key, "/") {
+		if isFanoutDirName(segment) {
+			return "", errors.Errorf("invalid key %q: segments such as %q are reserved by the hashed keyLayout", key, segment)
+		}
+	}
+	return filepath.Join(bucketPath, filepath.FromSlash(fanoutKey(key))), nil
+}
+
+// logicalKey returns the key of an object or directory from its path relative to the bucket, dropping the fanout
+// directories of the hashed layout.
+func (o *LocalVolumeObjectStore) logicalKey(key string) string {
+	if !o.isHashedLayout() {
+		return key
+	}
+	segments := strings.Split(key, "/")
+	kept := segments[:0]
+	for _, segment := range segments {
+		if !isFanoutDirName(segment) {
+			kept = append(kept, segment)
+		}
+	}
+	if len(kept) == 0 {
+		return "."
+	}
+	return strings.Join(kept, "/")
+}
+
+// isFanoutDir returns truthy if the directory entry is a fanout directory of the hashed layout,
+// whose objects belong to the directory holding it.
+func (o *LocalVolumeObjectStore) isFanoutDir(d fs.DirEntry) bool {
+	return o.isHashedLayout() && d.IsDir() && isFanoutDirName(d.Name())
+}
+
+// fanoutObjects returns the keys of the objects stored in the fanout directory dir that start with prefix.
+func (o *LocalVolumeObjectStore) fanoutObjects(bucketPath, dir, prefix string, log logrus.FieldLogger) ([]string, error) {
+	var keys []string
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		// Directories removed during the walk, as by concurrent deletions, hold no objects
+		if isMissingDir(err) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if p != dir && !isFanoutDirName(d.Name()) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		info, err := o.objectFileInfo(bucketPath, p, d, log)
+		if err != nil || info == nil {
+			return err
+		}
+		key, err := objectKey(bucketPath, p)
+		if err != nil {
+			return err
+		}
+		if key = o.logicalKey(key); strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+		return nil
+	})
+	if isMissingDir(err) {
+		return nil, nil
+	}
+	return keys, err
+}
+
+// readFanoutDir returns the entries of the directory sorted as the keys they hold, as readDirSorted, except that with
+// the hashed layout the files of its fanout directories are listed in the place of the fanout directories, each named
+// by the last segment of its key. The returned paths are those of the entries.
+func (o *LocalVolumeObjectStore) readFanoutDir(dir string) ([]fs.DirEntry, []string, error) {
+	entries, err := readDirSorted(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !o.isHashedLayout() {
+		paths := make([]string, len(entries))
+		for i, entry := range entries {
+			paths[i] = filepath.Join(dir, entry.Name())
+		}
+		return entries, paths, nil
+	}
+
+	type item struct {
+		entry fs.DirEntry
+		path  string
+	}
+	var items []item
+	var expand func(dir string, entries []fs.DirEntry, depth int) error
+	expand = func(dir string, entries []fs.DirEntry, depth int) error {
+		for _, entry := range entries {
+			p := filepath.Join(dir, entry.Name())
+			if !o.isFanoutDir(entry) {
+				// Only files are stored within fanout directories
+				if depth == 0 || !entry.IsDir() {
+					items = append(items, item{entry, p})
+				}
+				continue
+			}
+			fanout, err := os.ReadDir(p)
+			if isMissingDir(err) {
+				continue
+			} else if err != nil {
+				return err
+			}
+			if err := expand(p, fanout, depth+1); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := expand(dir, entries, 0); err != nil {
+		return nil, nil, err
+	}
+
+	sort.SliceStable(items, func(i, j int) bool { return entryKeyLess(items[i].entry, items[j].entry) })
+	entries = make([]fs.DirEntry, len(items))
+	paths := make([]string, len(items))
+	for i, it := range items {
+		entries[i], paths[i] = it.entry, it.path
+	}
+	return entries, paths, nil
+}
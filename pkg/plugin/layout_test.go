@@ -0,0 +1,164 @@
+package plugin
+
+import (
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_fanoutKey(t *testing.T) {
+	req := require.New(t)
+
+	stored := fanoutKey("backups/my-backup/my-backup.tar.gz")
+	segments := strings.Split(stored, "/")
+	req.Len(segments, 5)
+	req.Equal([]string{"backups", "my-backup"}, segments[:2])
+	req.True(isFanoutDirName(segments[2]), stored)
+	req.True(isFanoutDirName(segments[3]), stored)
+	req.Equal("my-backup.tar.gz", segments[4])
+	req.Equal(stored, fanoutKey("backups/my-backup/my-backup.tar.gz"), "the layout should be deterministic")
+
+	stored = fanoutKey("revision")
+	req.True(strings.HasSuffix(stored, "/revision"), stored)
+	req.Len(strings.Split(stored, "/"), 3)
+
+	req.False(isFanoutDirName(".lvp-AB"))
+	req.False(isFanoutDirName(".lvp-abc"))
+	req.False(isFanoutDirName("ab"))
+}
+
+func Test_keyLayout_hashed(t *testing.T) {
+	req := require.New(t)
+	o, root := newTestObjectStore(t)
+	req.NoError(o.applyConfig(map[string]string{"keyLayout": "hashed"}))
+
+	keys := []string{
+		"backups/my-backup/my-backup.tar.gz",
+		"backups/my-backup/my-backup-logs.gz",
+		"backups/my-backup/velero-backup.json",
+		"backups/my-backup-2/velero-backup.json",
+		"restores/my-restore/restore-my-restore-logs.gz",
+		"revision",
+	}
+	for _, key := range keys {
+		req.NoError(o.PutObject("my-bucket", key, strings.NewReader(key)))
+	}
+	o.compression = compressionGzip
+	req.NoError(o.PutObject("my-bucket", "backups/my-backup/compressed", strings.NewReader("compressed")))
+	o.compression = ""
+	keys = append(keys, "backups/my-backup/compressed")
+
+	// Objects are stored in the fanout directories of their key, with their sidecars
+	for _, key := range keys {
+		stored := filepath.Join(root, "my-bucket", filepath.FromSlash(fanoutKey(key)))
+		_, _, err := findObjectFile(stored)
+		req.NoError(err, key)
+		_, err = os.Stat(checksumPath(stored))
+		req.NoError(err, key)
+		_, err = os.Stat(filepath.Join(root, "my-bucket", key))
+		req.True(os.IsNotExist(err), "%s should not be stored at its key", key)
+	}
+
+	// Round trip
+	for _, key := range keys {
+		exists, err := o.ObjectExists("my-bucket", key)
+		req.NoError(err)
+		req.True(exists, key)
+
+		rc, err := o.GetObject("my-bucket", key)
+		req.NoError(err)
+		got, err := io.ReadAll(rc)
+		req.NoError(err)
+		req.NoError(rc.Close())
+		want := key
+		if key == "backups/my-backup/compressed" {
+			want = "compressed"
+		}
+		req.Equal(want, string(got))
+	}
+
+	// Listings return logical keys
+	listed, err := o.ListObjects("my-bucket", "")
+	req.NoError(err)
+	req.ElementsMatch(keys, listed)
+
+	listed, err = o.ListObjects("my-bucket", "backups/my-backup")
+	req.NoError(err)
+	req.ElementsMatch([]string{
+		"backups/my-backup/my-backup.tar.gz",
+		"backups/my-backup/my-backup-logs.gz",
+		"backups/my-backup/velero-backup.json",
+		"backups/my-backup/compressed",
+	}, listed)
+
+	listed, err = o.ListObjects("my-bucket", "rev")
+	req.NoError(err)
+	req.Empty(listed)
+	infos, err := o.listObjectsWithInfo("my-bucket", "rev")
+	req.NoError(err)
+	req.Len(infos, 1)
+	req.Equal("revision", infos[0].Key)
+
+	prefixes, err := o.ListCommonPrefixes("my-bucket", "backups/", "/")
+	req.NoError(err)
+	req.ElementsMatch([]string{"backups/my-backup/", "backups/my-backup-2/"}, prefixes)
+	prefixes, err = o.ListCommonPrefixes("my-bucket", "", "/")
+	req.NoError(err)
+	req.ElementsMatch([]string{"backups/", "restores/"}, prefixes)
+
+	objects, _, err := o.listWithDelimiter("my-bucket", "", "/")
+	req.NoError(err)
+	req.Equal([]string{"revision"}, objects)
+	objects, _, err = o.listWithDelimiter("my-bucket", "backups/my-backup/", "/")
+	req.NoError(err)
+	req.Len(objects, 4)
+
+	// Pages are in the order of the logical keys
+	var paged []string
+	marker := ""
+	for {
+		page, nextMarker, err := o.ListObjectsPaged("my-bucket", "", marker, 2)
+		req.NoError(err)
+		paged = append(paged, page...)
+		if nextMarker == "" {
+			break
+		}
+		marker = nextMarker
+	}
+	req.ElementsMatch(keys, paged)
+	req.True(sort.SliceIsSorted(paged, func(i, j int) bool { return compareKeys(paged[i], paged[j]) < 0 }), paged)
+
+	// Signed URLs point the fileserver at the stored file
+	o.opts = &localVolumeObjectStoreOpts{fileserverExternalHost: "backups.example.com", signingKey: []byte("signing-key")}
+	signedURL, err := o.CreateSignedURL("my-bucket", "backups/my-backup/my-backup.tar.gz", time.Hour)
+	req.NoError(err)
+	u, err := url.Parse(signedURL)
+	req.NoError(err)
+	req.Equal("/my-bucket/"+fanoutKey("backups/my-backup/my-backup.tar.gz"), u.Path)
+
+	// Deleting removes the emptied fanout directories
+	req.NoError(o.DeleteObject("my-bucket", "revision"))
+	exists, err := o.ObjectExists("my-bucket", "revision")
+	req.NoError(err)
+	req.False(exists)
+	entries, err := os.ReadDir(filepath.Join(root, "my-bucket"))
+	req.NoError(err)
+	for _, entry := range entries {
+		req.False(isFanoutDirName(entry.Name()), entry.Name())
+	}
+
+	// Segments that name fanout directories are reserved
+	req.ErrorContains(o.PutObject("my-bucket", "backups/.lvp-ab/key", strings.NewReader("x")), "reserved")
+}
+
+func Test_keyLayout_invalid(t *testing.T) {
+	o, _ := newTestObjectStore(t)
+	require.ErrorContains(t, o.applyConfig(map[string]string{"keyLayout": "sharded"}), "unsupported keyLayout")
+}
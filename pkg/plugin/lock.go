@@ -0,0 +1,165 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	kuberneteserrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/utils/pointer"
+)
+
+// initLockName is the name of the Lease in the Velero namespace held by Init while it changes the volumes and deployments.
+const initLockName = "local-volume-provider-init"
+
+var (
+	// initLockDuration is how long a held lock stays valid without being renewed, so a lock left by a crashed
+	// holder is taken over once it expires. The holder renews it every third of the duration.
+	initLockDuration = 30 * time.Second
+	// initLockTimeout is how long Init waits for the lock held by another Init.
+	initLockTimeout = 2 * time.Minute
+	// initLockPollInterval is how often a waiting Init checks whether the lock was released.
+	initLockPollInterval = time.Second
+)
+
+// initLockHolder returns a holder identity unique to this call, so that concurrent Inits of one process also exclude each other.
+func initLockHolder() string {
+	hostname, _ := os.Hostname()
+	return fmt.Sprintf("%s-%d", hostname, rand.Int63())
+}
+
+// acquireInitLock waits until it holds the Init lock, or initLockTimeout has passed. Velero may run several replicas,
+// or initialize several locations at once, and Inits creating directories and patching the deployment at the same time
+// would conflict. The returned func renews the lock until it is called to release it.
+func acquireInitLock(clientset kubernetes.Interface, namespace string, log logrus.FieldLogger) (release func(), err error) {
+	leases := clientset.CoordinationV1().Leases(namespace)
+	holder := initLockHolder()
+
+	ctx, cancel := context.WithTimeout(context.Background(), initLockTimeout)
+	defer cancel()
+
+	waiting := false
+	for {
+		acquired, err := tryAcquireInitLock(ctx, clientset, namespace, holder)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to acquire Init lock")
+		}
+		if acquired {
+			break
+		}
+		if !waiting {
+			log.Info("Waiting for another Init to release its lock")
+			waiting = true
+		}
+		select {
+		case <-ctx.Done():
+			return nil, errors.Errorf("timed out after %s waiting for Init lock", initLockTimeout)
+		case <-time.After(initLockPollInterval):
+		}
+	}
+	log.Debug("Acquired Init lock")
+
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(initLockDuration / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				if err := renewInitLock(clientset, namespace, holder); err != nil {
+					log.WithError(err).Warn("Failed to renew Init lock")
+				}
+			}
+		}
+	}()
+
+	return func() {
+		close(stop)
+		<-stopped
+		lease, err := leases.Get(context.TODO(), initLockName, metav1.GetOptions{})
+		if err != nil || pointer.StringDeref(lease.Spec.HolderIdentity, "") != holder {
+			log.WithError(err).Warn("Init lock was lost before it was released")
+			return
+		}
+		lease.Spec.HolderIdentity = nil
+		if _, err := leases.Update(context.TODO(), lease, metav1.UpdateOptions{}); err != nil {
+			log.WithError(err).Warn("Failed to release Init lock, it is released once it expires")
+			return
+		}
+		log.Debug("Released Init lock")
+	}, nil
+}
+
+// tryAcquireInitLock takes the Init lock for holder if it is free or expired, returning truthy if it did.
+// Losing a race to create or update the Lease is reported as not acquired, to be tried again.
+func tryAcquireInitLock(ctx context.Context, clientset kubernetes.Interface, namespace, holder string) (bool, error) {
+	leases := clientset.CoordinationV1().Leases(namespace)
+	now := metav1.NewMicroTime(time.Now())
+	spec := coordinationv1.LeaseSpec{
+		HolderIdentity:       pointer.String(holder),
+		LeaseDurationSeconds: pointer.Int32(int32(initLockDuration / time.Second)),
+		AcquireTime:          &now,
+		RenewTime:            &now,
+	}
+
+	lease, err := leases.Get(ctx, initLockName, metav1.GetOptions{})
+	if kuberneteserrors.IsNotFound(err) {
+		_, err = leases.Create(ctx, &coordinationv1.Lease{
+			ObjectMeta: metav1.ObjectMeta{Name: initLockName, Namespace: namespace},
+			Spec:       spec,
+		}, metav1.CreateOptions{})
+		if kuberneteserrors.IsAlreadyExists(err) {
+			return false, nil
+		}
+		return err == nil, err
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if isInitLockHeld(lease, now.Time) {
+		return false, nil
+	}
+	lease.Spec = spec
+	_, err = leases.Update(ctx, lease, metav1.UpdateOptions{})
+	if kuberneteserrors.IsConflict(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// isInitLockHeld returns truthy if the Lease has a holder that renewed it within its duration.
+func isInitLockHeld(lease *coordinationv1.Lease, now time.Time) bool {
+	if pointer.StringDeref(lease.Spec.HolderIdentity, "") == "" || lease.Spec.RenewTime == nil {
+		return false
+	}
+	duration := time.Duration(pointer.Int32Deref(lease.Spec.LeaseDurationSeconds, 0)) * time.Second
+	return now.Before(lease.Spec.RenewTime.Add(duration))
+}
+
+// renewInitLock extends the Init lock held by holder.
+func renewInitLock(clientset kubernetes.Interface, namespace, holder string) error {
+	leases := clientset.CoordinationV1().Leases(namespace)
+	lease, err := leases.Get(context.TODO(), initLockName, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	if pointer.StringDeref(lease.Spec.HolderIdentity, "") != holder {
+		return errors.New("lock is held by another Init")
+	}
+	now := metav1.NewMicroTime(time.Now())
+	lease.Spec.RenewTime = &now
+	_, err = leases.Update(context.TODO(), lease, metav1.UpdateOptions{})
+	return err
+}
@@ -0,0 +1,96 @@
+package plugin
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/utils/pointer"
+)
+
+func Test_acquireInitLock(t *testing.T) {
+	req := require.New(t)
+	defer func(interval time.Duration) { initLockPollInterval = interval }(initLockPollInterval)
+	initLockPollInterval = 10 * time.Millisecond
+
+	clientset := fake.NewSimpleClientset()
+	log := logrus.New()
+
+	release, err := acquireInitLock(clientset, "velero", log)
+	req.NoError(err)
+
+	// A second Init blocks until the first releases the lock
+	acquired := make(chan func())
+	go func() {
+		release, err := acquireInitLock(clientset, "velero", log)
+		if err != nil {
+			close(acquired)
+			return
+		}
+		acquired <- release
+	}()
+
+	select {
+	case <-acquired:
+		req.Fail("second Init acquired the lock while it was held")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	release()
+	select {
+	case release, ok := <-acquired:
+		req.True(ok, "second Init failed to acquire the lock")
+		release()
+	case <-time.After(time.Second):
+		req.Fail("second Init did not acquire the released lock")
+	}
+
+	lease, err := clientset.CoordinationV1().Leases("velero").Get(context.TODO(), initLockName, metav1.GetOptions{})
+	req.NoError(err)
+	req.Nil(lease.Spec.HolderIdentity)
+}
+
+func Test_acquireInitLock_expired(t *testing.T) {
+	req := require.New(t)
+
+	// The holder crashed without releasing the lock
+	renewed := metav1.NewMicroTime(time.Now().Add(-time.Minute))
+	clientset := fake.NewSimpleClientset(&coordinationv1.Lease{
+		ObjectMeta: metav1.ObjectMeta{Name: initLockName, Namespace: "velero"},
+		Spec: coordinationv1.LeaseSpec{
+			HolderIdentity:       pointer.String("crashed"),
+			LeaseDurationSeconds: pointer.Int32(30),
+			RenewTime:            &renewed,
+		},
+	})
+
+	release, err := acquireInitLock(clientset, "velero", logrus.New())
+	req.NoError(err)
+	release()
+}
+
+func Test_acquireInitLock_timeout(t *testing.T) {
+	req := require.New(t)
+	defer func(timeout, interval time.Duration) {
+		initLockTimeout, initLockPollInterval = timeout, interval
+	}(initLockTimeout, initLockPollInterval)
+	initLockTimeout, initLockPollInterval = 50*time.Millisecond, 10*time.Millisecond
+
+	renewed := metav1.NewMicroTime(time.Now())
+	clientset := fake.NewSimpleClientset(&coordinationv1.Lease{
+		ObjectMeta: metav1.ObjectMeta{Name: initLockName, Namespace: "velero"},
+		Spec: coordinationv1.LeaseSpec{
+			HolderIdentity:       pointer.String("other"),
+			LeaseDurationSeconds: pointer.Int32(30),
+			RenewTime:            &renewed,
+		},
+	})
+
+	_, err := acquireInitLock(clientset, "velero", logrus.New())
+	req.ErrorContains(err, "timed out")
+}
@@ -0,0 +1,72 @@
+package plugin
+
+import (
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// defaultLogLevel is the level the plugin logs at unless configured otherwise.
+const defaultLogLevel = logrus.InfoLevel
+
+const (
+	logFormatText = "text"
+	logFormatJSON = "json"
+)
+
+// parseLogLevel returns the logrus level named by value, or the default level if it is empty.
+func parseLogLevel(value string) (logrus.Level, error) {
+	if value == "" {
+		return defaultLogLevel, nil
+	}
+	level, err := logrus.ParseLevel(value)
+	if err != nil {
+		return 0, errors.Errorf("invalid logLevel %q", value)
+	}
+	return level, nil
+}
+
+// validateLogFormat returns an error if the log format is not supported.
+// An empty format keeps the formatter of the logger passed to the plugin.
+func validateLogFormat(format string) error {
+	switch format {
+	case "", logFormatText, logFormatJSON:
+		return nil
+	default:
+		return errors.Errorf("invalid logFormat %q: must be %q or %q", format, logFormatText, logFormatJSON)
+	}
+}
+
+// configureLogger returns a logger writing to the same output and hooks as base, with its fields,
+// logging at level in the given format. Loggers derived from it with WithField inherit the level.
+// base itself is left as it is, so it can be configured again by a later Init.
+// Loggers that are not backed by a logrus.Logger are returned unchanged.
+func configureLogger(base logrus.FieldLogger, level logrus.Level, format string) logrus.FieldLogger {
+	var src *logrus.Logger
+	var data logrus.Fields
+	switch l := base.(type) {
+	case *logrus.Logger:
+		src = l
+	case *logrus.Entry:
+		src = l.Logger
+		data = l.Data
+	default:
+		return base
+	}
+
+	logger := &logrus.Logger{
+		Out:          src.Out,
+		Hooks:        src.Hooks,
+		Formatter:    src.Formatter,
+		ReportCaller: src.ReportCaller,
+		Level:        level,
+		ExitFunc:     src.ExitFunc,
+	}
+	switch format {
+	case logFormatText:
+		logger.Formatter = &logrus.TextFormatter{}
+	case logFormatJSON:
+		logger.Formatter = &logrus.JSONFormatter{}
+	}
+
+	return logger.WithFields(data)
+}
@@ -0,0 +1,102 @@
+package plugin
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_applyConfig_logging(t *testing.T) {
+	tests := []struct {
+		name      string
+		config    map[string]string
+		wantDebug bool
+		wantInfo  bool
+		wantJSON  bool
+		wantErr   bool
+	}{
+		{
+			name:     "defaults to info",
+			config:   map[string]string{},
+			wantInfo: true,
+		},
+		{
+			name:      "debug level",
+			config:    map[string]string{"logLevel": "debug"},
+			wantDebug: true,
+			wantInfo:  true,
+		},
+		{
+			name:   "warning level",
+			config: map[string]string{"logLevel": "warning"},
+		},
+		{
+			name:     "json format",
+			config:   map[string]string{"logFormat": "json"},
+			wantInfo: true,
+			wantJSON: true,
+		},
+		{
+			name:    "invalid level",
+			config:  map[string]string{"logLevel": "loud"},
+			wantErr: true,
+		},
+		{
+			name:    "invalid format",
+			config:  map[string]string{"logFormat": "xml"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := require.New(t)
+			var out bytes.Buffer
+			base := logrus.New()
+			base.SetLevel(logrus.DebugLevel)
+			base.Out = &out
+
+			o := NewLocalVolumeObjectStore(base, Hostpath)
+			err := o.applyConfig(tt.config)
+			if tt.wantErr {
+				req.Error(err)
+				return
+			}
+			req.NoError(err)
+
+			// Per call loggers are derived from the store's logger and must inherit its level
+			log := o.log.WithField("bucket", "my-bucket")
+			log.Debug("debug message")
+			log.Info("info message")
+
+			req.Equal(tt.wantDebug, strings.Contains(out.String(), "debug message"))
+			req.Equal(tt.wantInfo, strings.Contains(out.String(), "info message"))
+			if tt.wantJSON {
+				var entry map[string]interface{}
+				req.NoError(json.Unmarshal(bytes.TrimSpace(out.Bytes()), &entry))
+				req.Equal("my-bucket", entry["bucket"])
+			}
+
+			// The logger passed to the plugin is not reconfigured
+			req.Equal(logrus.DebugLevel, base.GetLevel())
+		})
+	}
+}
+
+func Test_configureLogger_entry(t *testing.T) {
+	req := require.New(t)
+	var out bytes.Buffer
+	base := logrus.New()
+	base.Out = &out
+
+	log := configureLogger(base.WithField("plugin", "local-volume-provider"), logrus.DebugLevel, logFormatJSON)
+	log.Debug("debug message")
+
+	var entry map[string]interface{}
+	req.NoError(json.Unmarshal(bytes.TrimSpace(out.Bytes()), &entry))
+	req.Equal("local-volume-provider", entry["plugin"])
+	req.Equal("debug message", entry["msg"])
+}
@@ -0,0 +1,217 @@
+package plugin
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// metadataSuffix is appended to an object path to get the path of its metadata sidecar file,
+// used on filesystems without extended attributes.
+const metadataSuffix = ".meta.json"
+
+// metadataXattr is the extended attribute holding the JSON encoded metadata of an object.
+const metadataXattr = "user.lvp.metadata"
+
+// setxattr and getxattr are variables so tests can simulate filesystems without extended attributes.
+var (
+	setxattr = syscall.Setxattr
+	getxattr = syscall.Getxattr
+)
+
+// metadataPath returns the path of the sidecar file holding the metadata for the object at path.
+func metadataPath(path string) string {
+	return path + metadataSuffix
+}
+
+// isMetadataFile returns truthy if the file name is a metadata sidecar rather than an object.
+func isMetadataFile(name string) bool {
+	return strings.HasSuffix(name, metadataSuffix)
+}
+
+// isSidecarFile returns truthy if the file name is a checksum or metadata sidecar rather than an object.
+func isSidecarFile(name string) bool {
+	return isChecksumFile(name) || isMetadataFile(name)
+}
+
+// xattrUnsupported returns truthy if the error is the filesystem not supporting extended attributes, as on NFS before 4.2.
+func xattrUnsupported(err error) bool {
+	return errors.Is(err, syscall.ENOTSUP) || errors.Is(err, syscall.EOPNOTSUPP)
+}
+
+// SetObjectMetadata replaces the key/value metadata of an object, such as tags added by downstream tooling.
+// It is stored in an extended attribute of the object file, or a .meta.json sidecar where the filesystem does
+// not support them. Deduplicated objects share their file, so their metadata is always stored in a sidecar.
+// Metadata is removed when the object is overwritten or deleted, follows the object when it is moved and is
+// copied along with it. Keys starting with lvp. are reserved for the metadata the plugin records, such as the
+// ownership of the object: they are ignored in meta and kept as they are.
+func (o *LocalVolumeObjectStore) SetObjectMetadata(bucket, key string, meta map[string]string) (err error) {
+	defer observeOperation("SetObjectMetadata", time.Now(), &err)
+	if o.readOnly {
+		return ErrReadOnly
+	}
+
+	done, err := o.beginWrite()
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	path, err := o.objectPath(bucket, key)
+	if err != nil {
+		return err
+	}
+
+	log := o.log.WithFields(logrus.Fields{
+		"bucket": bucket,
+		"key":    key,
+		"path":   path,
+	})
+	log.Debug("LocalVolumeObjectStore.SetObjectMetadata called")
+	defer o.keyLocks.lock(path)()
+
+	filePath, _, err := findObjectFile(path)
+	if err != nil {
+		return err
+	}
+	if err := o.checkSymlinks(o.bucketPath(bucket), filePath); err != nil {
+		return err
+	}
+
+	current, err := readMetadata(path, filePath)
+	if err != nil {
+		return err
+	}
+	return o.writeMetadata(path, filePath, withReservedMetadata(meta, current), log)
+}
+
+// GetObjectMetadata returns the metadata set on an object, which is empty if none was set.
+func (o *LocalVolumeObjectStore) GetObjectMetadata(bucket, key string) (meta map[string]string, err error) {
+	defer observeOperation("GetObjectMetadata", time.Now(), &err)
+
+	path, err := o.objectPath(bucket, key)
+	if err != nil {
+		return nil, err
+	}
+
+	log := o.log.WithFields(logrus.Fields{
+		"bucket": bucket,
+		"key":    key,
+		"path":   path,
+	})
+	log.Debug("LocalVolumeObjectStore.GetObjectMetadata called")
+
+	filePath, _, err := findObjectFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := o.checkSymlinks(o.bucketPath(bucket), filePath); err != nil {
+		return nil, err
+	}
+
+	return readMetadata(path, filePath)
+}
+
+// writeMetadata stores the metadata of the object at path, held in filePath, preferring an extended attribute
+// unless the object is deduplicated.
+func (o *LocalVolumeObjectStore) writeMetadata(path, filePath string, meta map[string]string, log logrus.FieldLogger) error {
+	if len(meta) == 0 {
+		return removeMetadata(path, filePath)
+	}
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode object metadata")
+	}
+
+	if o.dedup != dedupHardlink {
+		err := setxattr(filePath, metadataXattr, data, 0)
+		if err == nil {
+			// A sidecar written before would otherwise be stale, though the attribute is read first
+			if err := os.Remove(metadataPath(path)); err != nil && !os.IsNotExist(err) {
+				return errors.Wrap(err, "failed to remove object metadata sidecar")
+			}
+			return nil
+		}
+		if !xattrUnsupported(err) {
+			return errors.Wrap(err, "failed to set object metadata")
+		}
+		log.Debug("Extended attributes are not supported, writing metadata sidecar")
+	}
+
+	sidecar := metadataPath(path)
+	tmpPath := tempFilePath(sidecar)
+	if err := os.WriteFile(tmpPath, data, o.getFileMode()); err != nil {
+		os.Remove(tmpPath)
+		return errors.Wrap(err, "failed to write object metadata sidecar")
+	}
+	if err := os.Chmod(tmpPath, o.getFileMode()); err != nil {
+		os.Remove(tmpPath)
+		return errors.Wrap(err, "failed to write object metadata sidecar")
+	}
+	if err := os.Rename(tmpPath, sidecar); err != nil {
+		os.Remove(tmpPath)
+		return errors.Wrap(err, "failed to write object metadata sidecar")
+	}
+	return nil
+}
+
+// readMetadata returns the metadata of the object at path, held in filePath, from its extended attribute or sidecar.
+func readMetadata(path, filePath string) (map[string]string, error) {
+	data, err := readMetadataXattr(filePath)
+	if err != nil {
+		return nil, err
+	}
+	if data == nil {
+		data, err = os.ReadFile(metadataPath(path))
+		if os.IsNotExist(err) {
+			return map[string]string{}, nil
+		} else if err != nil {
+			return nil, errors.Wrap(err, "failed to read object metadata sidecar")
+		}
+	}
+
+	meta := map[string]string{}
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return nil, errors.Wrap(err, "failed to decode object metadata")
+	}
+	return meta, nil
+}
+
+// readMetadataXattr returns the metadata attribute of the file, or nil if it has none or the filesystem
+// does not support extended attributes.
+func readMetadataXattr(filePath string) ([]byte, error) {
+	for {
+		size, err := getxattr(filePath, metadataXattr, nil)
+		if errors.Is(err, syscall.ENODATA) || xattrUnsupported(err) {
+			return nil, nil
+		} else if err != nil {
+			return nil, errors.Wrap(err, "failed to read object metadata")
+		}
+
+		data := make([]byte, size)
+		n, err := getxattr(filePath, metadataXattr, data)
+		// The attribute grew since its size was read
+		if errors.Is(err, syscall.ERANGE) {
+			continue
+		} else if err != nil {
+			return nil, errors.Wrap(err, "failed to read object metadata")
+		}
+		return data[:n], nil
+	}
+}
+
+// removeMetadata removes the metadata of the object at path, held in filePath, if it has any.
+func removeMetadata(path, filePath string) error {
+	if err := syscall.Removexattr(filePath, metadataXattr); err != nil && !errors.Is(err, syscall.ENODATA) && !xattrUnsupported(err) && !os.IsNotExist(err) {
+		return errors.Wrap(err, "failed to remove object metadata")
+	}
+	if err := os.Remove(metadataPath(path)); err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "failed to remove object metadata sidecar")
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+package plugin
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// withoutXattrs makes extended attributes unsupported for the rest of the test, as on NFS.
+func withoutXattrs(t *testing.T) {
+	set, get := setxattr, getxattr
+	t.Cleanup(func() { setxattr, getxattr = set, get })
+	setxattr = func(string, string, []byte, int) error { return syscall.ENOTSUP }
+	getxattr = func(string, string, []byte) (int, error) { return 0, syscall.ENOTSUP }
+}
+
+func Test_ObjectMetadata(t *testing.T) {
+	tests := []struct {
+		name        string
+		noXattrs    bool
+		config      map[string]string
+		wantSidecar bool
+	}{
+		{
+			name: "extended attributes",
+		},
+		{
+			name:        "sidecar without extended attributes",
+			noXattrs:    true,
+			wantSidecar: true,
+		},
+		{
+			name:        "sidecar for deduplicated objects",
+			config:      map[string]string{"dedup": dedupHardlink},
+			wantSidecar: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := require.New(t)
+			o, root := newTestObjectStore(t)
+			req.NoError(o.applyConfig(tt.config))
+			if tt.noXattrs {
+				withoutXattrs(t)
+			} else if err := setxattr(root, metadataXattr, []byte("{}"), 0); err != nil {
+				t.Skipf("extended attributes are not supported by the test filesystem: %v", err)
+			}
+
+			key := "backups/my-backup/my-backup.tar.gz"
+			path := filepath.Join(root, "my-bucket", key)
+			req.NoError(o.PutObject("my-bucket", key, strings.NewReader("data")))
+
+			meta, err := o.GetObjectMetadata("my-bucket", key)
+			req.NoError(err)
+			req.Empty(meta)
+
+			tags := map[string]string{"environment": "production", "app": "etcd", "ticket": "OPS-1234"}
+			req.NoError(o.SetObjectMetadata("my-bucket", key, tags))
+			_, err = os.Stat(metadataPath(path))
+			req.Equal(tt.wantSidecar, err == nil)
+
+			meta, err = o.GetObjectMetadata("my-bucket", key)
+			req.NoError(err)
+			req.Equal(tags, meta)
+
+			// the sidecar is not an object
+			objects, err := o.ListObjects("my-bucket", "backups/")
+			req.NoError(err)
+			req.Equal([]string{key}, objects)
+			keys, _, err := o.ListObjectsPaged("my-bucket", "backups/", "", 10)
+			req.NoError(err)
+			req.Equal([]string{key}, keys)
+
+			// metadata is copied and moved with the object
+			req.NoError(o.CopyObject("my-bucket", key, "backups/copy/copy.tar.gz"))
+			meta, err = o.GetObjectMetadata("my-bucket", "backups/copy/copy.tar.gz")
+			req.NoError(err)
+			req.Equal(tags, meta)
+
+			req.NoError(o.MoveObject("my-bucket", "backups/copy/copy.tar.gz", "backups/moved/moved.tar.gz"))
+			meta, err = o.GetObjectMetadata("my-bucket", "backups/moved/moved.tar.gz")
+			req.NoError(err)
+			req.Equal(tags, meta)
+
+			// overwriting the object replaces its metadata
+			req.NoError(o.PutObject("my-bucket", key, strings.NewReader("new data")))
+			meta, err = o.GetObjectMetadata("my-bucket", key)
+			req.NoError(err)
+			req.Empty(meta)
+
+			// setting empty metadata removes it
+			req.NoError(o.SetObjectMetadata("my-bucket", key, tags))
+			req.NoError(o.SetObjectMetadata("my-bucket", key, nil))
+			meta, err = o.GetObjectMetadata("my-bucket", key)
+			req.NoError(err)
+			req.Empty(meta)
+
+			// deleting the object removes its metadata, so the backup directory is cleaned up
+			req.NoError(o.DeleteObject("my-bucket", "backups/moved/moved.tar.gz"))
+			_, err = os.Stat(filepath.Join(root, "my-bucket", "backups", "moved"))
+			req.True(os.IsNotExist(err))
+		})
+	}
+}
+
+func Test_ObjectMetadata_errors(t *testing.T) {
+	req := require.New(t)
+	o, _ := newTestObjectStore(t)
+
+	_, err := o.GetObjectMetadata("my-bucket", "backups/missing")
+	req.ErrorIs(err, ErrObjectNotFound)
+	req.ErrorIs(o.SetObjectMetadata("my-bucket", "backups/missing", map[string]string{"app": "etcd"}), ErrObjectNotFound)
+	req.ErrorIs(o.SetObjectMetadata("my-bucket", "../escape", map[string]string{"app": "etcd"}), ErrPathTraversal)
+
+	req.NoError(o.PutObject("my-bucket", "backups/my-backup/my-backup.tar.gz", strings.NewReader("data")))
+	o.readOnly = true
+	req.ErrorIs(o.SetObjectMetadata("my-bucket", "backups/my-backup/my-backup.tar.gz", map[string]string{"app": "etcd"}), ErrReadOnly)
+}
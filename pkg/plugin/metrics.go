@@ -0,0 +1,111 @@
+package plugin
+
+import (
+	"io"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const metricsNamespace = "local_volume_provider"
+
+const (
+	outcomeSuccess = "success"
+	outcomeError   = "error"
+)
+
+// metrics holds the Prometheus collectors for object store operations.
+// They are shared by all object store instances in the process.
+var metrics = struct {
+	operations    *prometheus.CounterVec
+	bytes         *prometheus.HistogramVec
+	latency       *prometheus.HistogramVec
+	storageFull   prometheus.Counter
+	inflightBytes prometheus.Gauge
+}{
+	operations: prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "operations_total",
+		Help:      "Number of object store operations by operation and outcome.",
+	}, []string{"operation", "outcome"}),
+	bytes: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "transferred_bytes",
+		Help:      "Bytes transferred by object store operations.",
+		Buckets:   prometheus.ExponentialBuckets(1024, 4, 12),
+	}, []string{"operation"}),
+	latency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "operation_duration_seconds",
+		Help:      "Latency of object store operations.",
+		Buckets:   prometheus.ExponentialBuckets(0.001, 4, 10),
+	}, []string{"operation"}),
+	storageFull: prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "storage_full_total",
+		Help:      "Number of writes that failed because the volume was full or over quota.",
+	}),
+	inflightBytes: prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "inflight_bytes",
+		Help:      "Bytes written so far by the uploads in progress.",
+	}),
+}
+
+// RegisterMetrics registers the object store operation metrics with the given registerer.
+func RegisterMetrics(reg prometheus.Registerer) error {
+	return registerCollectors(reg, metrics.operations, metrics.bytes, metrics.latency)
+}
+
+// RegisterWriteMetrics registers the metrics of the writes to the volume with the given registerer.
+// Only the plugin process writes objects, so only it updates them.
+func RegisterWriteMetrics(reg prometheus.Registerer) error {
+	return registerCollectors(reg, metrics.storageFull, metrics.inflightBytes)
+}
+
+func registerCollectors(reg prometheus.Registerer, collectors ...prometheus.Collector) error {
+	for _, c := range collectors {
+		if err := reg.Register(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ObserveOperation records the outcome and latency of an operation started at start.
+func ObserveOperation(operation string, start time.Time, err error) {
+	outcome := outcomeSuccess
+	if err != nil {
+		outcome = outcomeError
+	}
+	metrics.operations.WithLabelValues(operation, outcome).Inc()
+	metrics.latency.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+}
+
+// ObserveBytes records the number of bytes transferred by an operation.
+func ObserveBytes(operation string, n int64) {
+	metrics.bytes.WithLabelValues(operation).Observe(float64(n))
+}
+
+// observeOperation is deferred by object store methods to record the operation with its final error.
+func observeOperation(operation string, start time.Time, errp *error) {
+	ObserveOperation(operation, start, *errp)
+}
+
+// meteredReadCloser counts the bytes read through it and records them when closed.
+type meteredReadCloser struct {
+	io.ReadCloser
+	operation string
+	n         int64
+}
+
+func (r *meteredReadCloser) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.n += int64(n)
+	return n, err
+}
+
+func (r *meteredReadCloser) Close() error {
+	ObserveBytes(r.operation, r.n)
+	return r.ReadCloser.Close()
+}
@@ -0,0 +1,70 @@
+package plugin
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_metrics(t *testing.T) {
+	req := require.New(t)
+	reg := prometheus.NewRegistry()
+	req.NoError(RegisterMetrics(reg))
+
+	o, _ := newTestObjectStore(t)
+	req.NoError(o.PutObject("my-bucket", "backups/my-backup/my-backup.tar.gz", strings.NewReader("backup contents")))
+	_, err := o.GetObject("my-bucket", "backups/my-backup/missing.tar.gz")
+	req.Error(err)
+
+	families, err := reg.Gather()
+	req.NoError(err)
+
+	counters := map[string]float64{}
+	for _, family := range families {
+		if family.GetName() != "local_volume_provider_operations_total" {
+			continue
+		}
+		for _, m := range family.GetMetric() {
+			counters[labelValue(m, "operation")+"/"+labelValue(m, "outcome")] = m.GetCounter().GetValue()
+		}
+	}
+	req.GreaterOrEqual(counters["PutObject/success"], float64(1))
+	req.GreaterOrEqual(counters["GetObject/error"], float64(1))
+}
+
+func Test_RegisterWriteMetrics(t *testing.T) {
+	req := require.New(t)
+
+	// The fileserver only registers the operation metrics, as it never writes objects
+	reg := prometheus.NewRegistry()
+	req.NoError(RegisterMetrics(reg))
+	names := map[string]bool{}
+	families, err := reg.Gather()
+	req.NoError(err)
+	for _, family := range families {
+		names[family.GetName()] = true
+	}
+	req.False(names["local_volume_provider_storage_full_total"])
+	req.False(names["local_volume_provider_inflight_bytes"])
+
+	req.NoError(RegisterWriteMetrics(reg))
+	families, err = reg.Gather()
+	req.NoError(err)
+	for _, family := range families {
+		names[family.GetName()] = true
+	}
+	req.True(names["local_volume_provider_storage_full_total"])
+	req.True(names["local_volume_provider_inflight_bytes"])
+}
+
+func labelValue(m *dto.Metric, name string) string {
+	for _, label := range m.GetLabel() {
+		if label.GetName() == name {
+			return label.GetValue()
+		}
+	}
+	return ""
+}
@@ -0,0 +1,108 @@
+package plugin
+
+import (
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+)
+
+// mmapFile maps the first size bytes of the open file read-only.
+// It is a variable so tests can simulate volumes that cannot be memory-mapped.
+var mmapFile = func(file *os.File, size int) ([]byte, error) {
+	return unix.Mmap(int(file.Fd()), 0, size, unix.PROT_READ, unix.MAP_SHARED)
+}
+
+// GetObjectReaderAt returns a reader for random access to an object, such as tooling reading small parts of
+// a large backup, along with its size and a func releasing the reader once it is no longer used.
+// The object is memory-mapped, so reads are served from the page cache without a seek and read per call,
+// falling back to reading the file where the volume cannot be mapped. Compressed and encrypted objects cannot
+// be read at an offset and fail with ErrNoRandomAccess. Checksums are not verified.
+func (o *LocalVolumeObjectStore) GetObjectReaderAt(bucket, key string) (r io.ReaderAt, size int64, release func() error, err error) {
+	defer observeOperation("GetObjectReaderAt", time.Now(), &err)
+
+	path, err := o.objectPath(bucket, key)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+
+	log := o.log.WithFields(logrus.Fields{
+		"bucket": bucket,
+		"key":    key,
+		"path":   path,
+	})
+	log.Debug("LocalVolumeObjectStore.GetObjectReaderAt called")
+
+	filePath, compression, err := findObjectFile(path)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	if compression != "" {
+		return nil, 0, nil, errors.Wrap(ErrNoRandomAccess, "object is compressed")
+	}
+	if o.getEncryptionKey() != nil {
+		return nil, 0, nil, errors.Wrap(ErrNoRandomAccess, "object is encrypted")
+	}
+	if err := o.checkSymlinks(o.bucketPath(bucket), filePath); err != nil {
+		return nil, 0, nil, err
+	}
+	if err := checkRegularFile(filePath); err != nil {
+		return nil, 0, nil, err
+	}
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, 0, nil, err
+	}
+	size = info.Size()
+
+	// An empty file cannot be mapped, and a file larger than the address space cannot be mapped whole
+	if size > 0 && int64(int(size)) == size {
+		data, err := mmapFile(file, int(size))
+		if err == nil {
+			// The mapping stays valid once the file is closed
+			file.Close()
+			return &mmapReaderAt{data: data}, size, releaseOnce(func() error { return unix.Munmap(data) }), nil
+		}
+		log.WithError(err).Debug("Failed to memory-map object, reading the file instead")
+	}
+	return file, size, releaseOnce(file.Close), nil
+}
+
+// releaseOnce returns a func calling release the first time it is called only, so a reader can be released twice.
+func releaseOnce(release func() error) func() error {
+	var once sync.Once
+	var err error
+	return func() error {
+		once.Do(func() { err = release() })
+		return err
+	}
+}
+
+// mmapReaderAt reads from a memory-mapped file.
+type mmapReaderAt struct {
+	data []byte
+}
+
+func (r *mmapReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+	if off >= int64(len(r.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, r.data[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
@@ -0,0 +1,102 @@
+package plugin
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io"
+	mathrand "math/rand"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_GetObjectReaderAt(t *testing.T) {
+	tests := []struct {
+		name    string
+		mmapErr error
+		wantMap bool
+	}{
+		{
+			name:    "memory-mapped",
+			wantMap: true,
+		},
+		{
+			name:    "mmap unavailable -- reads the file",
+			mmapErr: errors.New("operation not supported"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := require.New(t)
+			o, _ := newTestObjectStore(t)
+			if tt.mmapErr != nil {
+				defer func(m func(*os.File, int) ([]byte, error)) { mmapFile = m }(mmapFile)
+				mmapFile = func(*os.File, int) ([]byte, error) { return nil, tt.mmapErr }
+			}
+
+			content := make([]byte, 5<<20+123)
+			_, err := rand.Read(content)
+			req.NoError(err)
+			req.NoError(o.PutObject("my-bucket", "backups/my-backup/my-backup.tar.gz", bytes.NewReader(content)))
+
+			r, size, release, err := o.GetObjectReaderAt("my-bucket", "backups/my-backup/my-backup.tar.gz")
+			req.NoError(err)
+			req.Equal(int64(len(content)), size)
+			_, mapped := r.(*mmapReaderAt)
+			req.Equal(tt.wantMap, mapped)
+
+			// scattered reads, including the very start and the very end
+			rnd := mathrand.New(mathrand.NewSource(1))
+			offsets := []int64{0, int64(len(content)) - 10}
+			for i := 0; i < 200; i++ {
+				offsets = append(offsets, rnd.Int63n(int64(len(content))-10))
+			}
+			for _, off := range offsets {
+				length := 1 + rnd.Intn(4096)
+				if off+int64(length) > int64(len(content)) {
+					length = int(int64(len(content)) - off)
+				}
+				buf := make([]byte, length)
+				n, err := r.ReadAt(buf, off)
+				req.NoError(err)
+				req.Equal(length, n)
+				req.Equal(content[off:off+int64(length)], buf)
+			}
+
+			// reading past the end
+			buf := make([]byte, 100)
+			n, err := r.ReadAt(buf, int64(len(content))-50)
+			req.ErrorIs(err, io.EOF)
+			req.Equal(50, n)
+			req.Equal(content[len(content)-50:], buf[:n])
+
+			req.NoError(release())
+			req.NoError(release(), "releasing twice should be harmless")
+		})
+	}
+}
+
+func Test_GetObjectReaderAt_noRandomAccess(t *testing.T) {
+	req := require.New(t)
+	o, _ := newTestObjectStore(t)
+
+	o.compression = compressionGzip
+	req.NoError(o.PutObject("my-bucket", "compressed", strings.NewReader("compressed contents")))
+	_, _, _, err := o.GetObjectReaderAt("my-bucket", "compressed")
+	req.ErrorIs(err, ErrNoRandomAccess)
+
+	_, _, _, err = o.GetObjectReaderAt("my-bucket", "missing")
+	req.ErrorIs(err, ErrObjectNotFound)
+
+	o.compression = ""
+	req.NoError(o.PutObject("my-bucket", "empty", bytes.NewReader(nil)))
+	r, size, release, err := o.GetObjectReaderAt("my-bucket", "empty")
+	req.NoError(err)
+	req.Zero(size)
+	_, err = r.ReadAt(make([]byte, 1), 0)
+	req.ErrorIs(err, io.EOF)
+	req.NoError(release())
+}
@@ -0,0 +1,118 @@
+package plugin
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// mountsPath is the mount table read to find the filesystem backing a volume. Tests replace it with a fake.
+var mountsPath = "/proc/mounts"
+
+// remoteFSTypes are the filesystem types the volume of each remote volume type is expected to be mounted as.
+var remoteFSTypes = map[VolumeType][]string{
+	NFS: {"nfs", "nfs4"},
+	SMB: {"cifs", "smb3"},
+}
+
+type mountInfo struct {
+	mountPoint string
+	fsType     string
+}
+
+// readMounts parses a mount table in the format of /proc/mounts.
+func readMounts(path string) ([]mountInfo, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var mounts []mountInfo
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 3 {
+			continue
+		}
+		mounts = append(mounts, mountInfo{
+			mountPoint: unescapeMountField(fields[1]),
+			fsType:     fields[2],
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return mounts, nil
+}
+
+// unescapeMountField replaces the octal escapes the kernel writes for whitespace and backslashes, e.g. \040 for a space.
+func unescapeMountField(field string) string {
+	if !strings.Contains(field, `\`) {
+		return field
+	}
+	var b strings.Builder
+	for i := 0; i < len(field); i++ {
+		if field[i] == '\\' && i+4 <= len(field) {
+			if c, err := strconv.ParseUint(field[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(c))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(field[i])
+	}
+	return b.String()
+}
+
+// findMount returns the mount the path is on, which is the one with the longest mount point containing it.
+// Later mounts over the same mount point hide earlier ones.
+func findMount(mounts []mountInfo, path string) (mountInfo, bool) {
+	path = filepath.Clean(path)
+	var found mountInfo
+	ok := false
+	for _, m := range mounts {
+		if !pathWithin(m.mountPoint, path) {
+			continue
+		}
+		if !ok || len(m.mountPoint) >= len(found.mountPoint) {
+			found = m
+			ok = true
+		}
+	}
+	return found, ok
+}
+
+// pathWithin returns truthy if path is dir or beneath it.
+func pathWithin(dir, path string) bool {
+	dir = filepath.Clean(dir)
+	if dir == "/" || dir == path {
+		return true
+	}
+	return strings.HasPrefix(path, dir+string(filepath.Separator))
+}
+
+// checkMount returns an error wrapping ErrVolumeNotMounted if the path of a remote volume is not on a mount
+// of its own with the filesystem type of the volume type, as happens when the volume failed to mount and
+// objects would be written to the ephemeral filesystem of the container. Other volume types are not checked.
+func checkMount(volumeType VolumeType, path string, mounts []mountInfo) error {
+	fsTypes, ok := remoteFSTypes[volumeType]
+	if !ok {
+		return nil
+	}
+
+	m, ok := findMount(mounts, path)
+	if !ok || filepath.Clean(m.mountPoint) == "/" {
+		return errors.Wrapf(ErrVolumeNotMounted, "%s is on the root filesystem of the container", path)
+	}
+	for _, fsType := range fsTypes {
+		if m.fsType == fsType {
+			return nil
+		}
+	}
+	return errors.Wrapf(ErrVolumeNotMounted, "%s is on a %s mount at %s, expected %s", path, m.fsType, m.mountPoint, strings.Join(fsTypes, " or "))
+}
@@ -0,0 +1,124 @@
+package plugin
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+const testMounts = `overlay / overlay rw,relatime,lowerdir=/var/lib/docker/overlay2/l 0 0
+proc /proc proc rw,nosuid,nodev,noexec,relatime 0 0
+/dev/sda1 /var/velero-local-volume-provider ext4 rw,relatime 0 0
+10.0.0.1:/exports/daily /var/velero-local-volume-provider/daily nfs4 rw,relatime,vers=4.1 0 0
+//fileserver/weekly /var/velero-local-volume-provider/weekly cifs rw,relatime 0 0
+10.0.0.1:/exports/my\040bucket /var/velero-local-volume-provider/my\040bucket nfs rw,relatime 0 0
+`
+
+func writeTestMounts(t *testing.T, contents string) string {
+	path := filepath.Join(t.TempDir(), "mounts")
+	require.NoError(t, os.WriteFile(path, []byte(contents), 0644))
+	return path
+}
+
+func Test_readMounts(t *testing.T) {
+	req := require.New(t)
+
+	mounts, err := readMounts(writeTestMounts(t, testMounts))
+	req.NoError(err)
+	req.Len(mounts, 6)
+	req.Equal(mountInfo{mountPoint: "/", fsType: "overlay"}, mounts[0])
+	req.Equal(mountInfo{mountPoint: "/var/velero-local-volume-provider/my bucket", fsType: "nfs"}, mounts[5])
+
+	_, err = readMounts(filepath.Join(t.TempDir(), "missing"))
+	req.Error(err)
+}
+
+func Test_checkMount(t *testing.T) {
+	mounts, err := readMounts(writeTestMounts(t, testMounts))
+	require.NoError(t, err)
+
+	tests := []struct {
+		name       string
+		volumeType VolumeType
+		path       string
+		wantErr    bool
+	}{
+		{
+			name:       "nfs mount",
+			volumeType: NFS,
+			path:       "/var/velero-local-volume-provider/daily",
+		},
+		{
+			name:       "nfs mount with escaped space",
+			volumeType: NFS,
+			path:       "/var/velero-local-volume-provider/my bucket",
+		},
+		{
+			name:       "smb mount",
+			volumeType: SMB,
+			path:       "/var/velero-local-volume-provider/weekly",
+		},
+		{
+			name:       "nfs volume on the root filesystem",
+			volumeType: NFS,
+			path:       "/var/velero-local-volume-provider-other/daily",
+			wantErr:    true,
+		},
+		{
+			name:       "nfs volume on a local disk",
+			volumeType: NFS,
+			path:       "/var/velero-local-volume-provider/monthly",
+			wantErr:    true,
+		},
+		{
+			name:       "nfs volume on an smb mount",
+			volumeType: NFS,
+			path:       "/var/velero-local-volume-provider/weekly",
+			wantErr:    true,
+		},
+		{
+			name:       "hostpath is not checked",
+			volumeType: Hostpath,
+			path:       "/var/velero-local-volume-provider/monthly",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkMount(tt.volumeType, tt.path, mounts)
+			if tt.wantErr {
+				require.ErrorIs(t, err, ErrVolumeNotMounted)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
+
+func Test_checkVolumeMount(t *testing.T) {
+	req := require.New(t)
+
+	defer func(path string) { mountsPath = path }(mountsPath)
+	mountsPath = writeTestMounts(t, testMounts)
+
+	var out strings.Builder
+	logger := logrus.New()
+	logger.Out = &out
+	o := NewLocalVolumeObjectStore(logger, NFS)
+
+	req.NoError(o.checkVolumeMount("/var/velero-local-volume-provider/daily", logger))
+	req.Empty(out.String())
+
+	req.NoError(o.checkVolumeMount("/var/velero-local-volume-provider/monthly", logger))
+	req.Equal(1, strings.Count(out.String(), "level=warning"), out.String())
+
+	req.NoError(o.applyConfig(map[string]string{"requireRemoteMount": "true"}))
+	req.ErrorIs(o.checkVolumeMount("/var/velero-local-volume-provider/monthly", logger), ErrVolumeNotMounted)
+
+	// The check is skipped if the mount table cannot be read
+	mountsPath = filepath.Join(t.TempDir(), "missing")
+	req.NoError(o.checkVolumeMount("/var/velero-local-volume-provider/monthly", logger))
+}
@@ -0,0 +1,180 @@
+package plugin
+
+import (
+	"context"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// Ownership metadata keys record the owner an object was written with under preserveOwnership,
+// so that it can be applied again when the object is copied or imported elsewhere.
+const (
+	ownershipUIDKey = "lvp.uid"
+	ownershipGIDKey = "lvp.gid"
+)
+
+// reservedMetadataPrefix starts the metadata keys the plugin records itself, which SetObjectMetadata keeps.
+const reservedMetadataPrefix = "lvp."
+
+// Ownership is the Unix owner and group of an object file.
+type Ownership struct {
+	UID int
+	GID int
+}
+
+// ownershipSource is implemented by sources that can report the ownership of their objects,
+// such as another LocalVolumeObjectStore.
+type ownershipSource interface {
+	GetObjectOwnership(bucket, key string) (Ownership, error)
+}
+
+// PutObjectWithOwnership is PutObjectWithModTime also setting the owner and group of the object file, for
+// objects migrated from shares where tools inspect the files directly. Ownership is only applied with
+// preserveOwnership, which needs the Velero container to run with CAP_CHOWN, and is otherwise ignored.
+// It is recorded in the object metadata, so it carries over when the object is copied or imported.
+// With hardlink deduplication, an object sharing its file with other objects of the same content keeps the owner
+// of the file, as changing it would change theirs, and its ownership is only recorded.
+func (o *LocalVolumeObjectStore) PutObjectWithOwnership(bucket, key string, body io.Reader, modTime time.Time, owner Ownership) error {
+	return o.putObject(context.Background(), bucket, key, body, putOptions{modTime: modTime, owner: &owner})
+}
+
+// GetObjectOwnership returns the ownership recorded for an object when it was written with preserveOwnership,
+// or otherwise the owner and group of its file.
+func (o *LocalVolumeObjectStore) GetObjectOwnership(bucket, key string) (owner Ownership, err error) {
+	defer observeOperation("GetObjectOwnership", time.Now(), &err)
+
+	path, err := o.objectPath(bucket, key)
+	if err != nil {
+		return Ownership{}, err
+	}
+
+	log := o.log.WithFields(logrus.Fields{
+		"bucket": bucket,
+		"key":    key,
+		"path":   path,
+	})
+	log.Debug("LocalVolumeObjectStore.GetObjectOwnership called")
+
+	filePath, _, err := findObjectFile(path)
+	if err != nil {
+		return Ownership{}, err
+	}
+	if err := o.checkSymlinks(o.bucketPath(bucket), filePath); err != nil {
+		return Ownership{}, err
+	}
+
+	meta, err := readMetadata(path, filePath)
+	if err != nil {
+		return Ownership{}, err
+	}
+	if owner, ok, err := parseOwnership(meta); ok || err != nil {
+		return owner, err
+	}
+
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return Ownership{}, err
+	}
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return Ownership{}, errors.New("file ownership is not available on this platform")
+	}
+	return Ownership{UID: int(stat.Uid), GID: int(stat.Gid)}, nil
+}
+
+// parseOwnership returns the ownership recorded in the metadata of an object, if any.
+func parseOwnership(meta map[string]string) (Ownership, bool, error) {
+	uidValue, hasUID := meta[ownershipUIDKey]
+	gidValue, hasGID := meta[ownershipGIDKey]
+	if !hasUID && !hasGID {
+		return Ownership{}, false, nil
+	}
+	uid, err := strconv.Atoi(uidValue)
+	if err != nil || uid < 0 {
+		return Ownership{}, false, errors.Errorf("invalid recorded uid %q", uidValue)
+	}
+	gid, err := strconv.Atoi(gidValue)
+	if err != nil || gid < 0 {
+		return Ownership{}, false, errors.Errorf("invalid recorded gid %q", gidValue)
+	}
+	return Ownership{UID: uid, GID: gid}, true, nil
+}
+
+// applyOwnership sets the owner and group of a newly written object file and records them in its metadata,
+// if preserveOwnership is set. The metadata of a new object is empty, so it only holds the ownership.
+func (o *LocalVolumeObjectStore) applyOwnership(path, filePath string, owner *Ownership, log logrus.FieldLogger) error {
+	if owner == nil {
+		return nil
+	}
+	if !o.preserveOwnership {
+		log.Debug("Ignoring object ownership as preserveOwnership is not enabled")
+		return nil
+	}
+	if owner.UID < 0 || owner.GID < 0 {
+		return errors.Errorf("invalid ownership %d:%d", owner.UID, owner.GID)
+	}
+	if err := o.chownObjectFile(filePath, *owner, log); err != nil {
+		return err
+	}
+	return o.writeMetadata(path, filePath, map[string]string{
+		ownershipUIDKey: strconv.Itoa(owner.UID),
+		ownershipGIDKey: strconv.Itoa(owner.GID),
+	}, log)
+}
+
+// applyRecordedOwnership sets the ownership recorded in the metadata of a copied object on its new file,
+// if preserveOwnership is set.
+func (o *LocalVolumeObjectStore) applyRecordedOwnership(filePath string, meta map[string]string, log logrus.FieldLogger) error {
+	if !o.preserveOwnership {
+		return nil
+	}
+	owner, ok, err := parseOwnership(meta)
+	if err != nil || !ok {
+		return err
+	}
+	return o.chownObjectFile(filePath, owner, log)
+}
+
+// chownObjectFile sets the owner and group of an object file, unless other objects share it as a deduplicated blob.
+func (o *LocalVolumeObjectStore) chownObjectFile(filePath string, owner Ownership, log logrus.FieldLogger) error {
+	info, err := os.Lstat(filePath)
+	if err != nil {
+		return err
+	}
+	// A deduplicated object is linked to by its blob as well
+	links := uint64(1)
+	if o.dedup == dedupHardlink {
+		links = 2
+	}
+	if linkCount(info) > links {
+		log.Warn("Not setting the ownership of an object sharing its deduplicated file with other objects")
+		return nil
+	}
+	if err := os.Lchown(filePath, owner.UID, owner.GID); err != nil {
+		return errors.Wrap(err, "failed to set object ownership")
+	}
+	return nil
+}
+
+// withReservedMetadata returns the metadata with its reserved keys replaced by those of the current metadata.
+func withReservedMetadata(meta, current map[string]string) map[string]string {
+	merged := map[string]string{}
+	for k, v := range meta {
+		if !strings.HasPrefix(k, reservedMetadataPrefix) {
+			merged[k] = v
+		}
+	}
+	for k, v := range current {
+		if strings.HasPrefix(k, reservedMetadataPrefix) {
+			merged[k] = v
+		}
+	}
+	return merged
+}
@@ -0,0 +1,146 @@
+package plugin
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+// fileOwnership returns the owner and group of the file.
+func fileOwnership(t *testing.T, filePath string) Ownership {
+	info, err := os.Stat(filePath)
+	require.NoError(t, err)
+	stat := info.Sys().(*syscall.Stat_t)
+	return Ownership{UID: int(stat.Uid), GID: int(stat.Gid)}
+}
+
+// fakeOwnershipSource also reports the same ownership for all of its objects.
+type fakeOwnershipSource struct {
+	*fakeObjectSource
+	owner Ownership
+}
+
+func (s *fakeOwnershipSource) GetObjectOwnership(bucket, key string) (Ownership, error) {
+	return s.owner, nil
+}
+
+func Test_PutObjectWithOwnership(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("changing the ownership of files requires root")
+	}
+	owner := Ownership{UID: 1234, GID: 5678}
+
+	t.Run("preserveOwnership", func(t *testing.T) {
+		req := require.New(t)
+		o, root := newTestObjectStore(t)
+		o.preserveOwnership = true
+
+		req.NoError(o.PutObjectWithOwnership("my-bucket", "backups/my-backup/my-backup.tar.gz", strings.NewReader("content"), time.Time{}, owner))
+		req.Equal(owner, fileOwnership(t, filepath.Join(root, "my-bucket", "backups/my-backup/my-backup.tar.gz")))
+
+		got, err := o.GetObjectOwnership("my-bucket", "backups/my-backup/my-backup.tar.gz")
+		req.NoError(err)
+		req.Equal(owner, got)
+
+		// Copies keep the recorded ownership
+		req.NoError(o.CopyObject("my-bucket", "backups/my-backup/my-backup.tar.gz", "backups/copy/copy.tar.gz"))
+		req.Equal(owner, fileOwnership(t, filepath.Join(root, "my-bucket", "backups/copy/copy.tar.gz")))
+
+		// Overwriting the object without an owner drops the recorded one
+		req.NoError(o.PutObject("my-bucket", "backups/my-backup/my-backup.tar.gz", strings.NewReader("replaced")))
+		meta, err := o.GetObjectMetadata("my-bucket", "backups/my-backup/my-backup.tar.gz")
+		req.NoError(err)
+		req.Empty(meta)
+	})
+
+	t.Run("not enabled -- ownership is ignored", func(t *testing.T) {
+		req := require.New(t)
+		o, root := newTestObjectStore(t)
+
+		req.NoError(o.PutObjectWithOwnership("my-bucket", "backups/my-backup/my-backup.tar.gz", strings.NewReader("content"), time.Time{}, owner))
+		req.Equal(Ownership{UID: os.Geteuid(), GID: os.Getegid()}, fileOwnership(t, filepath.Join(root, "my-bucket", "backups/my-backup/my-backup.tar.gz")))
+
+		meta, err := o.GetObjectMetadata("my-bucket", "backups/my-backup/my-backup.tar.gz")
+		req.NoError(err)
+		req.Empty(meta)
+	})
+
+	t.Run("imported from a source with ownership", func(t *testing.T) {
+		req := require.New(t)
+		o, root := newTestObjectStore(t)
+		o.preserveOwnership = true
+
+		src := &fakeOwnershipSource{fakeObjectSource: newFakeObjectSource(3), owner: owner}
+		req.NoError(o.ImportFrom(src, "my-bucket"))
+		for key := range src.objects {
+			req.Equal(owner, fileOwnership(t, filepath.Join(root, "my-bucket", key)), key)
+		}
+	})
+
+	t.Run("deduplicated objects keep the owner of their shared file", func(t *testing.T) {
+		req := require.New(t)
+		o, root := newTestObjectStore(t)
+		req.NoError(o.applyConfig(map[string]string{"dedup": dedupHardlink, "preserveOwnership": "true"}))
+
+		other := Ownership{UID: 4321, GID: 8765}
+		req.NoError(o.PutObjectWithOwnership("my-bucket", "backups/first/first.tar.gz", strings.NewReader("content"), time.Time{}, owner))
+		req.NoError(o.PutObjectWithOwnership("my-bucket", "backups/second/second.tar.gz", strings.NewReader("content"), time.Time{}, other))
+		req.Equal(owner, fileOwnership(t, filepath.Join(root, "my-bucket", "backups/first/first.tar.gz")))
+
+		// The ownership of each object is still recorded
+		got, err := o.GetObjectOwnership("my-bucket", "backups/second/second.tar.gz")
+		req.NoError(err)
+		req.Equal(other, got)
+	})
+
+	t.Run("invalid ownership", func(t *testing.T) {
+		o, _ := newTestObjectStore(t)
+		o.preserveOwnership = true
+		require.Error(t, o.PutObjectWithOwnership("my-bucket", "key", strings.NewReader("content"), time.Time{}, Ownership{UID: -1}))
+	})
+}
+
+func Test_GetObjectOwnership(t *testing.T) {
+	req := require.New(t)
+	o, root := newTestObjectStore(t)
+	req.NoError(o.PutObject("my-bucket", "backups/my-backup/my-backup.tar.gz", strings.NewReader("content")))
+
+	// Without recorded ownership the owner of the file is returned
+	got, err := o.GetObjectOwnership("my-bucket", "backups/my-backup/my-backup.tar.gz")
+	req.NoError(err)
+	req.Equal(fileOwnership(t, filepath.Join(root, "my-bucket", "backups/my-backup/my-backup.tar.gz")), got)
+
+	// The ownership keys are reserved, so they are recorded directly
+	path := filepath.Join(root, "my-bucket", "backups/my-backup/my-backup.tar.gz")
+	log := logrus.New()
+	req.NoError(o.writeMetadata(path, path, map[string]string{ownershipUIDKey: "1234", ownershipGIDKey: "5678"}, log))
+	got, err = o.GetObjectOwnership("my-bucket", "backups/my-backup/my-backup.tar.gz")
+	req.NoError(err)
+	req.Equal(Ownership{UID: 1234, GID: 5678}, got)
+
+	// Setting the metadata keeps the recorded ownership, whatever the reserved keys given
+	req.NoError(o.SetObjectMetadata("my-bucket", "backups/my-backup/my-backup.tar.gz", map[string]string{"team": "storage", ownershipUIDKey: "1"}))
+	got, err = o.GetObjectOwnership("my-bucket", "backups/my-backup/my-backup.tar.gz")
+	req.NoError(err)
+	req.Equal(Ownership{UID: 1234, GID: 5678}, got)
+	meta, err := o.GetObjectMetadata("my-bucket", "backups/my-backup/my-backup.tar.gz")
+	req.NoError(err)
+	req.Equal(map[string]string{"team": "storage", ownershipUIDKey: "1234", ownershipGIDKey: "5678"}, meta)
+	req.NoError(o.SetObjectMetadata("my-bucket", "backups/my-backup/my-backup.tar.gz", nil))
+	got, err = o.GetObjectOwnership("my-bucket", "backups/my-backup/my-backup.tar.gz")
+	req.NoError(err)
+	req.Equal(Ownership{UID: 1234, GID: 5678}, got)
+
+	req.NoError(o.writeMetadata(path, path, map[string]string{ownershipUIDKey: "1234"}, log))
+	_, err = o.GetObjectOwnership("my-bucket", "backups/my-backup/my-backup.tar.gz")
+	req.ErrorContains(err, "invalid recorded gid")
+
+	_, err = o.GetObjectOwnership("my-bucket", "missing")
+	req.ErrorIs(err, ErrObjectNotFound)
+}
@@ -0,0 +1,236 @@
+package plugin
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// listPageReadSize is the number of directory entries read at a time when listing a page of objects.
+const listPageReadSize = 1000
+
+// ListObjectsPaged returns at most maxKeys keys starting with the prefix that come after marker, in the order of
+// compareKeys: depth first, with the segments of each directory of keys sorted by name. ListObjects returns keys in
+// the order of the files storing them instead, which only matches with the flat keyLayout and without compression:
+// the suffix of compressed files and the fanout directories of the hashed layout change the order of their keys.
+// If more keys remain, nextMarker is the marker for the next page; otherwise it is empty.
+// Directories entirely before the marker are not read, but all the names of each directory read are held in memory
+// to be sorted, along with those of the directories above it. With the hashed layout, a directory includes the objects
+// of its fanout directories, so listing a directory of keys holds the names of all its objects at once.
+func (o *LocalVolumeObjectStore) ListObjectsPaged(bucket, prefix, marker string, maxKeys int) (keys []string, nextMarker string, err error) {
+	defer observeOperation("ListObjectsPaged", time.Now(), &err)
+
+	if maxKeys <= 0 {
+		return nil, "", errors.Errorf("invalid maxKeys %d: must be positive", maxKeys)
+	}
+
+	bucketPath := o.bucketPath(bucket)
+	prefix = normalizeKeyPrefix(prefix)
+	path, err := o.prefixPath(bucket, keyPrefixDir(prefix))
+	if err != nil {
+		return nil, "", err
+	}
+
+	log := o.log.WithFields(logrus.Fields{
+		"bucket": bucket,
+		"prefix": prefix,
+		"path":   path,
+		"marker": marker,
+	})
+	log.Debug("LocalVolumeObjectStore.ListObjectsPaged called")
+
+	if _, err := os.Lstat(path); isMissingDir(err) {
+		return nil, "", nil
+	} else if err != nil {
+		return nil, "", err
+	}
+	if err := o.checkSymlinks(bucketPath, path); err != nil {
+		return nil, "", err
+	}
+
+	l := &pagedLister{
+		bucketPath:     bucketPath,
+		prefix:         prefix,
+		marker:         marker,
+		maxKeys:        maxKeys,
+		followSymlinks: o.followSymlinks,
+		auditLog:       o.auditLog,
+		readDir:        o.readFanoutDir,
+		logicalKey:     o.logicalKey,
+		log:            log,
+	}
+
+	if err := l.walkDir(path); err != nil && err != errPageFull {
+		return nil, "", err
+	}
+
+	if l.more {
+		nextMarker = l.keys[len(l.keys)-1]
+	}
+	return l.keys, nextMarker, nil
+}
+
+// errPageFull stops the walk once a page has been filled and another key has been found.
+var errPageFull = errors.New("page is full")
+
+// pagedLister gathers a page of keys while walking a bucket.
+type pagedLister struct {
+	bucketPath     string
+	prefix         string
+	marker         string
+	maxKeys        int
+	followSymlinks bool
+	auditLog       *auditLog
+	// readDir and logicalKey list directories and name their objects according to the keyLayout
+	readDir    func(dir string) ([]fs.DirEntry, []string, error)
+	logicalKey func(key string) string
+	log        logrus.FieldLogger
+	keys       []string
+	more       bool
+}
+
+// walkDir visits the entries of the directory in sorted order, skipping subdirectories entirely before the marker
+// or outside of the prefix.
+func (l *pagedLister) walkDir(dir string) error {
+	if isDedupDir(l.bucketPath, dir) {
+		return nil
+	}
+	if dir != l.bucketPath {
+		key, err := relativeKey(l.bucketPath, dir)
+		if err != nil {
+			return err
+		}
+		key = l.logicalKey(key)
+		if !dirMayMatchPrefix(key, l.prefix) {
+			return nil
+		}
+		if l.marker != "" && compareKeys(key, l.marker) < 0 && !strings.HasPrefix(l.marker, key+"/") {
+			return nil
+		}
+	}
+
+	entries, paths, err := l.readDir(dir)
+	// Directories removed during the walk, as by concurrent deletions, hold no objects
+	if isMissingDir(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	for i, entry := range entries {
+		p := paths[i]
+		if entry.IsDir() {
+			err = l.walkDir(p)
+		} else {
+			err = l.visitFile(p, entry.Type())
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// visitFile adds the key of the object file to the page if it comes after the marker.
+func (l *pagedLister) visitFile(p string, mode fs.FileMode) error {
+	if isInternalFile(filepath.Base(p)) || l.auditLog.isAuditLogFile(p) {
+		return nil
+	}
+	if mode&fs.ModeSymlink != 0 {
+		if !l.followSymlinks {
+			l.log.Warnf("Skipping symlink %s as followSymlinks is not enabled", p)
+			return nil
+		}
+		target, err := resolveSymlinkInBucket(l.bucketPath, p)
+		if err != nil {
+			return err
+		}
+		if target == "" {
+			l.log.Warnf("Skipping symlink %s that does not resolve inside the bucket", p)
+			return nil
+		}
+		info, err := os.Stat(target)
+		if err != nil {
+			return err
+		}
+		mode = info.Mode()
+	}
+	if !mode.IsRegular() {
+		l.log.Warnf("Skipping %s as it is a %s rather than a regular file", p, fileTypeName(mode))
+		return nil
+	}
+
+	key, err := objectKey(l.bucketPath, p)
+	if err != nil {
+		return err
+	}
+	if key = l.logicalKey(key); !strings.HasPrefix(key, l.prefix) {
+		return nil
+	}
+	if l.marker != "" && compareKeys(key, l.marker) <= 0 {
+		return nil
+	}
+	if len(l.keys) == l.maxKeys {
+		l.more = true
+		return errPageFull
+	}
+	l.keys = append(l.keys, key)
+	return nil
+}
+
+// readDirSorted reads the entries of the directory a batch at a time and returns them sorted as the keys they hold.
+// Every entry of the directory is held in memory, as all of them must be read before they can be sorted.
+func readDirSorted(dir string) ([]fs.DirEntry, error) {
+	f, err := os.Open(dir)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []fs.DirEntry
+	for {
+		batch, err := f.ReadDir(listPageReadSize)
+		entries = append(entries, batch...)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+	}
+	sort.SliceStable(entries, func(i, j int) bool { return entryKeyLess(entries[i], entries[j]) })
+	return entries, nil
+}
+
+// entryKeyLess orders directory entries by the key segment they hold, the name of a file without its compression
+// suffix, so that they are walked in the order of compareKeys. An object sorts before a directory of the same name,
+// as its key is a prefix of the keys beneath it.
+func entryKeyLess(a, b fs.DirEntry) bool {
+	aName, bName := a.Name(), b.Name()
+	if !a.IsDir() {
+		aName = objectNameFromFile(aName)
+	}
+	if !b.IsDir() {
+		bName = objectNameFromFile(bName)
+	}
+	if aName != bName {
+		return aName < bName
+	}
+	return !a.IsDir() && b.IsDir()
+}
+
+// compareKeys orders keys by comparing their path segments in turn, which is the order a depth first walk visits them in.
+func compareKeys(a, b string) int {
+	as, bs := strings.Split(a, "/"), strings.Split(b, "/")
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		if c := strings.Compare(as[i], bs[i]); c != 0 {
+			return c
+		}
+	}
+	return len(as) - len(bs)
+}
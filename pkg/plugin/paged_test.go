@@ -0,0 +1,143 @@
+package plugin
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_ListObjectsPaged(t *testing.T) {
+	keys := []string{
+		"backups/a/a-logs.gz",
+		"backups/a/velero-backup.json",
+		"backups/a-b/velero-backup.json",
+		"backups/b/velero-backup.json",
+		"restores/r/restore-logs.gz",
+	}
+
+	tests := []struct {
+		name           string
+		prefix         string
+		marker         string
+		maxKeys        int
+		wantKeys       []string
+		wantNextMarker string
+	}{
+		{
+			name:     "all keys fit in one page",
+			maxKeys:  10,
+			wantKeys: keys,
+		},
+		{
+			name:     "page exactly the number of keys",
+			maxKeys:  5,
+			wantKeys: keys,
+		},
+		{
+			name:           "first page",
+			maxKeys:        2,
+			wantKeys:       keys[:2],
+			wantNextMarker: keys[1],
+		},
+		{
+			name:           "page after a marker inside a directory",
+			marker:         keys[1],
+			maxKeys:        2,
+			wantKeys:       keys[2:4],
+			wantNextMarker: keys[3],
+		},
+		{
+			name:     "last page",
+			marker:   keys[3],
+			maxKeys:  2,
+			wantKeys: keys[4:],
+		},
+		{
+			name:    "exhausted",
+			marker:  keys[4],
+			maxKeys: 2,
+		},
+		{
+			name:     "directory prefix",
+			prefix:   "backups/a/",
+			maxKeys:  10,
+			wantKeys: keys[:2],
+		},
+		{
+			name:     "partial segment prefix",
+			prefix:   "backups/a",
+			maxKeys:  10,
+			wantKeys: keys[:3],
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := require.New(t)
+			o, _ := newTestObjectStore(t)
+			for _, key := range keys {
+				req.NoError(o.PutObject("my-bucket", key, bytes.NewReader([]byte(key))))
+			}
+
+			gotKeys, gotNextMarker, err := o.ListObjectsPaged("my-bucket", tt.prefix, tt.marker, tt.maxKeys)
+			req.NoError(err)
+			req.Equal(tt.wantKeys, gotKeys)
+			req.Equal(tt.wantNextMarker, gotNextMarker)
+		})
+	}
+}
+
+func Test_ListObjectsPaged_allPages(t *testing.T) {
+	req := require.New(t)
+	o, _ := newTestObjectStore(t)
+	for _, key := range []string{"backups/a/1", "backups/a/2", "backups/b/1", "backups/c"} {
+		req.NoError(o.PutObject("my-bucket", key, bytes.NewReader([]byte(key))))
+	}
+
+	want, err := o.ListObjects("my-bucket", "")
+	req.NoError(err)
+
+	var got []string
+	marker := ""
+	for {
+		keys, nextMarker, err := o.ListObjectsPaged("my-bucket", "", marker, 1)
+		req.NoError(err)
+		got = append(got, keys...)
+		if nextMarker == "" {
+			break
+		}
+		marker = nextMarker
+	}
+	req.Equal(want, got)
+
+	_, _, err = o.ListObjectsPaged("my-bucket", "", "", 0)
+	req.Error(err)
+}
+
+func Test_ListObjectsPaged_compressed(t *testing.T) {
+	for _, layout := range []string{"", "hashed"} {
+		t.Run("keyLayout="+layout, func(t *testing.T) {
+			req := require.New(t)
+			o, _ := newTestObjectStore(t)
+			req.NoError(o.applyConfig(map[string]string{"keyLayout": layout, "compression": compressionGzip}))
+			// The files of p/foo-bar and p/foo-bar/x sort before that of p/foo by name
+			keys := []string{"p/foo", "p/foo-bar", "p/foo.d/x", "p/foo/x", "p/fo"}
+			for _, key := range keys {
+				req.NoError(o.PutObject("my-bucket", key, bytes.NewReader([]byte(key))))
+			}
+
+			var got []string
+			marker := ""
+			for {
+				page, nextMarker, err := o.ListObjectsPaged("my-bucket", "", marker, 1)
+				req.NoError(err)
+				got = append(got, page...)
+				if nextMarker == "" {
+					break
+				}
+				marker = nextMarker
+			}
+			req.Equal([]string{"p/fo", "p/foo", "p/foo/x", "p/foo-bar", "p/foo.d/x"}, got)
+		})
+	}
+}
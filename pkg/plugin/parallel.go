@@ -0,0 +1,120 @@
+package plugin
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// parallelUploadSource returns a reader over the remaining body if it can be written with multiple workers.
+// That requires parallelism to be configured, the object to be stored uncompressed and unencrypted,
+// and the body to support concurrent reads at arbitrary offsets. Otherwise nil is returned.
+func (o *LocalVolumeObjectStore) parallelUploadSource(body io.Reader) *io.SectionReader {
+	if o.uploadParallelism <= 1 || o.compression != "" || o.getEncryptionKey() != nil {
+		return nil
+	}
+	type readSeekerAt interface {
+		io.ReaderAt
+		io.Seeker
+	}
+	rs, ok := body.(readSeekerAt)
+	if !ok {
+		return nil
+	}
+
+	start, err := rs.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil
+	}
+	end, err := rs.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil
+	}
+	if _, err := rs.Seek(start, io.SeekStart); err != nil {
+		return nil
+	}
+	if end-start <= int64(o.copyBufferSize) {
+		return nil
+	}
+
+	return io.NewSectionReader(rs, start, end-start)
+}
+
+// writeParallel writes the source to the file in chunks of copyBufferSize,
+// with uploadParallelism workers each writing to their own offset range of the pre-allocated file.
+// The checksum is computed from a sequential read of the source alongside the workers.
+func (o *LocalVolumeObjectStore) writeParallel(file *os.File, src *io.SectionReader) (string, error) {
+	size := src.Size()
+	if err := file.Truncate(size); err != nil {
+		return "", errors.Wrap(err, "failed to allocate object")
+	}
+
+	chunkSize := int64(o.copyBufferSize)
+	offsets := make(chan int64)
+	errs := make(chan error, o.uploadParallelism+1)
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+
+	for i := 0; i < o.uploadParallelism; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			buf := getCopyBuffer(o.copyBufferSize)
+			defer putCopyBuffer(buf)
+			for offset := range offsets {
+				n, err := src.ReadAt((*buf)[:min(chunkSize, size-offset)], offset)
+				if err != nil && !(err == io.EOF && int64(n) == size-offset) {
+					errs <- errors.Wrapf(err, "failed to read object at offset %d", offset)
+					return
+				}
+				if _, err := file.WriteAt((*buf)[:n], offset); err != nil {
+					errs <- errors.Wrapf(err, "failed to write object at offset %d", offset)
+					return
+				}
+			}
+		}()
+	}
+
+	hash := sha256.New()
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if _, err := copyBuffered(hash, io.NewSectionReader(src, 0, size), o.copyBufferSize); err != nil {
+			errs <- errors.Wrap(err, "failed to checksum object")
+		}
+	}()
+
+	go func() {
+		defer close(offsets)
+		for offset := int64(0); offset < size; offset += chunkSize {
+			select {
+			case offsets <- offset:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	go func() {
+		wg.Wait()
+		close(errs)
+	}()
+
+	// Stop handing out chunks on the first failure; the workers drain and exit once offsets is closed
+	var firstErr error
+	for err := range errs {
+		if firstErr == nil {
+			firstErr = err
+			close(done)
+		}
+	}
+	if firstErr != nil {
+		return "", firstErr
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
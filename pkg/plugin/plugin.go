@@ -1,18 +1,24 @@
 package plugin
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io"
-	"io/ioutil"
-	"net/url"
+	"io/fs"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/pkg/errors"
 	"github.com/replicatedhq/local-volume-provider/pkg/k8sutil"
 	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/client-go/kubernetes"
 )
 
 // Velero doesn't allow other non-velero directories in the root of the object store.
@@ -20,69 +26,398 @@ import (
 var directoryDenyList = []string{"lost+found"}
 
 type LocalVolumeObjectStore struct {
-	log        logrus.FieldLogger
-	volumeType VolumeType
-	opts       *localVolumeObjectStoreOpts
+	log               logrus.FieldLogger
+	baseLog           logrus.FieldLogger
+	volumeType        VolumeType
+	opts              *localVolumeObjectStoreOpts
+	verifyChecksums   bool
+	copyBufferSize    int
+	compression       string
+	compressionLevel  int
+	keyLayout         string
+	maxRetries        int
+	uploadParallelism int
+	durableWrites     bool
+	syncEveryBytes    int64
+	rootSubPath       string
+	dedup             string
+	minFreeBytes      int64
+	// maxObjectSizeBytes caps the size of an object body, unlimited if zero
+	maxObjectSizeBytes int64
+	auditLogPath       string
+	auditLogMaxBytes   int64
+	auditLog           *auditLog
+	tmpFileMaxAge      time.Duration
+	readOnly           bool
+	extraSubdirs       []string
+	followSymlinks     bool
+	verifyWorkers      int
+	prefetchWorkers    int
+	importWorkers      int
+
+	// progressInterval is how often uploads in progress are logged, never if zero
+	progressInterval time.Duration
+	// preserveOwnership applies the ownership objects are put with to their files, see PutObjectWithOwnership
+	preserveOwnership bool
+	// requireRemoteMount fails Init rather than warning when the volume is not mounted over the share
+	requireRemoteMount bool
+	// dryRun logs the changes Init would make to the Velero deployment and node-agent daemonset instead of making them
+	dryRun bool
+	// validateSignedURLReachability checks the fileserver accepts connections before returning a signed URL to it
+	validateSignedURLReachability bool
+	// directIO writes objects with O_DIRECT, warning once if the volume does not support it
+	directIO            bool
+	directIOUnsupported sync.Once
+	// treatEmptyAsMissing reports zero-byte objects, as left by truncated writes, as missing from ObjectExists
+	treatEmptyAsMissing bool
+	// validateOnExists reports objects failing ValidateObject as missing from ObjectExists
+	validateOnExists bool
+	// statTimeout bounds the stats of ObjectExists and HeadObject, so they fail rather than hang on a dead NFS mount
+	statTimeout time.Duration
+	// stats limits the stats with a statTimeout left blocked on a dead mount
+	stats statLimiter
+	// apiRetryTimeout is how long Init retries Kubernetes API requests failing with a transient error
+	apiRetryTimeout time.Duration
+	// veleroNamespace overrides the namespace Velero is looked up in, see resolveVeleroNamespace
+	veleroNamespace string
+	// prefix is the location prefix Init creates the subdirectory layout under
+	prefix string
+	// worm makes objects read-only once written, and refuses to delete or replace them within wormRetention
+	worm          bool
+	wormRetention time.Duration
+	// wormImmutable also sets the immutable flag of WORM objects, warning once if it cannot be set
+	wormImmutable        bool
+	immutableUnsupported sync.Once
+	// defaultACL is the binary access ACL set on objects once written, warning once if the volume does not support ACLs
+	defaultACL     []byte
+	aclUnsupported sync.Once
+
+	retentionRules         []retentionRule
+	retentionSweepInterval time.Duration
+	retentionStop          chan struct{}
+	retentionDone          chan struct{}
+
+	// closeMu guards closed, which stops new writes from being added to writes once Close is called
+	closeMu sync.Mutex
+	closed  bool
+	writes  sync.WaitGroup
+
+	// keyLocks serializes writes to the same object path
+	keyLocks keyLocks
+
+	usageCache *UsageCache
 }
 
 // NewLocalVolumeObjectStore instantiates a LocalVolumeObjectStore with a particular target volume type.
 func NewLocalVolumeObjectStore(log logrus.FieldLogger, v VolumeType) *LocalVolumeObjectStore {
 	return &LocalVolumeObjectStore{
-		log:        log,
-		volumeType: v,
+		log:               configureLogger(log, defaultLogLevel, ""),
+		baseLog:           log,
+		volumeType:        v,
+		copyBufferSize:    defaultCopyBufferSize,
+		maxRetries:        defaultMaxRetries,
+		uploadParallelism: 1,
+		durableWrites:     true,
+		tmpFileMaxAge:     defaultTmpFileMaxAge,
+		verifyWorkers:     defaultVerifyWorkers,
+		prefetchWorkers:   defaultPrefetchWorkers,
+		importWorkers:     defaultImportWorkers,
+		usageCache:        NewUsageCache(defaultUsageCacheTTL),
+		apiRetryTimeout:   defaultAPIRetryTimeout,
 	}
 }
 
 // Init initializes the plugin. It can be called multiple times.
+// A failure is recorded as an event on the Velero deployment, which is removed once Init succeeds.
 // It is part of the Velero plugin interface.
 func (o *LocalVolumeObjectStore) Init(config map[string]string) error {
+	err := o.init(config)
+	o.reportInitResult(config["bucket"], err)
+	return err
+}
+
+func (o *LocalVolumeObjectStore) init(config map[string]string) error {
 	bucket := config["bucket"]
 	prefix := config["prefix"]
-	path := filepath.Join(getRoot(), bucket)
 
 	log := o.log.WithFields(logrus.Fields{
 		"bucket": bucket,
-		"path":   path,
 		"prefix": prefix,
 	})
 	log.Debug("LocalVolumeObjectStore.Init called")
 
+	// The plugin config map is read with retries from the Velero namespace, so both are taken from the location config
+	apiRetryTimeout, err := parseAPIRetryTimeout(config["apiRetryTimeout"])
+	if err != nil {
+		return errors.Wrap(err, "invalid configuration")
+	}
+	o.apiRetryTimeout = apiRetryTimeout
+	o.veleroNamespace = config["veleroNamespace"]
+	o.prefix = prefix
+
 	if err := o.getLocalVolumeStoreOpts(); err != nil {
 		return errors.Wrap(err, "failed to get local volume configuration")
 	}
 
-	if err := ensureFilesystem(path, prefix, log); err != nil {
-		return errors.Wrap(err, "failed to ensure filesystem")
+	root := o.getRootPath()
+	if err := validateRoot(root); err != nil {
+		return errors.Wrap(err, "invalid root path")
+	}
+	path := filepath.Join(root, bucket)
+	log = log.WithField("path", path)
+
+	config = withBucketConfig(config, o.opts.bucketConfigs[bucket])
+
+	if err := o.applyConfig(config); err != nil {
+		return errors.Wrap(err, "invalid configuration")
+	}
+	log = o.log.WithFields(logrus.Fields{
+		"bucket": bucket,
+		"prefix": prefix,
+		"path":   path,
+	})
+
+	if err := validateVolumeConfig(o.volumeType, config); err != nil {
+		return errors.Wrap(err, "invalid volume configuration")
 	}
 
-	clientset, err := k8sutil.GetClientset()
+	var clientset kubernetes.Interface
+	err = retryAPI(o.apiRetryTimeout, log, func() error {
+		var err error
+		clientset, err = k8sutil.GetClientset()
+		return err
+	})
 	if err != nil {
 		return errors.Wrap(err, "failed to get kubernetes clientset")
 	}
 
+	// Velero may initialize several locations at once, or run several replicas
+	var release func()
+	err = retryAPI(o.apiRetryTimeout, log, func() error {
+		var err error
+		release, err = acquireInitLock(clientset, o.getVeleroNamespace(), log)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	if o.readOnly {
+		// Nothing is written to the volume of a read-only location, and once it is mounted the deployment is left as it is
+		if info, err := os.Stat(path); err == nil && info.IsDir() {
+			log.Debug("Volume of read-only location is already mounted")
+			return nil
+		}
+		log.Info("Volume of read-only location is not mounted, mounting it")
+	} else {
+		if err := ensureFilesystem(path, filepath.Join(o.rootSubPath, prefix), o.getSubDirectoryLayout(), o.getDirMode(), log); err != nil {
+			return errors.Wrap(err, "failed to ensure filesystem")
+		}
+
+		if err := o.openAuditLog(path); err != nil {
+			return err
+		}
+
+		// Uploads interrupted by a crash leave their temporary files behind
+		if removed, err := removeStaleTempFiles(path, o.tmpFileMaxAge, log); err != nil {
+			log.WithError(err).Warn("Failed to remove stale temporary files")
+		} else if removed > 0 {
+			log.Infof("Removed %d stale temporary files", removed)
+		}
+
+		o.stopRetentionSweeper()
+		if len(o.retentionRules) > 0 {
+			o.startRetentionSweeper(bucket, o.retentionRules, o.retentionSweepInterval, log)
+		}
+	}
+
 	ensureResourcesOpts := EnsureResourcesOpts{
 		clientset:  clientset,
-		namespace:  os.Getenv("VELERO_NAMESPACE"),
+		namespace:  o.getVeleroNamespace(),
 		bucket:     bucket,
 		prefix:     prefix,
 		path:       path,
 		config:     config,
 		pluginOpts: o.opts,
 		volumeType: o.volumeType,
+		dryRun:     o.dryRun,
 		log:        log,
 	}
 
-	if err := ensureResources(ensureResourcesOpts); err != nil {
+	// Each attempt fetches the resources again, so a partially applied attempt is completed by the next one
+	var updated bool
+	err = retryAPI(o.apiRetryTimeout, log, func() error {
+		var err error
+		updated, err = ensureResources(ensureResourcesOpts)
+		return err
+	})
+	if err != nil {
 		return errors.Wrap(err, "failed to ensure resources")
 	}
 
+	// Until the pods restart with the volume the path is expected to be on the container filesystem,
+	// and in a dry run they never will
+	if !updated {
+		if err := o.checkVolumeMount(path, log); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
+// checkVolumeMount warns that objects will be lost when the pod restarts if the bucket path of a remote volume
+// is not on the NFS or SMB share, or returns an error if requireRemoteMount is set. Each bucket is mounted
+// at its own path, so the path is checked rather than the root.
+func (o *LocalVolumeObjectStore) checkVolumeMount(path string, log logrus.FieldLogger) error {
+	mounts, err := readMounts(mountsPath)
+	if err != nil {
+		log.WithError(err).Warn("Failed to read the mount table, not checking that the volume is mounted")
+		return nil
+	}
+
+	err = checkMount(o.volumeType, path, mounts)
+	if err == nil {
+		return nil
+	}
+	if o.requireRemoteMount {
+		return errors.Wrap(err, "volume is not mounted")
+	}
+	log.WithError(err).Warn("VOLUME IS NOT MOUNTED: objects are written to the container filesystem and will be lost when the pod restarts")
+	return nil
+}
+
+// bucketPath returns the directory holding the objects of the bucket, beneath the configured rootSubPath of its volume.
+func (o *LocalVolumeObjectStore) bucketPath(bucket string) string {
+	return filepath.Join(o.getRootPath(), bucket, o.rootSubPath)
+}
+
+// objectPath returns the path the object with the key is stored at within the bucket, beneath the configured
+// rootSubPath of its volume and, with the hashed keyLayout, within the fanout directories of the key.
+// It returns ErrPathTraversal if the bucket or key would resolve outside of their roots, and ErrReservedKey
+// if the key names one of the files stored alongside objects.
+func (o *LocalVolumeObjectStore) objectPath(bucket, key string) (string, error) {
+	if err := checkReservedKey(key); err != nil {
+		return "", err
+	}
+	path, err := o.prefixPath(bucket, key)
+	if err != nil {
+		return "", err
+	}
+	return o.storedPath(o.bucketPath(bucket), path)
+}
+
+// prefixPath returns the path of the directory named by a key prefix within the bucket, beneath the configured
+// rootSubPath of its volume. Directories are never fanned out, whatever the keyLayout.
+// It returns ErrPathTraversal if the bucket or prefix would resolve outside of their roots.
+func (o *LocalVolumeObjectStore) prefixPath(bucket, key string) (string, error) {
+	if o.rootSubPath == "" {
+		return resolveKeyPath(o.getRootPath(), bucket, key)
+	}
+	if _, err := resolveKeyPath(o.getRootPath(), bucket, o.rootSubPath); err != nil {
+		return "", err
+	}
+	return resolveKeyPath(o.getRootPath(), filepath.Join(bucket, o.rootSubPath), key)
+}
+
 // PutObject puts an object into the LocalVolumeObjectStore.
+// The object is written to a temporary file and renamed into place once complete,
+// so a crash mid-upload never leaves a partial object at the final path.
+// Writes failing with a transient NFS error are retried, which requires the body to be an io.Seeker
+// once any of it has been read; otherwise only failures before the body is read are retried.
+// With hardlink deduplication the object is still written in full, then replaced by a link if its content is already stored.
 // It is part of the Velero plugin interface.
 func (o *LocalVolumeObjectStore) PutObject(bucket string, key string, body io.Reader) error {
-	path := filepath.Join(getRoot(), bucket, key)
+	return o.PutObjectCtx(context.Background(), bucket, key, body)
+}
+
+// PutObjectCtx is PutObject with a context. The write is abandoned and its temporary file removed
+// once the context is done, which is noticed between reads of the body.
+// If the volume is full or over quota the error wraps ErrStorageFull. When the size of the body is known
+// this is checked before writing, leaving the configured minFreeBytes free.
+func (o *LocalVolumeObjectStore) PutObjectCtx(ctx context.Context, bucket string, key string, body io.Reader) error {
+	return o.putObject(ctx, bucket, key, body, putOptions{})
+}
+
+// PutObjectWithModTime is PutObject for objects migrated from elsewhere, setting the modification time
+// of the object to modTime rather than the time it was written, so age based retention and listings
+// by last modified time see its original age. A zero modTime leaves the time of the write.
+// With hardlink deduplication the time is shared by every object with the same content.
+func (o *LocalVolumeObjectStore) PutObjectWithModTime(bucket, key string, body io.Reader, modTime time.Time) error {
+	return o.putObject(context.Background(), bucket, key, body, putOptions{modTime: modTime})
+}
+
+// PutObjectIfAbsent is PutObject creating the object only if the key does not exist yet, so that retried uploads
+// never replace an object already written. It returns false without an error if the key exists, in which case the
+// body is not read. The object is linked rather than renamed into place, so this holds against concurrent writers
+// in other processes too, on volumes supporting hard links.
+func (o *LocalVolumeObjectStore) PutObjectIfAbsent(bucket, key string, body io.Reader) (created bool, err error) {
+	err = o.putObject(context.Background(), bucket, key, body, putOptions{ifAbsent: true})
+	if errors.Is(err, errObjectExists) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// PutObjectWithChecksum is PutObject checking that the content streamed matches expectedSHA256, the hex encoded
+// SHA-256 of the object as known to the uploader, before the object is renamed into place. On a mismatch the
+// partial object is removed, any object already at the key is kept, and the error wraps ErrChecksumMismatch.
+func (o *LocalVolumeObjectStore) PutObjectWithChecksum(bucket, key string, body io.Reader, expectedSHA256 string) error {
+	expected := strings.ToLower(expectedSHA256)
+	if decoded, err := hex.DecodeString(expected); err != nil || len(decoded) != sha256.Size {
+		return errors.Errorf("invalid expected checksum %q: must be a hex encoded SHA-256", expectedSHA256)
+	}
+	return o.putObject(context.Background(), bucket, key, body, putOptions{expectedDigest: expected})
+}
+
+// errObjectExists is returned by putObject with ifAbsent when the key already has an object.
+var errObjectExists = errors.New("object already exists")
+
+// putOptions changes how putObject writes an object.
+type putOptions struct {
+	// modTime is set as the modification time of the object, unless it is zero
+	modTime time.Time
+	// ifAbsent fails with errObjectExists rather than replacing an existing object
+	ifAbsent bool
+	// owner is set as the ownership of the object with preserveOwnership, unless it is nil
+	owner *Ownership
+	// expectedDigest fails the write with ErrChecksumMismatch unless the content has this checksum, if set
+	expectedDigest string
+}
+
+// putObject writes the object with the options.
+func (o *LocalVolumeObjectStore) putObject(ctx context.Context, bucket string, key string, body io.Reader, opts putOptions) (err error) {
+	defer func(start time.Time) {
+		// A create-only write finding the object has not failed
+		observed := err
+		if errors.Is(err, errObjectExists) {
+			observed = nil
+		}
+		ObserveOperation("PutObject", start, observed)
+	}(time.Now())
+	if o.readOnly {
+		return ErrReadOnly
+	}
+	defer func() {
+		if errors.Is(err, ErrStorageFull) {
+			metrics.storageFull.Inc()
+		} else if isStorageFullError(err) {
+			metrics.storageFull.Inc()
+			err = fmt.Errorf("%w: %w", ErrStorageFull, err)
+		}
+	}()
+
+	done, err := o.beginWrite()
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	path, err := o.objectPath(bucket, key)
+	if err != nil {
+		return err
+	}
 
 	log := o.log.WithFields(logrus.Fields{
 		"bucket": bucket,
@@ -91,30 +426,349 @@ func (o *LocalVolumeObjectStore) PutObject(bucket string, key string, body io.Re
 	})
 	log.Debug("LocalVolumeObjectStore.PutObject called")
 
+	// Concurrent writes to one key each stage their own temporary file; serializing them means the
+	// last writer's rename wins and its checksum and dedup links always match the content in place
+	defer o.keyLocks.lock(path)()
+
+	var written int64
+	defer func() { o.auditLog.record(log, "PutObject", written, err) }()
+
+	if opts.ifAbsent {
+		if _, _, err := findObjectFile(path); err == nil {
+			return errObjectExists
+		} else if !errors.Is(err, ErrObjectNotFound) {
+			return err
+		}
+	}
+
+	if err := o.checkWORMRetention(path); err != nil {
+		return err
+	}
+
+	// The object being replaced may be the last link to a deduplicated blob
+	defer releaseDedupBlobs(linkedDedupBlobs(o.bucketPath(bucket), path), log)
+
+	// Writing through a symlink could replace or create files outside of the bucket
 	dir := filepath.Dir(path)
+	if err := o.checkSymlinks(o.bucketPath(bucket), dir); err != nil {
+		return err
+	}
 	log.Debugf("Creating dir %s", dir)
-	if err := os.MkdirAll(dir, 0755); err != nil {
+	if err := mkdirAll(dir, o.getDirMode()); err != nil {
 		return err
 	}
 
-	log.Debug("Creating file")
-	file, err := os.Create(path)
+	// Fail early rather than after writing most of a large object. For compressed or encrypted objects
+	// the body size is only an estimate of the space needed.
+	if size, ok := bodySize(body); ok {
+		if o.maxObjectSizeBytes > 0 && size > o.maxObjectSizeBytes {
+			return errors.Wrapf(ErrObjectTooLarge, "%d bytes", size)
+		}
+		if err := checkFreeSpace(dir, size, o.minFreeBytes); err != nil {
+			return err
+		}
+	}
+
+	filePath := compressedPath(path, o.compression)
+	if err := o.checkSymlinks(o.bucketPath(bucket), filePath); err != nil {
+		return err
+	}
+
+	progress := o.startPutProgress(log)
+	defer progress.finish()
+
+	if section := o.parallelUploadSource(body); section != nil {
+		log.Debugf("Writing with %d workers", o.uploadParallelism)
+		var digest string
+		err = retryTransient(o.maxRetries, log, func() error {
+			progress.reset()
+			var err error
+			digest, err = writeObjectFile(filePath, o.getFileMode(), opts.ifAbsent, log, func(file *os.File) (string, error) {
+				src := &progressReaderAt{ReaderAt: &contextReaderAt{ctx: ctx, ReaderAt: section}, progress: progress}
+				digest, err := o.writeParallel(file, io.NewSectionReader(src, 0, section.Size()))
+				if err != nil {
+					return "", err
+				}
+				return digest, checkDigest(digest, opts.expectedDigest)
+			})
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		written = section.Size()
+		ObserveBytes("PutObject", written)
+		if err := o.finishPutObject(bucket, path, filePath, digest, log); err != nil {
+			return err
+		}
+		if err := setModTime(filePath, opts.modTime); err != nil {
+			return err
+		}
+		if err := o.applyOwnership(path, filePath, opts.owner, log); err != nil {
+			return err
+		}
+		if err := o.applyDefaultACL(filePath, log); err != nil {
+			return err
+		}
+		return o.protectWORMObject(filePath, log)
+	}
+
+	// A failed attempt can only be retried if the body can be rewound to where it started
+	seeker, seekable := body.(io.Seeker)
+	var bodyStart int64
+	if seekable {
+		if bodyStart, err = seeker.Seek(0, io.SeekCurrent); err != nil {
+			seekable = false
+		}
+	}
+	counted := &countingReader{Reader: &progressReader{Reader: &contextReader{ctx: ctx, Reader: body}, progress: progress}}
+
+	var digest string
+	err = retryTransient(o.maxRetries, log, func() error {
+		if counted.n > 0 {
+			if _, err := seeker.Seek(bodyStart, io.SeekStart); err != nil {
+				return permanentError{errors.Wrap(err, "failed to rewind body")}
+			}
+			counted.n = 0
+			progress.reset()
+		}
+
+		var err error
+		digest, err = writeObjectFile(filePath, o.getFileMode(), opts.ifAbsent, log, func(file *os.File) (string, error) {
+			// The limit starts over with the body on every attempt
+			digest, err := o.writeSequential(file, o.limitBody(counted), log)
+			if err != nil {
+				return "", err
+			}
+			return digest, checkDigest(digest, opts.expectedDigest)
+		})
+		if err != nil && counted.n > 0 && !seekable {
+			return permanentError{err}
+		}
+		return err
+	})
+	written = counted.n
+	ObserveBytes("PutObject", written)
 	if err != nil {
 		return err
 	}
-	defer file.Close()
 
-	log.Debug("Writing to file")
-	_, err = io.Copy(file, body)
+	if err := o.finishPutObject(bucket, path, filePath, digest, log); err != nil {
+		return err
+	}
+	if err := setModTime(filePath, opts.modTime); err != nil {
+		return err
+	}
+	if err := o.applyOwnership(path, filePath, opts.owner, log); err != nil {
+		return err
+	}
+	if err := o.applyDefaultACL(filePath, log); err != nil {
+		return err
+	}
+	return o.protectWORMObject(filePath, log)
+}
+
+// checkDigest returns an error wrapping ErrChecksumMismatch if the digest of a written object is not the expected one.
+// An empty expected digest is not checked. A mismatch is not transient, so the write is not retried.
+func checkDigest(digest, expected string) error {
+	if expected == "" || digest == expected {
+		return nil
+	}
+	return fmt.Errorf("%w: expected %s, got %s", ErrChecksumMismatch, expected, digest)
+}
+
+// setModTime sets the access and modification times of the file to modTime, unless it is zero.
+func setModTime(filePath string, modTime time.Time) error {
+	if modTime.IsZero() {
+		return nil
+	}
+	if err := os.Chtimes(filePath, modTime, modTime); err != nil {
+		return errors.Wrap(err, "failed to set object modification time")
+	}
+	return nil
+}
+
+// finishPutObject removes any stale copy of a newly written object and records its checksum.
+// An empty digest removes any existing checksum, for objects copied from one that has none.
+func (o *LocalVolumeObjectStore) finishPutObject(bucket, path, filePath, digest string, log logrus.FieldLogger) error {
+	removeStaleObjectFile(path, filePath, log)
+
+	// The metadata of the object being replaced does not carry over
+	if err := os.Remove(metadataPath(path)); err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "failed to remove object metadata sidecar")
+	}
+
+	if o.dedup == dedupHardlink && digest != "" {
+		if err := o.dedupObjectFile(o.bucketPath(bucket), filePath, digest, log); err != nil {
+			return errors.Wrap(err, "failed to deduplicate object")
+		}
+	}
+
+	if digest == "" {
+		if err := removeChecksum(path); err != nil {
+			return errors.Wrap(err, "failed to remove object checksum")
+		}
+	} else {
+		log.Debug("Writing checksum")
+		if err := writeChecksum(path, digest, o.getFileMode()); err != nil {
+			return errors.Wrap(err, "failed to write object checksum")
+		}
+	}
+
+	// The renames are only durable once the directory holding them is synced
+	if o.durableWrites {
+		log.Debug("Syncing directory")
+		if err := syncDir(filepath.Dir(path)); err != nil {
+			return errors.Wrap(err, "failed to sync object directory")
+		}
+	}
 
 	log.Debug("Done")
-	return err
+	return nil
+}
+
+// removeStaleObjectFile removes any copy of the object at path stored in another form than filePath,
+// so that it is never ambiguous which one to read.
+func removeStaleObjectFile(path, filePath string, log logrus.FieldLogger) {
+	for _, stalePath := range objectFilePaths(path) {
+		if stalePath == filePath {
+			continue
+		}
+		if err := os.Remove(stalePath); err != nil && !os.IsNotExist(err) {
+			log.WithError(err).Warnf("Failed to remove stale object %s", stalePath)
+		}
+	}
+}
+
+// writeObjectFile creates a temporary file with the given mode, fills it using write and renames it to filePath
+// once it is complete and synced. It returns the hex encoded SHA256 of the uncompressed content returned by write.
+// With noReplace the file is linked to filePath instead, failing with errObjectExists if something is already there.
+func writeObjectFile(filePath string, mode os.FileMode, noReplace bool, log logrus.FieldLogger, write func(file *os.File) (string, error)) (digest string, err error) {
+	tmpPath := tempFilePath(filePath)
+	log.Debugf("Creating temporary file %s", tmpPath)
+	file, err := os.OpenFile(tmpPath, os.O_RDWR|os.O_CREATE|os.O_EXCL, mode)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		if err != nil {
+			file.Close()
+			os.Remove(tmpPath)
+		}
+	}()
+
+	// The mode given when creating the file is subject to the umask
+	if err = file.Chmod(mode); err != nil {
+		return "", errors.Wrap(err, "failed to set object file mode")
+	}
+
+	log.Debug("Writing to file")
+	if digest, err = write(file); err != nil {
+		return "", err
+	}
+	if err = file.Sync(); err != nil {
+		return "", errors.Wrap(err, "failed to sync object")
+	}
+	if err = file.Close(); err != nil {
+		return "", errors.Wrap(err, "failed to close object")
+	}
+
+	if noReplace {
+		log.Debug("Linking file into place")
+		err = os.Link(tmpPath, filePath)
+		if os.IsExist(err) {
+			return "", errObjectExists
+		}
+		if err == nil {
+			os.Remove(tmpPath)
+			return digest, nil
+		}
+		// Without hard links only writers holding the key lock are excluded
+		log.WithError(err).Debug("Failed to link object into place, renaming it")
+	}
+
+	log.Debug("Renaming file into place")
+	if err = os.Rename(tmpPath, filePath); err != nil {
+		return "", errors.Wrap(err, "failed to rename object into place")
+	}
+
+	return digest, nil
+}
+
+// writeSequential streams the body to the file, compressing it if configured.
+// With directIO the file is written bypassing the page cache, unless the volume does not support it.
+// With syncEveryBytes the file is synced each time that many bytes have been written to it.
+func (o *LocalVolumeObjectStore) writeSequential(file *os.File, body io.Reader, log logrus.FieldLogger) (string, error) {
+	var w io.Writer = file
+	var dw *directWriter
+	if o.directIO {
+		if err := setDirectIO(file, true); err != nil {
+			o.directIOUnsupported.Do(func() {
+				log.WithError(err).Warn("Direct IO is not supported by the volume, writing objects through the page cache")
+			})
+		} else {
+			dw = newDirectWriter(file, o.copyBufferSize)
+			w = dw
+		}
+	}
+	if o.syncEveryBytes > 0 {
+		w = newSyncingWriter(file, w, o.syncEveryBytes)
+	}
+	var encw io.WriteCloser
+	if key := o.getEncryptionKey(); key != nil {
+		var err error
+		if encw, err = newEncryptingWriter(w, key); err != nil {
+			return "", errors.Wrap(err, "failed to encrypt object")
+		}
+		w = encw
+	}
+	var cw io.WriteCloser
+	if o.compression != "" {
+		var err error
+		if cw, err = newCompressingWriter(w, o.compression, o.compressionLevel); err != nil {
+			return "", errors.Wrap(err, "failed to compress object")
+		}
+		w = cw
+	}
+
+	// The checksum is always of the uncompressed content
+	hash := sha256.New()
+	if _, err := copyBuffered(w, io.TeeReader(body, hash), o.copyBufferSize); err != nil {
+		return "", errors.Wrap(err, "failed to write object")
+	}
+	if cw != nil {
+		if err := cw.Close(); err != nil {
+			return "", errors.Wrap(err, "failed to compress object")
+		}
+	}
+	if encw != nil {
+		if err := encw.Close(); err != nil {
+			return "", errors.Wrap(err, "failed to encrypt object")
+		}
+	}
+	if dw != nil {
+		if err := dw.Close(); err != nil {
+			return "", errors.Wrap(err, "failed to write object")
+		}
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
 }
 
 // ObjectExists returns truthy if an object is in the LocalVolumeObjectStore.
+// If the existence of the object cannot be determined, it returns false along with the error, which wraps
+// context.DeadlineExceeded if the volume did not answer within statTimeout, or ErrStatsBlocked if earlier stats
+// are still blocked on the volume.
+// With treatEmptyAsMissing set an object without content is reported as missing, and with validateOnExists so is
+// any object failing ValidateObject, so Velero does not restore from a truncated or corrupt backup.
 // It is part of the Velero plugin interface.
-func (o *LocalVolumeObjectStore) ObjectExists(bucket, key string) (bool, error) {
-	path := filepath.Join(getRoot(), bucket, key)
+func (o *LocalVolumeObjectStore) ObjectExists(bucket, key string) (exists bool, err error) {
+	defer observeOperation("ObjectExists", time.Now(), &err)
+
+	path, err := o.objectPath(bucket, key)
+	if err != nil {
+		return false, err
+	}
 
 	log := o.log.WithFields(logrus.Fields{
 		"bucket": bucket,
@@ -123,21 +777,105 @@ func (o *LocalVolumeObjectStore) ObjectExists(bucket, key string) (bool, error)
 	})
 	log.Debug("LocalVolumeObjectStore.ObjectExists called")
 
-	_, err := os.Stat(path)
+	filePath, compression, err := o.lookupObjectFile(bucket, path)
 	if err == nil {
-		return true, nil
+		if o.validateOnExists {
+			err = o.validateObjectFile(path, filePath, compression)
+		} else if o.treatEmptyAsMissing {
+			err = o.checkObjectNotEmpty(filePath, compression)
+		}
+		if err == nil {
+			return true, nil
+		}
+	}
+	if errors.Is(err, ErrObjectNotFound) {
+		return false, nil
 	}
-	if os.IsNotExist(err) {
+	if errors.Is(err, ErrObjectEmpty) || errors.Is(err, ErrChecksumMismatch) || errors.Is(err, ErrObjectAuthentication) {
+		log.WithError(err).Warn("Object failed validation, reporting it as missing")
 		return false, nil
 	}
 
-	return true, err
+	return false, err
 }
 
-// GetObject returns truthy if an object is in the LocalVolumeObjectStore.
+// HeadObject returns whether an object is in the LocalVolumeObjectStore along with its size and modification time,
+// without opening it. As with ListObjectsWithInfo, the size is that of the object file, so for compressed or
+// encrypted objects it is the size stored on the volume. A missing object is reported as not existing, not as an error.
+// As with ObjectExists, an error wrapping context.DeadlineExceeded is returned if the volume did not answer within statTimeout.
+func (o *LocalVolumeObjectStore) HeadObject(bucket, key string) (exists bool, size int64, modTime time.Time, err error) {
+	defer observeOperation("HeadObject", time.Now(), &err)
+
+	path, err := o.objectPath(bucket, key)
+	if err != nil {
+		return false, 0, time.Time{}, err
+	}
+
+	log := o.log.WithFields(logrus.Fields{
+		"bucket": bucket,
+		"key":    key,
+		"path":   path,
+	})
+	log.Debug("LocalVolumeObjectStore.HeadObject called")
+
+	info, err := withStatTimeout(&o.stats, o.statTimeout, path, func() (fs.FileInfo, error) {
+		filePath := path
+		info, err := statObject(filePath)
+		if os.IsNotExist(err) {
+			// Compressed objects are stored with the suffix of their format
+			if filePath, _, err = findObjectFile(path); errors.Is(err, ErrObjectNotFound) {
+				return nil, nil
+			} else if err != nil {
+				return nil, err
+			}
+			info, err = statObject(filePath)
+		}
+		if isMissingDir(err) {
+			return nil, nil
+		} else if err != nil {
+			return nil, err
+		}
+		return info, o.checkSymlinks(o.bucketPath(bucket), filePath)
+	})
+	if err != nil {
+		return false, 0, time.Time{}, err
+	}
+	// A directory is only part of the keys beneath it
+	if info == nil || info.IsDir() {
+		return false, 0, time.Time{}, nil
+	}
+
+	return true, info.Size(), info.ModTime(), nil
+}
+
+// GetObject returns a reader for an object in the LocalVolumeObjectStore.
 // It is part of the Velero plugin interface.
 func (o *LocalVolumeObjectStore) GetObject(bucket, key string) (io.ReadCloser, error) {
-	path := filepath.Join(getRoot(), bucket, key)
+	return o.GetObjectCtx(context.Background(), bucket, key)
+}
+
+// GetObjectCtx is GetObject with a context. Reads from the returned reader fail once the context is done.
+func (o *LocalVolumeObjectStore) GetObjectCtx(ctx context.Context, bucket, key string) (io.ReadCloser, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	rc, _, err := o.GetObjectWithInfo(bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	return &contextReadCloser{contextReader: contextReader{ctx: ctx, Reader: rc}, closer: rc}, nil
+}
+
+// GetObjectWithInfo returns a reader for an object in the LocalVolumeObjectStore along with the number of bytes it will read,
+// so the object can be served with a Content-Length. The size is -1 for compressed or encrypted objects,
+// whose content size is only known once they have been read.
+func (o *LocalVolumeObjectStore) GetObjectWithInfo(bucket, key string) (rc io.ReadCloser, size int64, err error) {
+	defer observeOperation("GetObject", time.Now(), &err)
+
+	path, err := o.objectPath(bucket, key)
+	if err != nil {
+		return nil, 0, err
+	}
 
 	log := o.log.WithFields(logrus.Fields{
 		"bucket": bucket,
@@ -146,13 +884,92 @@ func (o *LocalVolumeObjectStore) GetObject(bucket, key string) (io.ReadCloser, e
 	})
 	log.Debug("LocalVolumeObjectStore.GetObject called")
 
-	return os.Open(path)
+	var (
+		file *objectReadCloser
+		want string
+	)
+	err = retryTransient(o.maxRetries, log, func() error {
+		filePath, compression, err := findObjectFile(path)
+		if err != nil {
+			return err
+		}
+		if err := o.checkSymlinks(o.bucketPath(bucket), filePath); err != nil {
+			return err
+		}
+		// Opening a FIFO would block until something writes to it
+		if err := checkRegularFile(filePath); err != nil {
+			return err
+		}
+
+		// The content is verified as it is read, rather than read once more up front
+		if o.verifyChecksums {
+			if want, err = readChecksum(path); err != nil {
+				return errors.Wrap(err, "failed to read object checksum")
+			}
+		}
+
+		file, err = openObjectFile(filePath, compression, o.getEncryptionKey())
+		return err
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	size, err = file.contentSize()
+	if err != nil {
+		file.Close()
+		return nil, 0, err
+	}
+
+	rc = file
+	if o.verifyChecksums {
+		log.Debug("Verifying checksum while the object is read")
+		rc = newVerifyingReadCloser(rc, want)
+	}
+	return &meteredReadCloser{ReadCloser: rc, operation: "GetObject"}, size, nil
 }
 
-// ListCommonPrefixes returns a list of subdirectories in the root of the LocalVolumeObjectStore.
+// verifyObjectChecksum reads the object file and compares its uncompressed content
+// to the checksum sidecar of the object at path.
+func (o *LocalVolumeObjectStore) verifyObjectChecksum(path, filePath, compression string) error {
+	file, err := openObjectFile(filePath, compression, o.getEncryptionKey())
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return verifyChecksum(path, file, o.copyBufferSize)
+}
+
+// ListCommonPrefixes returns the distinct key prefixes under prefix that end in the first occurrence of the delimiter
+// after it, matching S3 semantics. Each returned prefix includes prefix and the delimiter, and is only returned
+// if some object has it, so empty directories are not prefixes.
 // It is part of the Velero plugin interface.
-func (o *LocalVolumeObjectStore) ListCommonPrefixes(bucket, prefix, delimiter string) ([]string, error) {
-	path := filepath.Join(getRoot(), bucket, prefix, delimiter)
+func (o *LocalVolumeObjectStore) ListCommonPrefixes(bucket, prefix, delimiter string) (prefixes []string, err error) {
+	defer observeOperation("ListCommonPrefixes", time.Now(), &err)
+
+	if delimiter == "" {
+		return nil, nil
+	}
+	_, prefixes, err = o.listWithDelimiter(bucket, prefix, delimiter)
+	return prefixes, err
+}
+
+// listWithDelimiter splits the objects under prefix into the keys without the delimiter after the prefix, and the
+// common prefixes of the others as returned by ListCommonPrefixes. Without a delimiter every key is an object.
+// With the path separator as the delimiter the split is exactly the files and the subdirectories holding objects of
+// the prefix directory, so it is read once rather than walked, and the objects beneath the subdirectories are not.
+func (o *LocalVolumeObjectStore) listWithDelimiter(bucket, prefix, delimiter string) (objects []string, prefixes []string, err error) {
+	// Returned prefixes are built from the given one, so it must have the same form as listed keys
+	bucketPath := o.bucketPath(bucket)
+	prefix = normalizeKeyPrefix(prefix)
+
+	// All keys starting with prefix are in the directory named by its last complete path segment
+	dirPrefix := keyPrefixDir(prefix)
+	path, err := o.prefixPath(bucket, dirPrefix)
+	if err != nil {
+		return nil, nil, err
+	}
 
 	log := o.log.WithFields(logrus.Fields{
 		"bucket":    bucket,
@@ -160,27 +977,215 @@ func (o *LocalVolumeObjectStore) ListCommonPrefixes(bucket, prefix, delimiter st
 		"path":      path,
 		"prefix":    prefix,
 	})
-	log.Debug("LocalVolumeObjectStore.ListCommonPrefixes called")
+	log.Debug("LocalVolumeObjectStore.listWithDelimiter called")
+
+	if delimiter != "/" {
+		// Any other delimiter may occur anywhere in a key, so every object under the prefix has to be considered
+		infos, err := o.listObjectsWithInfo(bucket, prefix)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		seen := map[string]bool{}
+		for _, info := range infos {
+			i := -1
+			if delimiter != "" {
+				i = strings.Index(info.Key[len(prefix):], delimiter)
+			}
+			if i < 0 {
+				objects = append(objects, info.Key)
+				continue
+			}
+			if sliceContainsString(directoryDenyList, strings.SplitN(info.Key, "/", 2)[0]) {
+				continue
+			}
+			commonPrefix := info.Key[:len(prefix)+i+len(delimiter)]
+			if !seen[commonPrefix] {
+				seen[commonPrefix] = true
+				prefixes = append(prefixes, commonPrefix)
+			}
+		}
+		return objects, prefixes, nil
+	}
+
+	// A prefix without objects, such as any prefix of a new location, has no objects or common prefixes rather than failing
+	if _, err := os.Lstat(path); isMissingDir(err) {
+		return nil, nil, nil
+	} else if err != nil {
+		return nil, nil, err
+	}
+	if err := o.checkSymlinks(bucketPath, path); err != nil {
+		return nil, nil, err
+	}
 
 	dirEntries, err := os.ReadDir(path)
-	if err != nil {
-		return nil, err
+	if isMissingDir(err) {
+		return nil, nil, nil
+	} else if err != nil {
+		return nil, nil, err
 	}
 
-	var dirs []string
 	for _, dirEntry := range dirEntries {
-		if dirEntry.IsDir() && !sliceContainsString(directoryDenyList, dirEntry.Name()) {
-			dirs = append(dirs, dirEntry.Name())
+		p := filepath.Join(path, dirEntry.Name())
+		if o.isFanoutDir(dirEntry) {
+			keys, err := o.fanoutObjects(bucketPath, p, prefix, log)
+			if err != nil {
+				return nil, nil, err
+			}
+			objects = append(objects, keys...)
+			continue
+		}
+		if !dirEntry.IsDir() {
+			info, err := o.objectFileInfo(bucketPath, p, dirEntry, log)
+			if err != nil {
+				return nil, nil, err
+			}
+			if info == nil {
+				continue
+			}
+			key, err := objectKey(bucketPath, p)
+			if err != nil {
+				return nil, nil, err
+			}
+			if key = o.logicalKey(key); strings.HasPrefix(key, prefix) {
+				objects = append(objects, key)
+			}
+			continue
+		}
+
+		key := dirPrefix + dirEntry.Name()
+		if !strings.HasPrefix(key, prefix) || sliceContainsString(directoryDenyList, dirEntry.Name()) ||
+			isDedupDir(bucketPath, p) {
+			continue
+		}
+		hasObjects, err := o.hasObjects(bucketPath, p)
+		if err != nil {
+			return nil, nil, err
+		}
+		if hasObjects {
+			prefixes = append(prefixes, key+delimiter)
+		}
+	}
+	return objects, prefixes, nil
+}
+
+// errObjectFound stops the walk of hasObjects at the first object.
+var errObjectFound = errors.New("object found")
+
+// hasObjects returns truthy if the directory holds an object that would be listed, directly or in a subdirectory.
+func (o *LocalVolumeObjectStore) hasObjects(bucketPath, dir string) (bool, error) {
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		// Directories removed during the walk, as by concurrent deletions, hold no objects
+		if isMissingDir(err) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if isDedupDir(bucketPath, p) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if isInternalFile(d.Name()) || o.auditLog.isAuditLogFile(p) {
+			return nil
 		}
+		if d.Type()&fs.ModeSymlink != 0 {
+			if !o.followSymlinks {
+				return nil
+			}
+			target, err := resolveSymlinkInBucket(bucketPath, p)
+			if err != nil || target == "" {
+				return err
+			}
+			if info, err := os.Stat(target); err != nil || !info.Mode().IsRegular() {
+				return err
+			}
+		} else if !d.Type().IsRegular() {
+			return nil
+		}
+		return errObjectFound
+	})
+	if err == errObjectFound {
+		return true, nil
 	}
+	return false, err
+}
 
-	return dirs, nil
+// ObjectInfo describes an object in the LocalVolumeObjectStore.
+// Size is the size of the file on the volume, which is smaller than the object for compressed objects.
+type ObjectInfo struct {
+	Key     string
+	Size    int64
+	ModTime time.Time
 }
 
-// ListObjects returns a list of files in the LocalVolumeObjectStore.
+// ListObjects returns a list of files under the prefix in the LocalVolumeObjectStore, including those in nested directories.
+// Keys are relative to the bucket root. A prefix without a trailing slash names a directory or an object, so prefix
+// backup lists backup and the keys under backup/, but not backup2/.
 // It is part of the Velero plugin interface.
-func (o *LocalVolumeObjectStore) ListObjects(bucket, prefix string) ([]string, error) {
-	path := filepath.Join(getRoot(), bucket, prefix)
+func (o *LocalVolumeObjectStore) ListObjects(bucket, prefix string) (objects []string, err error) {
+	defer observeOperation("ListObjects", time.Now(), &err)
+
+	prefix = normalizeKeyPrefix(prefix)
+	keys, _, err := o.listWithDelimiter(bucket, prefix, "")
+	if err != nil {
+		return nil, err
+	}
+
+	for _, key := range keys {
+		if isUnderSegmentPrefix(key, prefix) {
+			objects = append(objects, key)
+		}
+	}
+
+	return objects, nil
+}
+
+// ListObjectsWithInfo returns the same files as ListObjects along with their size and modification time.
+func (o *LocalVolumeObjectStore) ListObjectsWithInfo(bucket, prefix string) (infos []ObjectInfo, err error) {
+	defer observeOperation("ListObjectsWithInfo", time.Now(), &err)
+
+	return o.listSegmentPrefix(bucket, prefix)
+}
+
+// listSegmentPrefix is listObjectsWithInfo matching a prefix without a trailing slash at path segment boundaries
+// only: the object named by the prefix and the objects beneath it, without the siblings whose names it starts.
+func (o *LocalVolumeObjectStore) listSegmentPrefix(bucket, prefix string) ([]ObjectInfo, error) {
+	prefix = normalizeKeyPrefix(prefix)
+	infos, err := o.listObjectsWithInfo(bucket, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	var matched []ObjectInfo
+	for _, info := range infos {
+		if isUnderSegmentPrefix(info.Key, prefix) {
+			matched = append(matched, info)
+		}
+	}
+	return matched, nil
+}
+
+// isUnderSegmentPrefix returns truthy if the key starts with a prefix ending in a slash, or if the prefix does not,
+// is the key the prefix names or one beneath it.
+func isUnderSegmentPrefix(key, prefix string) bool {
+	if prefix == "" || strings.HasSuffix(prefix, "/") {
+		return strings.HasPrefix(key, prefix)
+	}
+	return key == prefix || strings.HasPrefix(key, prefix+"/")
+}
+
+// listObjectsWithInfo walks the tree under the prefix and describes every object whose key starts with it.
+// As with S3, the prefix is matched against whole keys rather than path segments, as ListCommonPrefixes
+// and DeletePrefix need, and a prefix that matches no objects lists nothing rather than failing.
+func (o *LocalVolumeObjectStore) listObjectsWithInfo(bucket, prefix string) ([]ObjectInfo, error) {
+	bucketPath := o.bucketPath(bucket)
+	prefix = normalizeKeyPrefix(prefix)
+	path, err := o.prefixPath(bucket, keyPrefixDir(prefix))
+	if err != nil {
+		return nil, err
+	}
 
 	log := o.log.WithFields(logrus.Fields{
 		"bucket": bucket,
@@ -189,23 +1194,129 @@ func (o *LocalVolumeObjectStore) ListObjects(bucket, prefix string) ([]string, e
 	})
 	log.Debug("LocalVolumeObjectStore.ListObjects called")
 
-	dirEntries, err := os.ReadDir(path)
+	// Permission errors and the like are returned, so they are not mistaken for an empty prefix
+	if _, err := os.Lstat(path); isMissingDir(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := o.checkSymlinks(bucketPath, path); err != nil {
+		return nil, err
+	}
+
+	var infos []ObjectInfo
+	err = filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+		// Directories removed during the walk, as by concurrent deletions, hold no objects
+		if isMissingDir(err) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		// The objects of fanout directories belong to the directory holding them, which is being walked
+		if d.IsDir() && p != path && !o.isFanoutDir(d) {
+			if isDedupDir(bucketPath, p) {
+				return filepath.SkipDir
+			}
+			dirKey, err := relativeKey(bucketPath, p)
+			if err != nil {
+				return err
+			}
+			if !dirMayMatchPrefix(o.logicalKey(dirKey), prefix) {
+				return filepath.SkipDir
+			}
+		}
+		if d.IsDir() {
+			return nil
+		}
+		info, err := o.objectFileInfo(bucketPath, p, d, log)
+		if err != nil || info == nil {
+			return err
+		}
+
+		key, err := objectKey(bucketPath, p)
+		if err != nil {
+			return err
+		}
+		if key = o.logicalKey(key); !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+		infos = append(infos, ObjectInfo{
+			Key:     key,
+			Size:    info.Size(),
+			ModTime: info.ModTime(),
+		})
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	var objects []string
-	for _, dirEntry := range dirEntries {
-		objects = append(objects, filepath.Join(prefix, dirEntry.Name()))
+	return infos, nil
+}
+
+// objectFileInfo describes the file at p of a directory entry that is not a directory, or returns nil if it is not
+// an object: internal files are skipped, as are symlinks unless followed and anything but regular files.
+func (o *LocalVolumeObjectStore) objectFileInfo(bucketPath, p string, d fs.DirEntry, log logrus.FieldLogger) (fs.FileInfo, error) {
+	if isInternalFile(d.Name()) || o.auditLog.isAuditLogFile(p) {
+		return nil, nil
 	}
 
-	return objects, nil
+	var info fs.FileInfo
+	if d.Type()&fs.ModeSymlink != 0 {
+		if !o.followSymlinks {
+			log.Warnf("Skipping symlink %s as followSymlinks is not enabled", p)
+			return nil, nil
+		}
+		target, err := resolveSymlinkInBucket(bucketPath, p)
+		if err != nil {
+			return nil, err
+		}
+		if target == "" {
+			log.Warnf("Skipping symlink %s that does not resolve inside the bucket", p)
+			return nil, nil
+		}
+		if info, err = os.Stat(target); err != nil {
+			return nil, err
+		}
+	} else {
+		var err error
+		if info, err = d.Info(); err != nil {
+			return nil, err
+		}
+	}
+	if !info.Mode().IsRegular() {
+		log.Warnf("Skipping %s as it is a %s rather than a regular file", p, fileTypeName(info.Mode()))
+		return nil, nil
+	}
+	return info, nil
+}
+
+// resolveSymlinkInBucket returns the target of the symlink at p, or an empty string if it does not resolve inside the bucket.
+func resolveSymlinkInBucket(bucketPath, p string) (string, error) {
+	// compare resolved paths, as the bucket itself may be reached through a symlink
+	realBucketPath, err := filepath.EvalSymlinks(bucketPath)
+	if err != nil {
+		return "", err
+	}
+	target, err := filepath.EvalSymlinks(p)
+	if err != nil || !isWithinDir(realBucketPath, target) {
+		return "", nil
+	}
+	return target, nil
 }
 
 // DeleteObject removes a files from the LocalVolumeObjectStore.
 // It is part of the Velero plugin interface.
-func (o *LocalVolumeObjectStore) DeleteObject(bucket, key string) error {
-	path := filepath.Join(getRoot(), bucket, key)
+func (o *LocalVolumeObjectStore) DeleteObject(bucket, key string) (err error) {
+	defer observeOperation("DeleteObject", time.Now(), &err)
+	if o.readOnly {
+		return ErrReadOnly
+	}
+
+	path, err := o.objectPath(bucket, key)
+	if err != nil {
+		return err
+	}
 
 	log := o.log.WithFields(logrus.Fields{
 		"bucket": bucket,
@@ -214,33 +1325,183 @@ func (o *LocalVolumeObjectStore) DeleteObject(bucket, key string) error {
 	})
 	log.Debug("LocalVolumeObjectStore.DeleteObject called")
 
-	err := os.Remove(path)
+	unlock := o.keyLocks.lock(path)
+	_, removeErr := o.removeObject(o.bucketPath(bucket), path, log)
+	unlock()
+	o.auditLog.record(log, "DeleteObject", 0, removeErr)
 
-	// This logic is specific to a file system; we need to clean up the backup directory
+	// This logic is specific to a file system; we need to clean up the directories of the key
 	// if there's nothing left. "Normal" object stores only mimic directory structures and don't need this.
-	keyParts := strings.Split(key, "/")
-	var backupPath string
-	if len(keyParts) > 1 {
-		backupPath = filepath.Join(getRoot(), bucket, keyParts[0], keyParts[1])
+	// The cleanup is best-effort and never masks the result of removing the object itself.
+	o.cleanupDir(bucket, filepath.Dir(path), log)
+
+	return removeErr
+}
+
+// DeleteObjects removes the objects with the given keys from the bucket.
+// All objects are removed before the backup directories they were in are cleaned up,
+// so each directory is only checked once however many of its objects are deleted.
+// Failing keys do not stop the remaining keys from being removed; all failures are returned together.
+func (o *LocalVolumeObjectStore) DeleteObjects(bucket string, keys []string) (err error) {
+	defer observeOperation("DeleteObjects", time.Now(), &err)
+	if o.readOnly {
+		return ErrReadOnly
+	}
+
+	log := o.log.WithFields(logrus.Fields{
+		"bucket": bucket,
+		"keys":   len(keys),
+	})
+	log.Debug("LocalVolumeObjectStore.DeleteObjects called")
+
+	_, _, err = o.deleteObjects(bucket, keys, log)
+	return err
+}
+
+// DeletePrefix removes every object whose key starts with prefix, such as all the objects of a backup, in one call.
+// It returns the bytes reclaimed on the volume, which for compressed objects is the size of their files, and the
+// number of objects deleted. A deduplicated object only reclaims its size once no other object shares its content.
+// Failing objects do not stop the remaining ones from being removed; all failures are returned together.
+func (o *LocalVolumeObjectStore) DeletePrefix(bucket, prefix string) (reclaimedBytes int64, deleted int, err error) {
+	defer observeOperation("DeletePrefix", time.Now(), &err)
+	if o.readOnly {
+		return 0, 0, ErrReadOnly
 	}
-	if backupPath != "" {
-		infos, err := ioutil.ReadDir(backupPath)
+	// Deleting the whole bucket is never what was meant
+	if normalizeKeyPrefix(prefix) == "" {
+		return 0, 0, errors.New("prefix must not be empty")
+	}
+
+	log := o.log.WithFields(logrus.Fields{
+		"bucket": bucket,
+		"prefix": prefix,
+	})
+	log.Debug("LocalVolumeObjectStore.DeletePrefix called")
+
+	infos, err := o.listObjectsWithInfo(bucket, prefix)
+	if err != nil {
+		return 0, 0, err
+	}
+	keys := make([]string, 0, len(infos))
+	for _, info := range infos {
+		keys = append(keys, info.Key)
+	}
+
+	reclaimedBytes, deleted, err = o.deleteObjects(bucket, keys, log)
+	log.Infof("Deleted %d objects, reclaiming %d bytes", deleted, reclaimedBytes)
+	return reclaimedBytes, deleted, err
+}
+
+// deleteObjects removes the objects with the given keys, then cleans up the directories they were in.
+// It returns the bytes reclaimed and the number of objects removed, along with all failures together.
+func (o *LocalVolumeObjectStore) deleteObjects(bucket string, keys []string, log logrus.FieldLogger) (int64, int, error) {
+	var errs []error
+	var reclaimedBytes int64
+	deleted := 0
+	dirs := map[string]bool{}
+	for _, key := range keys {
+		path, err := o.objectPath(bucket, key)
 		if err != nil {
-			return err
+			errs = append(errs, err)
+			continue
 		}
-		if len(infos) == 0 {
-			l := o.log.WithFields(logrus.Fields{
-				"backupPath": backupPath,
-			})
-			l.Debug("Deleted backup directory")
-			os.Remove(backupPath)
+
+		keyLog := log.WithField("key", key)
+		unlock := o.keyLocks.lock(path)
+		reclaimed, removeErr := o.removeObject(o.bucketPath(bucket), path, keyLog)
+		unlock()
+		o.auditLog.record(keyLog, "DeleteObject", 0, removeErr)
+		if removeErr != nil {
+			errs = append(errs, errors.Wrapf(removeErr, "failed to delete %s", key))
+		} else {
+			reclaimedBytes += reclaimed
+			deleted++
 		}
+		dirs[filepath.Dir(path)] = true
 	}
 
-	return err
+	for dir := range dirs {
+		o.cleanupDir(bucket, dir, log)
+	}
+
+	return reclaimedBytes, deleted, utilerrors.NewAggregate(errs)
+}
+
+// removeObject removes the file holding the object at path, and its checksum and metadata, returning the size of the file
+// if the space it used is reclaimed. A deduplicated blob is removed along with the last object linked to it,
+// so the space of a deduplicated object is only reclaimed then. WORM objects within their retention window are not removed.
+// The caller holds the key lock of path.
+func (o *LocalVolumeObjectStore) removeObject(bucketPath, path string, log logrus.FieldLogger) (int64, error) {
+	if err := o.checkWORMRetention(path); err != nil {
+		return 0, err
+	}
+	blobs := linkedDedupBlobs(bucketPath, path)
+	filePath, _, _ := findObjectFile(path)
+	info, err := os.Lstat(filePath)
+	if err != nil {
+		return 0, err
+	}
+	if err := os.Remove(filePath); err != nil {
+		return 0, err
+	}
+	releaseDedupBlobs(blobs, log)
+	if err := removeChecksum(path); err != nil {
+		log.WithError(err).Warn("Failed to remove object checksum")
+	}
+	if err := os.Remove(metadataPath(path)); err != nil && !os.IsNotExist(err) {
+		log.WithError(err).Warn("Failed to remove object metadata sidecar")
+	}
+
+	// The blobs are the only other links once no other object shares the content
+	if linkCount(info) > uint64(1+len(blobs)) {
+		return 0, nil
+	}
+	return info.Size(), nil
+}
+
+// cleanupDir removes the directory an object was deleted from if it is left empty, and in turn each ancestor left empty,
+// stopping at the bucket root and at the subdirectory layout created by Init, which are kept even when empty.
+func (o *LocalVolumeObjectStore) cleanupDir(bucket, dir string, log logrus.FieldLogger) {
+	bucketPath := o.bucketPath(bucket)
+	keep := map[string]bool{filepath.Join(bucketPath, o.prefix): true}
+	for _, subdir := range o.getSubDirectoryLayout() {
+		keep[filepath.Join(bucketPath, o.prefix, subdir)] = true
+	}
+	cleanupEmptyDirs(dir, func(dir string) bool {
+		return keep[dir] || !strings.HasPrefix(dir, bucketPath+string(filepath.Separator))
+	}, log)
+}
+
+// cleanupEmptyDirs removes dir if it is empty, then each of its ancestors left empty in turn, until reaching one that
+// is not empty or for which stop returns truthy. Failures are logged rather than returned.
+// It is a variable so tests can observe cleanup passes.
+var cleanupEmptyDirs = func(dir string, stop func(dir string) bool, log logrus.FieldLogger) {
+	for ; !stop(dir); dir = filepath.Dir(dir) {
+		l := log.WithFields(logrus.Fields{
+			"dir": dir,
+		})
+		entries, err := os.ReadDir(dir)
+		if isMissingDir(err) {
+			// Already removed, such as by the cleanup of another object, but its ancestors may now be empty
+			continue
+		} else if err != nil {
+			l.WithError(err).Warn("Failed to read directory for cleanup")
+			return
+		}
+		if len(entries) > 0 {
+			return
+		}
+		if err := os.Remove(dir); err != nil {
+			l.WithError(err).Warn("Failed to delete empty directory")
+			return
+		}
+		l.Debug("Deleted empty directory")
+	}
 }
 
 // CreateSignedURL creates a signed URL to the pod ID for anonymous external access to LocalVolumeObjectStore files.
+// It fails rather than returning a dead link if the address of the fileserver is unknown or,
+// with validateSignedURLReachability, the fileserver does not accept connections.
 // It is part of the Velero plugin interface.
 func (o *LocalVolumeObjectStore) CreateSignedURL(bucket, key string, ttl time.Duration) (string, error) {
 	log := o.log.WithFields(logrus.Fields{
@@ -249,16 +1510,22 @@ func (o *LocalVolumeObjectStore) CreateSignedURL(bucket, key string, ttl time.Du
 	})
 	log.Debug("LocalVolumeObjectStore.CreateSignedURL called")
 
-	namespace := os.Getenv("VELERO_NAMESPACE")
+	// The fileserver serves the files of the volume, so the URL has the path the object is stored at
+	path, err := o.objectPath(bucket, key)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to create signed url")
+	}
+	storedKey, err := relativeKey(o.bucketPath(bucket), path)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to create signed url")
+	}
 
-	signedUrl := url.URL{
-		Scheme: "http",
-		Host:   fmt.Sprintf("%s:%d", os.Getenv("POD_IP"), 3000),
-		Path:   fmt.Sprintf("/%s/%s", bucket, key),
+	signedUrl := getFileserverURL(o.opts, filepath.ToSlash(filepath.Join(bucket, o.rootSubPath)), storedKey)
+	if err := checkFileserverURL(signedUrl, o.validateSignedURLReachability); err != nil {
+		return "", errors.Wrap(err, "failed to create signed url")
 	}
 
-	err := SignURL(&signedUrl, namespace, ttl)
-	if err != nil {
+	if err := SignURL(signedUrl, o.opts.signingKey, o.opts.signingAlgorithm, ttl); err != nil {
 		return "", errors.Wrap(err, "failed to create signed url")
 	}
 
@@ -267,32 +1534,109 @@ func (o *LocalVolumeObjectStore) CreateSignedURL(bucket, key string, ttl time.Du
 
 // getLocalVolumeStoreOpts looks for the optional plugin config map and then uses it
 // to populate options for the rest of the plugin calls.
+// Reads failing with a transient API error are retried for the apiRetryTimeout.
 func (o *LocalVolumeObjectStore) getLocalVolumeStoreOpts() error {
-	pluginConfigMap, err := getPluginConfigMap(o.volumeType)
+	var pluginConfigMap *corev1.ConfigMap
+	err := retryAPI(o.apiRetryTimeout, o.log, func() error {
+		var err error
+		pluginConfigMap, err = getPluginConfigMap(o.volumeType, o.getVeleroNamespace())
+		return err
+	})
 	if err != nil {
 		return errors.Wrap(err, "failed to get plugin config map")
 	}
+	// Without a config map every option has its default
+	var data map[string]string
 	if pluginConfigMap == nil {
 		o.log.Debug("Did not find a configmap fot this plugin")
-		o.opts = &localVolumeObjectStoreOpts{}
 	} else {
 		o.log.Debug("Found a configmap for this plugin")
+		data = pluginConfigMap.Data
+	}
+	opts, err := parsePluginConfig(data, o.log)
+	if err != nil {
+		return errors.Wrap(err, "invalid plugin config map")
+	}
+	o.opts = opts
 
-		preserveVolumes := make(map[string]bool)
-		if pluginConfigMap.Data["preserveVolumes"] != "" {
-			preserveVolumesList := strings.Split(pluginConfigMap.Data["preserveVolumes"], ",")
-			for _, volume := range preserveVolumesList {
-				preserveVolumes[volume] = true
-			}
-		}
+	var signingKey []byte
+	err = retryAPI(o.apiRetryTimeout, o.log, func() error {
+		var err error
+		signingKey, err = GetSigningKey(o.getVeleroNamespace(), o.opts.signingSecretName)
+		return err
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to get signing key")
+	}
+	o.opts.signingKey = signingKey
 
-		o.opts = &localVolumeObjectStoreOpts{
-			fileserverImage:           pluginConfigMap.Data["fileserverImage"],
-			securityContextRunAsUser:  pluginConfigMap.Data["securityContextRunAsUser"],
-			securityContextRunAsGroup: pluginConfigMap.Data["securityContextRunAsGroup"],
-			securityContextFSGroup:    pluginConfigMap.Data["securityContextFsGroup"],
-			preserveVolumes:           preserveVolumes,
+	if o.opts.encryptionSecretName != "" {
+		var encryptionKey []byte
+		err := retryAPI(o.apiRetryTimeout, o.log, func() error {
+			var err error
+			encryptionKey, err = GetEncryptionKey(o.getVeleroNamespace(), o.opts.encryptionSecretName)
+			return err
+		})
+		if err != nil {
+			return errors.Wrap(err, "failed to get encryption key")
 		}
+		o.opts.encryptionKey = encryptionKey
 	}
+
 	return nil
 }
+
+// getVeleroNamespace returns the namespace of the Velero deployment and the secrets and config map of the plugin.
+func (o *LocalVolumeObjectStore) getVeleroNamespace() string {
+	return resolveVeleroNamespace(o.veleroNamespace)
+}
+
+// getSubDirectoryLayout returns the subdirectories created in the bucket, Velero's followed by any configured extraSubdirs.
+func (o *LocalVolumeObjectStore) getSubDirectoryLayout() []string {
+	return append(getSubDirectoryLayout(), o.extraSubdirs...)
+}
+
+// getRootPath returns the directory the bucket volumes are mounted under.
+func (o *LocalVolumeObjectStore) getRootPath() string {
+	if o.opts == nil || o.opts.rootPath == "" {
+		return getRoot()
+	}
+	return o.opts.rootPath
+}
+
+// getDirMode returns the mode directories are created with.
+func (o *LocalVolumeObjectStore) getDirMode() os.FileMode {
+	if o.opts == nil || o.opts.dirMode == 0 {
+		return defaultDirMode
+	}
+	return o.opts.dirMode
+}
+
+// getFileMode returns the mode object files are created with.
+func (o *LocalVolumeObjectStore) getFileMode() os.FileMode {
+	if o.opts == nil || o.opts.fileMode == 0 {
+		return defaultFileMode
+	}
+	return o.opts.fileMode
+}
+
+// getUsageCacheTTL returns how long the usage of a bucket is cached for.
+func (o *LocalVolumeObjectStore) getUsageCacheTTL() time.Duration {
+	if o.opts == nil {
+		return defaultUsageCacheTTL
+	}
+	// The setting is validated when the plugin ConfigMap is read
+	ttl, err := ParseUsageCacheTTL(o.opts.usageCacheTTL)
+	if err != nil {
+		return defaultUsageCacheTTL
+	}
+	return ttl
+}
+
+// getEncryptionKey returns the key objects are encrypted with, or nil if encryption is not configured.
+func (o *LocalVolumeObjectStore) getEncryptionKey() []byte {
+	if o.opts == nil {
+		return nil
+	}
+	return o.opts.encryptionKey
+}
@@ -0,0 +1,2560 @@
+package plugin
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/pkg/errors"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+// newTestObjectStore returns an object store rooted at a temporary directory.
+func newTestObjectStore(t *testing.T) (*LocalVolumeObjectStore, string) {
+	root := t.TempDir()
+	t.Setenv("VOLUME_ROOT", root)
+	return NewLocalVolumeObjectStore(logrus.New(), Hostpath), root
+}
+
+func Test_PutObject(t *testing.T) {
+	tests := []struct {
+		name        string
+		body        io.Reader
+		wantErr     bool
+		wantContent string
+	}{
+		{
+			name:        "complete write -- object is renamed into place",
+			body:        strings.NewReader("backup contents"),
+			wantContent: "backup contents",
+		},
+		{
+			name:    "interrupted write -- final path does not exist",
+			body:    io.MultiReader(strings.NewReader("partial"), iotest.ErrReader(errors.New("connection reset"))),
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := require.New(t)
+			o, root := newTestObjectStore(t)
+
+			err := o.PutObject("my-bucket", "backups/my-backup/my-backup.tar.gz", tt.body)
+			if tt.wantErr {
+				req.Error(err)
+			} else {
+				req.NoError(err)
+			}
+
+			dir := filepath.Join(root, "my-bucket", "backups", "my-backup")
+			path := filepath.Join(dir, "my-backup.tar.gz")
+			if tt.wantContent != "" {
+				content, err := os.ReadFile(path)
+				req.NoError(err)
+				req.Equal(tt.wantContent, string(content))
+			} else {
+				_, err := os.Stat(path)
+				req.True(os.IsNotExist(err))
+			}
+
+			// no temporary files are left behind in either case
+			entries, err := os.ReadDir(dir)
+			req.NoError(err)
+			for _, entry := range entries {
+				req.NotContains(entry.Name(), tempFileInfix)
+			}
+		})
+	}
+}
+
+func Test_PutObject_durableWrites(t *testing.T) {
+	tests := []struct {
+		name   string
+		config map[string]string
+		want   bool
+	}{
+		{
+			name: "default -- directory is synced",
+			want: true,
+		},
+		{
+			name:   "disabled",
+			config: map[string]string{"durableWrites": "false"},
+			want:   false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := require.New(t)
+			o, root := newTestObjectStore(t)
+			req.NoError(o.applyConfig(tt.config))
+			req.Equal(tt.want, o.durableWrites)
+
+			req.NoError(o.PutObject("bucket", "backups/my-backup/key", strings.NewReader("data")))
+
+			content, err := os.ReadFile(filepath.Join(root, "bucket", "backups", "my-backup", "key"))
+			req.NoError(err)
+			req.Equal("data", string(content))
+		})
+	}
+
+	require.NoError(t, syncDir(t.TempDir()))
+	require.Error(t, syncDir(filepath.Join(t.TempDir(), "missing")))
+}
+
+func Test_PutObject_syncEveryBytes(t *testing.T) {
+	tests := []struct {
+		name      string
+		config    map[string]string
+		wantSyncs int
+		wantErr   string
+	}{
+		{
+			name:      "unset -- only the final sync",
+			wantSyncs: 0,
+		},
+		{
+			name:      "every 1000 bytes",
+			config:    map[string]string{"syncEveryBytes": "1000", "copyBufferSizeBytes": "300"},
+			wantSyncs: 10,
+		},
+		{
+			name:      "interval smaller than the writes",
+			config:    map[string]string{"syncEveryBytes": "100", "copyBufferSizeBytes": "4096"},
+			wantSyncs: 105,
+		},
+		{
+			name:    "negative",
+			config:  map[string]string{"syncEveryBytes": "-1"},
+			wantErr: `invalid syncEveryBytes "-1"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := require.New(t)
+			o, root := newTestObjectStore(t)
+			err := o.applyConfig(tt.config)
+			if tt.wantErr != "" {
+				req.EqualError(err, tt.wantErr)
+				return
+			}
+			req.NoError(err)
+
+			syncs := 0
+			defer func(sync func(*os.File) error) { syncFile = sync }(syncFile)
+			syncFile = func(file *os.File) error {
+				syncs++
+				return file.Sync()
+			}
+
+			data := make([]byte, 10500)
+			rand.Read(data)
+			req.NoError(o.PutObject("bucket", "backups/my-backup/key", io.MultiReader(bytes.NewReader(data))))
+			req.Equal(tt.wantSyncs, syncs)
+
+			content, err := os.ReadFile(filepath.Join(root, "bucket", "backups", "my-backup", "key"))
+			req.NoError(err)
+			req.Equal(data, content)
+		})
+	}
+}
+
+func Test_PutObject_modes(t *testing.T) {
+	tests := []struct {
+		name         string
+		opts         *localVolumeObjectStoreOpts
+		wantDirMode  os.FileMode
+		wantFileMode os.FileMode
+	}{
+		{
+			name:         "defaults",
+			wantDirMode:  0755,
+			wantFileMode: 0644,
+		},
+		{
+			name:         "custom modes",
+			opts:         &localVolumeObjectStoreOpts{dirMode: 0700, fileMode: 0600},
+			wantDirMode:  0700,
+			wantFileMode: 0600,
+		},
+		{
+			name:         "group writable modes are not masked",
+			opts:         &localVolumeObjectStoreOpts{dirMode: 0775, fileMode: 0664},
+			wantDirMode:  0775,
+			wantFileMode: 0664,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := require.New(t)
+			o, root := newTestObjectStore(t)
+			o.opts = tt.opts
+
+			req.NoError(o.PutObject("my-bucket", "backups/my-backup/my-backup.tar.gz", strings.NewReader("data")))
+
+			for _, dir := range []string{"backups", "backups/my-backup"} {
+				info, err := os.Stat(filepath.Join(root, "my-bucket", dir))
+				req.NoError(err)
+				req.Equal(tt.wantDirMode, info.Mode().Perm(), dir)
+			}
+			path := filepath.Join(root, "my-bucket", "backups", "my-backup", "my-backup.tar.gz")
+			for _, file := range []string{path, checksumPath(path)} {
+				info, err := os.Stat(file)
+				req.NoError(err)
+				req.Equal(tt.wantFileMode, info.Mode().Perm(), file)
+			}
+		})
+	}
+}
+
+func Test_parseFileMode(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    os.FileMode
+		wantErr bool
+	}{
+		{value: "", want: defaultDirMode},
+		{value: "0700", want: 0700},
+		{value: "750", want: 0750},
+		{value: "0999", wantErr: true},
+		{value: "rwxr-xr-x", wantErr: true},
+		{value: "01777", wantErr: true},
+		{value: "0", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := parseFileMode("dirMode", tt.value, defaultDirMode)
+			if tt.wantErr {
+				require.ErrorContains(t, err, "dirMode")
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_ObjectExists(t *testing.T) {
+	tests := []struct {
+		name       string
+		key        string
+		setup      func(t *testing.T, bucketPath string)
+		wantExists bool
+		wantErr    bool
+	}{
+		{
+			name: "object exists",
+			key:  "backups/my-backup/my-backup.tar.gz",
+			setup: func(t *testing.T, bucketPath string) {
+				dir := filepath.Join(bucketPath, "backups", "my-backup")
+				require.NoError(t, os.MkdirAll(dir, 0755))
+				require.NoError(t, os.WriteFile(filepath.Join(dir, "my-backup.tar.gz"), []byte("data"), 0644))
+			},
+			wantExists: true,
+		},
+		{
+			name:       "object does not exist",
+			key:        "backups/my-backup/my-backup.tar.gz",
+			setup:      func(t *testing.T, bucketPath string) {},
+			wantExists: false,
+		},
+		{
+			name: "ENOTDIR -- parent of the key is a file",
+			key:  "backups/my-backup/my-backup.tar.gz",
+			setup: func(t *testing.T, bucketPath string) {
+				dir := filepath.Join(bucketPath, "backups")
+				require.NoError(t, os.MkdirAll(dir, 0755))
+				require.NoError(t, os.WriteFile(filepath.Join(dir, "my-backup"), []byte("data"), 0644))
+			},
+			wantExists: false,
+			wantErr:    true,
+		},
+		{
+			name: "EACCES -- parent of the key is not searchable",
+			key:  "backups/my-backup/my-backup.tar.gz",
+			setup: func(t *testing.T, bucketPath string) {
+				if os.Geteuid() == 0 {
+					t.Skip("permission checks are bypassed when running as root")
+				}
+				dir := filepath.Join(bucketPath, "backups", "my-backup")
+				require.NoError(t, os.MkdirAll(dir, 0755))
+				require.NoError(t, os.Chmod(dir, 0000))
+				t.Cleanup(func() { os.Chmod(dir, 0755) })
+			},
+			wantExists: false,
+			wantErr:    true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := require.New(t)
+			o, root := newTestObjectStore(t)
+			tt.setup(t, filepath.Join(root, "my-bucket"))
+
+			exists, err := o.ObjectExists("my-bucket", tt.key)
+			if tt.wantErr {
+				req.Error(err)
+			} else {
+				req.NoError(err)
+			}
+			req.Equal(tt.wantExists, exists)
+		})
+	}
+}
+
+func Test_GetObject(t *testing.T) {
+	tests := []struct {
+		name            string
+		verifyChecksums bool
+		corrupt         func(t *testing.T, path string)
+		wantErr         bool
+		wantNotFound    bool
+		wantReadErr     error
+	}{
+		{
+			name:            "checksum verification disabled",
+			verifyChecksums: false,
+			corrupt: func(t *testing.T, path string) {
+				require.NoError(t, os.WriteFile(path, []byte("bit rot"), 0644))
+			},
+		},
+		{
+			name:            "checksum verification enabled -- object is intact",
+			verifyChecksums: true,
+			corrupt:         func(t *testing.T, path string) {},
+		},
+		{
+			name:            "checksum verification enabled -- object is corrupted",
+			verifyChecksums: true,
+			corrupt: func(t *testing.T, path string) {
+				require.NoError(t, os.WriteFile(path, []byte("bit rot"), 0644))
+			},
+			wantReadErr: ErrChecksumMismatch,
+		},
+		{
+			name:            "checksum verification enabled -- sidecar is missing",
+			verifyChecksums: true,
+			corrupt: func(t *testing.T, path string) {
+				require.NoError(t, os.Remove(checksumPath(path)))
+			},
+			wantErr: true,
+		},
+		{
+			name: "object is missing",
+			corrupt: func(t *testing.T, path string) {
+				require.NoError(t, os.Remove(path))
+			},
+			wantErr:      true,
+			wantNotFound: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := require.New(t)
+			o, root := newTestObjectStore(t)
+			o.verifyChecksums = tt.verifyChecksums
+
+			key := "backups/my-backup/my-backup.tar.gz"
+			req.NoError(o.PutObject("my-bucket", key, strings.NewReader("backup contents")))
+			tt.corrupt(t, filepath.Join(root, "my-bucket", key))
+
+			rc, err := o.GetObject("my-bucket", key)
+			if tt.wantErr {
+				req.Error(err)
+				req.Equal(tt.wantNotFound, errors.Is(err, ErrObjectNotFound))
+				if tt.wantNotFound {
+					req.ErrorIs(err, os.ErrNotExist)
+				}
+				return
+			}
+			req.NoError(err)
+
+			// the content is verified as it is read, failing the final read and the close
+			got, err := io.ReadAll(rc)
+			if tt.wantReadErr != nil {
+				req.ErrorIs(err, tt.wantReadErr)
+				req.ErrorIs(rc.Close(), tt.wantReadErr)
+				return
+			}
+			req.NoError(err)
+			req.NotEmpty(got)
+			req.NoError(rc.Close())
+		})
+	}
+}
+
+func Test_GetObject_verifyInline(t *testing.T) {
+	req := require.New(t)
+	o, root := newTestObjectStore(t)
+	o.verifyChecksums = true
+
+	key := "backups/my-backup/my-backup.tar.gz"
+	content := bytes.Repeat([]byte("backup contents "), 100000)
+	req.NoError(o.PutObject("my-bucket", key, bytes.NewReader(content)))
+	corrupted := bytes.Clone(content)
+	corrupted[len(corrupted)-1] ^= 0xff
+	req.NoError(os.WriteFile(filepath.Join(root, "my-bucket", key), corrupted, 0644))
+
+	rc, err := o.GetObject("my-bucket", key)
+	req.NoError(err)
+
+	// everything before the end is read without an error
+	buf := make([]byte, len(content)-1)
+	_, err = io.ReadFull(rc, buf)
+	req.NoError(err)
+	req.Equal(content[:len(content)-1], buf)
+
+	_, err = io.ReadAll(rc)
+	req.ErrorIs(err, ErrChecksumMismatch)
+	_, err = rc.Read(buf)
+	req.ErrorIs(err, ErrChecksumMismatch, "the mismatch should be returned by every later read")
+	req.ErrorIs(rc.Close(), ErrChecksumMismatch)
+
+	// an object closed before the end is not verified
+	rc, err = o.GetObject("my-bucket", key)
+	req.NoError(err)
+	_, err = rc.Read(buf[:10])
+	req.NoError(err)
+	req.NoError(rc.Close())
+}
+
+func Test_GetObjectWithInfo(t *testing.T) {
+	tests := []struct {
+		name        string
+		compression string
+		wantSize    func(content []byte) int64
+	}{
+		{
+			name:     "uncompressed -- size of the content",
+			wantSize: func(content []byte) int64 { return int64(len(content)) },
+		},
+		{
+			name:        "compressed -- size unknown",
+			compression: compressionGzip,
+			wantSize:    func(content []byte) int64 { return -1 },
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := require.New(t)
+			o, _ := newTestObjectStore(t)
+			o.compression = tt.compression
+
+			content := bytes.Repeat([]byte("backup contents "), 1000)
+			req.NoError(o.PutObject("my-bucket", "backups/my-backup/my-backup.tar.gz", bytes.NewReader(content)))
+
+			rc, size, err := o.GetObjectWithInfo("my-bucket", "backups/my-backup/my-backup.tar.gz")
+			req.NoError(err)
+			defer rc.Close()
+			req.Equal(tt.wantSize(content), size)
+
+			got, err := io.ReadAll(rc)
+			req.NoError(err)
+			req.Equal(content, got)
+		})
+	}
+}
+
+func Test_HeadObject(t *testing.T) {
+	tests := []struct {
+		name        string
+		key         string
+		compression string
+		wantExists  bool
+	}{
+		{
+			name:       "present",
+			key:        "backups/my-backup/my-backup.tar.gz",
+			wantExists: true,
+		},
+		{
+			name:        "compressed -- size of the object file",
+			key:         "backups/my-backup/my-backup.tar.gz",
+			compression: compressionGzip,
+			wantExists:  true,
+		},
+		{
+			name: "absent",
+			key:  "backups/my-backup/missing",
+		},
+		{
+			name: "absent backup directory",
+			key:  "backups/missing/missing",
+		},
+		{
+			name: "beneath an object",
+			key:  "backups/my-backup/my-backup.tar.gz/nested",
+		},
+		{
+			name: "directory",
+			key:  "backups/my-backup",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := require.New(t)
+			o, _ := newTestObjectStore(t)
+			o.compression = tt.compression
+
+			modTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+			content := bytes.Repeat([]byte("backup contents "), 1000)
+			req.NoError(o.PutObjectWithModTime("my-bucket", "backups/my-backup/my-backup.tar.gz", bytes.NewReader(content), modTime))
+
+			exists, size, gotModTime, err := o.HeadObject("my-bucket", tt.key)
+			req.NoError(err)
+			req.Equal(tt.wantExists, exists)
+			if !tt.wantExists {
+				req.Zero(size)
+				req.True(gotModTime.IsZero())
+				return
+			}
+
+			infos, err := o.ListObjectsWithInfo("my-bucket", tt.key)
+			req.NoError(err)
+			req.Len(infos, 1)
+			req.Equal(infos[0].Size, size)
+			if tt.compression == "" {
+				req.EqualValues(len(content), size)
+			}
+			req.True(modTime.Equal(gotModTime))
+		})
+	}
+
+	o, root := newTestObjectStore(t)
+	outside := filepath.Join(root, "outside")
+	require.NoError(t, os.WriteFile(outside, []byte("secret"), 0644))
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "my-bucket"), 0755))
+	require.NoError(t, os.Symlink(outside, filepath.Join(root, "my-bucket", "escape")))
+	_, _, _, err := o.HeadObject("my-bucket", "escape")
+	require.ErrorIs(t, err, ErrSymlink)
+}
+
+func Test_ListObjects(t *testing.T) {
+	req := require.New(t)
+	o, root := newTestObjectStore(t)
+
+	req.NoError(o.PutObject("my-bucket", "backups/my-backup/my-backup.tar.gz", strings.NewReader("data")))
+	req.NoError(o.PutObject("my-bucket", "backups/my-backup/my-backup-logs.gz", strings.NewReader("logs")))
+	req.NoError(o.PutObject("my-bucket", "backups/my-backup/nested/volume-info.json", strings.NewReader("{}")))
+	req.NoError(o.PutObject("my-bucket", "restores/my-restore/restore-logs.gz", strings.NewReader("logs")))
+
+	// symlinks escaping the bucket are not listed
+	outside := filepath.Join(root, "outside")
+	req.NoError(os.WriteFile(outside, []byte("secret"), 0644))
+	req.NoError(os.Symlink(outside, filepath.Join(root, "my-bucket", "backups", "my-backup", "escape")))
+
+	objects, err := o.ListObjects("my-bucket", "backups/my-backup")
+	req.NoError(err)
+	req.ElementsMatch([]string{
+		"backups/my-backup/my-backup.tar.gz",
+		"backups/my-backup/my-backup-logs.gz",
+		"backups/my-backup/nested/volume-info.json",
+	}, objects)
+}
+
+func Test_isInternalFile(t *testing.T) {
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{name: "my-backup.tar.gz", want: false},
+		{name: "my-backup.tar.gz" + CompressedSuffix, want: false},
+		{name: "my-backup.tar.gz" + ZstdCompressedSuffix, want: false},
+		{name: "my-backup.tar.gz.sha256", want: true},
+		{name: "my-backup.tar.gz.meta.json", want: true},
+		{name: "my-backup.tar.gz.tmp-1234", want: true},
+		{name: "my-backup.tar.gz.sha256.tmp-1234", want: true},
+		{name: "my-backup.tmp-notes", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, isInternalFile(tt.name))
+		})
+	}
+}
+
+func Test_ListObjects_internalFiles(t *testing.T) {
+	req := require.New(t)
+	o, root := newTestObjectStore(t)
+
+	req.NoError(o.PutObject("my-bucket", "backups/my-backup/my-backup.tar.gz", strings.NewReader("data")))
+	o.compression = compressionGzip
+	req.NoError(o.PutObject("my-bucket", "backups/my-backup/my-backup-logs.gz", strings.NewReader("logs")))
+	o.compression = ""
+	req.NoError(o.SetObjectMetadata("my-bucket", "backups/my-backup/my-backup.tar.gz", map[string]string{"app": "etcd"}))
+
+	// a directory holding nothing but the files of interrupted uploads and orphaned sidecars
+	dir := filepath.Join(root, "my-bucket", "backups", "my-backup")
+	orphans := filepath.Join(root, "my-bucket", "backups", "interrupted")
+	req.NoError(os.MkdirAll(orphans, 0755))
+	for _, name := range []string{
+		filepath.Join(dir, "my-backup.tar.gz.tmp-1234"),
+		filepath.Join(dir, "my-backup.tar.gz.sha256.tmp-5678"),
+		filepath.Join(dir, "my-backup-logs.gz.meta.json"),
+		filepath.Join(orphans, "interrupted.tar.gz.tmp-1234"),
+		filepath.Join(orphans, "interrupted.tar.gz.sha256"),
+	} {
+		req.NoError(os.WriteFile(name, []byte("internal"), 0644))
+	}
+
+	want := []string{
+		"backups/my-backup/my-backup.tar.gz",
+		"backups/my-backup/my-backup-logs.gz",
+	}
+	objects, err := o.ListObjects("my-bucket", "backups/")
+	req.NoError(err)
+	req.ElementsMatch(want, objects)
+
+	keys, _, err := o.ListObjectsPaged("my-bucket", "backups/", "", 100)
+	req.NoError(err)
+	req.ElementsMatch(want, keys)
+
+	prefixes, err := o.ListCommonPrefixes("my-bucket", "backups/", "/")
+	req.NoError(err)
+	req.Equal([]string{"backups/my-backup/"}, prefixes)
+
+	_, objectCount, err := o.BucketUsage("my-bucket")
+	req.NoError(err)
+	req.Equal(2, objectCount)
+
+	// the logical keys still read their objects
+	for _, key := range want {
+		rc, err := o.GetObject("my-bucket", key)
+		req.NoError(err)
+		req.NoError(rc.Close())
+	}
+}
+
+func Test_ListObjects_nonRegularFiles(t *testing.T) {
+	req := require.New(t)
+	o, root := newTestObjectStore(t)
+	o.followSymlinks = true
+
+	req.NoError(o.PutObject("my-bucket", "backups/my-backup/my-backup.tar.gz", strings.NewReader("data")))
+
+	// a FIFO left by a misbehaving restore, and a symlink to it, which both block once opened
+	dir := filepath.Join(root, "my-bucket", "backups", "my-backup")
+	req.NoError(unix.Mkfifo(filepath.Join(dir, "fifo"), 0644))
+	req.NoError(os.Symlink("fifo", filepath.Join(dir, "fifo-link")))
+	orphans := filepath.Join(root, "my-bucket", "backups", "fifos-only")
+	req.NoError(os.MkdirAll(orphans, 0755))
+	req.NoError(unix.Mkfifo(filepath.Join(orphans, "fifo"), 0644))
+
+	want := []string{"backups/my-backup/my-backup.tar.gz"}
+	objects, err := o.ListObjects("my-bucket", "backups/")
+	req.NoError(err)
+	req.Equal(want, objects)
+
+	keys, _, err := o.ListObjectsPaged("my-bucket", "backups/", "", 100)
+	req.NoError(err)
+	req.Equal(want, keys)
+
+	prefixes, err := o.ListCommonPrefixes("my-bucket", "backups/", "/")
+	req.NoError(err)
+	req.Equal([]string{"backups/my-backup/"}, prefixes)
+
+	_, objectCount, err := o.BucketUsage("my-bucket")
+	req.NoError(err)
+	req.Equal(1, objectCount)
+
+	for _, key := range []string{"backups/my-backup/fifo", "backups/my-backup/fifo-link"} {
+		_, err = o.GetObject("my-bucket", key)
+		req.ErrorIs(err, ErrNotRegularFile, key)
+		req.ErrorContains(err, "is a FIFO", key)
+
+		req.ErrorIs(o.CopyObject("my-bucket", key, "backups/copy/copy"), ErrNotRegularFile, key)
+	}
+}
+
+func Test_ListObjects_missingPrefix(t *testing.T) {
+	req := require.New(t)
+	o, _ := newTestObjectStore(t)
+
+	// a new location has no bucket directory until the first object is written
+	objects, err := o.ListObjects("new-bucket", "backups/")
+	req.NoError(err)
+	req.Empty(objects)
+	prefixes, err := o.ListCommonPrefixes("new-bucket", "backups/", "/")
+	req.NoError(err)
+	req.Empty(prefixes)
+
+	req.NoError(o.PutObject("my-bucket", "backups/my-backup/my-backup.tar.gz", strings.NewReader("data")))
+	for _, prefix := range []string{
+		"restores/",
+		"backups/missing/nested/",
+		// an object is not a directory
+		"backups/my-backup/my-backup.tar.gz/",
+		"backups/my-backup/my-backup.tar.gz/nested/",
+	} {
+		objects, err := o.ListObjects("my-bucket", prefix)
+		req.NoError(err, prefix)
+		req.Empty(objects, prefix)
+		keys, marker, err := o.ListObjectsPaged("my-bucket", prefix, "", 10)
+		req.NoError(err, prefix)
+		req.Empty(keys, prefix)
+		req.Empty(marker, prefix)
+		for _, delimiter := range []string{"/", "-"} {
+			prefixes, err := o.ListCommonPrefixes("my-bucket", prefix, delimiter)
+			req.NoError(err, prefix)
+			req.Empty(prefixes, prefix)
+		}
+	}
+}
+
+func Test_ListObjects_unreadablePrefix(t *testing.T) {
+	req := require.New(t)
+	o, root := newTestObjectStore(t)
+	req.NoError(o.PutObject("my-bucket", "backups/my-backup/my-backup.tar.gz", strings.NewReader("data")))
+
+	// errors other than the prefix not existing are not mistaken for an empty prefix
+	tooLong := "backups/" + strings.Repeat("x", 300) + "/"
+	_, err := o.ListObjects("my-bucket", tooLong)
+	req.ErrorIs(err, unix.ENAMETOOLONG)
+	_, _, err = o.ListObjectsPaged("my-bucket", tooLong, "", 10)
+	req.ErrorIs(err, unix.ENAMETOOLONG)
+	_, err = o.ListCommonPrefixes("my-bucket", tooLong, "/")
+	req.ErrorIs(err, unix.ENAMETOOLONG)
+
+	if os.Geteuid() == 0 {
+		t.Skip("directory permissions do not apply to root")
+	}
+	dir := filepath.Join(root, "my-bucket", "backups")
+	req.NoError(os.Chmod(dir, 0))
+	defer os.Chmod(dir, 0755)
+	_, err = o.ListObjects("my-bucket", "backups/")
+	req.ErrorIs(err, os.ErrPermission)
+	_, _, err = o.ListObjectsPaged("my-bucket", "backups/", "", 10)
+	req.ErrorIs(err, os.ErrPermission)
+	_, err = o.ListCommonPrefixes("my-bucket", "backups/", "/")
+	req.ErrorIs(err, os.ErrPermission)
+}
+
+func Test_ListCommonPrefixes(t *testing.T) {
+	keys := []string{
+		"backups/backup-1/backup-1.tar.gz",
+		"backups/backup-1/backup-1-logs.gz",
+		"backups/backup-2/backup-2.tar.gz",
+		"backups/other/other.tar.gz",
+		"restores/restore-1/restore-1-logs.gz",
+		"metadata/revision",
+		"lost+found/orphan",
+	}
+
+	tests := []struct {
+		name      string
+		prefix    string
+		delimiter string
+		want      []string
+	}{
+		{
+			name:      "bucket root",
+			prefix:    "",
+			delimiter: "/",
+			want:      []string{"backups/", "metadata/", "restores/"},
+		},
+		{
+			name:      "directory prefix",
+			prefix:    "backups/",
+			delimiter: "/",
+			want:      []string{"backups/backup-1/", "backups/backup-2/", "backups/other/"},
+		},
+		{
+			name:      "partial segment prefix",
+			prefix:    "backups/backup-",
+			delimiter: "/",
+			want:      []string{"backups/backup-1/", "backups/backup-2/"},
+		},
+		{
+			name:      "multi-segment prefix",
+			prefix:    "backups/backup-1/",
+			delimiter: "/",
+		},
+		{
+			name:      "leading slash prefix",
+			prefix:    "/backups/",
+			delimiter: "/",
+			want:      []string{"backups/backup-1/", "backups/backup-2/", "backups/other/"},
+		},
+		{
+			name:      "repeated slash prefix",
+			prefix:    "backups//backup-",
+			delimiter: "/",
+			want:      []string{"backups/backup-1/", "backups/backup-2/"},
+		},
+		{
+			name:      "prefix does not exist",
+			prefix:    "schedules/",
+			delimiter: "/",
+		},
+		{
+			name:      "non-separator delimiter",
+			prefix:    "backups/backup-1/",
+			delimiter: "-",
+			want:      []string{"backups/backup-1/backup-"},
+		},
+		{
+			name:      "non-separator delimiter spanning directories",
+			prefix:    "",
+			delimiter: "-",
+			want:      []string{"backups/backup-", "restores/restore-"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := require.New(t)
+			o, _ := newTestObjectStore(t)
+			for _, key := range keys {
+				req.NoError(o.PutObject("my-bucket", key, strings.NewReader("data")))
+			}
+
+			prefixes, err := o.ListCommonPrefixes("my-bucket", tt.prefix, tt.delimiter)
+			req.NoError(err)
+			req.ElementsMatch(tt.want, prefixes)
+		})
+	}
+}
+
+func Test_ListObjects_segmentPrefix(t *testing.T) {
+	keys := []string{
+		"backup",
+		"backup2",
+		"backup2/backup2.tar.gz",
+		"backup/foo",
+		"backup/nested/foo",
+		"backup-logs.gz",
+	}
+	tests := []struct {
+		name   string
+		prefix string
+		want   []string
+	}{
+		{
+			name:   "prefix without trailing slash -- the object and the keys beneath it",
+			prefix: "backup",
+			want:   []string{"backup", "backup/foo", "backup/nested/foo"},
+		},
+		{
+			name:   "prefix with trailing slash -- the keys beneath it",
+			prefix: "backup/",
+			want:   []string{"backup/foo", "backup/nested/foo"},
+		},
+		{
+			name:   "sibling prefix",
+			prefix: "backup2",
+			want:   []string{"backup2", "backup2/backup2.tar.gz"},
+		},
+		{
+			name:   "nested prefix",
+			prefix: "backup/foo",
+			want:   []string{"backup/foo"},
+		},
+		{
+			name:   "partial segment -- matches nothing",
+			prefix: "back",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := require.New(t)
+			o, _ := newTestObjectStore(t)
+			// A key cannot be both an object and a directory, so "backup" is put in a bucket of its own
+			for _, key := range keys {
+				bucket := "my-bucket"
+				if key == "backup" || key == "backup2" {
+					bucket = "objects"
+				}
+				req.NoError(o.PutObject(bucket, key, strings.NewReader(key)))
+			}
+
+			var got []string
+			for _, bucket := range []string{"my-bucket", "objects"} {
+				objects, err := o.ListObjects(bucket, tt.prefix)
+				req.NoError(err)
+				got = append(got, objects...)
+
+				infos, err := o.ListObjectsWithInfo(bucket, tt.prefix)
+				req.NoError(err)
+				req.Len(infos, len(objects))
+			}
+			req.ElementsMatch(tt.want, got)
+		})
+	}
+}
+
+func Test_listWithDelimiter(t *testing.T) {
+	req := require.New(t)
+	o, _ := newTestObjectStore(t)
+
+	keys := []string{
+		"backups/my-backup/my-backup.tar.gz",
+		"backups/my-backup/my-backup-logs.gz",
+		"backups/my-backup/nested/foo",
+		"backups/my-backup-2/my-backup-2.tar.gz",
+		"backups/top-level",
+		"restores/my-restore/restore-my-restore-logs.gz",
+		"metadata/revision",
+	}
+	for _, key := range keys {
+		req.NoError(o.PutObject("my-bucket", key, strings.NewReader(key)))
+	}
+	o.compression = compressionZstd
+	req.NoError(o.PutObject("my-bucket", "backups/compressed", strings.NewReader("compressed")))
+	keys = append(keys, "backups/compressed")
+
+	for _, prefix := range []string{"", "backups/", "backups/my-b", "backups/my-backup/", "missing/"} {
+		for _, delimiter := range []string{"/", "-", ""} {
+			t.Run(fmt.Sprintf("prefix=%q delimiter=%q", prefix, delimiter), func(t *testing.T) {
+				req := require.New(t)
+				objects, prefixes, err := o.listWithDelimiter("my-bucket", prefix, delimiter)
+				req.NoError(err)
+
+				wantPrefixes, err := o.ListCommonPrefixes("my-bucket", prefix, delimiter)
+				req.NoError(err)
+				req.ElementsMatch(wantPrefixes, prefixes)
+
+				// Every key under the prefix is either an object or under exactly one of the prefixes
+				var wantObjects []string
+				for _, key := range keys {
+					if !strings.HasPrefix(key, prefix) {
+						continue
+					}
+					under := 0
+					for _, commonPrefix := range prefixes {
+						if strings.HasPrefix(key, commonPrefix) {
+							under++
+						}
+					}
+					req.LessOrEqual(under, 1, key)
+					if under == 0 {
+						wantObjects = append(wantObjects, key)
+					}
+				}
+				req.ElementsMatch(wantObjects, objects)
+
+				if delimiter == "" && (prefix == "" || strings.HasSuffix(prefix, "/")) {
+					listed, err := o.ListObjects("my-bucket", prefix)
+					req.NoError(err)
+					req.ElementsMatch(listed, objects)
+				}
+			})
+		}
+	}
+}
+
+func Test_ListObjects_keyForms(t *testing.T) {
+	req := require.New(t)
+	o, _ := newTestObjectStore(t)
+
+	keys := []string{
+		"backups/my-backup/my-backup.tar.gz",
+		"backups/my-backup/nested/deeper/volume-info.json",
+		"restores/my-restore/restore-logs.gz",
+	}
+	for _, key := range keys {
+		req.NoError(o.PutObject("my-bucket", key, strings.NewReader("data")))
+	}
+
+	backups := []string{
+		"backups/my-backup/my-backup.tar.gz",
+		"backups/my-backup/nested/deeper/volume-info.json",
+	}
+	tests := []struct {
+		name   string
+		prefix string
+		want   []string
+	}{
+		{
+			name:   "empty prefix",
+			prefix: "",
+			want:   keys,
+		},
+		{
+			name:   "trailing slash prefix",
+			prefix: "backups/",
+			want:   backups,
+		},
+		{
+			name:   "prefix without trailing slash",
+			prefix: "backups",
+			want:   backups,
+		},
+		{
+			name:   "leading slash prefix",
+			prefix: "/backups/",
+			want:   backups,
+		},
+		{
+			name:   "nested prefix",
+			prefix: "backups/my-backup/nested/",
+			want:   []string{"backups/my-backup/nested/deeper/volume-info.json"},
+		},
+		{
+			name:   "object key",
+			prefix: "backups/my-backup/my-backup.tar.gz",
+			want:   []string{"backups/my-backup/my-backup.tar.gz"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := require.New(t)
+
+			objects, err := o.ListObjects("my-bucket", tt.prefix)
+			req.NoError(err)
+			req.ElementsMatch(tt.want, objects)
+
+			paged, _, err := o.ListObjectsPaged("my-bucket", tt.prefix, "", len(keys))
+			req.NoError(err)
+			req.ElementsMatch(tt.want, paged)
+		})
+	}
+}
+
+// Test_listing_s3Semantics lists a bucket laid out as Velero writes it and expects the keys and common prefixes
+// the S3 object store plugin returns for the same objects, which Velero relies on. Unlike S3, ListObjects matches
+// a prefix without a trailing slash at path segment boundaries.
+func Test_listing_s3Semantics(t *testing.T) {
+	req := require.New(t)
+	o, root := newTestObjectStore(t)
+
+	keys := []string{
+		"backups/backup-1/backup-1.tar.gz",
+		"backups/backup-1/velero-backup.json",
+		"backups/backup-10/velero-backup.json",
+		"backups/backup-2/velero-backup.json",
+		"restores/restore-1/restore-1-logs.gz",
+		"metadata/revision",
+	}
+	for _, key := range keys {
+		req.NoError(o.PutObject("my-bucket", key, strings.NewReader("data")))
+	}
+	// S3 has no empty directories, and uploads in progress are not yet objects
+	req.NoError(os.MkdirAll(filepath.Join(root, "my-bucket", "backups", "empty"), 0755))
+	req.NoError(os.WriteFile(tempFilePath(filepath.Join(root, "my-bucket", "backups", "backup-2", "backup-2.tar.gz")), []byte("partial"), 0644))
+
+	tests := []struct {
+		name      string
+		prefix    string
+		delimiter string
+		want      []string
+	}{
+		{
+			name: "objects -- whole bucket",
+			want: keys,
+		},
+		{
+			name:   "objects -- backup directory",
+			prefix: "backups/backup-1/",
+			want:   []string{"backups/backup-1/backup-1.tar.gz", "backups/backup-1/velero-backup.json"},
+		},
+		{
+			name:   "objects -- prefix without trailing slash matches its directory only",
+			prefix: "backups/backup-1",
+			want:   []string{"backups/backup-1/backup-1.tar.gz", "backups/backup-1/velero-backup.json"},
+		},
+		{
+			name:   "objects -- prefix does not match part of a file name",
+			prefix: "backups/backup-1/velero",
+		},
+		{
+			name:   "objects -- prefix matches nothing",
+			prefix: "backups/missing/",
+		},
+		{
+			name:   "objects -- empty directory",
+			prefix: "backups/empty/",
+		},
+		{
+			name:      "common prefixes -- bucket root",
+			delimiter: "/",
+			want:      []string{"backups/", "metadata/", "restores/"},
+		},
+		{
+			name:      "common prefixes -- backups",
+			prefix:    "backups/",
+			delimiter: "/",
+			want:      []string{"backups/backup-1/", "backups/backup-10/", "backups/backup-2/"},
+		},
+		{
+			name:      "common prefixes -- partial segment",
+			prefix:    "backups/backup-1",
+			delimiter: "/",
+			want:      []string{"backups/backup-1/", "backups/backup-10/"},
+		},
+		{
+			name:      "common prefixes -- directory of objects only",
+			prefix:    "backups/backup-1/",
+			delimiter: "/",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := require.New(t)
+
+			var got []string
+			var err error
+			if tt.delimiter == "" {
+				got, err = o.ListObjects("my-bucket", tt.prefix)
+			} else {
+				got, err = o.ListCommonPrefixes("my-bucket", tt.prefix, tt.delimiter)
+			}
+			req.NoError(err)
+			req.ElementsMatch(tt.want, got)
+		})
+	}
+}
+
+// zeroReader is an endless source of zero bytes.
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
+
+func Benchmark_PutObject(b *testing.B) {
+	const objectSize = 64 << 20
+
+	// Prefer a tmpfs-backed directory so the benchmark measures copying rather than disk speed
+	root := "/dev/shm"
+	if _, err := os.Stat(root); err != nil {
+		root = b.TempDir()
+	} else {
+		dir, err := os.MkdirTemp(root, "lvp-bench-")
+		require.NoError(b, err)
+		b.Cleanup(func() { os.RemoveAll(dir) })
+		root = dir
+	}
+	b.Setenv("VOLUME_ROOT", root)
+
+	for _, size := range []int{32 << 10, 1 << 20} {
+		b.Run(fmt.Sprintf("buffer=%dKB", size>>10), func(b *testing.B) {
+			o := NewLocalVolumeObjectStore(logrus.New(), Hostpath)
+			o.copyBufferSize = size
+
+			b.SetBytes(objectSize)
+			for i := 0; i < b.N; i++ {
+				err := o.PutObject("my-bucket", "backups/my-backup/my-backup.tar.gz", io.LimitReader(zeroReader{}, objectSize))
+				require.NoError(b, err)
+			}
+		})
+	}
+}
+
+func Test_DeleteObject(t *testing.T) {
+	tests := []struct {
+		name          string
+		key           string
+		setup         func(t *testing.T, o *LocalVolumeObjectStore)
+		wantErr       bool
+		wantBackupDir bool
+		// wantDirs are the directories of the bucket expected to remain, or not, after the delete
+		wantDirs map[string]bool
+	}{
+		{
+			name: "last object in backup -- backup directory is cleaned up",
+			key:  "backups/my-backup/my-backup.tar.gz",
+			setup: func(t *testing.T, o *LocalVolumeObjectStore) {
+				require.NoError(t, o.PutObject("my-bucket", "backups/my-backup/my-backup.tar.gz", strings.NewReader("data")))
+			},
+			wantBackupDir: false,
+		},
+		{
+			name: "other objects remain -- backup directory is kept",
+			key:  "backups/my-backup/my-backup.tar.gz",
+			setup: func(t *testing.T, o *LocalVolumeObjectStore) {
+				require.NoError(t, o.PutObject("my-bucket", "backups/my-backup/my-backup.tar.gz", strings.NewReader("data")))
+				require.NoError(t, o.PutObject("my-bucket", "backups/my-backup/my-backup-logs.gz", strings.NewReader("logs")))
+			},
+			wantBackupDir: true,
+		},
+		{
+			name: "object removal fails but backup directory is readable -- removal error is returned",
+			key:  "backups/my-backup/nested",
+			setup: func(t *testing.T, o *LocalVolumeObjectStore) {
+				// removing a non-empty directory fails with ENOTEMPTY
+				require.NoError(t, o.PutObject("my-bucket", "backups/my-backup/nested/volume-info.json", strings.NewReader("{}")))
+			},
+			wantErr:       true,
+			wantBackupDir: true,
+		},
+		{
+			name: "last object in a deeply nested key -- every emptied directory is cleaned up",
+			key:  "backups/my-backup/a/b/c/object",
+			setup: func(t *testing.T, o *LocalVolumeObjectStore) {
+				require.NoError(t, o.PutObject("my-bucket", "backups/my-backup/a/b/c/object", strings.NewReader("data")))
+			},
+			wantBackupDir: false,
+			wantDirs:      map[string]bool{"backups": true},
+		},
+		{
+			name: "deeply nested key with siblings -- cleanup stops at the first non-empty directory",
+			key:  "backups/my-backup/a/b/c/object",
+			setup: func(t *testing.T, o *LocalVolumeObjectStore) {
+				require.NoError(t, o.PutObject("my-bucket", "backups/my-backup/a/b/c/object", strings.NewReader("data")))
+				require.NoError(t, o.PutObject("my-bucket", "backups/my-backup/a/other", strings.NewReader("data")))
+			},
+			wantBackupDir: true,
+			wantDirs:      map[string]bool{"backups/my-backup/a": true, "backups/my-backup/a/b": false},
+		},
+		{
+			name: "shallow key -- the subdirectory layout is kept",
+			key:  "backups/object",
+			setup: func(t *testing.T, o *LocalVolumeObjectStore) {
+				require.NoError(t, o.PutObject("my-bucket", "backups/object", strings.NewReader("data")))
+			},
+			wantDirs: map[string]bool{"backups": true},
+		},
+		{
+			name: "layout under the location prefix -- kept",
+			key:  "my-prefix/backups/my-backup/my-backup.tar.gz",
+			setup: func(t *testing.T, o *LocalVolumeObjectStore) {
+				o.prefix = "my-prefix"
+				require.NoError(t, o.PutObject("my-bucket", "my-prefix/backups/my-backup/my-backup.tar.gz", strings.NewReader("data")))
+			},
+			wantDirs: map[string]bool{"my-prefix/backups": true, "my-prefix/backups/my-backup": false},
+		},
+		{
+			name: "object at the bucket root -- the bucket is kept",
+			key:  "object",
+			setup: func(t *testing.T, o *LocalVolumeObjectStore) {
+				require.NoError(t, o.PutObject("my-bucket", "object", strings.NewReader("data")))
+			},
+			wantDirs: map[string]bool{"": true},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := require.New(t)
+			o, root := newTestObjectStore(t)
+			tt.setup(t, o)
+
+			err := o.DeleteObject("my-bucket", tt.key)
+			if tt.wantErr {
+				req.Error(err)
+			} else {
+				req.NoError(err)
+			}
+
+			_, err = os.Stat(filepath.Join(root, "my-bucket", "backups", "my-backup"))
+			req.Equal(tt.wantBackupDir, err == nil)
+			for dir, want := range tt.wantDirs {
+				_, err = os.Stat(filepath.Join(root, "my-bucket", dir))
+				req.Equal(want, err == nil, dir)
+			}
+		})
+	}
+}
+
+func Test_DeleteObjects(t *testing.T) {
+	req := require.New(t)
+	o, root := newTestObjectStore(t)
+
+	var keys []string
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("backups/my-backup/object-%d", i)
+		req.NoError(o.PutObject("my-bucket", key, strings.NewReader("data")))
+		keys = append(keys, key)
+	}
+	req.NoError(o.PutObject("my-bucket", "backups/other-backup/object", strings.NewReader("data")))
+
+	cleanups := 0
+	defer func(cleanup func(string, func(string) bool, logrus.FieldLogger)) { cleanupEmptyDirs = cleanup }(cleanupEmptyDirs)
+	cleanup := cleanupEmptyDirs
+	cleanupEmptyDirs = func(dir string, stop func(string) bool, log logrus.FieldLogger) {
+		cleanups++
+		cleanup(dir, stop, log)
+	}
+
+	err := o.DeleteObjects("my-bucket", append(keys, "backups/my-backup/missing", "../escape"))
+	req.Error(err)
+	req.Contains(err.Error(), "backups/my-backup/missing")
+	req.Contains(err.Error(), "path escapes the bucket root")
+	req.Equal(1, cleanups)
+
+	_, err = os.Stat(filepath.Join(root, "my-bucket", "backups", "my-backup"))
+	req.True(os.IsNotExist(err))
+	_, err = os.Stat(filepath.Join(root, "my-bucket", "backups", "other-backup", "object"))
+	req.NoError(err)
+}
+
+func Test_DeletePrefix(t *testing.T) {
+	tests := []struct {
+		name        string
+		config      map[string]string
+		prefix      string
+		wantDeleted []string
+		wantErr     string
+	}{
+		{
+			name:        "whole backup",
+			prefix:      "backups/my-backup/",
+			wantDeleted: []string{"backups/my-backup/my-backup.tar.gz", "backups/my-backup/my-backup-logs.gz", "backups/my-backup/nested/object"},
+		},
+		{
+			name:        "partial segment",
+			prefix:      "backups/my-backup",
+			wantDeleted: []string{"backups/my-backup/my-backup.tar.gz", "backups/my-backup/my-backup-logs.gz", "backups/my-backup/nested/object", "backups/my-backup-2/my-backup-2.tar.gz"},
+		},
+		{
+			name:        "compressed",
+			config:      map[string]string{"compression": compressionZstd},
+			prefix:      "backups/my-backup/",
+			wantDeleted: []string{"backups/my-backup/my-backup.tar.gz", "backups/my-backup/my-backup-logs.gz", "backups/my-backup/nested/object"},
+		},
+		{
+			name:   "no matching objects",
+			prefix: "backups/missing/",
+		},
+		{
+			name:    "empty prefix",
+			prefix:  "/",
+			wantErr: "prefix must not be empty",
+		},
+		{
+			name:    "read-only",
+			config:  map[string]string{"readOnly": "true"},
+			prefix:  "backups/my-backup/",
+			wantErr: ErrReadOnly.Error(),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := require.New(t)
+			o, root := newTestObjectStore(t)
+			req.NoError(o.applyConfig(tt.config))
+			readOnly := o.readOnly
+			o.readOnly = false
+
+			keys := []string{
+				"backups/my-backup/my-backup.tar.gz",
+				"backups/my-backup/my-backup-logs.gz",
+				"backups/my-backup/nested/object",
+				"backups/my-backup-2/my-backup-2.tar.gz",
+				"restores/my-restore/my-restore.tar.gz",
+			}
+			for i, key := range keys {
+				req.NoError(o.PutObject("my-bucket", key, strings.NewReader(strings.Repeat("data", 100*(i+1)))))
+			}
+			o.readOnly = readOnly
+
+			var wantBytes int64
+			for _, key := range tt.wantDeleted {
+				filePath, _, err := findObjectFile(filepath.Join(root, "my-bucket", key))
+				req.NoError(err)
+				info, err := os.Stat(filePath)
+				req.NoError(err)
+				wantBytes += info.Size()
+			}
+
+			reclaimed, deleted, err := o.DeletePrefix("my-bucket", tt.prefix)
+			if tt.wantErr != "" {
+				req.ErrorContains(err, tt.wantErr)
+				return
+			}
+			req.NoError(err)
+			req.Equal(len(tt.wantDeleted), deleted)
+			req.Equal(wantBytes, reclaimed)
+
+			// the deleted objects are the first of the keys
+			remaining, err := o.ListObjects("my-bucket", "")
+			req.NoError(err)
+			req.ElementsMatch(keys[len(tt.wantDeleted):], remaining)
+		})
+	}
+}
+
+func Test_DeletePrefix_dedup(t *testing.T) {
+	req := require.New(t)
+	o, _ := newTestObjectStore(t)
+	req.NoError(o.applyConfig(map[string]string{"dedup": dedupHardlink}))
+
+	content := strings.Repeat("data", 100)
+	for _, key := range []string{"backups/a/object", "backups/b/object", "backups/b/other"} {
+		req.NoError(o.PutObject("my-bucket", key, strings.NewReader(content)))
+	}
+
+	// the content of backups/a is shared with backups/b, so deleting it reclaims nothing
+	reclaimed, deleted, err := o.DeletePrefix("my-bucket", "backups/a/")
+	req.NoError(err)
+	req.Equal(1, deleted)
+	req.Zero(reclaimed)
+
+	// the last links to the content reclaim its size once
+	reclaimed, deleted, err = o.DeletePrefix("my-bucket", "backups/b/")
+	req.NoError(err)
+	req.Equal(2, deleted)
+	req.Equal(int64(len(content)), reclaimed)
+}
+
+func Test_resolveKeyPath(t *testing.T) {
+	root := t.TempDir()
+	t.Setenv("VOLUME_ROOT", root)
+
+	tests := []struct {
+		name    string
+		bucket  string
+		key     string
+		want    string
+		wantErr bool
+	}{
+		{
+			name:   "simple key",
+			bucket: "my-bucket",
+			key:    "backups/my-backup/my-backup.tar.gz",
+			want:   filepath.Join(root, "my-bucket", "backups", "my-backup", "my-backup.tar.gz"),
+		},
+		{
+			name:   "key with parent references that stays inside the bucket",
+			bucket: "my-bucket",
+			key:    "backups/other/../my-backup/my-backup.tar.gz",
+			want:   filepath.Join(root, "my-bucket", "backups", "my-backup", "my-backup.tar.gz"),
+		},
+		{
+			name:    "key escaping the bucket",
+			bucket:  "my-bucket",
+			key:     "../../etc/passwd",
+			wantErr: true,
+		},
+		{
+			name:    "key escaping into a sibling bucket",
+			bucket:  "my-bucket",
+			key:     "../other-bucket/backups/my-backup.tar.gz",
+			wantErr: true,
+		},
+		{
+			name:    "bucket escaping the root",
+			bucket:  "../etc",
+			key:     "passwd",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := resolveKeyPath(root, tt.bucket, tt.key)
+			if tt.wantErr {
+				require.ErrorIs(t, err, ErrPathTraversal)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_pathTraversalIsRejected(t *testing.T) {
+	req := require.New(t)
+	o, _ := newTestObjectStore(t)
+	key := "../../etc/passwd"
+
+	req.ErrorIs(o.PutObject("my-bucket", key, strings.NewReader("data")), ErrPathTraversal)
+
+	_, err := o.ObjectExists("my-bucket", key)
+	req.ErrorIs(err, ErrPathTraversal)
+
+	_, err = o.GetObject("my-bucket", key)
+	req.ErrorIs(err, ErrPathTraversal)
+
+	_, err = o.ListObjects("my-bucket", key)
+	req.ErrorIs(err, ErrPathTraversal)
+
+	_, err = o.ListCommonPrefixes("my-bucket", key, "/")
+	req.ErrorIs(err, ErrPathTraversal)
+
+	req.ErrorIs(o.DeleteObject("my-bucket", key), ErrPathTraversal)
+}
+
+func Test_reservedKeysAreRejected(t *testing.T) {
+	tests := []struct {
+		name string
+		key  string
+	}{
+		{
+			name: "gzip suffix",
+			key:  "backups/my-backup/my-backup.tar.gz.lvp.gz",
+		},
+		{
+			name: "zstd suffix",
+			key:  "backups/my-backup/my-backup.tar.gz.lvp.zst",
+		},
+		{
+			name: "reserved suffix on a directory",
+			key:  "backups/my-backup.lvp.gz/my-backup.tar.gz",
+		},
+		{
+			name: "checksum sidecar",
+			key:  "backups/my-backup/my-backup.tar.gz.sha256",
+		},
+		{
+			name: "metadata sidecar",
+			key:  "backups/my-backup/my-backup.tar.gz.meta.json",
+		},
+		{
+			name: "in-progress upload",
+			key:  "backups/my-backup/my-backup.tar.gz.tmp-1234",
+		},
+		{
+			name: "deduplicated content",
+			key:  ".dedup/0123abcd",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := require.New(t)
+			o, _ := newTestObjectStore(t)
+
+			req.ErrorIs(o.PutObject("my-bucket", tt.key, strings.NewReader("plain")), ErrReservedKey)
+
+			_, err := o.ObjectExists("my-bucket", tt.key)
+			req.ErrorIs(err, ErrReservedKey)
+
+			_, err = o.GetObject("my-bucket", tt.key)
+			req.ErrorIs(err, ErrReservedKey)
+
+			req.ErrorIs(o.DeleteObject("my-bucket", tt.key), ErrReservedKey)
+
+			keys, err := o.ListObjects("my-bucket", "")
+			req.NoError(err)
+			req.Empty(keys)
+		})
+	}
+}
+
+func Test_reservedKeysDoNotReplaceSidecars(t *testing.T) {
+	req := require.New(t)
+	o, _ := newTestObjectStore(t)
+	req.NoError(o.applyConfig(map[string]string{"verifyChecksums": "true"}))
+	req.NoError(o.PutObject("my-bucket", "backups/x", strings.NewReader("backup")))
+	req.NoError(o.SetObjectMetadata("my-bucket", "backups/x", map[string]string{"app": "etcd"}))
+
+	req.ErrorIs(o.PutObject("my-bucket", "backups/x.sha256", strings.NewReader("not a checksum")), ErrReservedKey)
+	req.ErrorIs(o.PutObject("my-bucket", "backups/x.meta.json", strings.NewReader("{}")), ErrReservedKey)
+
+	rc, err := o.GetObject("my-bucket", "backups/x")
+	req.NoError(err)
+	got, err := io.ReadAll(rc)
+	req.NoError(err)
+	req.NoError(rc.Close())
+	req.Equal("backup", string(got))
+	meta, err := o.GetObjectMetadata("my-bucket", "backups/x")
+	req.NoError(err)
+	req.Equal(map[string]string{"app": "etcd"}, meta)
+}
+
+func Test_rootSubPath(t *testing.T) {
+	req := require.New(t)
+	root := t.TempDir()
+	t.Setenv("VOLUME_ROOT", root)
+
+	first := NewLocalVolumeObjectStore(logrus.New(), Hostpath)
+	req.NoError(first.applyConfig(map[string]string{"rootSubPath": "cluster-a/"}))
+	second := NewLocalVolumeObjectStore(logrus.New(), Hostpath)
+	req.NoError(second.applyConfig(map[string]string{"rootSubPath": "cluster-b"}))
+
+	key := "backups/my-backup/my-backup.tar.gz"
+	req.NoError(first.PutObject("my-bucket", key, strings.NewReader("first")))
+	_, err := os.Stat(filepath.Join(root, "my-bucket", "cluster-a", key))
+	req.NoError(err)
+
+	exists, err := second.ObjectExists("my-bucket", key)
+	req.NoError(err)
+	req.False(exists)
+	objects, err := second.ListObjects("my-bucket", "backups")
+	req.True(os.IsNotExist(err) || len(objects) == 0)
+
+	objects, err = first.ListObjects("my-bucket", "backups")
+	req.NoError(err)
+	req.Equal([]string{key}, objects)
+	prefixes, err := first.ListCommonPrefixes("my-bucket", "backups/", "/")
+	req.NoError(err)
+	req.Equal([]string{"backups/my-backup/"}, prefixes)
+
+	_, err = first.GetObject("my-bucket", "../cluster-b/"+key)
+	req.ErrorIs(err, ErrPathTraversal)
+
+	req.NoError(first.DeleteObject("my-bucket", key))
+	_, err = os.Stat(filepath.Join(root, "my-bucket", "cluster-a", "backups", "my-backup"))
+	req.True(os.IsNotExist(err))
+
+	for _, subPath := range []string{"../escape", "/absolute", "nested/../../escape"} {
+		req.Error(NewLocalVolumeObjectStore(logrus.New(), Hostpath).applyConfig(map[string]string{"rootSubPath": subPath}), subPath)
+	}
+}
+
+func Test_compression(t *testing.T) {
+	incompressible := make([]byte, 256<<10)
+	_, err := rand.Read(incompressible)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name        string
+		content     []byte
+		wantSmaller bool
+	}{
+		{
+			name:    "incompressible data",
+			content: incompressible,
+		},
+		{
+			name:        "highly compressible data",
+			content:     bytes.Repeat([]byte("velero"), 256<<10),
+			wantSmaller: true,
+		},
+		{
+			name:    "empty object",
+			content: []byte{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := require.New(t)
+			o, root := newTestObjectStore(t)
+			o.compression = compressionGzip
+			o.verifyChecksums = true
+
+			key := "backups/my-backup/my-backup.tar.gz"
+			req.NoError(o.PutObject("my-bucket", key, bytes.NewReader(tt.content)))
+
+			// stored compressed under the suffixed name only
+			path := filepath.Join(root, "my-bucket", key)
+			_, err := os.Stat(path)
+			req.True(os.IsNotExist(err))
+			info, err := os.Stat(compressedPath(path, compressionGzip))
+			req.NoError(err)
+			if tt.wantSmaller {
+				req.Less(info.Size(), int64(len(tt.content)))
+			}
+
+			exists, err := o.ObjectExists("my-bucket", key)
+			req.NoError(err)
+			req.True(exists)
+
+			objects, err := o.ListObjects("my-bucket", "backups/my-backup")
+			req.NoError(err)
+			req.Equal([]string{key}, objects)
+
+			rc, err := o.GetObject("my-bucket", key)
+			req.NoError(err)
+			got, err := io.ReadAll(rc)
+			req.NoError(err)
+			req.NoError(rc.Close())
+			req.Equal(tt.content, got)
+
+			// objects written before compression was enabled are still readable, and are replaced on rewrite
+			o.compression = ""
+			req.NoError(o.PutObject("my-bucket", key, bytes.NewReader(tt.content)))
+			_, err = os.Stat(compressedPath(path, compressionGzip))
+			req.True(os.IsNotExist(err))
+
+			req.NoError(o.DeleteObject("my-bucket", key))
+			exists, err = o.ObjectExists("my-bucket", key)
+			req.NoError(err)
+			req.False(exists)
+		})
+	}
+}
+
+func Test_ListObjectsWithInfo(t *testing.T) {
+	tests := []struct {
+		name   string
+		prefix string
+		want   map[string]int64
+	}{
+		{
+			name:   "empty prefix -- whole bucket is listed",
+			prefix: "",
+			want: map[string]int64{
+				"backups/my-backup/my-backup.tar.gz":        4,
+				"backups/my-backup/nested/volume-info.json": 2,
+				"restores/my-restore/restore-logs.gz":       8,
+			},
+		},
+		{
+			name:   "nested files under the prefix",
+			prefix: "backups/my-backup",
+			want: map[string]int64{
+				"backups/my-backup/my-backup.tar.gz":        4,
+				"backups/my-backup/nested/volume-info.json": 2,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := require.New(t)
+			o, _ := newTestObjectStore(t)
+			before := time.Now().Add(-time.Minute)
+
+			req.NoError(o.PutObject("my-bucket", "backups/my-backup/my-backup.tar.gz", strings.NewReader("data")))
+			req.NoError(o.PutObject("my-bucket", "backups/my-backup/nested/volume-info.json", strings.NewReader("{}")))
+			req.NoError(o.PutObject("my-bucket", "restores/my-restore/restore-logs.gz", strings.NewReader("restored")))
+
+			infos, err := o.ListObjectsWithInfo("my-bucket", tt.prefix)
+			req.NoError(err)
+
+			got := map[string]int64{}
+			for _, info := range infos {
+				got[info.Key] = info.Size
+				req.True(info.ModTime.After(before))
+			}
+			req.Equal(tt.want, got)
+
+			objects, err := o.ListObjects("my-bucket", tt.prefix)
+			req.NoError(err)
+			req.Len(objects, len(tt.want))
+		})
+	}
+}
+
+func Test_PutObjectWithModTime(t *testing.T) {
+	modTime := time.Date(2023, 4, 5, 6, 7, 8, 0, time.UTC)
+
+	tests := []struct {
+		name        string
+		compression string
+		modTime     time.Time
+	}{
+		{
+			name:    "uncompressed object",
+			modTime: modTime,
+		},
+		{
+			name:        "compressed object",
+			compression: compressionGzip,
+			modTime:     modTime,
+		},
+		{
+			name: "zero time -- time of the write",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := require.New(t)
+			o, _ := newTestObjectStore(t)
+			o.compression = tt.compression
+			before := time.Now().Add(-time.Second)
+
+			key := "backups/my-backup/my-backup.tar.gz"
+			req.NoError(o.PutObjectWithModTime("my-bucket", key, strings.NewReader("migrated"), tt.modTime))
+
+			infos, err := o.ListObjectsWithInfo("my-bucket", key)
+			req.NoError(err)
+			req.Len(infos, 1)
+			if tt.modTime.IsZero() {
+				req.True(infos[0].ModTime.After(before))
+			} else {
+				req.True(tt.modTime.Equal(infos[0].ModTime), "got %s", infos[0].ModTime)
+			}
+
+			rc, err := o.GetObject("my-bucket", key)
+			req.NoError(err)
+			defer rc.Close()
+			data, err := io.ReadAll(rc)
+			req.NoError(err)
+			req.Equal("migrated", string(data))
+		})
+	}
+}
+
+func Test_PutObject_parallel(t *testing.T) {
+	content := make([]byte, 5<<20+123)
+	_, err := rand.Read(content)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name   string
+		body   func() io.Reader
+		config map[string]string
+	}{
+		{
+			name: "seekable body -- written in parallel",
+			body: func() io.Reader { return bytes.NewReader(content) },
+		},
+		{
+			name: "partially read body -- remainder written in parallel",
+			body: func() io.Reader {
+				r := bytes.NewReader(append([]byte("header"), content...))
+				r.Seek(int64(len("header")), io.SeekStart)
+				return r
+			},
+		},
+		{
+			name: "non-seekable body -- written sequentially",
+			body: func() io.Reader { return struct{ io.Reader }{bytes.NewReader(content)} },
+		},
+		{
+			name:   "compressed -- written sequentially",
+			body:   func() io.Reader { return bytes.NewReader(content) },
+			config: map[string]string{"compression": compressionGzip},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := require.New(t)
+			o, _ := newTestObjectStore(t)
+			req.NoError(o.applyConfig(tt.config))
+			o.uploadParallelism = 4
+			o.copyBufferSize = 64 << 10
+			o.verifyChecksums = true
+
+			req.NoError(o.PutObject("bucket", "key", tt.body()))
+
+			rc, err := o.GetObject("bucket", "key")
+			req.NoError(err)
+			defer rc.Close()
+			got, err := io.ReadAll(rc)
+			req.NoError(err)
+			req.True(bytes.Equal(content, got))
+		})
+	}
+}
+
+// Benchmark_PutObject_parallel measures upload scaling with the number of workers.
+// Set LVP_BENCH_ROOT to a directory on an NFS mount to see the effect of network latency;
+// on local storage the workers mostly contend for the same disk.
+func Benchmark_PutObject_parallel(b *testing.B) {
+	const objectSize = 256 << 20
+
+	root := os.Getenv("LVP_BENCH_ROOT")
+	if root == "" {
+		root = b.TempDir()
+	} else {
+		dir, err := os.MkdirTemp(root, "lvp-bench-")
+		require.NoError(b, err)
+		b.Cleanup(func() { os.RemoveAll(dir) })
+		root = dir
+	}
+	b.Setenv("VOLUME_ROOT", root)
+
+	content := make([]byte, objectSize)
+	for _, parallelism := range []int{1, 2, 4, 8} {
+		b.Run(fmt.Sprintf("parallelism=%d", parallelism), func(b *testing.B) {
+			o := NewLocalVolumeObjectStore(logrus.New(), Hostpath)
+			o.uploadParallelism = parallelism
+
+			b.SetBytes(objectSize)
+			for i := 0; i < b.N; i++ {
+				err := o.PutObject("my-bucket", "backups/my-backup/my-backup.tar.gz", bytes.NewReader(content))
+				require.NoError(b, err)
+			}
+		})
+	}
+}
+
+// mountTestTmpfs mounts a tmpfs of the given size as the volume root, skipping the test without the privileges to do so.
+func mountTestTmpfs(t *testing.T, size string) string {
+	root := t.TempDir()
+	if err := unix.Mount("tmpfs", root, "tmpfs", 0, "size="+size); err != nil {
+		t.Skipf("mounting a tmpfs requires privileges: %v", err)
+	}
+	t.Cleanup(func() { unix.Unmount(root, 0) })
+	t.Setenv("VOLUME_ROOT", root)
+	return root
+}
+
+func Test_PutObject_storageFull(t *testing.T) {
+	req := require.New(t)
+	root := mountTestTmpfs(t, "64k")
+
+	o := NewLocalVolumeObjectStore(logrus.New(), Hostpath)
+	before := &dto.Metric{}
+	req.NoError(metrics.storageFull.Write(before))
+
+	// the size is unknown, so the write fails part way through
+	content := make([]byte, 1<<20)
+	err := o.PutObject("my-bucket", "backups/my-backup/my-backup.tar.gz", io.MultiReader(bytes.NewReader(content)))
+	req.ErrorIs(err, ErrStorageFull)
+	req.True(isStorageFullError(err))
+
+	entries, err := os.ReadDir(filepath.Join(root, "my-bucket", "backups", "my-backup"))
+	req.NoError(err)
+	req.Empty(entries, "partial files should be removed")
+
+	after := &dto.Metric{}
+	req.NoError(metrics.storageFull.Write(after))
+	req.Equal(before.GetCounter().GetValue()+1, after.GetCounter().GetValue())
+}
+
+func Test_getRoot(t *testing.T) {
+	tests := []struct {
+		name           string
+		nfsProviderEnv string
+		volumeRootEnv  string
+		want           string
+	}{
+		{
+			name: "default",
+			want: defaultRoot,
+		},
+		{
+			name:          "VOLUME_ROOT",
+			volumeRootEnv: "/volume-root",
+			want:          "/volume-root",
+		},
+		{
+			name:           "NFS_PROVIDER_ROOT takes precedence",
+			nfsProviderEnv: "/nfs-provider-root",
+			volumeRootEnv:  "/volume-root",
+			want:           "/nfs-provider-root",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("NFS_PROVIDER_ROOT", tt.nfsProviderEnv)
+			t.Setenv("VOLUME_ROOT", tt.volumeRootEnv)
+			require.Equal(t, tt.want, getRoot())
+		})
+	}
+}
+
+func Test_validateRoot(t *testing.T) {
+	req := require.New(t)
+	root := t.TempDir()
+	file := filepath.Join(root, "file")
+	req.NoError(os.WriteFile(file, nil, 0644))
+
+	req.NoError(validateRoot(root))
+	req.Error(validateRoot(file))
+	req.Error(validateRoot(filepath.Join(root, "missing")))
+}
+
+func Test_rootOverride(t *testing.T) {
+	tests := []struct {
+		name     string
+		rootPath bool
+	}{
+		{
+			name: "NFS_PROVIDER_ROOT",
+		},
+		{
+			name:     "rootPath config",
+			rootPath: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := require.New(t)
+			root := t.TempDir()
+			t.Setenv("VOLUME_ROOT", t.TempDir())
+			o := NewLocalVolumeObjectStore(logrus.New(), Hostpath)
+			if tt.rootPath {
+				o.opts = &localVolumeObjectStoreOpts{rootPath: root}
+			} else {
+				t.Setenv("NFS_PROVIDER_ROOT", root)
+			}
+
+			req.NoError(o.PutObject("my-bucket", "backups/my-backup/my-backup.tar.gz", strings.NewReader("backup contents")))
+			_, err := os.Stat(filepath.Join(root, "my-bucket", "backups", "my-backup", "my-backup.tar.gz"))
+			req.NoError(err)
+
+			keys, err := o.ListObjects("my-bucket", "backups/")
+			req.NoError(err)
+			req.Equal([]string{"backups/my-backup/my-backup.tar.gz"}, keys)
+		})
+	}
+}
+
+func Test_PutObject_freeSpaceCheck(t *testing.T) {
+	tests := []struct {
+		name         string
+		fill         bool
+		minFreeBytes int64
+		body         io.Reader
+		wantErr      bool
+	}{
+		{
+			name: "room for the object",
+			body: bytes.NewReader([]byte("backup contents")),
+		},
+		{
+			name:    "full volume -- rejected before writing",
+			fill:    true,
+			body:    bytes.NewReader([]byte("backup contents")),
+			wantErr: true,
+		},
+		{
+			name:         "object would not leave minFreeBytes -- rejected before writing",
+			minFreeBytes: 2 << 20,
+			body:         strings.NewReader("backup contents"),
+			wantErr:      true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := require.New(t)
+			root := mountTestTmpfs(t, "1m")
+			o := NewLocalVolumeObjectStore(logrus.New(), Hostpath)
+			o.minFreeBytes = tt.minFreeBytes
+
+			if tt.fill {
+				f, err := os.Create(filepath.Join(root, "filler"))
+				req.NoError(err)
+				_, err = f.Write(make([]byte, 2<<20))
+				req.True(isStorageFullError(err))
+				req.NoError(f.Close())
+			}
+
+			err := o.PutObject("my-bucket", "backups/my-backup/my-backup.tar.gz", tt.body)
+			if !tt.wantErr {
+				req.NoError(err)
+				return
+			}
+			req.ErrorIs(err, ErrStorageFull)
+			req.False(isStorageFullError(err), "should be rejected without attempting the write")
+
+			entries, err := os.ReadDir(filepath.Join(root, "my-bucket", "backups", "my-backup"))
+			req.NoError(err)
+			req.Empty(entries)
+		})
+	}
+}
+
+func Test_PutObject_maxObjectSize(t *testing.T) {
+	tests := []struct {
+		name               string
+		maxObjectSizeBytes int64
+		body               io.Reader
+		wantErr            bool
+	}{
+		{
+			name:               "body of exactly the limit",
+			maxObjectSizeBytes: 15,
+			body:               io.MultiReader(strings.NewReader("backup contents")),
+		},
+		{
+			name:               "body past the limit -- aborted while writing",
+			maxObjectSizeBytes: 14,
+			body:               io.MultiReader(strings.NewReader("backup contents")),
+			wantErr:            true,
+		},
+		{
+			name:               "known size past the limit -- rejected before writing",
+			maxObjectSizeBytes: 14,
+			body:               strings.NewReader("backup contents"),
+			wantErr:            true,
+		},
+		{
+			name: "unset -- unlimited",
+			body: io.MultiReader(bytes.NewReader(make([]byte, 1<<20))),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := require.New(t)
+			o, root := newTestObjectStore(t)
+			o.maxObjectSizeBytes = tt.maxObjectSizeBytes
+
+			err := o.PutObject("my-bucket", "backups/my-backup/my-backup.tar.gz", tt.body)
+			if !tt.wantErr {
+				req.NoError(err)
+				exists, err := o.ObjectExists("my-bucket", "backups/my-backup/my-backup.tar.gz")
+				req.NoError(err)
+				req.True(exists)
+				return
+			}
+			req.ErrorIs(err, ErrObjectTooLarge)
+
+			entries, err := os.ReadDir(filepath.Join(root, "my-bucket", "backups", "my-backup"))
+			req.NoError(err)
+			req.Empty(entries, "partial files should be removed")
+		})
+	}
+}
+
+func Test_bodySize(t *testing.T) {
+	req := require.New(t)
+
+	r := strings.NewReader("backup contents")
+	_, err := r.Seek(7, io.SeekStart)
+	req.NoError(err)
+	size, ok := bodySize(r)
+	req.True(ok)
+	req.Equal(int64(8), size)
+
+	f, err := os.CreateTemp(t.TempDir(), "body")
+	req.NoError(err)
+	defer f.Close()
+	_, err = f.WriteString("backup contents")
+	req.NoError(err)
+	_, err = f.Seek(0, io.SeekStart)
+	req.NoError(err)
+	size, ok = bodySize(f)
+	req.True(ok)
+	req.Equal(int64(15), size)
+	offset, err := f.Seek(0, io.SeekCurrent)
+	req.NoError(err)
+	req.Zero(offset, "the body should be left where it was")
+
+	_, ok = bodySize(io.MultiReader(r))
+	req.False(ok)
+}
+
+func Test_readOnly(t *testing.T) {
+	req := require.New(t)
+	o, root := newTestObjectStore(t)
+	key := "backups/my-backup/my-backup.tar.gz"
+	req.NoError(o.PutObject("my-bucket", key, strings.NewReader("backup contents")))
+
+	req.NoError(o.applyConfig(map[string]string{"readOnly": "true"}))
+
+	req.ErrorIs(o.PutObject("my-bucket", "backups/other/other.tar.gz", strings.NewReader("other contents")), ErrReadOnly)
+	req.ErrorIs(o.PutObject("my-bucket", key, strings.NewReader("overwritten")), ErrReadOnly)
+	req.ErrorIs(o.DeleteObject("my-bucket", key), ErrReadOnly)
+	req.ErrorIs(o.DeleteObjects("my-bucket", []string{key}), ErrReadOnly)
+	req.ErrorIs(o.CopyObject("my-bucket", key, "backups/my-backup/copy.tar.gz"), ErrReadOnly)
+	req.ErrorIs(o.MoveObject("my-bucket", key, "backups/my-backup/moved.tar.gz"), ErrReadOnly)
+
+	_, err := os.Stat(filepath.Join(root, "my-bucket", "backups", "other"))
+	req.True(os.IsNotExist(err), "rejected writes should not touch the filesystem")
+
+	exists, err := o.ObjectExists("my-bucket", key)
+	req.NoError(err)
+	req.True(exists)
+
+	rc, err := o.GetObject("my-bucket", key)
+	req.NoError(err)
+	defer rc.Close()
+	got, err := io.ReadAll(rc)
+	req.NoError(err)
+	req.Equal("backup contents", string(got))
+
+	keys, err := o.ListObjects("my-bucket", "backups/")
+	req.NoError(err)
+	req.Equal([]string{key}, keys)
+}
+
+func Test_PutObject_concurrentSameKey(t *testing.T) {
+	req := require.New(t)
+	o, root := newTestObjectStore(t)
+	key := "backups/my-backup/my-backup.tar.gz"
+	first := bytes.Repeat([]byte("first "), 100000)
+	second := bytes.Repeat([]byte("second "), 100000)
+
+	// the first write holds the key while it waits for the rest of its body
+	body, w := io.Pipe()
+	firstErr := make(chan error, 1)
+	go func() {
+		firstErr <- o.PutObject("my-bucket", key, body)
+	}()
+	_, err := w.Write(first[:1024])
+	req.NoError(err)
+
+	secondErr := make(chan error, 1)
+	go func() {
+		secondErr <- o.PutObject("my-bucket", key, bytes.NewReader(second))
+	}()
+
+	// writes to other keys are not held up
+	req.NoError(o.PutObject("my-bucket", "backups/my-backup/other.tar.gz", strings.NewReader("other contents")))
+
+	select {
+	case <-secondErr:
+		t.Fatal("second write to the key finished while the first was in progress")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	_, err = w.Write(first[1024:])
+	req.NoError(err)
+	req.NoError(w.Close())
+	req.NoError(<-firstErr)
+	req.NoError(<-secondErr)
+
+	got, err := os.ReadFile(filepath.Join(root, "my-bucket", "backups", "my-backup", "my-backup.tar.gz"))
+	req.NoError(err)
+	req.Equal(second, got, "the last write should win with its complete body")
+
+	digest, err := readChecksum(filepath.Join(root, "my-bucket", "backups", "my-backup", "my-backup.tar.gz"))
+	req.NoError(err)
+	sum := sha256.Sum256(second)
+	req.Equal(hex.EncodeToString(sum[:]), digest, "the checksum should match the content in place")
+}
+
+func Test_PutObjectIfAbsent(t *testing.T) {
+	req := require.New(t)
+	o, root := newTestObjectStore(t)
+	key := "backups/my-backup/my-backup.tar.gz"
+	path := filepath.Join(root, "my-bucket", "backups", "my-backup", "my-backup.tar.gz")
+
+	created, err := o.PutObjectIfAbsent("my-bucket", key, strings.NewReader("first"))
+	req.NoError(err)
+	req.True(created)
+	got, err := os.ReadFile(path)
+	req.NoError(err)
+	req.Equal("first", string(got))
+	digest, err := readChecksum(path)
+	req.NoError(err)
+	sum := sha256.Sum256([]byte("first"))
+	req.Equal(hex.EncodeToString(sum[:]), digest)
+
+	created, err = o.PutObjectIfAbsent("my-bucket", key, strings.NewReader("second"))
+	req.NoError(err)
+	req.False(created)
+	got, err = os.ReadFile(path)
+	req.NoError(err)
+	req.Equal("first", string(got), "the existing object should be kept")
+
+	// an object stored compressed exists under its key too
+	o.compression = compressionZstd
+	req.NoError(o.PutObject("my-bucket", "backups/my-backup/logs.gz", strings.NewReader("logs")))
+	o.compression = ""
+	created, err = o.PutObjectIfAbsent("my-bucket", "backups/my-backup/logs.gz", strings.NewReader("other logs"))
+	req.NoError(err)
+	req.False(created)
+
+	o.readOnly = true
+	created, err = o.PutObjectIfAbsent("my-bucket", "backups/my-backup/new", strings.NewReader("new"))
+	req.ErrorIs(err, ErrReadOnly)
+	req.False(created)
+}
+
+func Test_PutObjectWithChecksum(t *testing.T) {
+	content := []byte("backup contents")
+	sum := sha256.Sum256(content)
+	digest := hex.EncodeToString(sum[:])
+	other := sha256.Sum256([]byte("other contents"))
+
+	tests := []struct {
+		name        string
+		parallelism int
+		compression string
+		expected    string
+		wantErr     error
+	}{
+		{
+			name:     "matching checksum",
+			expected: digest,
+		},
+		{
+			name:     "matching checksum in upper case",
+			expected: strings.ToUpper(digest),
+		},
+		{
+			name:        "matching checksum -- parallel upload",
+			parallelism: 2,
+			expected:    digest,
+		},
+		{
+			name:        "matching checksum -- compressed object is checked against its content",
+			compression: compressionZstd,
+			expected:    digest,
+		},
+		{
+			name:     "mismatching checksum",
+			expected: hex.EncodeToString(other[:]),
+			wantErr:  ErrChecksumMismatch,
+		},
+		{
+			name:        "mismatching checksum -- parallel upload",
+			parallelism: 2,
+			expected:    hex.EncodeToString(other[:]),
+			wantErr:     ErrChecksumMismatch,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := require.New(t)
+			o, root := newTestObjectStore(t)
+			o.uploadParallelism = 1
+			if tt.parallelism > 0 {
+				o.uploadParallelism = tt.parallelism
+			}
+			o.compression = tt.compression
+			key := "backups/my-backup/my-backup.tar.gz"
+			path := filepath.Join(root, "my-bucket", "backups", "my-backup", "my-backup.tar.gz")
+			req.NoError(o.PutObject("my-bucket", key, strings.NewReader("previous contents")))
+
+			err := o.PutObjectWithChecksum("my-bucket", key, bytes.NewReader(content), tt.expected)
+			if tt.wantErr != nil {
+				req.ErrorIs(err, tt.wantErr)
+
+				// The object in place and its checksum are kept, and no partial file is left behind
+				rc, err := o.GetObject("my-bucket", key)
+				req.NoError(err)
+				got, err := io.ReadAll(rc)
+				req.NoError(err)
+				req.NoError(rc.Close())
+				req.Equal("previous contents", string(got))
+				previous := sha256.Sum256([]byte("previous contents"))
+				sidecar, err := readChecksum(path)
+				req.NoError(err)
+				req.Equal(hex.EncodeToString(previous[:]), sidecar)
+				entries, err := os.ReadDir(filepath.Dir(path))
+				req.NoError(err)
+				for _, entry := range entries {
+					req.False(isTempFile(entry.Name()), entry.Name())
+				}
+				return
+			}
+			req.NoError(err)
+
+			rc, err := o.GetObject("my-bucket", key)
+			req.NoError(err)
+			got, err := io.ReadAll(rc)
+			req.NoError(err)
+			req.NoError(rc.Close())
+			req.Equal(content, got)
+			sidecar, err := readChecksum(path)
+			req.NoError(err)
+			req.Equal(digest, sidecar)
+		})
+	}
+}
+
+func Test_PutObjectWithChecksum_invalid(t *testing.T) {
+	o, root := newTestObjectStore(t)
+	for _, expected := range []string{"", "not hex", "abcd"} {
+		err := o.PutObjectWithChecksum("my-bucket", "backups/my-backup/my-backup.tar.gz", strings.NewReader("contents"), expected)
+		require.ErrorContains(t, err, "invalid expected checksum", expected)
+	}
+	_, err := os.Stat(filepath.Join(root, "my-bucket", "backups"))
+	require.True(t, os.IsNotExist(err), "nothing should be written for an invalid checksum")
+}
+
+func Test_writeObjectFile_noReplace(t *testing.T) {
+	req := require.New(t)
+	filePath := filepath.Join(t.TempDir(), "object")
+	req.NoError(os.WriteFile(filePath, []byte("existing"), 0644))
+
+	// an object linked into place by another process after the existence check is not replaced
+	_, err := writeObjectFile(filePath, 0644, true, logrus.New(), func(file *os.File) (string, error) {
+		_, err := file.WriteString("new")
+		return "", err
+	})
+	req.ErrorIs(err, errObjectExists)
+	got, err := os.ReadFile(filePath)
+	req.NoError(err)
+	req.Equal("existing", string(got))
+
+	entries, err := os.ReadDir(filepath.Dir(filePath))
+	req.NoError(err)
+	req.Len(entries, 1, "the temporary file should be removed")
+}
+
+func Test_keyLocks(t *testing.T) {
+	req := require.New(t)
+	var l keyLocks
+
+	unlock := l.lock("my-bucket/a")
+	locked := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer l.lock("my-bucket/a")()
+		close(locked)
+	}()
+	l.lock("my-bucket/b")()
+
+	select {
+	case <-locked:
+		t.Fatal("key was locked twice")
+	case <-time.After(20 * time.Millisecond):
+	}
+	unlock()
+	<-done
+
+	for i := range l.shards {
+		req.Empty(l.shards[i].locks, "released locks should be removed")
+	}
+}
+
+func Test_CreateSignedURL(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	listeningPort := listener.Addr().(*net.TCPAddr).Port
+
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	closedPort := closed.Addr().(*net.TCPAddr).Port
+	require.NoError(t, closed.Close())
+
+	tests := []struct {
+		name         string
+		podIP        string
+		opts         *localVolumeObjectStoreOpts
+		config       map[string]string
+		wantErr      string
+		wantHostPort int
+	}{
+		{
+			name:         "pod IP",
+			podIP:        "127.0.0.1",
+			opts:         &localVolumeObjectStoreOpts{fileserverPort: closedPort},
+			wantHostPort: closedPort,
+		},
+		{
+			name:    "empty pod IP",
+			opts:    &localVolumeObjectStoreOpts{},
+			wantErr: "POD_IP is not set",
+		},
+		{
+			name: "empty pod IP with an external host",
+			opts: &localVolumeObjectStoreOpts{fileserverExternalHost: "backups.example.com"},
+		},
+		{
+			name:         "reachable fileserver",
+			podIP:        "127.0.0.1",
+			opts:         &localVolumeObjectStoreOpts{fileserverPort: listeningPort},
+			config:       map[string]string{"validateSignedURLReachability": "true"},
+			wantHostPort: listeningPort,
+		},
+		{
+			name:    "unreachable fileserver",
+			podIP:   "127.0.0.1",
+			opts:    &localVolumeObjectStoreOpts{fileserverPort: closedPort},
+			config:  map[string]string{"validateSignedURLReachability": "true"},
+			wantErr: "not reachable",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := require.New(t)
+			t.Setenv("POD_IP", tt.podIP)
+			o := NewLocalVolumeObjectStore(logrus.New(), Hostpath)
+			req.NoError(o.applyConfig(tt.config))
+			o.opts = tt.opts
+			o.opts.signingKey = []byte("signing-key")
+
+			signedURL, err := o.CreateSignedURL("my-bucket", "backups/my-backup/my-backup.tar.gz", time.Hour)
+			if tt.wantErr != "" {
+				req.ErrorContains(err, tt.wantErr)
+				req.Empty(signedURL)
+				return
+			}
+			req.NoError(err)
+			u, err := url.Parse(signedURL)
+			req.NoError(err)
+			if tt.wantHostPort != 0 {
+				req.Equal(fmt.Sprintf("127.0.0.1:%d", tt.wantHostPort), u.Host)
+			}
+			req.NoError(CheckSignedURL(signedURL, o.opts.signingKey, "", 0))
+		})
+	}
+}
+
+func Test_keyLocks_lockAll(t *testing.T) {
+	req := require.New(t)
+	var l keyLocks
+
+	// Locking overlapping keys in opposite orders does not deadlock
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 1000; i++ {
+			l.lockAll("my-bucket/a", "my-bucket/b")()
+		}
+	}()
+	for i := 0; i < 1000; i++ {
+		l.lockAll("my-bucket/b", "my-bucket/a", "my-bucket/b")()
+	}
+	<-done
+
+	unlock := l.lockAll("my-bucket/a", "my-bucket/b")
+	locked := make(chan struct{})
+	released := make(chan struct{})
+	go func() {
+		defer close(released)
+		defer l.lock("my-bucket/b")()
+		close(locked)
+	}()
+	select {
+	case <-locked:
+		t.Fatal("key was locked twice")
+	case <-time.After(20 * time.Millisecond):
+	}
+	unlock()
+	<-released
+
+	for i := range l.shards {
+		shard := &l.shards[i]
+		shard.mu.Lock()
+		req.Empty(shard.locks, "released locks should be removed")
+		shard.mu.Unlock()
+	}
+}
+
+func Test_keyLocks_waitForPutObject(t *testing.T) {
+	key := "backups/my-backup/my-backup.tar.gz"
+	tests := []struct {
+		name string
+		op   func(o *LocalVolumeObjectStore) error
+	}{
+		{
+			name: "DeleteObject",
+			op: func(o *LocalVolumeObjectStore) error {
+				return o.DeleteObject("my-bucket", key)
+			},
+		},
+		{
+			name: "DeleteObjects",
+			op: func(o *LocalVolumeObjectStore) error {
+				return o.DeleteObjects("my-bucket", []string{key})
+			},
+		},
+		{
+			name: "CopyObject from the key",
+			op: func(o *LocalVolumeObjectStore) error {
+				return o.CopyObject("my-bucket", key, "backups/my-backup/copied.tar.gz")
+			},
+		},
+		{
+			name: "MoveObject from the key",
+			op: func(o *LocalVolumeObjectStore) error {
+				return o.MoveObject("my-bucket", key, "backups/my-backup/moved.tar.gz")
+			},
+		},
+		{
+			name: "MoveObject to the key",
+			op: func(o *LocalVolumeObjectStore) error {
+				return o.MoveObject("my-bucket", "backups/my-backup/other.tar.gz", key)
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := require.New(t)
+			o, _ := newTestObjectStore(t)
+			req.NoError(o.PutObject("my-bucket", key, strings.NewReader("old contents")))
+			req.NoError(o.PutObject("my-bucket", "backups/my-backup/other.tar.gz", strings.NewReader("other contents")))
+
+			// The write holds the key while it waits for the rest of its body
+			body, w := io.Pipe()
+			putErr := make(chan error, 1)
+			go func() {
+				putErr <- o.PutObject("my-bucket", key, body)
+			}()
+			_, err := w.Write([]byte("new "))
+			req.NoError(err)
+
+			opErr := make(chan error, 1)
+			go func() {
+				opErr <- tt.op(o)
+			}()
+			select {
+			case <-opErr:
+				t.Fatal("operation on the key finished while a write to it was in progress")
+			case <-time.After(50 * time.Millisecond):
+			}
+
+			_, err = w.Write([]byte("contents"))
+			req.NoError(err)
+			req.NoError(w.Close())
+			req.NoError(<-putErr)
+			req.NoError(<-opErr)
+		})
+	}
+}
@@ -0,0 +1,86 @@
+package plugin
+
+import (
+	"io"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// defaultPrefetchWorkers is the number of objects GetObjects opens at once unless configured otherwise.
+const defaultPrefetchWorkers = 8
+
+// GetObjects opens many objects of the bucket at once, as restores read thousands of small objects and the
+// round trip of each open on NFS would otherwise dominate. At most prefetchWorkers objects are opened at once.
+// The readers are returned by key, and the caller must close each of them.
+// If any object fails to open, the readers already opened are closed and the first error is returned.
+func (o *LocalVolumeObjectStore) GetObjects(bucket string, keys []string) (readers map[string]io.ReadCloser, err error) {
+	defer observeOperation("GetObjects", time.Now(), &err)
+
+	log := o.log.WithFields(logrus.Fields{
+		"bucket": bucket,
+		"keys":   len(keys),
+	})
+	log.Debug("LocalVolumeObjectStore.GetObjects called")
+
+	workers := o.prefetchWorkers
+	if workers < 1 {
+		workers = defaultPrefetchWorkers
+	}
+
+	var (
+		mu       sync.Mutex
+		firstErr error
+	)
+	readers = make(map[string]io.ReadCloser, len(keys))
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				key := keys[i]
+				mu.Lock()
+				failed := firstErr != nil
+				mu.Unlock()
+				// Once an object has failed to open, the rest are not needed
+				if failed {
+					continue
+				}
+
+				rc, err := o.GetObject(bucket, key)
+
+				mu.Lock()
+				if err != nil {
+					if firstErr == nil {
+						firstErr = errors.Wrapf(err, "failed to open object %s", key)
+					}
+				} else if existing, ok := readers[key]; ok {
+					// A key listed twice is only opened once
+					existing.Close()
+					readers[key] = rc
+				} else {
+					readers[key] = rc
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for i := range keys {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+
+	if firstErr != nil {
+		for _, rc := range readers {
+			rc.Close()
+		}
+		return nil, firstErr
+	}
+
+	return readers, nil
+}
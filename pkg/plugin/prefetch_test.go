@@ -0,0 +1,66 @@
+package plugin
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// openFiles returns the number of files open by the test process.
+func openFiles(t *testing.T) int {
+	entries, err := os.ReadDir("/proc/self/fd")
+	require.NoError(t, err)
+	return len(entries)
+}
+
+func Test_GetObjects(t *testing.T) {
+	for _, workers := range []int{1, 8, 64} {
+		t.Run(fmt.Sprintf("%d workers", workers), func(t *testing.T) {
+			req := require.New(t)
+			o, _ := newTestObjectStore(t)
+			o.prefetchWorkers = workers
+
+			var keys []string
+			for i := 0; i < 50; i++ {
+				key := fmt.Sprintf("restores/my-restore/object-%d", i)
+				req.NoError(o.PutObject("my-bucket", key, strings.NewReader(fmt.Sprintf("content of %d", i))))
+				keys = append(keys, key)
+			}
+
+			readers, err := o.GetObjects("my-bucket", keys)
+			req.NoError(err)
+			req.Len(readers, len(keys))
+			for i, key := range keys {
+				got, err := io.ReadAll(readers[key])
+				req.NoError(err)
+				req.NoError(readers[key].Close())
+				req.Equal(fmt.Sprintf("content of %d", i), string(got))
+			}
+		})
+	}
+}
+
+func Test_GetObjects_partialFailure(t *testing.T) {
+	req := require.New(t)
+	o, _ := newTestObjectStore(t)
+
+	var keys []string
+	for i := 0; i < 20; i++ {
+		key := fmt.Sprintf("restores/my-restore/object-%d", i)
+		req.NoError(o.PutObject("my-bucket", key, strings.NewReader("content")))
+		keys = append(keys, key)
+	}
+	keys = append(keys[:10], append([]string{"restores/my-restore/missing"}, keys[10:]...)...)
+
+	before := openFiles(t)
+	readers, err := o.GetObjects("my-bucket", keys)
+	req.ErrorIs(err, ErrObjectNotFound)
+	req.ErrorContains(err, "restores/my-restore/missing")
+	req.Nil(readers)
+	// every reader opened before the failure is closed
+	req.Equal(before, openFiles(t))
+}
@@ -0,0 +1,88 @@
+package plugin
+
+import (
+	"io"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// reportPutProgress logs how much of an upload in progress has been written after elapsed.
+var reportPutProgress = func(log logrus.FieldLogger, written int64, elapsed time.Duration) {
+	log.Infof("Uploaded %d bytes in %s (%.1f MiB/s)", written, elapsed.Round(time.Second), float64(written)/(1<<20)/elapsed.Seconds())
+}
+
+// putProgress counts the bytes an upload has read from its body, adding them to the inflight bytes gauge.
+// If the store has a progressInterval, they are also reported every interval until the upload finishes.
+type putProgress struct {
+	n       atomic.Int64
+	stop    chan struct{}
+	stopped chan struct{}
+}
+
+// startPutProgress starts tracking an upload. finish must be called once it succeeds or fails.
+func (o *LocalVolumeObjectStore) startPutProgress(log logrus.FieldLogger) *putProgress {
+	p := &putProgress{stop: make(chan struct{}), stopped: make(chan struct{})}
+	if o.progressInterval <= 0 {
+		close(p.stopped)
+		return p
+	}
+
+	start := time.Now()
+	go func() {
+		defer close(p.stopped)
+		ticker := time.NewTicker(o.progressInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-p.stop:
+				return
+			case <-ticker.C:
+				reportPutProgress(log, p.n.Load(), time.Since(start))
+			}
+		}
+	}()
+	return p
+}
+
+func (p *putProgress) add(n int64) {
+	p.n.Add(n)
+	metrics.inflightBytes.Add(float64(n))
+}
+
+// reset starts the count over, as when a failed attempt is retried from the start of the body.
+func (p *putProgress) reset() {
+	metrics.inflightBytes.Sub(float64(p.n.Swap(0)))
+}
+
+// finish stops reporting and removes the bytes of the upload from the inflight bytes gauge.
+func (p *putProgress) finish() {
+	close(p.stop)
+	<-p.stopped
+	p.reset()
+}
+
+// progressReader adds the bytes read through it to the progress of an upload.
+type progressReader struct {
+	io.Reader
+	progress *putProgress
+}
+
+func (r *progressReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.progress.add(int64(n))
+	return n, err
+}
+
+// progressReaderAt is the io.ReaderAt counterpart of progressReader, safe for the concurrent reads of parallel uploads.
+type progressReaderAt struct {
+	io.ReaderAt
+	progress *putProgress
+}
+
+func (r *progressReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	n, err := r.ReaderAt.ReadAt(p, off)
+	r.progress.add(int64(n))
+	return n, err
+}
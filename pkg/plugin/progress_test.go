@@ -0,0 +1,97 @@
+package plugin
+
+import (
+	"bytes"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+// slowReader returns its content a chunk at a time, pausing before each chunk like a body arriving over the network.
+type slowReader struct {
+	r     io.Reader
+	chunk int
+	delay time.Duration
+}
+
+func (s *slowReader) Read(p []byte) (int, error) {
+	time.Sleep(s.delay)
+	if len(p) > s.chunk {
+		p = p[:s.chunk]
+	}
+	return s.r.Read(p)
+}
+
+func Test_PutObject_progress(t *testing.T) {
+	req := require.New(t)
+	o, _ := newTestObjectStore(t)
+	req.NoError(o.applyConfig(map[string]string{"progressInterval": "10ms"}))
+
+	var (
+		mu      sync.Mutex
+		written []int64
+	)
+	defer func(report func(logrus.FieldLogger, int64, time.Duration)) { reportPutProgress = report }(reportPutProgress)
+	reportPutProgress = func(log logrus.FieldLogger, n int64, elapsed time.Duration) {
+		mu.Lock()
+		defer mu.Unlock()
+		written = append(written, n)
+	}
+
+	var inflight []float64
+	content := bytes.Repeat([]byte("backup contents "), 1<<16)
+	body := &slowReader{r: bytes.NewReader(content), chunk: 64 << 10, delay: 5 * time.Millisecond}
+	done := make(chan error, 1)
+	go func() {
+		done <- o.PutObject("my-bucket", "backups/my-backup/my-backup.tar.gz", body)
+	}()
+	for finished := false; !finished; {
+		select {
+		case err := <-done:
+			req.NoError(err)
+			finished = true
+		case <-time.After(10 * time.Millisecond):
+			inflight = append(inflight, gaugeValue(t))
+		}
+	}
+
+	mu.Lock()
+	reports := len(written)
+	req.GreaterOrEqual(reports, 3, "progress should be reported while the body is written")
+	for i := 1; i < len(written); i++ {
+		req.GreaterOrEqual(written[i], written[i-1])
+	}
+	req.LessOrEqual(written[len(written)-1], int64(len(content)))
+	mu.Unlock()
+	req.Greater(maxOf(inflight), float64(0), "the bytes of the upload should be in flight while it runs")
+	req.Equal(float64(0), gaugeValue(t), "the bytes of the upload should no longer be in flight once it finishes")
+
+	// reporting stops with the upload
+	time.Sleep(50 * time.Millisecond)
+	mu.Lock()
+	req.Equal(reports, len(written))
+	mu.Unlock()
+
+	req.ErrorContains(o.applyConfig(map[string]string{"progressInterval": "-1s"}), "progressInterval")
+}
+
+func gaugeValue(t *testing.T) float64 {
+	var m dto.Metric
+	require.NoError(t, metrics.inflightBytes.Write(&m))
+	return m.GetGauge().GetValue()
+}
+
+func maxOf(values []float64) float64 {
+	var max float64
+	for _, v := range values {
+		if v > max {
+			max = v
+		}
+	}
+	return max
+}
@@ -0,0 +1,134 @@
+package plugin
+
+import (
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// retentionConfigPrefix and retentionConfigSuffix surround the key prefix of a retention rule, as retention.<prefix>.ttl.
+	retentionConfigPrefix = "retention."
+	retentionConfigSuffix = ".ttl"
+
+	// defaultRetentionSweepInterval is how often objects are checked against the retention rules.
+	defaultRetentionSweepInterval = time.Hour
+)
+
+// retentionRule deletes the objects under a key prefix once they have not been modified for the TTL.
+type retentionRule struct {
+	prefix string
+	ttl    time.Duration
+}
+
+// parseRetentionRules returns the retention rules found in the config, ordered by prefix.
+func parseRetentionRules(config map[string]string) ([]retentionRule, error) {
+	var rules []retentionRule
+	for key, value := range config {
+		if !strings.HasPrefix(key, retentionConfigPrefix) || !strings.HasSuffix(key, retentionConfigSuffix) {
+			continue
+		}
+		prefix := strings.TrimSuffix(strings.TrimPrefix(key, retentionConfigPrefix), retentionConfigSuffix)
+		if prefix == "" {
+			return nil, errors.Errorf("invalid retention rule %q: missing prefix", key)
+		}
+		ttl, err := parseTTL(value)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid retention rule %q", key)
+		}
+		rules = append(rules, retentionRule{prefix: prefix, ttl: ttl})
+	}
+	sort.Slice(rules, func(i, j int) bool { return rules[i].prefix < rules[j].prefix })
+	return rules, nil
+}
+
+// parseTTL parses a positive duration, which may also be given as a number of days such as 7d.
+func parseTTL(value string) (time.Duration, error) {
+	var ttl time.Duration
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, errors.Errorf("invalid ttl %q", value)
+		}
+		ttl = time.Duration(n) * 24 * time.Hour
+	} else {
+		var err error
+		if ttl, err = time.ParseDuration(value); err != nil {
+			return 0, errors.Errorf("invalid ttl %q", value)
+		}
+	}
+	if ttl <= 0 {
+		return 0, errors.Errorf("invalid ttl %q: must be positive", value)
+	}
+	return ttl, nil
+}
+
+// sweepExpiredObjects deletes the objects of the bucket that have outlived the TTL of the retention rule for their prefix.
+// Objects under no rule are never deleted. Failures are logged and do not stop the sweep.
+func (o *LocalVolumeObjectStore) sweepExpiredObjects(bucket string, rules []retentionRule, log logrus.FieldLogger) (removed int) {
+	for _, rule := range rules {
+		// Rules apply to the directory named by their prefix, not to every key sharing it
+		infos, err := o.listObjectsWithInfo(bucket, strings.TrimSuffix(rule.prefix, "/")+"/")
+		if os.IsNotExist(errors.Cause(err)) {
+			continue
+		} else if err != nil {
+			log.WithError(err).Warnf("Failed to list objects under %s for retention", rule.prefix)
+			continue
+		}
+
+		cutoff := time.Now().Add(-rule.ttl)
+		for _, info := range infos {
+			if !info.ModTime.Before(cutoff) {
+				continue
+			}
+			log.Infof("Deleting %s, which is older than the %s retention of %s", info.Key, rule.ttl, rule.prefix)
+			if err := o.DeleteObject(bucket, info.Key); err != nil {
+				log.WithError(err).Warnf("Failed to delete expired object %s", info.Key)
+				continue
+			}
+			removed++
+		}
+	}
+	return removed
+}
+
+// startRetentionSweeper sweeps the bucket for expired objects every interval until stopRetentionSweeper is called.
+// Any sweeper already running is stopped first.
+func (o *LocalVolumeObjectStore) startRetentionSweeper(bucket string, rules []retentionRule, interval time.Duration, log logrus.FieldLogger) {
+	o.stopRetentionSweeper()
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	o.retentionStop, o.retentionDone = stop, done
+
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			if removed := o.sweepExpiredObjects(bucket, rules, log); removed > 0 {
+				log.Infof("Deleted %d expired objects", removed)
+			}
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// stopRetentionSweeper stops the retention sweeper, if one is running, and waits for it to finish.
+func (o *LocalVolumeObjectStore) stopRetentionSweeper() {
+	if o.retentionStop == nil {
+		return
+	}
+	close(o.retentionStop)
+	<-o.retentionDone
+	o.retentionStop, o.retentionDone = nil, nil
+}
@@ -0,0 +1,110 @@
+package plugin
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_parseRetentionRules(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  map[string]string
+		want    []retentionRule
+		wantErr bool
+	}{
+		{
+			name:   "no rules",
+			config: map[string]string{"bucket": "my-bucket"},
+		},
+		{
+			name: "rules in days and durations",
+			config: map[string]string{
+				"bucket":                       "my-bucket",
+				"retention.exports/tmp.ttl":    "7d",
+				"retention.exports/hourly.ttl": "90m",
+			},
+			want: []retentionRule{
+				{prefix: "exports/hourly", ttl: 90 * time.Minute},
+				{prefix: "exports/tmp", ttl: 7 * 24 * time.Hour},
+			},
+		},
+		{
+			name:    "invalid ttl",
+			config:  map[string]string{"retention.exports.ttl": "a week"},
+			wantErr: true,
+		},
+		{
+			name:    "zero ttl",
+			config:  map[string]string{"retention.exports.ttl": "0d"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseRetentionRules(tt.config)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_sweepExpiredObjects(t *testing.T) {
+	req := require.New(t)
+	o, root := newTestObjectStore(t)
+
+	objects := map[string]struct {
+		age         time.Duration
+		wantRemoved bool
+	}{
+		"exports/tmp/old-export.tar.gz":      {age: 2 * time.Hour, wantRemoved: true},
+		"exports/tmp/fresh-export.tar.gz":    {age: time.Minute},
+		"exports/other/old-export.tar.gz":    {age: 2 * time.Hour},
+		"backups/my-backup/my-backup.tar.gz": {age: 2 * time.Hour},
+	}
+	for key, object := range objects {
+		req.NoError(o.PutObject("my-bucket", key, strings.NewReader("contents")))
+		mtime := time.Now().Add(-object.age)
+		req.NoError(os.Chtimes(filepath.Join(root, "my-bucket", key), mtime, mtime))
+	}
+
+	rules := []retentionRule{
+		{prefix: "exports/tmp", ttl: time.Hour},
+		{prefix: "exports/missing", ttl: time.Hour},
+	}
+	req.Equal(1, o.sweepExpiredObjects("my-bucket", rules, logrus.New()))
+
+	for key, object := range objects {
+		exists, err := o.ObjectExists("my-bucket", key)
+		req.NoError(err)
+		req.Equal(!object.wantRemoved, exists, key)
+	}
+}
+
+func Test_retentionSweeper(t *testing.T) {
+	req := require.New(t)
+	o, root := newTestObjectStore(t)
+	key := "exports/tmp/export.tar.gz"
+	req.NoError(o.PutObject("my-bucket", key, strings.NewReader("contents")))
+
+	rules := []retentionRule{{prefix: "exports/tmp", ttl: 50 * time.Millisecond}}
+	o.startRetentionSweeper("my-bucket", rules, 10*time.Millisecond, logrus.New())
+	defer o.stopRetentionSweeper()
+
+	req.Eventually(func() bool {
+		_, err := os.Stat(filepath.Join(root, "my-bucket", key))
+		return os.IsNotExist(err)
+	}, 5*time.Second, 10*time.Millisecond)
+
+	o.stopRetentionSweeper()
+	req.Nil(o.retentionStop)
+}
@@ -0,0 +1,144 @@
+package plugin
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	kuberneteserrors "k8s.io/apimachinery/pkg/api/errors"
+	utilnet "k8s.io/apimachinery/pkg/util/net"
+)
+
+// defaultMaxRetries is the number of times an operation failing with a transient error is retried.
+const defaultMaxRetries = 3
+
+// retryBaseDelay is the delay before the first retry. It doubles with every retry.
+var retryBaseDelay = 100 * time.Millisecond
+
+// transientErrors are the errors an NFS mount may return during a server failover.
+var transientErrors = []syscall.Errno{syscall.ESTALE, syscall.EIO, syscall.EAGAIN}
+
+// isTransientError returns truthy if the error is worth retrying.
+func isTransientError(err error) bool {
+	for _, errno := range transientErrors {
+		if errors.Is(err, errno) {
+			return true
+		}
+	}
+	return false
+}
+
+// storageFullErrors are the errors returned when a write does not fit on the volume.
+var storageFullErrors = []syscall.Errno{syscall.ENOSPC, syscall.EDQUOT}
+
+// isStorageFullError returns truthy if the error is due to the volume being full or over quota.
+func isStorageFullError(err error) bool {
+	for _, errno := range storageFullErrors {
+		if errors.Is(err, errno) {
+			return true
+		}
+	}
+	return false
+}
+
+// permanentError wraps an error that must not be retried, even if it is transient.
+type permanentError struct {
+	error
+}
+
+// retryTransient calls fn until it succeeds, fails with an error that is not transient,
+// or has been retried maxRetries times, backing off exponentially between attempts.
+func retryTransient(maxRetries int, log logrus.FieldLogger, fn func() error) error {
+	delay := retryBaseDelay
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if p, ok := err.(permanentError); ok {
+			return p.error
+		}
+		if err == nil || attempt > maxRetries || !isTransientError(err) {
+			return err
+		}
+
+		log.WithError(err).Warnf("Transient error, retrying in %s (retry %d of %d)", delay, attempt, maxRetries)
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// defaultAPIRetryTimeout is how long Init keeps retrying API requests failing with a transient error.
+const defaultAPIRetryTimeout = 30 * time.Second
+
+// maxAPIRetryDelay caps the backoff between retries of API requests.
+const maxAPIRetryDelay = 5 * time.Second
+
+// parseAPIRetryTimeout parses the apiRetryTimeout setting as a duration, returning the default if it is empty.
+// A timeout of zero disables retries.
+func parseAPIRetryTimeout(value string) (time.Duration, error) {
+	if value == "" {
+		return defaultAPIRetryTimeout, nil
+	}
+	timeout, err := time.ParseDuration(value)
+	if err != nil || timeout < 0 {
+		return 0, errors.Errorf("invalid apiRetryTimeout %q: must be a non-negative duration", value)
+	}
+	return timeout, nil
+}
+
+// isTransientAPIError returns truthy if the API request may succeed once the API server is available again,
+// as during a control plane restart or upgrade.
+func isTransientAPIError(err error) bool {
+	if err == nil {
+		return false
+	}
+	if kuberneteserrors.IsServerTimeout(err) || kuberneteserrors.IsTimeout(err) || kuberneteserrors.IsTooManyRequests(err) ||
+		kuberneteserrors.IsInternalError(err) || kuberneteserrors.IsServiceUnavailable(err) || kuberneteserrors.IsUnexpectedServerError(err) {
+		return true
+	}
+	var status kuberneteserrors.APIStatus
+	if errors.As(err, &status) && status.Status().Code >= http.StatusInternalServerError {
+		return true
+	}
+	if utilnet.IsConnectionRefused(err) || utilnet.IsConnectionReset(err) || utilnet.IsProbableEOF(err) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// retryAPI calls fn until it succeeds or fails with an error that is not a transient API error, backing off
+// exponentially between attempts. Once retrying for longer than timeout, the last error is returned.
+func retryAPI(timeout time.Duration, log logrus.FieldLogger, fn func() error) error {
+	deadline := time.Now().Add(timeout)
+	delay := retryBaseDelay
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if !isTransientAPIError(err) {
+			return err
+		}
+		if time.Now().Add(delay).After(deadline) {
+			return errors.Wrapf(err, "API server still unavailable after %d attempts", attempt)
+		}
+
+		log.WithError(err).Warnf("Transient API error, retrying in %s", delay)
+		time.Sleep(delay)
+		if delay *= 2; delay > maxAPIRetryDelay {
+			delay = maxAPIRetryDelay
+		}
+	}
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	io.Reader
+	n int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.n += int64(n)
+	return n, err
+}
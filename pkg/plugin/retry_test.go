@@ -0,0 +1,266 @@
+package plugin
+
+import (
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	kuberneteserrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// flakyReader fails with err the first failures times it is read past the first byte of each attempt.
+type flakyReader struct {
+	*strings.Reader
+	failures int
+	err      error
+}
+
+func (r *flakyReader) Read(p []byte) (int, error) {
+	offset, _ := r.Reader.Seek(0, io.SeekCurrent)
+	if offset > 0 && r.failures > 0 {
+		r.failures--
+		return 0, r.err
+	}
+	return r.Reader.Read(p[:1])
+}
+
+func Test_PutObject_retry(t *testing.T) {
+	retryBaseDelay = time.Millisecond
+	defer func() { retryBaseDelay = 100 * time.Millisecond }()
+
+	tests := []struct {
+		name    string
+		body    func() io.Reader
+		wantErr bool
+	}{
+		{
+			name: "transient error -- retried until success",
+			body: func() io.Reader { return &flakyReader{strings.NewReader("contents"), 2, syscall.EIO} },
+		},
+		{
+			name:    "transient error past maxRetries -- fails",
+			body:    func() io.Reader { return &flakyReader{strings.NewReader("contents"), 4, syscall.ESTALE} },
+			wantErr: true,
+		},
+		{
+			name:    "non-transient error -- not retried",
+			body:    func() io.Reader { return &flakyReader{strings.NewReader("contents"), 1, syscall.EACCES} },
+			wantErr: true,
+		},
+		{
+			name: "transient error on non-seekable body -- not retried",
+			body: func() io.Reader {
+				return struct{ io.Reader }{&flakyReader{strings.NewReader("contents"), 1, syscall.EIO}}
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := require.New(t)
+			o, root := newTestObjectStore(t)
+
+			err := o.PutObject("bucket", "key", tt.body())
+			if tt.wantErr {
+				req.Error(err)
+				_, statErr := os.Stat(filepath.Join(root, "bucket", "key"))
+				req.True(os.IsNotExist(statErr))
+				return
+			}
+			req.NoError(err)
+
+			content, err := os.ReadFile(filepath.Join(root, "bucket", "key"))
+			req.NoError(err)
+			req.Equal("contents", string(content))
+		})
+	}
+}
+
+func Test_retryTransient(t *testing.T) {
+	retryBaseDelay = time.Millisecond
+	defer func() { retryBaseDelay = 100 * time.Millisecond }()
+
+	tests := []struct {
+		name         string
+		err          error
+		wantAttempts int
+	}{
+		{
+			name:         "success",
+			wantAttempts: 1,
+		},
+		{
+			name:         "wrapped transient error",
+			err:          errors.Wrap(&os.PathError{Op: "open", Path: "key", Err: syscall.EAGAIN}, "failed"),
+			wantAttempts: 4,
+		},
+		{
+			name:         "not found",
+			err:          os.ErrNotExist,
+			wantAttempts: 1,
+		},
+		{
+			name:         "permanent transient error",
+			err:          permanentError{syscall.EIO},
+			wantAttempts: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attempts := 0
+			err := retryTransient(defaultMaxRetries, logrus.New(), func() error {
+				attempts++
+				return tt.err
+			})
+			require.Equal(t, tt.wantAttempts, attempts)
+			if tt.err == nil {
+				require.NoError(t, err)
+			} else {
+				require.Error(t, err)
+				require.False(t, errors.As(err, &permanentError{}))
+			}
+		})
+	}
+}
+
+func Test_isTransientAPIError(t *testing.T) {
+	resource := schema.GroupResource{Group: "apps", Resource: "deployments"}
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "no error", err: nil, want: false},
+		{name: "service unavailable", err: kuberneteserrors.NewServiceUnavailable("etcd is unavailable"), want: true},
+		{name: "internal error", err: kuberneteserrors.NewInternalError(errors.New("boom")), want: true},
+		{name: "server timeout", err: kuberneteserrors.NewServerTimeout(resource, "get", 1), want: true},
+		{name: "too many requests", err: kuberneteserrors.NewTooManyRequests("slow down", 1), want: true},
+		{name: "bad gateway", err: kuberneteserrors.NewGenericServerResponse(502, "get", resource, "velero", "", 0, false), want: true},
+		{name: "connection refused", err: &net.OpError{Op: "dial", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}, want: true},
+		{name: "wrapped", err: errors.Wrap(kuberneteserrors.NewServiceUnavailable("etcd is unavailable"), "could not list config maps"), want: true},
+		{name: "not found", err: kuberneteserrors.NewNotFound(resource, "velero"), want: false},
+		{name: "forbidden", err: kuberneteserrors.NewForbidden(resource, "velero", errors.New("denied")), want: false},
+		{name: "conflict", err: kuberneteserrors.NewConflict(resource, "velero", errors.New("modified")), want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, isTransientAPIError(tt.err))
+		})
+	}
+}
+
+func Test_retryAPI_ensureResources(t *testing.T) {
+	retryBaseDelay = time.Millisecond
+	defer func() { retryBaseDelay = 100 * time.Millisecond }()
+
+	tests := []struct {
+		name         string
+		failures     int
+		noDeployment bool
+		wantErr      string
+		wantAttempts int
+	}{
+		{
+			name:         "API server recovers",
+			failures:     3,
+			wantAttempts: 4,
+		},
+		{
+			name:     "API server stays unavailable",
+			failures: -1,
+			wantErr:  "API server still unavailable",
+		},
+		{
+			name:         "missing deployment is not retried",
+			noDeployment: true,
+			wantErr:      "not found",
+			wantAttempts: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := require.New(t)
+			objects := []runtime.Object{&appsv1.DaemonSet{
+				ObjectMeta: metav1.ObjectMeta{Name: NodeAgentDaemonsetName, Namespace: "velero"},
+				Spec: appsv1.DaemonSetSpec{
+					Template: corev1.PodTemplateSpec{Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "node-agent"}}}},
+				},
+			}}
+			if !tt.noDeployment {
+				objects = append(objects, &appsv1.Deployment{
+					ObjectMeta: metav1.ObjectMeta{Name: VeleroDeploymentName, Namespace: "velero"},
+					Spec: appsv1.DeploymentSpec{
+						Template: corev1.PodTemplateSpec{Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "velero"}}}},
+					},
+				})
+			}
+			clientset := fake.NewSimpleClientset(objects...)
+
+			attempts := 0
+			clientset.PrependReactor("get", "deployments", func(k8stesting.Action) (bool, runtime.Object, error) {
+				attempts++
+				if tt.failures < 0 || attempts <= tt.failures {
+					return true, nil, kuberneteserrors.NewServiceUnavailable("the control plane is restarting")
+				}
+				return false, nil, nil
+			})
+
+			var updated bool
+			err := retryAPI(50*time.Millisecond, logrus.New(), func() error {
+				var err error
+				updated, err = ensureResources(EnsureResourcesOpts{
+					clientset:  clientset,
+					namespace:  "velero",
+					bucket:     "my-bucket",
+					path:       "/var/velero-local-volume-provider/my-bucket",
+					config:     map[string]string{"bucket": "my-bucket", "path": "/backups"},
+					pluginOpts: &localVolumeObjectStoreOpts{},
+					volumeType: Hostpath,
+					log:        logrus.NewEntry(logrus.New()),
+				})
+				return err
+			})
+			if tt.wantErr != "" {
+				req.ErrorContains(err, tt.wantErr)
+			} else {
+				req.NoError(err)
+				req.True(updated)
+			}
+			if tt.wantAttempts > 0 {
+				req.Equal(tt.wantAttempts, attempts)
+			}
+		})
+	}
+}
+
+func Test_parseAPIRetryTimeout(t *testing.T) {
+	req := require.New(t)
+	timeout, err := parseAPIRetryTimeout("")
+	req.NoError(err)
+	req.Equal(defaultAPIRetryTimeout, timeout)
+	timeout, err = parseAPIRetryTimeout("2m")
+	req.NoError(err)
+	req.Equal(2*time.Minute, timeout)
+	timeout, err = parseAPIRetryTimeout("0s")
+	req.NoError(err)
+	req.Zero(timeout)
+	_, err = parseAPIRetryTimeout("-1s")
+	req.Error(err)
+	_, err = parseAPIRetryTimeout("soon")
+	req.Error(err)
+}
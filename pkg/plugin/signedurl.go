@@ -3,10 +3,15 @@ package plugin
 import (
 	"crypto/hmac"
 	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
 	"encoding/base64"
 	"fmt"
-	"log"
+	"hash"
+	"net"
 	"net/url"
+	"os"
+	"strings"
 	"time"
 
 	"github.com/pkg/errors"
@@ -14,19 +19,103 @@ import (
 
 const expiryTimeLayout = "2006-01-02T15:04:05.000Z"
 
-// SignURL takes in a URL and adds a sha1 signature and expiration to it.
-// Namespace is used to create or get the signing key from a k8s secret.
-func SignURL(signedUrl *url.URL, namespace string, ttl time.Duration) error {
+const defaultFileserverScheme = "http"
 
-	expiration := time.Now().Add(ttl)
-	signedUrl.RawQuery += fmt.Sprintf("expires=%s", url.QueryEscape(expiration.Format(expiryTimeLayout)))
+// fileserverDialTimeout bounds the check that the fileserver accepts connections before a URL to it is returned.
+const fileserverDialTimeout = 2 * time.Second
 
-	signingKey, err := getSigningKey(namespace)
+// getFileserverURL returns the unsigned URL of an object on the fileserver based on the plugin configuration.
+// The host is the external host if one is configured, otherwise the pod IP and fileserver port.
+func getFileserverURL(opts *localVolumeObjectStoreOpts, bucket, key string) *url.URL {
+	scheme := defaultFileserverScheme
+	host := fmt.Sprintf("%s:%d", os.Getenv("POD_IP"), getFileserverPort(opts))
+	if opts != nil {
+		if opts.fileserverScheme != "" {
+			scheme = opts.fileserverScheme
+		}
+		if opts.fileserverExternalHost != "" {
+			host = opts.fileserverExternalHost
+		}
+	}
+
+	path := fmt.Sprintf("/%s/%s", bucket, key)
+	return &url.URL{
+		Scheme:  scheme,
+		Host:    host,
+		Path:    path,
+		RawPath: EscapeKeyPath(path),
+	}
+}
+
+// EscapeKeyPath escapes each segment of the slash separated path, so that keys containing characters such as
+// spaces, '#', '?' and '%' stay in the path of a URL. Paths are signed in this form, so the fileserver escapes
+// the decoded request path the same way to check the signature, whichever escaping the client used.
+func EscapeKeyPath(p string) string {
+	segments := strings.Split(p, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+// checkFileserverURL returns an error if the fileserver URL has no host, as when POD_IP is not set and no external host
+// is configured. If dial is set, it also returns an error unless the fileserver accepts TCP connections at the URL.
+func checkFileserverURL(u *url.URL, dial bool) error {
+	if u.Hostname() == "" {
+		return errors.New("the fileserver address is unknown: POD_IP is not set and no fileserverExternalHost is configured")
+	}
+	if !dial {
+		return nil
+	}
+
+	port := u.Port()
+	if port == "" {
+		port = "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+	}
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(u.Hostname(), port), fileserverDialTimeout)
 	if err != nil {
-		return errors.Wrap(err, "failed to get signing key")
+		return errors.Wrapf(err, "the fileserver is not reachable at %s", u.Host)
 	}
+	return conn.Close()
+}
+
+// signedURLVersion is the version of the signing scheme SignURL uses, sent as the v query parameter.
+// URLs signed before schemes were versioned have no v parameter and are verified as version 1.
+// Version 2 signs the v parameter along with the expiry, so a URL cannot be passed off as another version.
+// Its query is signed in the sorted form verifiers check, so fileservers that predate versions accept it too.
+const signedURLVersion = "2"
 
-	mac := hmac.New(sha1.New, signingKey)
+// SignURL takes in a URL and adds an HMAC signature and expiration to it.
+// The signature is made with the signing key using the given algorithm (sha256 if empty).
+// The scheme and host are part of the signed message, so they must not change after signing.
+func SignURL(signedUrl *url.URL, signingKey []byte, algorithm string, ttl time.Duration) error {
+	return signURL(signedUrl, signingKey, algorithm, ttl, signedURLVersion)
+}
+
+// signURL signs the URL with the given version of the signing scheme, "" being the unversioned version 1.
+func signURL(signedUrl *url.URL, signingKey []byte, algorithm string, ttl time.Duration, version string) error {
+	newHash, err := getSigningHash(algorithm)
+	if err != nil {
+		return err
+	}
+
+	expiration := time.Now().UTC().Add(ttl)
+	switch version {
+	case "":
+		signedUrl.RawQuery += fmt.Sprintf("expires=%s", url.QueryEscape(expiration.Format(expiryTimeLayout)))
+	case signedURLVersion:
+		query := signedUrl.Query()
+		query.Set("expires", expiration.Format(expiryTimeLayout))
+		query.Set("v", version)
+		signedUrl.RawQuery = query.Encode()
+	default:
+		return errors.Errorf("unsupported signed URL version %q", version)
+	}
+
+	mac := hmac.New(newHash, signingKey)
 	mac.Write([]byte(signedUrl.String()))
 	sig := base64.URLEncoding.EncodeToString(mac.Sum(nil))
 	signedUrl.RawQuery += fmt.Sprintf("&signature=%s", sig)
@@ -34,62 +123,138 @@ func SignURL(signedUrl *url.URL, namespace string, ttl time.Duration) error {
 	return nil
 }
 
-// IsSignedURL validates the expiration and signature of a signed url.
-// Namespace is used to get the signing key from a k8s secret.
-func IsSignedURLValid(requestURL, namespace string) (bool, error) {
-	parsedURL, err := url.Parse(requestURL)
-	if err != nil {
-		return false, errors.Wrap(err, "failed to parse URL")
-	}
+// ErrSignedURLInvalid is returned for a URL that is missing its expiry or signature, or whose signature does not match.
+var ErrSignedURLInvalid = errors.New("signed URL is invalid")
 
-	queryParams := parsedURL.Query()
+// ErrSignedURLExpired is returned for a correctly signed URL whose expiry has passed.
+var ErrSignedURLExpired = errors.New("signed URL has expired")
 
-	expiredQueryParam := queryParams.Get("expires")
-	if expiredQueryParam == "" {
+// ErrSignedURLVersion is returned for a URL signed with a version of the signing scheme this verifier does not know.
+var ErrSignedURLVersion = errors.New("signed URL version is not supported")
+
+// defaultClockSkewTolerance is how long after its expiry a signed URL is still accepted,
+// to absorb drift between the clocks of the signer and the verifier.
+const defaultClockSkewTolerance = 60 * time.Second
+
+// ParseClockSkewTolerance parses the clockSkewTolerance setting as a duration, returning the default if it is empty.
+func ParseClockSkewTolerance(value string) (time.Duration, error) {
+	if value == "" {
+		return defaultClockSkewTolerance, nil
+	}
+	tolerance, err := time.ParseDuration(value)
+	if err != nil || tolerance < 0 {
+		return 0, errors.Errorf("invalid clockSkewTolerance %q: must be a non-negative duration", value)
+	}
+	return tolerance, nil
+}
+
+// IsSignedURL validates the expiration and signature of a signed url.
+// The signing key and algorithm must match the ones the URL was signed with.
+func IsSignedURLValid(requestURL string, signingKey []byte, algorithm string) (bool, error) {
+	err := CheckSignedURL(requestURL, signingKey, algorithm, 0)
+	if errors.Is(err, ErrSignedURLInvalid) || errors.Is(err, ErrSignedURLExpired) || errors.Is(err, ErrSignedURLVersion) {
 		return false, nil
 	}
+	return err == nil, err
+}
 
-	log.Print(expiredQueryParam)
+// CheckSignedURL verifies the signature of a signed url and that it has not expired, allowing for clockSkewTolerance.
+// It returns an error wrapping ErrSignedURLInvalid, ErrSignedURLExpired or ErrSignedURLVersion if the URL must not be served.
+// URLs signed with any supported version of the signing scheme are accepted, so signers can be upgraded before verifiers.
+// The signing key and algorithm must match the ones the URL was signed with.
+func CheckSignedURL(requestURL string, signingKey []byte, algorithm string, clockSkewTolerance time.Duration) error {
+	return checkSignedURL(requestURL, signingKey, algorithm, clockSkewTolerance, time.Now())
+}
 
-	expirationTime, err := time.Parse(expiryTimeLayout, expiredQueryParam)
+func checkSignedURL(requestURL string, signingKey []byte, algorithm string, clockSkewTolerance time.Duration, now time.Time) error {
+	newHashes, err := getVerifyingHashes(algorithm)
 	if err != nil {
-		return false, errors.Wrap(err, "failed to parse expiration time")
+		return err
 	}
 
-	if expirationTime.Before(time.Now()) {
-		return false, err
+	parsedURL, err := url.Parse(requestURL)
+	if err != nil {
+		return errors.Wrap(err, "failed to parse URL")
 	}
 
-	encodedHash := queryParams.Get("signature")
-	if encodedHash == "" {
-		return false, nil
+	queryParams := parsedURL.Query()
+
+	// Every version so far signs the URL with its sorted query less the signature, and version 1 has no v parameter.
+	switch version := queryParams.Get("v"); version {
+	case "", signedURLVersion:
+	default:
+		return errors.Wrapf(ErrSignedURLVersion, "version %q", version)
 	}
 
-	signingKey, err := getSigningKey(namespace)
-	if err != nil {
-		return false, errors.Wrap(err, "failed to get signing key")
+	expiredQueryParam := queryParams.Get("expires")
+	if expiredQueryParam == "" {
+		return errors.Wrap(ErrSignedURLInvalid, "missing expiry")
+	}
+
+	encodedHash := queryParams.Get("signature")
+	if encodedHash == "" {
+		return errors.Wrap(ErrSignedURLInvalid, "missing signature")
 	}
 
 	messageMACBuf, err := base64.URLEncoding.DecodeString(encodedHash)
 	if err != nil {
-		return false, errors.Wrap(err, "failed to decode hash")
+		return errors.Wrapf(ErrSignedURLInvalid, "failed to decode hash: %v", err)
 	}
 
-	// Remove signature from URL and validate
+	// Remove signature from URL and validate. The expiry is part of the signed message, so it is only trusted once the signature is.
 	queryParams.Del("signature")
 	parsedURL.RawQuery = queryParams.Encode()
-	valid := CheckMAC([]byte(parsedURL.String()), []byte(messageMACBuf), signingKey)
-	if !valid {
-		return false, nil
+	matched := false
+	for _, newHash := range newHashes {
+		matched = matched || CheckMAC([]byte(parsedURL.String()), []byte(messageMACBuf), signingKey, newHash)
+	}
+	if !matched {
+		return errors.Wrap(ErrSignedURLInvalid, "signature does not match")
 	}
-	return true, nil
+
+	expirationTime, err := time.Parse(expiryTimeLayout, expiredQueryParam)
+	if err != nil {
+		return errors.Wrapf(ErrSignedURLInvalid, "failed to parse expiration time: %v", err)
+	}
+
+	if now.After(expirationTime.Add(clockSkewTolerance)) {
+		return errors.Wrapf(ErrSignedURLExpired, "expired at %s", expirationTime.Format(time.RFC3339))
+	}
+	return nil
 }
 
 // CheckMAC verifies hash checksum
-func CheckMAC(message, messageMAC, key []byte) bool {
-	mac := hmac.New(sha1.New, key)
+func CheckMAC(message, messageMAC, key []byte, newHash func() hash.Hash) bool {
+	mac := hmac.New(newHash, key)
 	mac.Write(message)
 	expectedMAC := mac.Sum(nil)
 
 	return hmac.Equal(messageMAC, expectedMAC)
 }
+
+// getSigningHash returns the hash constructor for the signing algorithm. sha256 is used if none is given.
+func getSigningHash(algorithm string) (func() hash.Hash, error) {
+	switch algorithm {
+	case "sha1":
+		return sha1.New, nil
+	case "", "sha256":
+		return sha256.New, nil
+	case "sha512":
+		return sha512.New, nil
+	default:
+		return nil, errors.Errorf("unsupported signing algorithm %q", algorithm)
+	}
+}
+
+// getVerifyingHashes returns the hash constructors a signature made with the signing algorithm may use.
+// Without an algorithm sha1 is accepted as well as sha256, as it was the default for URLs signed by older plugins.
+func getVerifyingHashes(algorithm string) ([]func() hash.Hash, error) {
+	newHash, err := getSigningHash(algorithm)
+	if err != nil {
+		return nil, err
+	}
+	if algorithm == "" {
+		return []func() hash.Hash{newHash, sha1.New}, nil
+	}
+	return []func() hash.Hash{newHash}, nil
+}
@@ -0,0 +1,285 @@
+package plugin
+
+import (
+	"crypto/sha1"
+	"encoding/base64"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_getFileserverURL(t *testing.T) {
+	t.Setenv("POD_IP", "10.0.0.5")
+
+	tests := []struct {
+		name       string
+		opts       *localVolumeObjectStoreOpts
+		wantScheme string
+		wantHost   string
+	}{
+		{
+			name:       "defaults -- http to the pod IP",
+			opts:       &localVolumeObjectStoreOpts{},
+			wantScheme: "http",
+			wantHost:   "10.0.0.5:3000",
+		},
+		{
+			name:       "custom port",
+			opts:       &localVolumeObjectStoreOpts{fileserverPort: 3333},
+			wantScheme: "http",
+			wantHost:   "10.0.0.5:3333",
+		},
+		{
+			name: "https through an external host",
+			opts: &localVolumeObjectStoreOpts{
+				fileserverScheme:       "https",
+				fileserverExternalHost: "backups.example.com",
+			},
+			wantScheme: "https",
+			wantHost:   "backups.example.com",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := getFileserverURL(tt.opts, "my-bucket", "backups/my-backup/my-backup.tar.gz")
+			require.Equal(t, tt.wantScheme, got.Scheme)
+			require.Equal(t, tt.wantHost, got.Host)
+			require.Equal(t, "/my-bucket/backups/my-backup/my-backup.tar.gz", got.Path)
+		})
+	}
+}
+
+func Test_getFileserverURL_specialCharacters(t *testing.T) {
+	t.Setenv("POD_IP", "10.0.0.5")
+
+	tests := []struct {
+		key         string
+		wantEscaped string
+	}{
+		{key: "backups/my backup/my backup.tar.gz", wantEscaped: "/my-bucket/backups/my%20backup/my%20backup.tar.gz"},
+		{key: "backups/my-backup/#1?.tar.gz", wantEscaped: "/my-bucket/backups/my-backup/%231%3F.tar.gz"},
+		{key: "backups/my-backup/100%.tar.gz", wantEscaped: "/my-bucket/backups/my-backup/100%25.tar.gz"},
+		{key: "backups/été/日本.tar.gz", wantEscaped: "/my-bucket/backups/%C3%A9t%C3%A9/%E6%97%A5%E6%9C%AC.tar.gz"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			req := require.New(t)
+			u := getFileserverURL(&localVolumeObjectStoreOpts{}, "my-bucket", tt.key)
+			req.Equal(tt.wantEscaped, u.EscapedPath())
+
+			// the key survives a round trip through the URL string
+			parsed, err := url.Parse(u.String())
+			req.NoError(err)
+			req.Equal("/my-bucket/"+tt.key, parsed.Path)
+			req.Empty(parsed.Fragment)
+			req.Empty(parsed.RawQuery)
+		})
+	}
+}
+
+func Test_SignURL(t *testing.T) {
+	key := []byte("0123456789abcdef")
+	opts := &localVolumeObjectStoreOpts{
+		fileserverScheme:       "https",
+		fileserverExternalHost: "backups.example.com",
+	}
+
+	for _, algorithm := range []string{"", "sha1", "sha256", "sha512"} {
+		t.Run("algorithm="+algorithm, func(t *testing.T) {
+			req := require.New(t)
+
+			signedUrl := getFileserverURL(opts, "my-bucket", "backups/my-backup/my-backup.tar.gz")
+			req.NoError(SignURL(signedUrl, key, algorithm, time.Hour))
+			req.Equal("https", signedUrl.Scheme)
+
+			valid, err := IsSignedURLValid(signedUrl.String(), key, algorithm)
+			req.NoError(err)
+			req.True(valid)
+
+			// the scheme is part of the signature
+			tampered := *signedUrl
+			tampered.Scheme = "http"
+			valid, err = IsSignedURLValid(tampered.String(), key, algorithm)
+			req.NoError(err)
+			req.False(valid)
+
+			// URLs signed with a rotated out key are rejected
+			valid, err = IsSignedURLValid(signedUrl.String(), []byte("rotated key"), algorithm)
+			req.NoError(err)
+			req.False(valid)
+		})
+	}
+}
+
+func Test_SignURL_defaultAlgorithm(t *testing.T) {
+	req := require.New(t)
+	key := []byte("0123456789abcdef")
+	newURL := func(algorithm string) string {
+		signedUrl := getFileserverURL(&localVolumeObjectStoreOpts{}, "my-bucket", "backups/my-backup/my-backup.tar.gz")
+		req.NoError(SignURL(signedUrl, key, algorithm, time.Hour))
+		return signedUrl.String()
+	}
+
+	// New URLs are signed with sha256
+	defaultURL := newURL("")
+	req.NoError(CheckSignedURL(defaultURL, key, "sha256", 0))
+	req.ErrorIs(CheckSignedURL(defaultURL, key, "sha1", 0), ErrSignedURLInvalid)
+
+	// URLs signed with sha1 before the default changed are still accepted without an algorithm, but not in place of another
+	sha1URL := newURL("sha1")
+	req.NoError(CheckSignedURL(sha1URL, key, "", 0))
+	req.ErrorIs(CheckSignedURL(sha1URL, key, "sha256", 0), ErrSignedURLInvalid)
+	req.ErrorIs(CheckSignedURL(newURL("sha512"), key, "", 0), ErrSignedURLInvalid)
+}
+
+func Test_SignURL_algorithmMismatch(t *testing.T) {
+	key := []byte("0123456789abcdef")
+
+	signedUrl := getFileserverURL(&localVolumeObjectStoreOpts{}, "my-bucket", "backups/my-backup/my-backup.tar.gz")
+	require.NoError(t, SignURL(signedUrl, key, "sha256", time.Hour))
+
+	valid, err := IsSignedURLValid(signedUrl.String(), key, "sha512")
+	require.NoError(t, err)
+	require.False(t, valid)
+
+	require.Error(t, SignURL(signedUrl, key, "md5", time.Hour))
+}
+
+func Test_checkSignedURL_expiry(t *testing.T) {
+	key := []byte("0123456789abcdef")
+	signedUrl := getFileserverURL(&localVolumeObjectStoreOpts{}, "my-bucket", "backups/my-backup/my-backup.tar.gz")
+	require.NoError(t, SignURL(signedUrl, key, "", time.Hour))
+	expiry, err := time.Parse(expiryTimeLayout, signedUrl.Query().Get("expires"))
+	require.NoError(t, err)
+
+	tests := []struct {
+		name               string
+		now                time.Time
+		clockSkewTolerance time.Duration
+		wantErr            error
+	}{
+		{
+			name: "just before expiry",
+			now:  expiry.Add(-time.Second),
+		},
+		{
+			name:    "just after expiry without tolerance",
+			now:     expiry.Add(time.Second),
+			wantErr: ErrSignedURLExpired,
+		},
+		{
+			name:               "after expiry within the skew window",
+			now:                expiry.Add(59 * time.Second),
+			clockSkewTolerance: defaultClockSkewTolerance,
+		},
+		{
+			name:               "beyond the skew window",
+			now:                expiry.Add(61 * time.Second),
+			clockSkewTolerance: defaultClockSkewTolerance,
+			wantErr:            ErrSignedURLExpired,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkSignedURL(signedUrl.String(), key, "", tt.clockSkewTolerance, tt.now)
+			if tt.wantErr != nil {
+				require.ErrorIs(t, err, tt.wantErr)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
+
+func Test_checkSignedURL_tampered(t *testing.T) {
+	key := []byte("0123456789abcdef")
+	signedUrl := getFileserverURL(&localVolumeObjectStoreOpts{}, "my-bucket", "backups/my-backup/my-backup.tar.gz")
+	require.NoError(t, SignURL(signedUrl, key, "", -time.Hour))
+
+	// extending the expiry of an expired URL invalidates its signature
+	query := signedUrl.Query()
+	query.Set("expires", time.Now().UTC().Add(time.Hour).Format(expiryTimeLayout))
+	signedUrl.RawQuery = query.Encode()
+
+	err := checkSignedURL(signedUrl.String(), key, "", defaultClockSkewTolerance, time.Now())
+	require.ErrorIs(t, err, ErrSignedURLInvalid)
+}
+
+func Test_ParseClockSkewTolerance(t *testing.T) {
+	got, err := ParseClockSkewTolerance("")
+	require.NoError(t, err)
+	require.Equal(t, defaultClockSkewTolerance, got)
+
+	got, err = ParseClockSkewTolerance("5s")
+	require.NoError(t, err)
+	require.Equal(t, 5*time.Second, got)
+
+	_, err = ParseClockSkewTolerance("-5s")
+	require.Error(t, err)
+	_, err = ParseClockSkewTolerance("soon")
+	require.Error(t, err)
+}
+
+// checkSignedURLUnversioned verifies a signature the way fileservers did before signed URLs were versioned.
+func checkSignedURLUnversioned(t *testing.T, signedUrl *url.URL, key []byte) bool {
+	query := signedUrl.Query()
+	sig, err := base64.URLEncoding.DecodeString(query.Get("signature"))
+	require.NoError(t, err)
+	query.Del("signature")
+	unsigned := *signedUrl
+	unsigned.RawQuery = query.Encode()
+	return CheckMAC([]byte(unsigned.String()), sig, key, sha1.New)
+}
+
+func Test_SignURL_versions(t *testing.T) {
+	key := []byte("0123456789abcdef")
+	// Fileservers verified signatures with sha1 by default before signed URLs were versioned
+	newURL := func(version string) *url.URL {
+		signedUrl := getFileserverURL(&localVolumeObjectStoreOpts{}, "my-bucket", "backups/my-backup/my-backup.tar.gz")
+		require.NoError(t, signURL(signedUrl, key, "sha1", time.Hour, version))
+		return signedUrl
+	}
+
+	t.Run("v1 URL verified by the current verifier", func(t *testing.T) {
+		signedUrl := newURL("")
+		require.False(t, signedUrl.Query().Has("v"))
+		require.NoError(t, CheckSignedURL(signedUrl.String(), key, "", 0))
+	})
+
+	t.Run("v2 URL verified by an unversioned verifier", func(t *testing.T) {
+		signedUrl := newURL(signedURLVersion)
+		require.Equal(t, signedURLVersion, signedUrl.Query().Get("v"))
+		require.True(t, checkSignedURLUnversioned(t, signedUrl, key))
+		require.NoError(t, CheckSignedURL(signedUrl.String(), key, "", 0))
+	})
+
+	t.Run("SignURL uses the current version", func(t *testing.T) {
+		signedUrl := getFileserverURL(&localVolumeObjectStoreOpts{}, "my-bucket", "backups/my-backup/my-backup.tar.gz")
+		require.NoError(t, SignURL(signedUrl, key, "", time.Hour))
+		require.Equal(t, signedURLVersion, signedUrl.Query().Get("v"))
+	})
+
+	t.Run("downgraded version", func(t *testing.T) {
+		signedUrl := newURL(signedURLVersion)
+		query := signedUrl.Query()
+		query.Del("v")
+		signedUrl.RawQuery = query.Encode()
+		require.ErrorIs(t, CheckSignedURL(signedUrl.String(), key, "", 0), ErrSignedURLInvalid)
+	})
+
+	t.Run("unknown version", func(t *testing.T) {
+		signedUrl := newURL(signedURLVersion)
+		query := signedUrl.Query()
+		query.Set("v", "3")
+		signedUrl.RawQuery = query.Encode()
+		require.ErrorIs(t, CheckSignedURL(signedUrl.String(), key, "", 0), ErrSignedURLVersion)
+
+		valid, err := IsSignedURLValid(signedUrl.String(), key, "")
+		require.NoError(t, err)
+		require.False(t, valid)
+	})
+
+	require.Error(t, signURL(&url.URL{}, key, "", time.Hour, "3"))
+}
@@ -0,0 +1,73 @@
+package plugin
+
+import (
+	"io"
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+// bodySize returns the number of bytes remaining in the body, if that can be known without reading it.
+func bodySize(body io.Reader) (int64, bool) {
+	switch b := body.(type) {
+	case interface{ Len() int }:
+		return int64(b.Len()), true
+	case io.Seeker:
+		start, err := b.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return 0, false
+		}
+		end, err := b.Seek(0, io.SeekEnd)
+		if err != nil {
+			return 0, false
+		}
+		if _, err := b.Seek(start, io.SeekStart); err != nil {
+			return 0, false
+		}
+		return end - start, true
+	}
+	return 0, false
+}
+
+// checkFreeSpace returns an error wrapping ErrStorageFull if the filesystem holding dir
+// does not have room for size bytes while keeping minFreeBytes free.
+func checkFreeSpace(dir string, size, minFreeBytes int64) error {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(dir, &stat); err != nil {
+		return errors.Wrap(err, "failed to stat filesystem")
+	}
+	free := int64(stat.Bavail) * int64(stat.Bsize)
+	if free < size+minFreeBytes {
+		return errors.Wrapf(ErrStorageFull, "%d bytes free, need %d for the object and %d to keep free", free, size, minFreeBytes)
+	}
+	return nil
+}
+
+// maxSizeReader fails with ErrObjectTooLarge once more than max bytes have been read from the body,
+// so a body that never ends cannot fill the volume.
+type maxSizeReader struct {
+	io.Reader
+	max  int64
+	read int64
+}
+
+func (r *maxSizeReader) Read(p []byte) (int, error) {
+	// Reading one byte past the limit tells a body of exactly max bytes from a larger one
+	if remaining := r.max - r.read + 1; int64(len(p)) > remaining {
+		p = p[:remaining]
+	}
+	n, err := r.Reader.Read(p)
+	r.read += int64(n)
+	if r.read > r.max {
+		return n, errors.Wrapf(ErrObjectTooLarge, "more than %d bytes", r.max)
+	}
+	return n, err
+}
+
+// limitBody returns the body limited to maxObjectSizeBytes, if set.
+func (o *LocalVolumeObjectStore) limitBody(body io.Reader) io.Reader {
+	if o.maxObjectSizeBytes <= 0 {
+		return body
+	}
+	return &maxSizeReader{Reader: body, max: o.maxObjectSizeBytes}
+}
@@ -0,0 +1,97 @@
+package plugin
+
+import (
+	"context"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// statObject stats the file of an object. It is a variable so tests can simulate an unreachable NFS server,
+// on which stats block until the mount times out.
+var statObject = os.Stat
+
+// maxInflightStats is the most stats with a statTimeout an object store runs at once. A stat that timed out stays
+// blocked in its goroutine until the mount answers, so once this many are, further stats fail at once rather than
+// adding to them.
+const maxInflightStats = 64
+
+// ErrStatsBlocked is returned by stats with a statTimeout while maxInflightStats stats are still blocked on the volume.
+var ErrStatsBlocked = errors.New("too many stats are blocked on the volume")
+
+// statLimiter counts the stats with a statTimeout in flight. The zero value is ready to use.
+type statLimiter struct {
+	mu       sync.Mutex
+	inflight int
+}
+
+// acquire returns truthy if a stat may start, counting it until release is called.
+func (l *statLimiter) acquire() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.inflight >= maxInflightStats {
+		return false
+	}
+	l.inflight++
+	return true
+}
+
+func (l *statLimiter) release() {
+	l.mu.Lock()
+	l.inflight--
+	l.mu.Unlock()
+}
+
+// withStatTimeout returns the result of lookup, or an error wrapping context.DeadlineExceeded if it has not returned
+// within the timeout. With zero timeout, lookup is run in the calling goroutine. A lookup that timed out is left to
+// return in its own goroutine, as a stat blocked on a dead mount cannot be interrupted, and counts against the limiter
+// until it does; ErrStatsBlocked is returned without running lookup while the limiter is full.
+func withStatTimeout[T any](limiter *statLimiter, timeout time.Duration, path string, lookup func() (T, error)) (T, error) {
+	if timeout <= 0 {
+		return lookup()
+	}
+	if !limiter.acquire() {
+		var zero T
+		return zero, errors.Wrapf(ErrStatsBlocked, "not running stat %s", path)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	type result struct {
+		value T
+		err   error
+	}
+	done := make(chan result, 1)
+	go func() {
+		defer limiter.release()
+		value, err := lookup()
+		done <- result{value, err}
+	}()
+
+	select {
+	case r := <-done:
+		return r.value, r.err
+	case <-ctx.Done():
+		var zero T
+		return zero, errors.Wrapf(ctx.Err(), "stat %s did not return within statTimeout %s", path, timeout)
+	}
+}
+
+// lookupObjectFile returns the file and compression of the object at path, as findObjectFile, after checking its path
+// for symlinks, within the statTimeout.
+func (o *LocalVolumeObjectStore) lookupObjectFile(bucket, path string) (string, string, error) {
+	type objectFile struct {
+		path        string
+		compression string
+	}
+	found, err := withStatTimeout(&o.stats, o.statTimeout, path, func() (objectFile, error) {
+		filePath, compression, err := findObjectFile(path)
+		if err == nil {
+			err = o.checkSymlinks(o.bucketPath(bucket), filePath)
+		}
+		return objectFile{filePath, compression}, err
+	})
+	return found.path, found.compression, err
+}
@@ -0,0 +1,151 @@
+package plugin
+
+import (
+	"context"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_statTimeout(t *testing.T) {
+	req := require.New(t)
+	o, _ := newTestObjectStore(t)
+	req.NoError(o.applyConfig(map[string]string{"statTimeout": "50ms"}))
+	req.NoError(o.PutObject("my-bucket", "backups/my-backup/velero-backup.json", strings.NewReader("backup")))
+
+	// Stats of the bucket dead-nfs hang as on an unreachable NFS server, then fail once the test ends
+	entered := make(chan struct{}, 1)
+	hung := make(chan struct{})
+	defer func(stat func(string) (os.FileInfo, error)) {
+		close(hung)
+		statObject = stat
+	}(statObject)
+	stat := statObject
+	statObject = func(name string) (os.FileInfo, error) {
+		if strings.Contains(name, "dead-nfs") {
+			entered <- struct{}{}
+			<-hung
+			return nil, &os.PathError{Op: "stat", Path: name, Err: syscall.EIO}
+		}
+		return stat(name)
+	}
+
+	tests := []struct {
+		name       string
+		bucket     string
+		key        string
+		wantExists bool
+		wantErr    error
+	}{
+		{
+			name:       "existing object",
+			bucket:     "my-bucket",
+			key:        "backups/my-backup/velero-backup.json",
+			wantExists: true,
+		},
+		{
+			name:   "missing object is not an error",
+			bucket: "my-bucket",
+			key:    "backups/my-backup/missing.json",
+		},
+		{
+			name:    "stat hangs",
+			bucket:  "dead-nfs",
+			key:     "backups/my-backup/velero-backup.json",
+			wantErr: context.DeadlineExceeded,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := require.New(t)
+
+			start := time.Now()
+			exists, err := o.ObjectExists(tt.bucket, tt.key)
+			req.Less(time.Since(start), 5*time.Second)
+			if tt.wantErr != nil {
+				req.ErrorIs(err, tt.wantErr)
+				req.NotErrorIs(err, ErrObjectNotFound)
+				// The stat is left blocked in its own goroutine
+				<-entered
+			} else {
+				req.NoError(err)
+			}
+			req.Equal(tt.wantExists, exists)
+
+			exists, size, _, err := o.HeadObject(tt.bucket, tt.key)
+			if tt.wantErr != nil {
+				req.ErrorIs(err, tt.wantErr)
+				<-entered
+			} else {
+				req.NoError(err)
+			}
+			req.Equal(tt.wantExists, exists)
+			if tt.wantExists {
+				req.Equal(int64(len("backup")), size)
+			}
+		})
+	}
+}
+
+func Test_statTimeout_inflightCap(t *testing.T) {
+	req := require.New(t)
+	o, _ := newTestObjectStore(t)
+	req.NoError(o.applyConfig(map[string]string{"statTimeout": "50ms"}))
+	req.NoError(o.PutObject("my-bucket", "backups/my-backup/velero-backup.json", strings.NewReader("backup")))
+
+	entered := make(chan struct{}, maxInflightStats)
+	hung := make(chan struct{})
+	released := make(chan struct{}, maxInflightStats)
+	defer func(stat func(string) (os.FileInfo, error)) {
+		statObject = stat
+	}(statObject)
+	stat := statObject
+	statObject = func(name string) (os.FileInfo, error) {
+		if strings.Contains(name, "dead-nfs") {
+			entered <- struct{}{}
+			<-hung
+			defer func() { released <- struct{}{} }()
+			return nil, &os.PathError{Op: "stat", Path: name, Err: syscall.EIO}
+		}
+		return stat(name)
+	}
+
+	// Fill the cap with stats left blocked after timing out
+	errs := make(chan error, maxInflightStats)
+	for i := 0; i < maxInflightStats; i++ {
+		go func() {
+			_, err := o.ObjectExists("dead-nfs", "backups/my-backup/velero-backup.json")
+			errs <- err
+		}()
+	}
+	for i := 0; i < maxInflightStats; i++ {
+		<-entered
+		req.ErrorIs(<-errs, context.DeadlineExceeded)
+	}
+
+	// Further stats fail at once without running, whichever bucket they are for
+	_, err := o.ObjectExists("my-bucket", "backups/my-backup/velero-backup.json")
+	req.ErrorIs(err, ErrStatsBlocked)
+	_, _, _, err = o.HeadObject("my-bucket", "backups/my-backup/velero-backup.json")
+	req.ErrorIs(err, ErrStatsBlocked)
+
+	// Stats run again once the blocked ones return
+	close(hung)
+	for i := 0; i < maxInflightStats; i++ {
+		<-released
+	}
+	req.Eventually(func() bool {
+		exists, err := o.ObjectExists("my-bucket", "backups/my-backup/velero-backup.json")
+		return err == nil && exists
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func Test_statTimeout_invalid(t *testing.T) {
+	o, _ := newTestObjectStore(t)
+	require.ErrorContains(t, o.applyConfig(map[string]string{"statTimeout": "-1s"}), "invalid statTimeout")
+	require.ErrorContains(t, o.applyConfig(map[string]string{"statTimeout": "soon"}), "invalid statTimeout")
+}
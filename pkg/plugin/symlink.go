@@ -0,0 +1,50 @@
+package plugin
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// checkSymlinks returns an error wrapping ErrSymlink if path, or any directory between the bucket and path,
+// is a symlink that may not be followed. Unless followSymlinks is set no symlink may be followed,
+// and even then only symlinks resolving inside the bucket are. Components that do not exist yet are not checked.
+func (o *LocalVolumeObjectStore) checkSymlinks(bucketPath, path string) error {
+	rel, err := filepath.Rel(bucketPath, path)
+	if err != nil {
+		return err
+	}
+	if rel == "." {
+		return nil
+	}
+
+	current := bucketPath
+	for _, part := range strings.Split(rel, string(filepath.Separator)) {
+		current = filepath.Join(current, part)
+		info, err := os.Lstat(current)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if info.Mode()&fs.ModeSymlink == 0 {
+			continue
+		}
+
+		if !o.followSymlinks {
+			return errors.Wrapf(ErrSymlink, "%s", current)
+		}
+		target, err := resolveSymlinkInBucket(bucketPath, current)
+		if err != nil {
+			return err
+		}
+		if target == "" {
+			return errors.Wrapf(ErrSymlink, "%s does not resolve inside the bucket", current)
+		}
+	}
+	return nil
+}
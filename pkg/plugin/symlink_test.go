@@ -0,0 +1,92 @@
+package plugin
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// newSymlinkTestObjectStore returns an object store whose bucket holds an object, a symlink to it,
+// and symlinks to a file and a directory outside of the bucket.
+func newSymlinkTestObjectStore(t *testing.T) (*LocalVolumeObjectStore, string) {
+	req := require.New(t)
+	o, root := newTestObjectStore(t)
+
+	req.NoError(o.PutObject("my-bucket", "backups/my-backup/my-backup.tar.gz", strings.NewReader("data")))
+
+	outside := filepath.Join(root, "outside")
+	req.NoError(os.MkdirAll(outside, 0755))
+	req.NoError(os.WriteFile(filepath.Join(outside, "secret"), []byte("secret"), 0644))
+
+	backupPath := filepath.Join(root, "my-bucket", "backups", "my-backup")
+	req.NoError(os.Symlink(filepath.Join(backupPath, "my-backup.tar.gz"), filepath.Join(backupPath, "inside")))
+	req.NoError(os.Symlink(filepath.Join(outside, "secret"), filepath.Join(backupPath, "escape")))
+	req.NoError(os.Symlink(outside, filepath.Join(root, "my-bucket", "backups", "linked")))
+
+	return o, outside
+}
+
+func Test_symlinks_notFollowed(t *testing.T) {
+	req := require.New(t)
+	o, outside := newSymlinkTestObjectStore(t)
+
+	objects, err := o.ListObjects("my-bucket", "backups/")
+	req.NoError(err)
+	req.Equal([]string{"backups/my-backup/my-backup.tar.gz"}, objects)
+
+	keys, _, err := o.ListObjectsPaged("my-bucket", "backups/", "", 10)
+	req.NoError(err)
+	req.Equal([]string{"backups/my-backup/my-backup.tar.gz"}, keys)
+
+	_, err = o.ListObjects("my-bucket", "backups/linked/")
+	req.ErrorIs(err, ErrSymlink)
+
+	for _, key := range []string{"backups/my-backup/inside", "backups/my-backup/escape", "backups/linked/secret"} {
+		_, err := o.GetObject("my-bucket", key)
+		req.ErrorIs(err, ErrSymlink, key)
+
+		_, err = o.ObjectExists("my-bucket", key)
+		req.ErrorIs(err, ErrSymlink, key)
+
+		req.ErrorIs(o.PutObject("my-bucket", key, strings.NewReader("overwritten")), ErrSymlink, key)
+		req.ErrorIs(o.CopyObject("my-bucket", "backups/my-backup/my-backup.tar.gz", key), ErrSymlink, key)
+	}
+
+	// Nothing was written through the symlinks
+	req.NoError(o.PutObject("my-bucket", "backups/my-backup/my-backup.tar.gz", strings.NewReader("data")))
+	req.ErrorIs(o.PutObject("my-bucket", "backups/linked/new", strings.NewReader("new")), ErrSymlink)
+	data, err := os.ReadFile(filepath.Join(outside, "secret"))
+	req.NoError(err)
+	req.Equal("secret", string(data))
+	entries, err := os.ReadDir(outside)
+	req.NoError(err)
+	req.Len(entries, 1)
+}
+
+func Test_symlinks_followed(t *testing.T) {
+	req := require.New(t)
+	o, _ := newSymlinkTestObjectStore(t)
+	req.NoError(o.applyConfig(map[string]string{"followSymlinks": "true"}))
+
+	objects, err := o.ListObjects("my-bucket", "backups/")
+	req.NoError(err)
+	req.ElementsMatch([]string{"backups/my-backup/my-backup.tar.gz", "backups/my-backup/inside"}, objects)
+
+	rc, err := o.GetObject("my-bucket", "backups/my-backup/inside")
+	req.NoError(err)
+	data, err := io.ReadAll(rc)
+	req.NoError(err)
+	req.NoError(rc.Close())
+	req.Equal("data", string(data))
+
+	// Symlinks out of the bucket are rejected even when following symlinks
+	for _, key := range []string{"backups/my-backup/escape", "backups/linked/secret"} {
+		_, err := o.GetObject("my-bucket", key)
+		req.ErrorIs(err, ErrSymlink, key)
+		req.ErrorIs(o.PutObject("my-bucket", key, strings.NewReader("overwritten")), ErrSymlink, key)
+	}
+}
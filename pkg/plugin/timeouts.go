@@ -0,0 +1,71 @@
+package plugin
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// defaultFileserverReadHeaderTimeout is how long a client has to send the headers of a request,
+	// so that clients sending them slowly cannot hold connections open.
+	defaultFileserverReadHeaderTimeout = 10 * time.Second
+	// defaultFileserverReadTimeout is how long a client has to send the body of a request once its headers are read.
+	defaultFileserverReadTimeout = time.Minute
+	// defaultFileserverWriteTimeout does not limit writing a response, as downloading a large backup may take hours.
+	defaultFileserverWriteTimeout = 0
+	// defaultFileserverIdleTimeout is how long an idle keep-alive connection is kept open.
+	defaultFileserverIdleTimeout = 2 * time.Minute
+)
+
+// fileserverTimeoutKeys are the plugin ConfigMap keys of the fileserver timeouts, passed to the fileserver
+// in the environment variables of fileserverTimeoutEnvVars.
+var fileserverTimeoutKeys = []string{
+	"fileserverReadHeaderTimeout",
+	"fileserverReadTimeout",
+	"fileserverWriteTimeout",
+	"fileserverIdleTimeout",
+}
+
+var fileserverTimeoutEnvVars = map[string]string{
+	"fileserverReadHeaderTimeout": "FILESERVER_READ_HEADER_TIMEOUT",
+	"fileserverReadTimeout":       "FILESERVER_READ_TIMEOUT",
+	"fileserverWriteTimeout":      "FILESERVER_WRITE_TIMEOUT",
+	"fileserverIdleTimeout":       "FILESERVER_IDLE_TIMEOUT",
+}
+
+// FileserverTimeouts bound how long the fileserver waits on clients. Zero is no limit.
+type FileserverTimeouts struct {
+	ReadHeader time.Duration
+	Read       time.Duration
+	Write      time.Duration
+	Idle       time.Duration
+}
+
+// ParseFileserverTimeouts parses the fileserverReadHeaderTimeout, fileserverReadTimeout, fileserverWriteTimeout and
+// fileserverIdleTimeout settings as durations, returning the default for each one that is empty.
+func ParseFileserverTimeouts(readHeader, read, write, idle string) (FileserverTimeouts, error) {
+	var timeouts FileserverTimeouts
+	for _, setting := range []struct {
+		key   string
+		value string
+		def   time.Duration
+		dst   *time.Duration
+	}{
+		{"fileserverReadHeaderTimeout", readHeader, defaultFileserverReadHeaderTimeout, &timeouts.ReadHeader},
+		{"fileserverReadTimeout", read, defaultFileserverReadTimeout, &timeouts.Read},
+		{"fileserverWriteTimeout", write, defaultFileserverWriteTimeout, &timeouts.Write},
+		{"fileserverIdleTimeout", idle, defaultFileserverIdleTimeout, &timeouts.Idle},
+	} {
+		*setting.dst = setting.def
+		if setting.value == "" {
+			continue
+		}
+		timeout, err := time.ParseDuration(setting.value)
+		if err != nil || timeout < 0 {
+			return FileserverTimeouts{}, errors.Errorf("invalid %s %q: must be a non-negative duration", setting.key, setting.value)
+		}
+		*setting.dst = timeout
+	}
+	return timeouts, nil
+}
@@ -0,0 +1,30 @@
+package plugin
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_ParseFileserverTimeouts(t *testing.T) {
+	req := require.New(t)
+
+	timeouts, err := ParseFileserverTimeouts("", "", "", "")
+	req.NoError(err)
+	req.Equal(FileserverTimeouts{
+		ReadHeader: defaultFileserverReadHeaderTimeout,
+		Read:       defaultFileserverReadTimeout,
+		Write:      defaultFileserverWriteTimeout,
+		Idle:       defaultFileserverIdleTimeout,
+	}, timeouts)
+
+	timeouts, err = ParseFileserverTimeouts("5s", "0s", "1h", "30s")
+	req.NoError(err)
+	req.Equal(FileserverTimeouts{ReadHeader: 5 * time.Second, Write: time.Hour, Idle: 30 * time.Second}, timeouts)
+
+	_, err = ParseFileserverTimeouts("", "-1s", "", "")
+	req.ErrorContains(err, "fileserverReadTimeout")
+	_, err = ParseFileserverTimeouts("", "", "", "forever")
+	req.ErrorContains(err, "fileserverIdleTimeout")
+}
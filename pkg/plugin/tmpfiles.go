@@ -0,0 +1,66 @@
+package plugin
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// defaultTmpFileMaxAge is how old an upload's temporary file must be before it is assumed to be abandoned.
+const defaultTmpFileMaxAge = 24 * time.Hour
+
+// isTempFile returns truthy if the file name is that of an in-progress upload, as created by tempFilePath.
+func isTempFile(name string) bool {
+	i := strings.LastIndex(name, tempFileInfix)
+	if i <= 0 {
+		return false
+	}
+	suffix := name[i+len(tempFileInfix):]
+	if suffix == "" {
+		return false
+	}
+	for _, c := range suffix {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// removeStaleTempFiles removes the temporary files left under dir by uploads that did not complete,
+// such as after a crash. Only files not modified within maxAge are removed, so uploads still in progress are left alone.
+// Failures to remove individual files are logged and do not stop the sweep.
+func removeStaleTempFiles(dir string, maxAge time.Duration, log logrus.FieldLogger) (removed int, err error) {
+	cutoff := time.Now().Add(-maxAge)
+	err = filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() || !isTempFile(d.Name()) {
+			return nil
+		}
+		info, err := d.Info()
+		if os.IsNotExist(err) {
+			// the upload completed and renamed the file while we were walking
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if info.ModTime().After(cutoff) {
+			return nil
+		}
+
+		log.Infof("Removing stale temporary file %s last modified %s", p, info.ModTime().Format(time.RFC3339))
+		if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+			log.WithError(err).Warnf("Failed to remove stale temporary file %s", p)
+			return nil
+		}
+		removed++
+		return nil
+	})
+	return removed, err
+}
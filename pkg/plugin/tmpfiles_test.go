@@ -0,0 +1,56 @@
+package plugin
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_removeStaleTempFiles(t *testing.T) {
+	req := require.New(t)
+	root := t.TempDir()
+	dir := filepath.Join(root, "backups", "my-backup")
+	req.NoError(os.MkdirAll(dir, 0755))
+
+	files := map[string]struct {
+		age         time.Duration
+		wantRemoved bool
+	}{
+		"my-backup.tar.gz.tmp-123":        {age: 48 * time.Hour, wantRemoved: true},
+		"my-backup.tar.gz.sha256.tmp-456": {age: 25 * time.Hour, wantRemoved: true},
+		"my-backup-logs.gz.tmp-789":       {age: time.Minute},
+		"my-backup.tar.gz":                {age: 48 * time.Hour},
+		"my-backup.tmp-notes":             {age: 48 * time.Hour},
+	}
+	for name, file := range files {
+		p := filepath.Join(dir, name)
+		req.NoError(os.WriteFile(p, []byte("contents"), 0644))
+		mtime := time.Now().Add(-file.age)
+		req.NoError(os.Chtimes(p, mtime, mtime))
+	}
+
+	removed, err := removeStaleTempFiles(root, defaultTmpFileMaxAge, logrus.New())
+	req.NoError(err)
+	req.Equal(2, removed)
+
+	for name, file := range files {
+		_, err := os.Stat(filepath.Join(dir, name))
+		if file.wantRemoved {
+			req.True(os.IsNotExist(err), "%s should be removed", name)
+		} else {
+			req.NoError(err, "%s should be kept", name)
+		}
+	}
+}
+
+func Test_isTempFile(t *testing.T) {
+	req := require.New(t)
+	req.True(isTempFile(filepath.Base(tempFilePath("/root/my-bucket/backups/my-backup/my-backup.tar.gz"))))
+	req.False(isTempFile("my-backup.tar.gz"))
+	req.False(isTempFile("my-backup.tmp-"))
+	req.False(isTempFile(".tmp-123"))
+}
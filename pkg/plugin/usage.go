@@ -0,0 +1,171 @@
+package plugin
+
+import (
+	"io/fs"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// defaultUsageCacheTTL is how long the usage of a bucket is reused before the bucket is walked again.
+const defaultUsageCacheTTL = time.Minute
+
+// ParseUsageCacheTTL parses the usageCacheTTL setting as a duration, returning the default if it is empty.
+// Zero disables caching.
+func ParseUsageCacheTTL(value string) (time.Duration, error) {
+	if value == "" {
+		return defaultUsageCacheTTL, nil
+	}
+	ttl, err := time.ParseDuration(value)
+	if err != nil || ttl < 0 {
+		return 0, errors.Errorf("invalid usageCacheTTL %q: must be a non-negative duration", value)
+	}
+	return ttl, nil
+}
+
+// Usage is the space consumed by the objects beneath a directory.
+// TotalBytes is the size of the files on the volume, which is smaller than the objects for compressed objects.
+type Usage struct {
+	TotalBytes  int64 `json:"totalBytes"`
+	ObjectCount int   `json:"objectCount"`
+}
+
+// dirUsage walks the directory and sums the sizes of the object files in it, skipping checksum and metadata sidecars,
+// temporary files, symlinks and other files that are not regular, the dedup blob store and any file skip returns truthy for.
+func dirUsage(dir string, skip func(path string) bool) (Usage, error) {
+	var usage Usage
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if isDedupDir(dir, p) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() || isInternalFile(d.Name()) || (skip != nil && skip(p)) {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		usage.TotalBytes += info.Size()
+		usage.ObjectCount++
+		return nil
+	})
+	if err != nil {
+		return Usage{}, err
+	}
+	return usage, nil
+}
+
+// UsageCache holds the usage of directories for a time, so repeated requests do not each walk the whole volume.
+type UsageCache struct {
+	ttl time.Duration
+	now func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]cachedUsage
+}
+
+type cachedUsage struct {
+	usage      Usage
+	computedAt time.Time
+}
+
+// NewUsageCache returns a cache that reuses the usage of a directory for ttl.
+func NewUsageCache(ttl time.Duration) *UsageCache {
+	return &UsageCache{
+		ttl:     ttl,
+		now:     time.Now,
+		entries: map[string]cachedUsage{},
+	}
+}
+
+// DirUsage returns the usage of the objects beneath the directory, walking it if the cached usage is older than the ttl.
+func (c *UsageCache) DirUsage(dir string) (Usage, error) {
+	return c.get(dir, c.ttl, func() (Usage, error) {
+		return dirUsage(dir, nil)
+	})
+}
+
+// LocationUsage returns the usage of the objects of a bucket on its volume at volumePath, for the config of its
+// location: beneath its rootSubPath and without its audit log, as BucketUsage counts them.
+func (c *UsageCache) LocationUsage(volumePath string, config map[string]string) (Usage, error) {
+	rootSubPath, err := parseRootSubPath(config["rootSubPath"])
+	if err != nil {
+		return Usage{}, err
+	}
+	auditLogPath, err := parseAuditLogPath(config["auditLogPath"])
+	if err != nil {
+		return Usage{}, err
+	}
+	dir := filepath.Join(volumePath, rootSubPath)
+	return c.get(dir, c.ttl, func() (Usage, error) {
+		return dirUsage(dir, auditLogFiles(volumePath, auditLogPath))
+	})
+}
+
+// auditLogFiles returns a function returning truthy for the audit log at auditLogPath within the volume at
+// volumePath and its rotated file, or nil without an audit log.
+func auditLogFiles(volumePath, auditLogPath string) func(path string) bool {
+	if auditLogPath == "" {
+		return nil
+	}
+	auditLog := filepath.Join(volumePath, auditLogPath)
+	return func(path string) bool {
+		return path == auditLog || path == rotatedAuditLogPath(auditLog)
+	}
+}
+
+// get returns the usage cached for key if it is younger than ttl, and otherwise computes and caches it.
+// Concurrent callers for a stale key wait for a single computation.
+func (c *UsageCache) get(key string, ttl time.Duration, compute func() (Usage, error)) (Usage, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if entry, ok := c.entries[key]; ok && c.now().Sub(entry.computedAt) < ttl {
+		return entry.usage, nil
+	}
+
+	usage, err := compute()
+	if err != nil {
+		return Usage{}, err
+	}
+	c.entries[key] = cachedUsage{usage: usage, computedAt: c.now()}
+	return usage, nil
+}
+
+// BucketUsage returns the bytes used on the volume by the objects of the bucket and their number,
+// excluding checksum sidecars, temporary files and the audit log. Results are reused for the
+// usageCacheTTL of the plugin ConfigMap.
+func (o *LocalVolumeObjectStore) BucketUsage(bucket string) (totalBytes int64, objectCount int, err error) {
+	defer observeOperation("BucketUsage", time.Now(), &err)
+
+	bucketPath := o.bucketPath(bucket)
+	if _, err := o.objectPath(bucket, ""); err != nil {
+		return 0, 0, err
+	}
+
+	log := o.log.WithFields(logrus.Fields{
+		"bucket": bucket,
+		"path":   bucketPath,
+	})
+	log.Debug("LocalVolumeObjectStore.BucketUsage called")
+
+	// The audit log is not opened for read-only locations, so it is skipped by its configured path
+	volumePath := filepath.Join(o.getRootPath(), bucket)
+	usage, err := o.usageCache.get(bucketPath, o.getUsageCacheTTL(), func() (Usage, error) {
+		return dirUsage(bucketPath, auditLogFiles(volumePath, o.auditLogPath))
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	return usage.TotalBytes, usage.ObjectCount, nil
+}
@@ -0,0 +1,139 @@
+package plugin
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_BucketUsage(t *testing.T) {
+	tests := []struct {
+		name      string
+		keys      map[string]string
+		wantBytes int64
+		wantCount int
+	}{
+		{
+			name: "empty bucket",
+		},
+		{
+			name: "nested files",
+			keys: map[string]string{
+				"backups/my-backup/my-backup.tar.gz":               "backup",
+				"backups/my-backup/nested/deeper/volume-info.json": "{}",
+				"restores/my-restore/restore-logs.gz":              "logs",
+			},
+			wantBytes: int64(len("backup") + len("{}") + len("logs")),
+			wantCount: 3,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := require.New(t)
+			o, root := newTestObjectStore(t)
+			bucketPath := filepath.Join(root, "my-bucket")
+			req.NoError(os.MkdirAll(bucketPath, 0755))
+
+			for key, content := range tt.keys {
+				req.NoError(o.PutObject("my-bucket", key, strings.NewReader(content)))
+			}
+			// neither an upload in progress nor the audit log are objects
+			req.NoError(os.WriteFile(tempFilePath(filepath.Join(bucketPath, "partial")), []byte("partial"), 0644))
+			req.NoError(o.applyConfig(map[string]string{"auditLogPath": "audit.log"}))
+			req.NoError(os.WriteFile(filepath.Join(bucketPath, "audit.log"), []byte("{}\n"), 0644))
+
+			totalBytes, objectCount, err := o.BucketUsage("my-bucket")
+			req.NoError(err)
+			req.Equal(tt.wantBytes, totalBytes)
+			req.Equal(tt.wantCount, objectCount)
+		})
+	}
+}
+
+func Test_BucketUsage_missingBucket(t *testing.T) {
+	o, _ := newTestObjectStore(t)
+
+	_, _, err := o.BucketUsage("my-bucket")
+	require.True(t, os.IsNotExist(err))
+}
+
+func Test_LocationUsage(t *testing.T) {
+	req := require.New(t)
+	o, root := newTestObjectStore(t)
+	config := map[string]string{"rootSubPath": "team-a", "auditLogPath": "team-a/audit.log", "dedup": "hardlink"}
+	req.NoError(o.applyConfig(config))
+
+	req.NoError(o.PutObject("my-bucket", "backups/my-backup/my-backup.tar.gz", strings.NewReader("backup")))
+	req.NoError(o.PutObject("my-bucket", "backups/my-backup-2/my-backup-2.tar.gz", strings.NewReader("backup")))
+	volumePath := filepath.Join(root, "my-bucket")
+	req.NoError(os.WriteFile(filepath.Join(volumePath, "team-a", "audit.log"), []byte("{}\n"), 0644))
+	// objects of the other locations sharing the volume are not counted either
+	req.NoError(os.MkdirAll(filepath.Join(volumePath, "team-b"), 0755))
+	req.NoError(os.WriteFile(filepath.Join(volumePath, "team-b", "other"), []byte("other"), 0644))
+
+	totalBytes, objectCount, err := o.BucketUsage("my-bucket")
+	req.NoError(err)
+	req.Equal(int64(2*len("backup")), totalBytes)
+	req.Equal(2, objectCount)
+
+	usage, err := NewUsageCache(0).LocationUsage(volumePath, config)
+	req.NoError(err)
+	req.Equal(Usage{TotalBytes: totalBytes, ObjectCount: objectCount}, usage)
+
+	_, err = NewUsageCache(0).LocationUsage(volumePath, map[string]string{"rootSubPath": "../escape"})
+	req.ErrorContains(err, "invalid rootSubPath")
+}
+
+func Test_UsageCache(t *testing.T) {
+	req := require.New(t)
+	dir := t.TempDir()
+	req.NoError(os.WriteFile(filepath.Join(dir, "first"), []byte("first"), 0644))
+
+	now := time.Now()
+	cache := NewUsageCache(time.Minute)
+	cache.now = func() time.Time { return now }
+
+	usage, err := cache.DirUsage(dir)
+	req.NoError(err)
+	req.Equal(Usage{TotalBytes: 5, ObjectCount: 1}, usage)
+
+	// the cached usage is returned until the ttl has passed
+	req.NoError(os.WriteFile(filepath.Join(dir, "second"), []byte("second"), 0644))
+	now = now.Add(59 * time.Second)
+	usage, err = cache.DirUsage(dir)
+	req.NoError(err)
+	req.Equal(Usage{TotalBytes: 5, ObjectCount: 1}, usage)
+
+	now = now.Add(time.Second)
+	usage, err = cache.DirUsage(dir)
+	req.NoError(err)
+	req.Equal(Usage{TotalBytes: 11, ObjectCount: 2}, usage)
+
+	// a zero ttl disables caching
+	uncached := NewUsageCache(0)
+	req.NoError(os.WriteFile(filepath.Join(dir, "third"), []byte("third"), 0644))
+	usage, err = uncached.DirUsage(dir)
+	req.NoError(err)
+	req.Equal(Usage{TotalBytes: 16, ObjectCount: 3}, usage)
+}
+
+func Test_ParseUsageCacheTTL(t *testing.T) {
+	req := require.New(t)
+
+	ttl, err := ParseUsageCacheTTL("")
+	req.NoError(err)
+	req.Equal(defaultUsageCacheTTL, ttl)
+
+	ttl, err = ParseUsageCacheTTL("5m")
+	req.NoError(err)
+	req.Equal(5*time.Minute, ttl)
+
+	for _, value := range []string{"-1s", "soon"} {
+		_, err := ParseUsageCacheTTL(value)
+		req.Error(err, value)
+	}
+}
@@ -1,8 +1,12 @@
 package plugin
 
 import (
+	"fmt"
+	"io"
 	"io/fs"
+	"math/rand"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"syscall"
@@ -13,8 +17,15 @@ import (
 
 const defaultRoot = "/var/velero-local-volume-provider"
 
+// tempFileInfix separates an object path from the random suffix of its in-progress upload file.
+const tempFileInfix = ".tmp-"
+
 // getRoot returns the internal mount point of the Velero container for the local volumes.
+// NFS_PROVIDER_ROOT takes precedence over VOLUME_ROOT, so the object store can be pointed at any directory.
 func getRoot() string {
+	if root := os.Getenv("NFS_PROVIDER_ROOT"); root != "" {
+		return root
+	}
 	root := os.Getenv("VOLUME_ROOT")
 	if root != "" {
 		return root
@@ -35,6 +46,179 @@ func getSubDirectoryLayout() []string {
 	}
 }
 
+// tempFilePath returns a unique sibling of path used to stage a write before it is renamed into place.
+func tempFilePath(path string) string {
+	return fmt.Sprintf("%s%s%d", path, tempFileInfix, rand.Int63())
+}
+
+// isMissingDir returns truthy if the error accessing a directory means that it does not exist, because it or one of
+// its parents is missing or a parent is a file. For listings this means there are no objects under it.
+func isMissingDir(err error) bool {
+	return os.IsNotExist(err) || errors.Is(err, syscall.ENOTDIR)
+}
+
+// isInternalFile returns truthy if the file name is one the plugin keeps alongside objects rather than an object:
+// a checksum or metadata sidecar, or the temporary file of an upload in progress. Listings and usage skip them,
+// while reads and writes map keys to the files holding them, such as compressed objects.
+func isInternalFile(name string) bool {
+	return isSidecarFile(name) || isTempFile(name)
+}
+
+// ErrNotRegularFile is returned when the file of an object is not a regular file, such as a FIFO, socket or
+// device node left on the volume, which could block or misbehave when opened.
+var ErrNotRegularFile = errors.New("object is not a regular file")
+
+// checkRegularFile returns ErrNotRegularFile if the object file, or the target of a symlink to it, is not a regular file.
+func checkRegularFile(filePath string) error {
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return errors.Wrapf(ErrNotRegularFile, "%s is a %s", filepath.Base(filePath), fileTypeName(info.Mode()))
+	}
+	return nil
+}
+
+// fileTypeName describes the type of a file that is not regular, for logs and errors.
+func fileTypeName(mode fs.FileMode) string {
+	switch {
+	case mode&fs.ModeNamedPipe != 0:
+		return "FIFO"
+	case mode&fs.ModeSocket != 0:
+		return "socket"
+	case mode&fs.ModeCharDevice != 0:
+		return "character device"
+	case mode&fs.ModeDevice != 0:
+		return "device"
+	case mode.IsDir():
+		return "directory"
+	default:
+		return "irregular file"
+	}
+}
+
+// ErrPathTraversal is returned when a bucket or key would resolve to a path outside of its root.
+var ErrPathTraversal = errors.New("path escapes the bucket root")
+
+// ErrObjectNotFound is returned when the object for a key does not exist.
+// The error it wraps is preserved, so os.ErrNotExist also matches.
+var ErrObjectNotFound = errors.New("object not found")
+
+// ErrReadOnly is returned by writes to a location configured as read-only.
+var ErrReadOnly = errors.New("backup storage location is read-only")
+
+// ErrSymlink is returned when an object would be read or written through a symlink that may not be followed.
+var ErrSymlink = errors.New("path is a symlink")
+
+// ErrVolumeNotMounted is returned when the volume of a remote volume type is not mounted over the NFS or SMB share.
+var ErrVolumeNotMounted = errors.New("volume is not a remote mount")
+
+// ErrObjectEmpty is returned by ValidateObject for an object whose file is zero bytes, as left by a truncated write.
+var ErrObjectEmpty = errors.New("object is empty")
+
+// ErrChecksumMismatch is returned when the content of an object does not match its checksum sidecar.
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+// ErrObjectTooLarge is returned when the body of an object is larger than maxObjectSizeBytes.
+var ErrObjectTooLarge = errors.New("object exceeds maxObjectSizeBytes")
+
+// ErrNoRandomAccess is returned by GetObjectReaderAt for objects whose content cannot be read at an offset.
+var ErrNoRandomAccess = errors.New("object does not support random access")
+
+// ErrStorageFull is returned when an object cannot be written because the volume is full or its quota is exceeded.
+var ErrStorageFull = errors.New("storage full")
+
+// ErrReservedKey is returned for keys naming the files the plugin stores alongside objects, such as checksum
+// and metadata sidecars, in-progress uploads and compressed object files, which would be mistaken for them.
+var ErrReservedKey = errors.New("key is reserved")
+
+// checkReservedKey returns ErrReservedKey if a segment of the key is named as the plugin names the files it stores
+// alongside objects, or if the key is within the deduplication directory of the bucket.
+func checkReservedKey(key string) error {
+	for i, segment := range strings.Split(filepath.ToSlash(key), "/") {
+		if isInternalFile(segment) || fileCompression(segment) != "" || (i == 0 && segment == dedupDirName) {
+			return errors.Wrapf(ErrReservedKey, "invalid key %q: %q is reserved for the files stored alongside objects", key, segment)
+		}
+	}
+	return nil
+}
+
+// validateRoot returns an error if the root does not exist or is not a directory.
+func validateRoot(root string) error {
+	info, err := os.Stat(root)
+	if err != nil {
+		return errors.Wrapf(err, "failed to stat root %s", root)
+	}
+	if !info.IsDir() {
+		return errors.Errorf("root %s is not a directory", root)
+	}
+	return nil
+}
+
+// resolveKeyPath returns the cleaned path of the key within the bucket on the local volume at root.
+// It returns ErrPathTraversal if the bucket or key would resolve outside of their roots.
+func resolveKeyPath(root, bucket, key string) (string, error) {
+	bucketPath := filepath.Join(root, bucket)
+	if !isWithinDir(root, bucketPath) || bucketPath == filepath.Clean(root) {
+		return "", errors.Wrapf(ErrPathTraversal, "invalid bucket %q", bucket)
+	}
+
+	path := filepath.Join(bucketPath, key)
+	if !isWithinDir(bucketPath, path) {
+		return "", errors.Wrapf(ErrPathTraversal, "invalid key %q", key)
+	}
+
+	return path, nil
+}
+
+// objectKey returns the key of the object stored in the file at path p within the bucket directory:
+// relative to the bucket, without any compressed suffix and always separated by forward slashes.
+func objectKey(bucketPath, p string) (string, error) {
+	return relativeKey(bucketPath, objectNameFromFile(p))
+}
+
+// relativeKey returns the path p relative to the bucket directory, separated by forward slashes.
+func relativeKey(bucketPath, p string) (string, error) {
+	rel, err := filepath.Rel(bucketPath, p)
+	if err != nil {
+		return "", err
+	}
+	return filepath.ToSlash(rel), nil
+}
+
+// keyPrefixDir returns the directory holding every key that starts with the prefix:
+// the prefix up to and including its last forward slash.
+func keyPrefixDir(prefix string) string {
+	return prefix[:strings.LastIndex(prefix, "/")+1]
+}
+
+// dirMayMatchPrefix returns truthy if the directory with the bucket relative key dirKey
+// may hold keys starting with the prefix.
+func dirMayMatchPrefix(dirKey, prefix string) bool {
+	dirKey += "/"
+	return strings.HasPrefix(dirKey, prefix) || strings.HasPrefix(prefix, dirKey)
+}
+
+// normalizeKeyPrefix returns the prefix in the form of the keys returned by listings,
+// without leading or repeated forward slashes. A trailing slash is kept.
+func normalizeKeyPrefix(prefix string) string {
+	prefix = strings.TrimLeft(filepath.ToSlash(prefix), "/")
+	for strings.Contains(prefix, "//") {
+		prefix = strings.ReplaceAll(prefix, "//", "/")
+	}
+	return prefix
+}
+
+// isWithinDir returns truthy if path is dir or is located beneath it.
+func isWithinDir(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	if err != nil {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
 func sliceContainsString(list []string, s string) bool {
 	for _, v := range list {
 		if strings.Contains(v, s) {
@@ -83,3 +267,95 @@ func StringToIntPointer(x string) (*int64, error) {
 	}
 	return &xout, nil
 }
+
+const (
+	defaultDirMode  os.FileMode = 0755
+	defaultFileMode os.FileMode = 0644
+)
+
+// parseFileMode parses an octal permission mode config value, returning def if it is empty.
+func parseFileMode(key, value string, def os.FileMode) (os.FileMode, error) {
+	if value == "" {
+		return def, nil
+	}
+	mode, err := strconv.ParseUint(value, 8, 32)
+	if err != nil || mode == 0 || mode > 0777 {
+		return 0, errors.Errorf("invalid %s %q: must be an octal permission mode such as 0755", key, value)
+	}
+	return os.FileMode(mode), nil
+}
+
+// mkdirAll creates the directory and any missing parents like os.MkdirAll,
+// then sets the mode of each directory it created, as the mode given when creating them is subject to the umask.
+func mkdirAll(path string, mode os.FileMode) error {
+	var created []string
+	for dir := filepath.Clean(path); ; dir = filepath.Dir(dir) {
+		if _, err := os.Stat(dir); err == nil || !os.IsNotExist(err) || dir == filepath.Dir(dir) {
+			break
+		}
+		created = append(created, dir)
+	}
+
+	if err := os.MkdirAll(path, mode); err != nil {
+		return err
+	}
+	for _, dir := range created {
+		if err := os.Chmod(dir, mode); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// syncDir flushes the entries of the directory to stable storage, making renames and removals within it durable.
+func syncDir(path string) error {
+	dir, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	if err := dir.Sync(); err != nil {
+		dir.Close()
+		return err
+	}
+	return dir.Close()
+}
+
+// syncFile flushes the file to stable storage. It is a variable so tests can count the syncs.
+var syncFile = (*os.File).Sync
+
+// syncingWriter writes to w, syncing file each time every bytes have been written, so a long stream becomes durable
+// as it is written rather than only once complete. When w buffers, as with directIO, at most its buffer lags behind.
+type syncingWriter struct {
+	file     *os.File
+	w        io.Writer
+	every    int64
+	unsynced int64
+}
+
+func newSyncingWriter(file *os.File, w io.Writer, every int64) *syncingWriter {
+	return &syncingWriter{file: file, w: w, every: every}
+}
+
+func (s *syncingWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		chunk := p
+		if remaining := s.every - s.unsynced; int64(len(chunk)) > remaining {
+			chunk = chunk[:remaining]
+		}
+		n, err := s.w.Write(chunk)
+		written += n
+		s.unsynced += int64(n)
+		if err != nil {
+			return written, err
+		}
+		if s.unsynced == s.every {
+			if err := syncFile(s.file); err != nil {
+				return written, errors.Wrap(err, "failed to sync object")
+			}
+			s.unsynced = 0
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
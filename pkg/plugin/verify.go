@@ -0,0 +1,286 @@
+package plugin
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+)
+
+// defaultVerifyWorkers is the number of objects VerifyBucket hashes at once unless configured otherwise.
+const defaultVerifyWorkers = 4
+
+// VerifyStatus is the outcome of verifying the checksum of an object.
+type VerifyStatus string
+
+const (
+	// VerifyPassed means the content of the object matches its checksum sidecar.
+	VerifyPassed VerifyStatus = "pass"
+	// VerifyFailed means the content of the object does not match its checksum sidecar, or could not be read.
+	VerifyFailed VerifyStatus = "fail"
+	// VerifyMissingChecksum means the object has no checksum sidecar to verify it against.
+	VerifyMissingChecksum VerifyStatus = "missing-sidecar"
+)
+
+// VerifyResult describes the verification of a single object.
+// Err is set for failed objects.
+type VerifyResult struct {
+	Key    string
+	Status VerifyStatus
+	Err    error
+}
+
+// VerifyBucket recomputes the SHA256 checksum of every object in the bucket and compares it to the object's sidecar,
+// returning a result per object in listing order. At most verifyWorkers objects are read at once.
+// Objects that fail verification are reported in the results rather than as an error,
+// which is returned only if the bucket cannot be listed.
+func (o *LocalVolumeObjectStore) VerifyBucket(bucket string) (results []VerifyResult, err error) {
+	defer observeOperation("VerifyBucket", time.Now(), &err)
+
+	log := o.log.WithFields(logrus.Fields{
+		"bucket": bucket,
+	})
+	log.Debug("LocalVolumeObjectStore.VerifyBucket called")
+
+	infos, err := o.listObjectsWithInfo(bucket, "")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list objects")
+	}
+
+	workers := o.verifyWorkers
+	if workers < 1 {
+		workers = defaultVerifyWorkers
+	}
+
+	results = make([]VerifyResult, len(infos))
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				results[i] = o.verifyObject(bucket, infos[i].Key)
+			}
+		}()
+	}
+	for i := range infos {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+
+	for _, result := range results {
+		if result.Status == VerifyFailed {
+			log.WithField("key", result.Key).WithError(result.Err).Warn("Object failed checksum verification")
+		}
+	}
+
+	return results, nil
+}
+
+// verifyObject compares the content of the object to its checksum sidecar.
+func (o *LocalVolumeObjectStore) verifyObject(bucket, key string) VerifyResult {
+	result := VerifyResult{Key: key, Status: VerifyFailed}
+
+	path, err := o.objectPath(bucket, key)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+
+	if _, err := os.Stat(checksumPath(path)); os.IsNotExist(err) {
+		result.Status = VerifyMissingChecksum
+		return result
+	}
+
+	filePath, compression, err := findObjectFile(path)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	if err := o.verifyObjectChecksum(path, filePath, compression); err != nil {
+		result.Err = err
+		return result
+	}
+
+	result.Status = VerifyPassed
+	return result
+}
+
+// BackfillChecksums writes the checksum sidecar of every object in the bucket lacking one, such as objects written
+// before checksums were recorded or copied onto the volume directly, returning how many were written.
+// Objects with a sidecar holding a digest are skipped without being read, so an existing sidecar is never replaced,
+// not even one its object no longer matches, as that is corruption for VerifyBucket to report. At most verifyWorkers
+// objects are hashed at once. Failing objects do not stop the rest; all failures are returned together.
+func (o *LocalVolumeObjectStore) BackfillChecksums(bucket string) (written int, err error) {
+	defer observeOperation("BackfillChecksums", time.Now(), &err)
+	if o.readOnly {
+		return 0, ErrReadOnly
+	}
+	done, err := o.beginWrite()
+	if err != nil {
+		return 0, err
+	}
+	defer done()
+
+	log := o.log.WithFields(logrus.Fields{
+		"bucket": bucket,
+	})
+	log.Debug("LocalVolumeObjectStore.BackfillChecksums called")
+
+	infos, err := o.listObjectsWithInfo(bucket, "")
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to list objects")
+	}
+
+	workers := o.verifyWorkers
+	if workers < 1 {
+		workers = defaultVerifyWorkers
+	}
+
+	var (
+		mu   sync.Mutex
+		errs []error
+	)
+	keys := make(chan string)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for key := range keys {
+				wrote, err := o.backfillChecksum(bucket, key)
+
+				mu.Lock()
+				if err != nil {
+					errs = append(errs, errors.Wrapf(err, "failed to backfill checksum of %s", key))
+				} else if wrote {
+					written++
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, info := range infos {
+		keys <- info.Key
+	}
+	close(keys)
+	wg.Wait()
+
+	log.Infof("Backfilled %d checksums, %d failed", written, len(errs))
+	return written, utilerrors.NewAggregate(errs)
+}
+
+// backfillChecksum writes the checksum sidecar of the object unless it has one holding a digest.
+// It returns truthy if the sidecar was written.
+func (o *LocalVolumeObjectStore) backfillChecksum(bucket, key string) (bool, error) {
+	path, err := o.objectPath(bucket, key)
+	if err != nil {
+		return false, err
+	}
+	defer o.keyLocks.lock(path)()
+
+	digest, err := readChecksum(path)
+	if err == nil && isDigest(digest) {
+		return false, nil
+	} else if err != nil && !os.IsNotExist(err) {
+		return false, errors.Wrap(err, "failed to read object checksum")
+	}
+
+	filePath, compression, err := findObjectFile(path)
+	if err != nil {
+		return false, err
+	}
+	if err := o.checkSymlinks(o.bucketPath(bucket), filePath); err != nil {
+		return false, err
+	}
+	file, err := openObjectFile(filePath, compression, o.getEncryptionKey())
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	// The checksum is always of the uncompressed content
+	hash := sha256.New()
+	if _, err := copyBuffered(hash, file, o.copyBufferSize); err != nil {
+		return false, errors.Wrap(err, "failed to hash object")
+	}
+	if err := writeChecksum(path, hex.EncodeToString(hash.Sum(nil)), o.getFileMode()); err != nil {
+		return false, errors.Wrap(err, "failed to write object checksum")
+	}
+	return true, nil
+}
+
+// ValidateObject returns an error if the object cannot be trusted for a restore: ErrObjectEmpty if it has no
+// content, as left by a truncated write, or ErrChecksumMismatch if it has a checksum sidecar its content
+// does not match. Objects without a sidecar are only checked for being empty.
+func (o *LocalVolumeObjectStore) ValidateObject(bucket, key string) (err error) {
+	defer observeOperation("ValidateObject", time.Now(), &err)
+
+	path, err := o.objectPath(bucket, key)
+	if err != nil {
+		return err
+	}
+
+	log := o.log.WithFields(logrus.Fields{
+		"bucket": bucket,
+		"key":    key,
+		"path":   path,
+	})
+	log.Debug("LocalVolumeObjectStore.ValidateObject called")
+
+	filePath, compression, err := findObjectFile(path)
+	if err != nil {
+		return err
+	}
+	if err := o.checkSymlinks(o.bucketPath(bucket), filePath); err != nil {
+		return err
+	}
+
+	return o.validateObjectFile(path, filePath, compression)
+}
+
+// validateObjectFile checks the object at path, held in filePath, is not empty and matches its checksum sidecar if it has one.
+func (o *LocalVolumeObjectStore) validateObjectFile(path, filePath, compression string) error {
+	if err := o.checkObjectNotEmpty(filePath, compression); err != nil {
+		return err
+	}
+	if _, err := os.Stat(checksumPath(path)); os.IsNotExist(err) {
+		return nil
+	}
+	return o.verifyObjectChecksum(path, filePath, compression)
+}
+
+// checkObjectNotEmpty returns ErrObjectEmpty if the object held in filePath has no content. A compressed or encrypted
+// object is stored with a header even when empty, so its content is read to check that it holds at least a byte.
+func (o *LocalVolumeObjectStore) checkObjectNotEmpty(filePath, compression string) error {
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return errors.Wrap(err, "failed to stat object")
+	}
+	if info.Size() == 0 {
+		return ErrObjectEmpty
+	}
+	if compression == "" && o.getEncryptionKey() == nil {
+		return nil
+	}
+
+	file, err := openObjectFile(filePath, compression, o.getEncryptionKey())
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if _, err := io.ReadFull(file, make([]byte, 1)); err == io.EOF {
+		return ErrObjectEmpty
+	} else if err != nil {
+		return errors.Wrap(err, "failed to read object")
+	}
+	return nil
+}
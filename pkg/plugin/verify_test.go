@@ -0,0 +1,260 @@
+package plugin
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_VerifyBucket(t *testing.T) {
+	for _, workers := range []int{1, 3} {
+		req := require.New(t)
+		o, root := newTestObjectStore(t)
+		o.verifyWorkers = workers
+
+		req.NoError(o.PutObject("my-bucket", "backups/intact/intact.tar.gz", strings.NewReader("intact")))
+		req.NoError(o.PutObject("my-bucket", "backups/corrupted/corrupted.tar.gz", strings.NewReader("original")))
+		req.NoError(o.PutObject("my-bucket", "backups/legacy/legacy.tar.gz", strings.NewReader("legacy")))
+		o.compression = compressionGzip
+		req.NoError(o.PutObject("my-bucket", "backups/compressed/compressed.tar.gz", strings.NewReader("compressed")))
+
+		bucketPath := filepath.Join(root, "my-bucket")
+		req.NoError(os.WriteFile(filepath.Join(bucketPath, "backups/corrupted/corrupted.tar.gz"), []byte("bit-rot!"), 0644))
+		req.NoError(os.Remove(checksumPath(filepath.Join(bucketPath, "backups/legacy/legacy.tar.gz"))))
+
+		results, err := o.VerifyBucket("my-bucket")
+		req.NoError(err)
+
+		statuses := map[string]VerifyStatus{}
+		for _, result := range results {
+			statuses[result.Key] = result.Status
+			if result.Status == VerifyFailed {
+				req.ErrorContains(result.Err, "checksum mismatch")
+			} else {
+				req.NoError(result.Err)
+			}
+		}
+		req.Equal(map[string]VerifyStatus{
+			"backups/intact/intact.tar.gz":         VerifyPassed,
+			"backups/corrupted/corrupted.tar.gz":   VerifyFailed,
+			"backups/legacy/legacy.tar.gz":         VerifyMissingChecksum,
+			"backups/compressed/compressed.tar.gz": VerifyPassed,
+		}, statuses, "workers=%d", workers)
+	}
+}
+
+func Test_VerifyBucket_empty(t *testing.T) {
+	req := require.New(t)
+	o, _ := newTestObjectStore(t)
+	req.NoError(os.MkdirAll(o.bucketPath("my-bucket"), 0755))
+
+	results, err := o.VerifyBucket("my-bucket")
+	req.NoError(err)
+	req.Empty(results)
+}
+
+func Test_BackfillChecksums(t *testing.T) {
+	for _, workers := range []int{1, 3} {
+		req := require.New(t)
+		o, root := newTestObjectStore(t)
+		o.verifyWorkers = workers
+		bucketPath := filepath.Join(root, "my-bucket")
+
+		req.NoError(o.PutObject("my-bucket", "backups/intact/intact.tar.gz", strings.NewReader("intact")))
+		req.NoError(o.PutObject("my-bucket", "backups/corrupted/corrupted.tar.gz", strings.NewReader("original")))
+		req.NoError(o.PutObject("my-bucket", "backups/legacy/legacy.tar.gz", strings.NewReader("legacy")))
+		req.NoError(o.PutObject("my-bucket", "backups/garbled/garbled.tar.gz", strings.NewReader("garbled")))
+		o.compression = compressionGzip
+		req.NoError(o.PutObject("my-bucket", "backups/compressed/compressed.tar.gz", strings.NewReader("compressed")))
+
+		req.NoError(os.WriteFile(filepath.Join(bucketPath, "backups/corrupted/corrupted.tar.gz"), []byte("bit-rot!"), 0644))
+		req.NoError(os.Remove(checksumPath(filepath.Join(bucketPath, "backups/legacy/legacy.tar.gz"))))
+		req.NoError(os.Remove(checksumPath(filepath.Join(bucketPath, "backups/compressed/compressed.tar.gz"))))
+		req.NoError(os.WriteFile(checksumPath(filepath.Join(bucketPath, "backups/garbled/garbled.tar.gz")), nil, 0644))
+		req.NoError(os.MkdirAll(filepath.Join(bucketPath, "backups", "imported"), 0755))
+		req.NoError(os.WriteFile(filepath.Join(bucketPath, "backups/imported/imported.tar.gz"), []byte("imported"), 0644))
+
+		corruptedSidecar, err := os.ReadFile(checksumPath(filepath.Join(bucketPath, "backups/corrupted/corrupted.tar.gz")))
+		req.NoError(err)
+
+		written, err := o.BackfillChecksums("my-bucket")
+		req.NoError(err)
+		req.Equal(4, written, "workers=%d", workers)
+
+		// The mismatching sidecar is kept for verification to report
+		sidecar, err := os.ReadFile(checksumPath(filepath.Join(bucketPath, "backups/corrupted/corrupted.tar.gz")))
+		req.NoError(err)
+		req.Equal(corruptedSidecar, sidecar)
+
+		results, err := o.VerifyBucket("my-bucket")
+		req.NoError(err)
+		statuses := map[string]VerifyStatus{}
+		for _, result := range results {
+			statuses[result.Key] = result.Status
+		}
+		req.Equal(map[string]VerifyStatus{
+			"backups/intact/intact.tar.gz":         VerifyPassed,
+			"backups/corrupted/corrupted.tar.gz":   VerifyFailed,
+			"backups/legacy/legacy.tar.gz":         VerifyPassed,
+			"backups/garbled/garbled.tar.gz":       VerifyPassed,
+			"backups/compressed/compressed.tar.gz": VerifyPassed,
+			"backups/imported/imported.tar.gz":     VerifyPassed,
+		}, statuses, "workers=%d", workers)
+
+		// Nothing is left to backfill
+		written, err = o.BackfillChecksums("my-bucket")
+		req.NoError(err)
+		req.Zero(written)
+	}
+}
+
+func Test_ValidateObject(t *testing.T) {
+	tests := []struct {
+		name    string
+		corrupt func(path string) error
+		wantErr error
+	}{
+		{
+			name: "intact object",
+		},
+		{
+			name:    "zero-byte file",
+			corrupt: func(path string) error { return os.Truncate(path, 0) },
+			wantErr: ErrObjectEmpty,
+		},
+		{
+			name:    "checksum mismatch",
+			corrupt: func(path string) error { return os.WriteFile(path, []byte("truncat"), 0644) },
+			wantErr: ErrChecksumMismatch,
+		},
+		{
+			name:    "no checksum sidecar",
+			corrupt: func(path string) error { return os.Remove(checksumPath(path)) },
+		},
+		{
+			name:    "missing object",
+			corrupt: os.Remove,
+			wantErr: ErrObjectNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := require.New(t)
+			o, root := newTestObjectStore(t)
+
+			key := "backups/my-backup/my-backup.tar.gz"
+			req.NoError(o.PutObject("my-bucket", key, strings.NewReader("truncated")))
+			if tt.corrupt != nil {
+				req.NoError(tt.corrupt(filepath.Join(root, "my-bucket", key)))
+			}
+
+			err := o.ValidateObject("my-bucket", key)
+			if tt.wantErr != nil {
+				req.ErrorIs(err, tt.wantErr)
+			} else {
+				req.NoError(err)
+			}
+		})
+	}
+}
+
+func Test_ObjectExists_validation(t *testing.T) {
+	tests := []struct {
+		name   string
+		config map[string]string
+		// whether the intact, zero-byte and checksum-mismatched objects exist
+		want []bool
+	}{
+		{
+			name: "no validation",
+			want: []bool{true, true, true},
+		},
+		{
+			name:   "treatEmptyAsMissing",
+			config: map[string]string{"treatEmptyAsMissing": "true"},
+			want:   []bool{true, false, true},
+		},
+		{
+			name:   "validateOnExists",
+			config: map[string]string{"validateOnExists": "true"},
+			want:   []bool{true, false, false},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := require.New(t)
+			o, root := newTestObjectStore(t)
+			req.NoError(o.applyConfig(tt.config))
+
+			keys := []string{"backups/intact/intact.tar.gz", "backups/empty/empty.tar.gz", "backups/corrupted/corrupted.tar.gz"}
+			for _, key := range keys {
+				req.NoError(o.PutObject("my-bucket", key, strings.NewReader("original")))
+			}
+			bucketPath := filepath.Join(root, "my-bucket")
+			req.NoError(os.Truncate(filepath.Join(bucketPath, keys[1]), 0))
+			req.NoError(os.WriteFile(filepath.Join(bucketPath, keys[2]), []byte("bit-rot!"), 0644))
+
+			for i, key := range keys {
+				exists, err := o.ObjectExists("my-bucket", key)
+				req.NoError(err)
+				req.Equal(tt.want[i], exists, key)
+			}
+		})
+	}
+}
+
+func Test_ObjectExists_treatEmptyAsMissing_encoded(t *testing.T) {
+	tests := []struct {
+		name   string
+		config map[string]string
+		// encrypted sets an encryption key on the store
+		encrypted bool
+	}{
+		{
+			name:   "gzip",
+			config: map[string]string{"compression": compressionGzip},
+		},
+		{
+			name:   "zstd",
+			config: map[string]string{"compression": compressionZstd},
+		},
+		{
+			name:      "encrypted",
+			encrypted: true,
+		},
+		{
+			name:      "encrypted gzip",
+			config:    map[string]string{"compression": compressionGzip},
+			encrypted: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := require.New(t)
+			o, _ := newTestObjectStore(t)
+			config := map[string]string{"treatEmptyAsMissing": "true"}
+			for k, v := range tt.config {
+				config[k] = v
+			}
+			req.NoError(o.applyConfig(config))
+			if tt.encrypted {
+				o.opts = &localVolumeObjectStoreOpts{encryptionKey: newTestEncryptionKey(t)}
+			}
+
+			req.NoError(o.PutObject("my-bucket", "backups/empty/empty.tar.gz", strings.NewReader("")))
+			req.NoError(o.PutObject("my-bucket", "backups/intact/intact.tar.gz", strings.NewReader("original")))
+
+			exists, err := o.ObjectExists("my-bucket", "backups/empty/empty.tar.gz")
+			req.NoError(err)
+			req.False(exists, "an empty object stored with a header should be missing")
+			req.ErrorIs(o.ValidateObject("my-bucket", "backups/empty/empty.tar.gz"), ErrObjectEmpty)
+
+			exists, err = o.ObjectExists("my-bucket", "backups/intact/intact.tar.gz")
+			req.NoError(err)
+			req.True(exists)
+		})
+	}
+}
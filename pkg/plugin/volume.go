@@ -2,7 +2,11 @@ package plugin
 
 import (
 	"context"
-	"os"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
 
 	"github.com/pkg/errors"
 	"github.com/replicatedhq/local-volume-provider/pkg/k8sutil"
@@ -17,12 +21,30 @@ import (
 const VolumeProviderKey = "app"
 const VolumeProviderLabel = "velero"
 
+// smbCSIDriverName is the name of the upstream SMB CSI driver (https://github.com/kubernetes-csi/csi-driver-smb)
+const smbCSIDriverName = "smb.csi.k8s.io"
+
+// nfsCSIDriverName is the name of the upstream NFS CSI driver (https://github.com/kubernetes-csi/csi-driver-nfs),
+// used for NFS volumes with a version or mount options, which the in-tree NFS volume source cannot set.
+const nfsCSIDriverName = "nfs.csi.k8s.io"
+
+// validNFSVersions are the NFS protocol versions the nfsVersion config may pin.
+var validNFSVersions = []string{"3", "4", "4.0", "4.1", "4.2"}
+
+// nfsMountOptionPattern matches a single NFS mount option, such as hard, rsize=1048576 or sec=krb5p.
+var nfsMountOptionPattern = regexp.MustCompile(`^[a-z0-9_-]+(=[A-Za-z0-9_.:/-]+)?$`)
+
 type VolumeType string
 
 const (
 	Hostpath VolumeType = "hostpath"
 	NFS      VolumeType = "nfs"
 	PVC      VolumeType = "pvc"
+	SMB      VolumeType = "smb"
+	ISCSI    VolumeType = "iscsi"
+	EmptyDir VolumeType = "emptyDir"
+
+	ExistingClaim VolumeType = "existingClaim"
 )
 
 // buildVoume creates a new k8s volume object based on the Velero BSL Config
@@ -32,15 +54,25 @@ func buildVolume(vt VolumeType, config map[string]string, log *logrus.Entry) (*c
 	var err error
 	switch vt {
 	case Hostpath:
+		log.Warn("Hostpath volumes are local to the node; backups will not survive the loss of the node unless the path is shared storage")
 		volumeSource, err = getHostPathVolumeSource(config)
 	case NFS:
 		volumeSource, err = getNFSVolumeSource(config)
+	case SMB:
+		volumeSource, err = getSMBVolumeSource(config)
+	case ISCSI:
+		volumeSource, err = getISCSIVolumeSource(config)
+	case EmptyDir:
+		log.Warn("EmptyDir volumes are lost when the Velero pod restarts and are not shared with node-agent; use them for testing only")
+		volumeSource, err = getEmptyDirVolumeSource(config)
 	case PVC:
 		err = ensurePVC(config, log)
 		if err != nil {
 			return nil, errors.Wrapf(err, "failed to create pvc for %s", config["bucket"])
 		}
 		volumeSource, err = getPVCVolumeSource(config)
+	case ExistingClaim:
+		volumeSource, err = getExistingClaimVolumeSource(config)
 	default:
 		return nil, errors.New("unrecognized volume type")
 	}
@@ -62,16 +94,92 @@ func getHostPathVolumeSource(config map[string]string) (*corev1.VolumeSource, er
 		return nil, errors.New("hostpath config missing path")
 	}
 
+	hostPathType := corev1.HostPathDirectory
+	if config["type"] != "" {
+		hostPathType = corev1.HostPathType(config["type"])
+	}
+
 	volumeSource := &corev1.VolumeSource{
 		HostPath: &corev1.HostPathVolumeSource{
 			Path: config["path"],
-			Type: hostPathTypePtr(corev1.HostPathDirectory),
+			Type: hostPathTypePtr(hostPathType),
+		},
+	}
+
+	return volumeSource, nil
+}
+
+// validHostPathTypes are the hostpath types that can hold the objects of a bucket
+var validHostPathTypes = []corev1.HostPathType{corev1.HostPathDirectory, corev1.HostPathDirectoryOrCreate}
+
+// getEmptyDirVolumeSource returns an emptyDir volume source to be used in a k8s volume, limited to the optional sizeLimit
+func getEmptyDirVolumeSource(config map[string]string) (*corev1.VolumeSource, error) {
+	if err := validateEmptyDirConfig(config); err != nil {
+		return nil, err
+	}
+
+	emptyDir := &corev1.EmptyDirVolumeSource{
+		Medium: corev1.StorageMedium(config["medium"]),
+	}
+	if config["sizeLimit"] != "" {
+		sizeLimit := resource.MustParse(config["sizeLimit"])
+		emptyDir.SizeLimit = &sizeLimit
+	}
+
+	return &corev1.VolumeSource{EmptyDir: emptyDir}, nil
+}
+
+// validateEmptyDirConfig checks the sizeLimit quantity and the medium of an emptyDir volume,
+// which is either the default storage of the node or Memory.
+func validateEmptyDirConfig(config map[string]string) error {
+	if config["sizeLimit"] != "" {
+		sizeLimit, err := resource.ParseQuantity(config["sizeLimit"])
+		if err != nil || sizeLimit.Sign() <= 0 {
+			return errors.Errorf("invalid emptyDir sizeLimit %q: must be a positive quantity", config["sizeLimit"])
+		}
+	}
+	switch medium := corev1.StorageMedium(config["medium"]); medium {
+	case corev1.StorageMediumDefault, corev1.StorageMediumMemory:
+	default:
+		return errors.Errorf("invalid emptyDir medium %q: must be empty or %s", medium, corev1.StorageMediumMemory)
+	}
+	return nil
+}
+
+// getISCSIVolumeSource returns an iscsi volume source to be used in a k8s volume
+func getISCSIVolumeSource(config map[string]string) (*corev1.VolumeSource, error) {
+	for _, key := range []string{"targetPortal", "iqn", "lun"} {
+		if config[key] == "" {
+			return nil, errors.Errorf("iscsi config missing %s", key)
+		}
+	}
+
+	lun, err := parseISCSILun(config["lun"])
+	if err != nil {
+		return nil, err
+	}
+
+	volumeSource := &corev1.VolumeSource{
+		ISCSI: &corev1.ISCSIVolumeSource{
+			TargetPortal: config["targetPortal"],
+			IQN:          config["iqn"],
+			Lun:          lun,
+			FSType:       config["fsType"],
 		},
 	}
 
 	return volumeSource, nil
 }
 
+// parseISCSILun parses the logical unit number of an iscsi target
+func parseISCSILun(value string) (int32, error) {
+	lun, err := strconv.ParseInt(value, 10, 32)
+	if err != nil || lun < 0 {
+		return 0, errors.Errorf("invalid iscsi lun %q: must be a non-negative integer", value)
+	}
+	return int32(lun), nil
+}
+
 // getNFSVolumeSource returns an nfs volume source to be used in a k8s volume
 func getNFSVolumeSource(config map[string]string) (*corev1.VolumeSource, error) {
 	path, ok := config["path"]
@@ -84,6 +192,23 @@ func getNFSVolumeSource(config map[string]string) (*corev1.VolumeSource, error)
 		return nil, errors.New("nfs config missing server address")
 	}
 
+	mountOptions, err := parseNFSMountOptions(config["nfsVersion"], config["nfsMountOptions"])
+	if err != nil {
+		return nil, err
+	}
+	if len(mountOptions) > 0 {
+		return &corev1.VolumeSource{
+			CSI: &corev1.CSIVolumeSource{
+				Driver: nfsCSIDriverName,
+				VolumeAttributes: map[string]string{
+					"server":       server,
+					"share":        path,
+					"mountOptions": strings.Join(mountOptions, ","),
+				},
+			},
+		}, nil
+	}
+
 	volumeSource := &corev1.VolumeSource{
 		NFS: &corev1.NFSVolumeSource{
 			Path:   path,
@@ -94,6 +219,66 @@ func getNFSVolumeSource(config map[string]string) (*corev1.VolumeSource, error)
 	return volumeSource, nil
 }
 
+// parseNFSMountOptions returns the mount options of an NFS volume from the comma separated nfsMountOptions config,
+// with nfsVersion appended as nfsvers. The version may be given by either, but not both.
+func parseNFSMountOptions(version, options string) ([]string, error) {
+	var mountOptions []string
+	for _, option := range strings.Split(options, ",") {
+		option = strings.TrimSpace(option)
+		if option == "" {
+			continue
+		}
+		if !nfsMountOptionPattern.MatchString(option) {
+			return nil, errors.Errorf("invalid nfsMountOptions: invalid option %q", option)
+		}
+		name, _, _ := strings.Cut(option, "=")
+		if version != "" && (name == "vers" || name == "nfsvers") {
+			return nil, errors.Errorf("invalid nfsMountOptions: %s conflicts with nfsVersion", name)
+		}
+		mountOptions = append(mountOptions, option)
+	}
+
+	if version != "" {
+		if !slices.Contains(validNFSVersions, version) {
+			return nil, errors.Errorf("invalid nfsVersion %q: must be one of %v", version, validNFSVersions)
+		}
+		mountOptions = append(mountOptions, "nfsvers="+version)
+	}
+	return mountOptions, nil
+}
+
+// getSMBVolumeSource returns an smb csi volume source to be used in a k8s volume
+func getSMBVolumeSource(config map[string]string) (*corev1.VolumeSource, error) {
+	server, ok := config["server"]
+	if !ok {
+		return nil, errors.New("smb config missing server address")
+	}
+
+	share, ok := config["share"]
+	if !ok {
+		return nil, errors.New("smb config missing share")
+	}
+
+	secretName, ok := config["secretName"]
+	if !ok {
+		return nil, errors.New("smb config missing secretName")
+	}
+
+	volumeSource := &corev1.VolumeSource{
+		CSI: &corev1.CSIVolumeSource{
+			Driver: smbCSIDriverName,
+			VolumeAttributes: map[string]string{
+				"source": fmt.Sprintf("//%s/%s", server, share),
+			},
+			NodePublishSecretRef: &corev1.LocalObjectReference{
+				Name: secretName,
+			},
+		},
+	}
+
+	return volumeSource, nil
+}
+
 // getPVCVolumeSource returns an nfs volume source to be used in a k8s volume
 func getPVCVolumeSource(config map[string]string) (*corev1.VolumeSource, error) {
 	pvcName, ok := config["bucket"]
@@ -109,6 +294,72 @@ func getPVCVolumeSource(config map[string]string) (*corev1.VolumeSource, error)
 	return volumeSource, nil
 }
 
+// getExistingClaimVolumeSource returns a volume source for a pre-provisioned pvc to be used in a k8s volume
+func getExistingClaimVolumeSource(config map[string]string) (*corev1.VolumeSource, error) {
+	claimName := config["claimName"]
+	if claimName == "" {
+		return nil, errors.New("existingClaim config missing claimName")
+	}
+
+	volumeSource := &corev1.VolumeSource{
+		PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
+			ClaimName: claimName,
+		},
+	}
+
+	return volumeSource, nil
+}
+
+// validateVolumeConfig checks that the Velero BSL Config contains the keys required by the volume type,
+// so that misconfiguration is reported before any resources are modified.
+func validateVolumeConfig(vt VolumeType, config map[string]string) error {
+	switch vt {
+	case ExistingClaim:
+		if config["claimName"] == "" {
+			return errors.New("existingClaim config missing claimName")
+		}
+	case NFS:
+		if _, err := parseNFSMountOptions(config["nfsVersion"], config["nfsMountOptions"]); err != nil {
+			return err
+		}
+	case SMB:
+		for _, key := range []string{"server", "share", "secretName"} {
+			if config[key] == "" {
+				return errors.Errorf("smb config missing %s", key)
+			}
+		}
+	case Hostpath:
+		if hostPathType := corev1.HostPathType(config["type"]); hostPathType != "" && !slices.Contains(validHostPathTypes, hostPathType) {
+			return errors.Errorf("invalid hostpath type %q: must be one of %v", config["type"], validHostPathTypes)
+		}
+	case ISCSI:
+		for _, key := range []string{"targetPortal", "iqn", "lun"} {
+			if config[key] == "" {
+				return errors.Errorf("iscsi config missing %s", key)
+			}
+		}
+		if _, err := parseISCSILun(config["lun"]); err != nil {
+			return err
+		}
+	case EmptyDir:
+		return validateEmptyDirConfig(config)
+	}
+	return nil
+}
+
+// podHasClaimMounted returns true if the pod already has the given pvc volume under the same name.
+func podHasClaimMounted(ps *corev1.PodSpec, volume *corev1.Volume) bool {
+	if volume.PersistentVolumeClaim == nil {
+		return false
+	}
+	for _, v := range ps.Volumes {
+		if v.Name == volume.Name && v.PersistentVolumeClaim != nil && v.PersistentVolumeClaim.ClaimName == volume.PersistentVolumeClaim.ClaimName {
+			return true
+		}
+	}
+	return false
+}
+
 // buildVolumeMount creates a new k8s volume mount object
 func buildVolumeMount(bucket string, mountPath string) *corev1.VolumeMount {
 	return &corev1.VolumeMount{Name: bucket, MountPath: mountPath, ReadOnly: false}
@@ -121,7 +372,7 @@ func hostPathTypePtr(v corev1.HostPathType) *corev1.HostPathType {
 
 // ensurePVC creates a PVC based on the config present in the backupstoragelocation CRD
 func ensurePVC(config map[string]string, log *logrus.Entry) error {
-	namespace := os.Getenv("VELERO_NAMESPACE")
+	namespace := resolveVeleroNamespace(config["veleroNamespace"])
 
 	clientset, err := k8sutil.GetClientset()
 	if err != nil {
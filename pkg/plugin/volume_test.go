@@ -0,0 +1,364 @@
+package plugin
+
+import (
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+func Test_buildVolume(t *testing.T) {
+	tests := []struct {
+		name       string
+		volumeType VolumeType
+		config     map[string]string
+		want       *corev1.Volume
+		wantErr    bool
+	}{
+		{
+			name:       "existing claim",
+			volumeType: ExistingClaim,
+			config: map[string]string{
+				"bucket":    "my-bucket",
+				"claimName": "shared-backups",
+			},
+			want: &corev1.Volume{
+				Name: "my-bucket",
+				VolumeSource: corev1.VolumeSource{
+					PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
+						ClaimName: "shared-backups",
+					},
+				},
+			},
+		},
+		{
+			name:       "existing claim -- missing claimName",
+			volumeType: ExistingClaim,
+			config: map[string]string{
+				"bucket": "my-bucket",
+			},
+			wantErr: true,
+		},
+		{
+			name:       "nfs",
+			volumeType: NFS,
+			config: map[string]string{
+				"bucket": "my-bucket",
+				"server": "1.2.3.4",
+				"path":   "/exports/backups",
+			},
+			want: &corev1.Volume{
+				Name: "my-bucket",
+				VolumeSource: corev1.VolumeSource{
+					NFS: &corev1.NFSVolumeSource{
+						Server: "1.2.3.4",
+						Path:   "/exports/backups",
+					},
+				},
+			},
+		},
+		{
+			name:       "nfs -- version and mount options",
+			volumeType: NFS,
+			config: map[string]string{
+				"bucket":          "my-bucket",
+				"server":          "1.2.3.4",
+				"path":            "/exports/backups",
+				"nfsVersion":      "4.1",
+				"nfsMountOptions": "hard, rsize=1048576,wsize=1048576",
+			},
+			want: &corev1.Volume{
+				Name: "my-bucket",
+				VolumeSource: corev1.VolumeSource{
+					CSI: &corev1.CSIVolumeSource{
+						Driver: "nfs.csi.k8s.io",
+						VolumeAttributes: map[string]string{
+							"server":       "1.2.3.4",
+							"share":        "/exports/backups",
+							"mountOptions": "hard,rsize=1048576,wsize=1048576,nfsvers=4.1",
+						},
+					},
+				},
+			},
+		},
+		{
+			name:       "nfs -- invalid mount option",
+			volumeType: NFS,
+			config: map[string]string{
+				"bucket":          "my-bucket",
+				"server":          "1.2.3.4",
+				"path":            "/exports/backups",
+				"nfsMountOptions": "hard;reboot",
+			},
+			wantErr: true,
+		},
+		{
+			name:       "smb",
+			volumeType: SMB,
+			config: map[string]string{
+				"bucket":     "my-bucket",
+				"server":     "fileserver.example.com",
+				"share":      "backups",
+				"secretName": "smb-creds",
+			},
+			want: &corev1.Volume{
+				Name: "my-bucket",
+				VolumeSource: corev1.VolumeSource{
+					CSI: &corev1.CSIVolumeSource{
+						Driver: "smb.csi.k8s.io",
+						VolumeAttributes: map[string]string{
+							"source": "//fileserver.example.com/backups",
+						},
+						NodePublishSecretRef: &corev1.LocalObjectReference{
+							Name: "smb-creds",
+						},
+					},
+				},
+			},
+		},
+		{
+			name:       "smb -- missing secretName",
+			volumeType: SMB,
+			config: map[string]string{
+				"bucket": "my-bucket",
+				"server": "fileserver.example.com",
+				"share":  "backups",
+			},
+			wantErr: true,
+		},
+		{
+			name:       "hostpath -- default type",
+			volumeType: Hostpath,
+			config: map[string]string{
+				"bucket": "my-bucket",
+				"path":   "/mnt/backups",
+			},
+			want: &corev1.Volume{
+				Name: "my-bucket",
+				VolumeSource: corev1.VolumeSource{
+					HostPath: &corev1.HostPathVolumeSource{
+						Path: "/mnt/backups",
+						Type: hostPathTypePtr(corev1.HostPathDirectory),
+					},
+				},
+			},
+		},
+		{
+			name:       "hostpath -- with type",
+			volumeType: Hostpath,
+			config: map[string]string{
+				"bucket": "my-bucket",
+				"path":   "/mnt/backups",
+				"type":   "DirectoryOrCreate",
+			},
+			want: &corev1.Volume{
+				Name: "my-bucket",
+				VolumeSource: corev1.VolumeSource{
+					HostPath: &corev1.HostPathVolumeSource{
+						Path: "/mnt/backups",
+						Type: hostPathTypePtr(corev1.HostPathDirectoryOrCreate),
+					},
+				},
+			},
+		},
+		{
+			name:       "iscsi",
+			volumeType: ISCSI,
+			config: map[string]string{
+				"bucket":       "my-bucket",
+				"targetPortal": "10.0.0.1:3260",
+				"iqn":          "iqn.2001-04.com.example:storage.backups",
+				"lun":          "2",
+				"fsType":       "xfs",
+			},
+			want: &corev1.Volume{
+				Name: "my-bucket",
+				VolumeSource: corev1.VolumeSource{
+					ISCSI: &corev1.ISCSIVolumeSource{
+						TargetPortal: "10.0.0.1:3260",
+						IQN:          "iqn.2001-04.com.example:storage.backups",
+						Lun:          2,
+						FSType:       "xfs",
+					},
+				},
+			},
+		},
+		{
+			name:       "iscsi -- missing iqn",
+			volumeType: ISCSI,
+			config: map[string]string{
+				"bucket":       "my-bucket",
+				"targetPortal": "10.0.0.1:3260",
+				"lun":          "0",
+			},
+			wantErr: true,
+		},
+		{
+			name:       "iscsi -- invalid lun",
+			volumeType: ISCSI,
+			config: map[string]string{
+				"bucket":       "my-bucket",
+				"targetPortal": "10.0.0.1:3260",
+				"iqn":          "iqn.2001-04.com.example:storage.backups",
+				"lun":          "first",
+			},
+			wantErr: true,
+		},
+		{
+			name:       "emptyDir",
+			volumeType: EmptyDir,
+			config: map[string]string{
+				"bucket": "my-bucket",
+			},
+			want: &corev1.Volume{
+				Name: "my-bucket",
+				VolumeSource: corev1.VolumeSource{
+					EmptyDir: &corev1.EmptyDirVolumeSource{},
+				},
+			},
+		},
+		{
+			name:       "emptyDir -- size limit in memory",
+			volumeType: EmptyDir,
+			config: map[string]string{
+				"bucket":    "my-bucket",
+				"sizeLimit": "2Gi",
+				"medium":    "Memory",
+			},
+			want: &corev1.Volume{
+				Name: "my-bucket",
+				VolumeSource: corev1.VolumeSource{
+					EmptyDir: &corev1.EmptyDirVolumeSource{
+						Medium:    corev1.StorageMediumMemory,
+						SizeLimit: resourceQuantityPtr(resource.MustParse("2Gi")),
+					},
+				},
+			},
+		},
+		{
+			name:       "emptyDir -- invalid size limit",
+			volumeType: EmptyDir,
+			config: map[string]string{
+				"bucket":    "my-bucket",
+				"sizeLimit": "lots",
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := buildVolume(tt.volumeType, tt.config, logrus.NewEntry(logrus.New()))
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_validateVolumeConfig(t *testing.T) {
+	tests := []struct {
+		name       string
+		volumeType VolumeType
+		config     map[string]string
+		wantErr    bool
+	}{
+		{
+			name:       "existing claim",
+			volumeType: ExistingClaim,
+			config:     map[string]string{"claimName": "shared-backups"},
+		},
+		{
+			name:       "emptyDir -- unknown medium",
+			volumeType: EmptyDir,
+			config:     map[string]string{"medium": "HugePages"},
+			wantErr:    true,
+		},
+		{
+			name:       "emptyDir -- zero size limit",
+			volumeType: EmptyDir,
+			config:     map[string]string{"sizeLimit": "0"},
+			wantErr:    true,
+		},
+		{
+			name:       "existing claim -- empty claimName",
+			volumeType: ExistingClaim,
+			config:     map[string]string{"claimName": ""},
+			wantErr:    true,
+		},
+		{
+			name:       "nfs -- mount options",
+			volumeType: NFS,
+			config:     map[string]string{"server": "1.2.3.4", "path": "/exports/backups", "nfsMountOptions": "soft,timeo=600,sec=krb5p"},
+		},
+		{
+			name:       "nfs -- unsupported version",
+			volumeType: NFS,
+			config:     map[string]string{"server": "1.2.3.4", "path": "/exports/backups", "nfsVersion": "2"},
+			wantErr:    true,
+		},
+		{
+			name:       "nfs -- version given twice",
+			volumeType: NFS,
+			config:     map[string]string{"server": "1.2.3.4", "path": "/exports/backups", "nfsVersion": "4.1", "nfsMountOptions": "vers=3"},
+			wantErr:    true,
+		},
+		{
+			name:       "nfs -- option with a space",
+			volumeType: NFS,
+			config:     map[string]string{"server": "1.2.3.4", "path": "/exports/backups", "nfsMountOptions": "rsize=1 wsize=1"},
+			wantErr:    true,
+		},
+		{
+			name:       "smb",
+			volumeType: SMB,
+			config:     map[string]string{"server": "fileserver.example.com", "share": "backups", "secretName": "smb-creds"},
+		},
+		{
+			name:       "smb -- missing share",
+			volumeType: SMB,
+			config:     map[string]string{"server": "fileserver.example.com", "secretName": "smb-creds"},
+			wantErr:    true,
+		},
+		{
+			name:       "hostpath -- valid type",
+			volumeType: Hostpath,
+			config:     map[string]string{"path": "/mnt/backups", "type": "DirectoryOrCreate"},
+		},
+		{
+			name:       "hostpath -- file type",
+			volumeType: Hostpath,
+			config:     map[string]string{"path": "/mnt/backups", "type": "File"},
+			wantErr:    true,
+		},
+		{
+			name:       "iscsi",
+			volumeType: ISCSI,
+			config:     map[string]string{"targetPortal": "10.0.0.1:3260", "iqn": "iqn.2001-04.com.example:storage.backups", "lun": "0"},
+		},
+		{
+			name:       "iscsi -- negative lun",
+			volumeType: ISCSI,
+			config:     map[string]string{"targetPortal": "10.0.0.1:3260", "iqn": "iqn.2001-04.com.example:storage.backups", "lun": "-1"},
+			wantErr:    true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateVolumeConfig(tt.volumeType, tt.config)
+			if tt.wantErr {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
+
+func resourceQuantityPtr(q resource.Quantity) *resource.Quantity {
+	return &q
+}
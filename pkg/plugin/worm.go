@@ -0,0 +1,116 @@
+package plugin
+
+import (
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+)
+
+// fsImmutableFlag is FS_IMMUTABLE_FL of the inode flags read and set with FS_IOC_GETFLAGS and FS_IOC_SETFLAGS.
+const fsImmutableFlag = 0x00000010
+
+// ErrObjectRetained is returned when a WORM object would be deleted or replaced within its retention window.
+var ErrObjectRetained = errors.New("object is within its WORM retention window")
+
+// validateWORMConfig returns an error if the WORM options of the config cannot be used together.
+func validateWORMConfig(config map[string]string) error {
+	if config["worm"] != "true" {
+		if config["wormImmutable"] == "true" {
+			return errors.New("wormImmutable requires worm")
+		}
+		return nil
+	}
+	if config["wormRetention"] == "" {
+		return errors.New("worm requires wormRetention")
+	}
+	// Removing one link to a blob would require clearing the flag shared by every object linked to it
+	if config["wormImmutable"] == "true" && config["dedup"] == dedupHardlink {
+		return errors.Errorf("wormImmutable cannot be used with dedup %q", dedupHardlink)
+	}
+	return nil
+}
+
+// setImmutable sets or clears the immutable flag of the file, which prevents even root from modifying, renaming
+// or removing it until the flag is cleared. It needs CAP_LINUX_IMMUTABLE and a filesystem supporting it, so NFS
+// and SMB volumes never do. It is a variable so tests can simulate such filesystems.
+var setImmutable = func(filePath string, immutable bool) error {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	flags, err := unix.IoctlGetUint32(int(file.Fd()), unix.FS_IOC_GETFLAGS)
+	if err != nil {
+		return err
+	}
+	if immutable {
+		flags |= fsImmutableFlag
+	} else {
+		flags &^= fsImmutableFlag
+	}
+	return unix.IoctlSetPointerInt(int(file.Fd()), unix.FS_IOC_SETFLAGS, int(flags))
+}
+
+// protectWORMObject makes a newly written object file read-only, and immutable with wormImmutable, if the bucket is WORM.
+// Failing to set the immutable flag is warned about once, as the retention window is still enforced by the plugin.
+func (o *LocalVolumeObjectStore) protectWORMObject(filePath string, log logrus.FieldLogger) error {
+	if !o.worm {
+		return nil
+	}
+	if err := os.Chmod(filePath, o.getFileMode()&^0222); err != nil {
+		return errors.Wrap(err, "failed to make object read-only")
+	}
+	if o.wormImmutable {
+		if err := setImmutable(filePath, true); err != nil {
+			o.immutableUnsupported.Do(func() {
+				log.WithError(err).Warn("Setting the immutable flag is not supported, WORM objects are only read-only")
+			})
+		}
+	}
+	return nil
+}
+
+// checkWORMRetention returns ErrObjectRetained if the object at path is in a WORM bucket and was written within the
+// wormRetention window. An expired object has its immutable flag cleared so that it can be replaced or removed.
+// The window starts when the inode of the object file last changed, which unlike its modification time cannot be
+// set through the plugin, so writing an object with an old modification time does not shorten it.
+func (o *LocalVolumeObjectStore) checkWORMRetention(path string) error {
+	if !o.worm {
+		return nil
+	}
+	filePath, _, err := findObjectFile(path)
+	if errors.Is(err, ErrObjectNotFound) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	info, err := os.Lstat(filePath)
+	if err != nil {
+		return err
+	}
+
+	retainedUntil := changeTime(info).Add(o.wormRetention)
+	if time.Now().Before(retainedUntil) {
+		return errors.Wrapf(ErrObjectRetained, "retained until %s", retainedUntil.UTC().Format(time.RFC3339))
+	}
+
+	if o.wormImmutable {
+		if err := setImmutable(filePath, false); err != nil && !errors.Is(err, syscall.ENOTTY) && !errors.Is(err, syscall.EOPNOTSUPP) {
+			return errors.Wrap(err, "failed to clear immutable flag of expired object")
+		}
+	}
+	return nil
+}
+
+// changeTime returns the time the inode of the file last changed, or its modification time where that is unknown.
+func changeTime(info os.FileInfo) time.Time {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		return time.Unix(stat.Ctim.Unix())
+	}
+	return info.ModTime()
+}
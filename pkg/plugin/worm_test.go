@@ -0,0 +1,147 @@
+package plugin
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_validateWORMConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  map[string]string
+		wantErr string
+	}{
+		{
+			name: "not worm",
+		},
+		{
+			name:   "worm",
+			config: map[string]string{"worm": "true", "wormRetention": "30d", "wormImmutable": "true"},
+		},
+		{
+			name:    "missing retention",
+			config:  map[string]string{"worm": "true"},
+			wantErr: "worm requires wormRetention",
+		},
+		{
+			name:    "immutable without worm",
+			config:  map[string]string{"wormImmutable": "true"},
+			wantErr: "wormImmutable requires worm",
+		},
+		{
+			name:    "immutable with dedup",
+			config:  map[string]string{"worm": "true", "wormRetention": "30d", "wormImmutable": "true", "dedup": dedupHardlink},
+			wantErr: `wormImmutable cannot be used with dedup "hardlink"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateWORMConfig(tt.config)
+			if tt.wantErr != "" {
+				require.EqualError(t, err, tt.wantErr)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
+
+func Test_WORM(t *testing.T) {
+	req := require.New(t)
+	o, root := newTestObjectStore(t)
+	req.NoError(o.applyConfig(map[string]string{"worm": "true", "wormRetention": "1h"}))
+
+	key := "backups/my-backup/my-backup.tar.gz"
+	filePath := filepath.Join(root, "my-bucket", key)
+	req.NoError(o.PutObject("my-bucket", key, strings.NewReader("backup contents")))
+	info, err := os.Stat(filePath)
+	req.NoError(err)
+	req.Zero(info.Mode().Perm() & 0222)
+
+	// within the window the object can be neither deleted nor replaced
+	req.ErrorIs(o.DeleteObject("my-bucket", key), ErrObjectRetained)
+	req.ErrorIs(o.DeleteObjects("my-bucket", []string{key}), ErrObjectRetained)
+	req.ErrorIs(o.PutObject("my-bucket", key, strings.NewReader("ransomware")), ErrObjectRetained)
+	req.ErrorIs(o.PutObjectWithModTime("my-bucket", key, strings.NewReader("ransomware"), time.Now().Add(-48*time.Hour)), ErrObjectRetained)
+	req.ErrorIs(o.MoveObject("my-bucket", key, "backups/moved/moved.tar.gz"), ErrObjectRetained)
+	req.NoError(o.PutObject("my-bucket", "backups/other/other.tar.gz", strings.NewReader("other")))
+	req.ErrorIs(o.CopyObject("my-bucket", "backups/other/other.tar.gz", key), ErrObjectRetained)
+
+	rc, err := o.GetObject("my-bucket", key)
+	req.NoError(err)
+	req.NoError(rc.Close())
+
+	// an object written with an old modification time is still retained from when it was written
+	req.NoError(o.PutObjectWithModTime("my-bucket", "backups/old/old.tar.gz", strings.NewReader("old"), time.Now().Add(-48*time.Hour)))
+	req.ErrorIs(o.DeleteObject("my-bucket", "backups/old/old.tar.gz"), ErrObjectRetained)
+
+	// once the window has passed the object is deleted
+	o.wormRetention = time.Nanosecond
+	req.NoError(o.DeleteObject("my-bucket", key))
+	_, err = os.Stat(filePath)
+	req.True(os.IsNotExist(err))
+}
+
+func Test_WORM_immutable(t *testing.T) {
+	tests := []struct {
+		name     string
+		setErr   error
+		wantWarn int
+	}{
+		{
+			name: "supported",
+		},
+		{
+			name:     "unsupported",
+			setErr:   syscall.ENOTTY,
+			wantWarn: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := require.New(t)
+			o, root := newTestObjectStore(t)
+			req.NoError(o.applyConfig(map[string]string{"worm": "true", "wormRetention": "1h", "wormImmutable": "true"}))
+
+			immutable := map[string]bool{}
+			defer func(set func(string, bool) error) { setImmutable = set }(setImmutable)
+			setImmutable = func(filePath string, value bool) error {
+				if tt.setErr != nil {
+					return tt.setErr
+				}
+				immutable[filePath] = value
+				return nil
+			}
+
+			var out strings.Builder
+			log := logrus.New()
+			log.Out = &out
+			o.log = log
+
+			keys := []string{"backups/my-backup/one.tar.gz", "backups/my-backup/two.tar.gz"}
+			for _, key := range keys {
+				req.NoError(o.PutObject("my-bucket", key, strings.NewReader("backup contents")))
+			}
+			req.Equal(tt.wantWarn, strings.Count(out.String(), "Setting the immutable flag is not supported"), out.String())
+
+			filePath := filepath.Join(root, "my-bucket", keys[0])
+			if tt.setErr == nil {
+				req.True(immutable[filePath])
+			}
+
+			// the flag is cleared so the expired object can be removed
+			o.wormRetention = time.Nanosecond
+			req.NoError(o.DeleteObject("my-bucket", keys[0]))
+			if tt.setErr == nil {
+				req.False(immutable[filePath])
+			}
+		})
+	}
+}